@@ -0,0 +1,256 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/sbbullet/to-do/db"
+	"github.com/sbbullet/to-do/logger"
+	"github.com/sbbullet/to-do/mail"
+	"github.com/sbbullet/to-do/util"
+)
+
+type updateCurrentUserRequest struct {
+	FullName *string `json:"full_name" validate:"omitempty,full_name"`
+}
+
+type changePasswordRequest struct {
+	Password    string `json:"password" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required"`
+}
+
+type changeEmailRequest struct {
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required"`
+}
+
+// passwordConfirmationRequest confirms a sensitive change with the password
+type passwordConfirmationRequest struct {
+	Password string `json:"password" validate:"required"`
+}
+
+// Handler to update the profile of the authorized user
+func (s *Server) UpdateCurrentUser(w http.ResponseWriter, r *http.Request) {
+	var req updateCurrentUserRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		util.RespondWithBadRequest(w, "Invalid request payload")
+		return
+	}
+
+	validationErrors := validateRequest(req)
+	if validationErrors != nil {
+		util.RespondWithValidationErrors(w, validationErrors)
+		return
+	}
+
+	var fullName sql.NullString
+	if req.FullName != nil {
+		fullName = sql.NullString{String: *req.FullName, Valid: true}
+	}
+
+	user, err := s.store.UpdateUser(db.UpdateUserParams{
+		Username: r.Header.Get(authUsernameHeaderKey),
+		FullName: fullName,
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	util.RespondWithOk(w, createUserResponse(user))
+}
+
+// Handler to change the password of the authorized user, who has to know the
+// current one. Every other session of the user is logged out.
+func (s *Server) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var req changePasswordRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		util.RespondWithBadRequest(w, "Invalid request payload")
+		return
+	}
+
+	validationErrors := validateRequest(req)
+	if validationErrors != nil {
+		util.RespondWithValidationErrors(w, validationErrors)
+		return
+	}
+
+	user, ok := s.confirmPassword(w, r, req.Password)
+	if !ok {
+		return
+	}
+
+	if !s.checkPasswordPolicy(w, "new_password", req.NewPassword, user.Username, user.Email) {
+		return
+	}
+
+	hashedPassword, err := s.passwords.Hash(req.NewPassword)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	sessions, err := s.store.ChangePassword(db.ChangePasswordParams{
+		Username:       user.Username,
+		HashedPassword: hashedPassword,
+		SessionID:      authPayload(r).SessionID,
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	for _, session := range sessions {
+		s.revocations.sessionRevoked(session.ID)
+	}
+
+	util.RespondWithOk(w, "Your password has been changed and your other sessions logged out")
+}
+
+// Handler to change the email of the authorized user, who has to know their
+// password. The new email is pending until it's verified, while the old one
+// is told about it and stays the email of the user until then.
+func (s *Server) ChangeEmail(w http.ResponseWriter, r *http.Request) {
+	var req changeEmailRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		util.RespondWithBadRequest(w, "Invalid request payload")
+		return
+	}
+
+	validationErrors := validateRequest(req)
+	if validationErrors != nil {
+		util.RespondWithValidationErrors(w, validationErrors)
+		return
+	}
+
+	user, ok := s.confirmPassword(w, r, req.Password)
+	if !ok {
+		return
+	}
+
+	updated, err := s.store.ChangeEmail(db.ChangeEmailParams{
+		Username: user.Username,
+		Email:    req.Email,
+	})
+	if err != nil {
+		if errors.Is(err, db.ErrEmailTaken) {
+			util.RespondWithValidationErrors(w, map[string][]string{
+				"email": {"This email is already taken"},
+			})
+			return
+		}
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	go func() {
+		s.sendMail(user, mail.Message{
+			To:      user.Email,
+			Subject: "Your email is being changed",
+			Body: fmt.Sprintf(
+				"Hi %s,\n\nThe email of your account is being changed to %s, which happens once it's verified. If it wasn't you, reset your password right away.\n",
+				user.FullName, *updated.PendingEmail,
+			),
+		})
+		s.sendEmailVerificationLink(updated)
+	}()
+
+	util.RespondWithOk(w, createUserResponse(updated))
+}
+
+// Handler to delete the authorized user along with everything they own, once
+// they have confirmed it with their password
+func (s *Server) DeleteCurrentUser(w http.ResponseWriter, r *http.Request) {
+	var req passwordConfirmationRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		util.RespondWithBadRequest(w, "Invalid request payload")
+		return
+	}
+
+	validationErrors := validateRequest(req)
+	if validationErrors != nil {
+		util.RespondWithValidationErrors(w, validationErrors)
+		return
+	}
+
+	user, ok := s.confirmPassword(w, r, req.Password)
+	if !ok {
+		return
+	}
+
+	if err := s.revocations.deleteUser(user.Username); err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	util.RespondWithOk(w, "Your account has been deleted")
+}
+
+// confirmPassword checks the password of the authorized user before a
+// sensitive change, responding with an error if it's wrong. Failures count
+// like failed logins, so that a stolen token can't be used to guess it.
+func (s *Server) confirmPassword(w http.ResponseWriter, r *http.Request, password string) (db.User, bool) {
+	user, err := s.store.GetUser(r.Header.Get(authUsernameHeaderKey))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			util.RespondWithUauthorizedError(w, "You are not authorized to perform the action")
+			return db.User{}, false
+		}
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return db.User{}, false
+	}
+
+	allowed, err := s.checkLoginAllowed(w, r, user.Username)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return db.User{}, false
+	}
+
+	if !allowed {
+		return db.User{}, false
+	}
+
+	// Users who signed up through a provider have no password until they reset it
+	if len(user.HashedPassword) == 0 {
+		util.RespondWithForbiddenError(w, "Your account has no password yet. Please, set one by resetting it first")
+		return db.User{}, false
+	}
+
+	if _, err := s.passwords.Verify(user.HashedPassword, password); err != nil {
+		if !errors.Is(err, util.ErrPasswordMismatch) {
+			logger.Error(err.Error())
+		}
+		if err := s.recordLoginFailure(r, user.Username); err != nil {
+			logger.Error(err.Error())
+		}
+		util.RespondWithValidationErrors(w, map[string][]string{
+			"password": {"This password is incorrect"},
+		})
+		return db.User{}, false
+	}
+
+	err = s.store.ClearLoginFailures(db.ClearLoginFailuresParams{Kind: db.LoginFailureKindUsername, Identifier: user.Username})
+	if err != nil {
+		logger.Error(err.Error())
+	}
+
+	return user, true
+}
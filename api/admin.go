@@ -0,0 +1,295 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sbbullet/to-do/db"
+	"github.com/sbbullet/to-do/logger"
+	"github.com/sbbullet/to-do/token"
+	"github.com/sbbullet/to-do/util"
+	"go.uber.org/zap"
+)
+
+type adminUserResponse struct {
+	Username        string     `json:"username"`
+	FullName        string     `json:"full_name"`
+	Email           string     `json:"email"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	PendingEmail    *string    `json:"pending_email"`
+	Role            string     `json:"role"`
+	HasPassword     bool       `json:"has_password"`
+	DisabledAt      *time.Time `json:"disabled_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+type adminUserDetailsResponse struct {
+	adminUserResponse
+	TodoCounts db.TodoCounts `json:"todo_counts"`
+}
+
+func createAdminUserResponse(user db.User) adminUserResponse {
+	return adminUserResponse{
+		Username:        user.Username,
+		FullName:        user.FullName,
+		Email:           user.Email,
+		EmailVerifiedAt: user.EmailVerifiedAt,
+		PendingEmail:    user.PendingEmail,
+		Role:            user.Role,
+		HasPassword:     len(user.HashedPassword) > 0,
+		DisabledAt:      user.DisabledAt,
+		CreatedAt:       user.CreatedAt,
+	}
+}
+
+// Handler to list the users, searching their username, email and full name
+// for the q query parameter when it's given
+func (s *Server) AdminListUsers(w http.ResponseWriter, r *http.Request) {
+	pageNum, err := strconv.Atoi(r.URL.Query().Get("page_num"))
+	if err != nil {
+		pageNum = 1
+	}
+
+	pageSize, err := strconv.Atoi(r.URL.Query().Get("page_size"))
+	if err != nil {
+		pageSize = 20
+	}
+
+	if pageNum <= 0 || pageSize <= 0 {
+		util.RespondWithBadRequest(w, "Page number and page size must be greater than zero")
+		return
+	}
+
+	users, err := s.store.ListUsers(db.ListUsersParams{
+		Query:  r.URL.Query().Get("q"),
+		Limit:  pageSize,
+		Offset: (pageNum - 1) * pageSize,
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	response := make([]adminUserResponse, 0, len(users))
+	for _, user := range users {
+		response = append(response, createAdminUserResponse(user))
+	}
+
+	util.RespondWithOk(w, response)
+}
+
+// Handler to get a user along with how many todos they have
+func (s *Server) AdminGetUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	counts, err := s.store.GetUserTodoCounts(user.Username)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	util.RespondWithOk(w, adminUserDetailsResponse{
+		adminUserResponse: createAdminUserResponse(user),
+		TodoCounts:        counts,
+	})
+}
+
+// Handler to disable a user, logging them out everywhere
+func (s *Server) AdminDisableUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	// Admins would lock themselves out
+	if user.Username == r.Header.Get(authUsernameHeaderKey) {
+		util.RespondWithBadRequest(w, "You can't disable your own account")
+		return
+	}
+
+	user, err := s.store.DisableUser(user.Username)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	if err := s.revocations.revokeAll(user.Username); err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	logAdminAction(r, "Disabled user", user.Username)
+
+	util.RespondWithOk(w, createAdminUserResponse(user))
+}
+
+// Handler to let a disabled user log in again
+func (s *Server) AdminEnableUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := s.store.EnableUser(user.Username)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	logAdminAction(r, "Enabled user", user.Username)
+
+	util.RespondWithOk(w, createAdminUserResponse(user))
+}
+
+// Handler to make a user reset their password before logging in with one
+// again. Their password stops working, they are logged out everywhere and
+// sent a reset link.
+func (s *Server) AdminForcePasswordReset(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := s.store.ForcePasswordReset(user.Username)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	if err := s.revocations.revokeAll(user.Username); err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	go s.sendPasswordResetLink(user.Email)
+
+	logAdminAction(r, "Forced password reset", user.Username)
+
+	util.RespondWithOk(w, createAdminUserResponse(user))
+}
+
+// Handler to let a user who got locked out by failed logins log in again
+// right away
+func (s *Server) AdminUnlockUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := s.store.ClearLoginFailures(db.ClearLoginFailuresParams{Kind: db.LoginFailureKindUsername, Identifier: user.Username})
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	logAdminAction(r, "Unlocked user", user.Username)
+
+	util.RespondWithOk(w, createAdminUserResponse(user))
+}
+
+// Handler to log a user out everywhere
+func (s *Server) AdminRevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.store.RevokeUserSessions(user.Username); err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	if err := s.revocations.revokeAll(user.Username); err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	logAdminAction(r, "Revoked sessions of user", user.Username)
+
+	util.RespondWithOk(w, "The user has been logged out everywhere")
+}
+
+type impersonateUserResponse struct {
+	AccessToken          string       `json:"access_token"`
+	AccessTokenExpiresAt time.Time    `json:"access_token_expires_at"`
+	User                 userResponse `json:"user"`
+}
+
+// Handler to get a short-lived access token acting as a user, to see what
+// they see. The token can't be refreshed nor manage the account, and every
+// request made with it is logged.
+func (s *Server) AdminImpersonateUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	admin := r.Header.Get(authUsernameHeaderKey)
+	if user.Username == admin {
+		util.RespondWithBadRequest(w, "You can't impersonate yourself")
+		return
+	}
+
+	accessToken, accessTokenPayload, err := s.tokenMaker.CreateToken(token.PayloadParams{
+		Username:     user.Username,
+		Duration:     s.config.ImpersonationTokenDuration,
+		Scopes:       token.AllScopes,
+		Impersonator: admin,
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	logAdminAction(r, "Impersonating user", user.Username)
+
+	util.RespondWithOk(w, impersonateUserResponse{
+		AccessToken:          accessToken,
+		AccessTokenExpiresAt: accessTokenPayload.ExpiresAt,
+		User:                 createUserResponse(user),
+	})
+}
+
+// adminTargetUser gets the user of the username or email in the path,
+// responding with an error if there is none
+func (s *Server) adminTargetUser(w http.ResponseWriter, r *http.Request) (db.User, bool) {
+	user, err := s.store.GetUserByLogin(mux.Vars(r)["username"])
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			util.RespondWithNotFoundError(w, "Oops!! We couldn't find the user")
+			return db.User{}, false
+		}
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return db.User{}, false
+	}
+
+	return user, true
+}
+
+// logAdminAction keeps track of who did what to which user
+func logAdminAction(r *http.Request, action string, username string) {
+	logger.Info(
+		action,
+		zap.String("Admin", r.Header.Get(authUsernameHeaderKey)),
+		zap.String("Username", username),
+	)
+}
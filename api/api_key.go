@@ -0,0 +1,214 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/sbbullet/to-do/db"
+	"github.com/sbbullet/to-do/logger"
+	"github.com/sbbullet/to-do/token"
+	"github.com/sbbullet/to-do/util"
+)
+
+const (
+	// apiKeyPrefix tells API keys apart from access tokens in the Authorization header
+	apiKeyPrefix = "todo_"
+	// apiKeyDisplayLength is how much of a key is kept to help users recognise it
+	apiKeyDisplayLength = 12
+)
+
+type createAPIKeyRequest struct {
+	Name          string   `json:"name" validate:"required,max=64"`
+	Scopes        []string `json:"scopes" validate:"required,min=1,dive,oneof=todos:read todos:write account:admin"`
+	ExpiresInDays int      `json:"expires_in_days" validate:"omitempty,min=1,max=365"`
+}
+
+type apiKeyResponse struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	KeyPrefix  string     `json:"key_prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type createdAPIKeyResponse struct {
+	apiKeyResponse
+	Key string `json:"key"`
+}
+
+func createAPIKeyResponse(apiKey db.APIKey) apiKeyResponse {
+	return apiKeyResponse{
+		ID:         apiKey.ID,
+		Name:       apiKey.Name,
+		KeyPrefix:  apiKey.KeyPrefix,
+		Scopes:     apiKey.Scopes,
+		ExpiresAt:  apiKey.ExpiresAt,
+		LastUsedAt: apiKey.LastUsedAt,
+		CreatedAt:  apiKey.CreatedAt,
+	}
+}
+
+// Create API key for the authorized user
+func (s *Server) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req createAPIKeyRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		util.RespondWithBadRequest(w, "Invalid request payload")
+		return
+	}
+
+	validationErrors := validateRequest(req)
+	if validationErrors != nil {
+		util.RespondWithValidationErrors(w, validationErrors)
+		return
+	}
+
+	secret, err := util.RandomSecret(32)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+	key := apiKeyPrefix + secret
+
+	apiKeyID, err := uuid.NewRandom()
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		expiresAt = &t
+	}
+
+	arg := db.CreateAPIKeyParams{
+		ID:        apiKeyID,
+		Username:  r.Header.Get(authUsernameHeaderKey),
+		Name:      req.Name,
+		KeyPrefix: key[:apiKeyDisplayLength],
+		KeyHash:   util.HashSecret(key),
+		Scopes:    req.Scopes,
+		ExpiresAt: expiresAt,
+	}
+
+	apiKey, err := s.store.CreateAPIKey(arg)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	// Only the hash is stored, so the key is only ever shown on creation
+	util.RespondWithOk(w, createdAPIKeyResponse{
+		apiKeyResponse: createAPIKeyResponse(apiKey),
+		Key:            key,
+	})
+}
+
+// Get API keys of the authorized user
+func (s *Server) GetUserAPIKeys(w http.ResponseWriter, r *http.Request) {
+	apiKeys, err := s.store.GetUserAPIKeys(r.Header.Get(authUsernameHeaderKey))
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	apiKeysToSend := []apiKeyResponse{}
+	for _, apiKey := range apiKeys {
+		apiKeysToSend = append(apiKeysToSend, createAPIKeyResponse(apiKey))
+	}
+
+	util.RespondWithOk(w, apiKeysToSend)
+}
+
+// Revoke specified API key of the authorized user
+func (s *Server) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	apiKeyID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		util.RespondWithBadRequest(w, "Invalid API key identifier")
+		return
+	}
+
+	arg := db.DeleteAPIKeyOfAUserParams{
+		ID:       apiKeyID,
+		Username: r.Header.Get(authUsernameHeaderKey),
+	}
+
+	err = s.store.DeleteAPIKeyOfAUser(arg)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			util.RespondWithNotFoundError(w, "Oops!! We couldn't find the associated API key")
+			return
+		}
+
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	util.RespondWithOk(w, "Successfully revoked specified API key")
+}
+
+// requestAPIKey gives the API key the request is authorized with, if it isn't
+// authorized with an access token
+func requestAPIKey(r *http.Request) (string, bool) {
+	fields := strings.Fields(r.Header.Get(authorizationHeaderKey))
+	if len(fields) == 2 && strings.HasPrefix(fields[1], apiKeyPrefix) {
+		return fields[1], true
+	}
+
+	return "", false
+}
+
+// authorizeAPIKey looks up the API key, responding with an error if it's
+// unknown or expired. The key is described with a payload like access tokens are.
+// API keys aren't revoked along with the access tokens of their user, when
+// logging out everywhere or resetting the password, but last until they're
+// deleted or expire.
+func (s *Server) authorizeAPIKey(w http.ResponseWriter, key string) (*token.Payload, bool) {
+	apiKey, err := s.store.GetAPIKeyByHash(util.HashSecret(key))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			util.RespondWithUauthorizedError(w, "You are not authorized to perform the action")
+			return nil, false
+		}
+
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return nil, false
+	}
+
+	now := time.Now()
+	if apiKey.ExpiresAt != nil && now.After(*apiKey.ExpiresAt) {
+		util.RespondWithUauthorizedError(w, "Your API key has expired")
+		return nil, false
+	}
+
+	if err := s.store.TouchAPIKey(db.TouchAPIKeyParams{ID: apiKey.ID, LastUsedAt: now}); err != nil {
+		logger.Error(err.Error())
+	}
+
+	payload := &token.Payload{
+		ID:       apiKey.ID,
+		Username: apiKey.Username,
+		Scopes:   apiKey.Scopes,
+		IssuedAt: apiKey.CreatedAt,
+	}
+	if apiKey.ExpiresAt != nil {
+		payload.ExpiresAt = *apiKey.ExpiresAt
+	}
+
+	return payload, true
+}
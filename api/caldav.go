@@ -0,0 +1,352 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/sbbullet/to-do/db"
+	"github.com/sbbullet/to-do/logger"
+	"github.com/sbbullet/to-do/util"
+)
+
+// Methods of the WebDAV and CalDAV extensions to HTTP
+const (
+	methodPropfind = "PROPFIND"
+	methodReport   = "REPORT"
+)
+
+const (
+	davAllowedMethods   = "OPTIONS, GET, HEAD, PROPFIND, REPORT"
+	maxDAVRequestSize   = 1 << 20
+	davStatusOK         = "HTTP/1.1 200 OK"
+	davStatusNotFound   = "HTTP/1.1 404 Not Found"
+	calendarContentType = "text/calendar; charset=utf-8; component=vtodo"
+)
+
+type davMultistatus struct {
+	XMLName   xml.Name      `xml:"D:multistatus"`
+	DAV       string        `xml:"xmlns:D,attr"`
+	CalDAV    string        `xml:"xmlns:C,attr"`
+	CS        string        `xml:"xmlns:CS,attr"`
+	Responses []davResponse `xml:"D:response"`
+}
+
+type davResponse struct {
+	Href     string       `xml:"D:href"`
+	Propstat *davPropstat `xml:"D:propstat,omitempty"`
+	Status   string       `xml:"D:status,omitempty"`
+}
+
+type davPropstat struct {
+	Prop   davProp `xml:"D:prop"`
+	Status string  `xml:"D:status"`
+}
+
+type davProp struct {
+	ResourceType        *davResourceType        `xml:"D:resourcetype,omitempty"`
+	DisplayName         string                  `xml:"D:displayname,omitempty"`
+	GetETag             string                  `xml:"D:getetag,omitempty"`
+	GetContentType      string                  `xml:"D:getcontenttype,omitempty"`
+	GetCTag             string                  `xml:"CS:getctag,omitempty"`
+	SupportedComponents *davSupportedComponents `xml:"C:supported-calendar-component-set,omitempty"`
+	CalendarData        string                  `xml:"C:calendar-data,omitempty"`
+}
+
+type davResourceType struct {
+	Collection *struct{} `xml:"D:collection,omitempty"`
+	Calendar   *struct{} `xml:"C:calendar,omitempty"`
+}
+
+type davSupportedComponents struct {
+	Components []davComponent `xml:"C:comp"`
+}
+
+type davComponent struct {
+	Name string `xml:"name,attr"`
+}
+
+// davReport is the subset of calendar-query and calendar-multiget reports
+// needed to decide which todos to return
+type davReport struct {
+	XMLName     xml.Name
+	Hrefs       []string `xml:"href"`
+	CompFilters []struct {
+		Name string `xml:"name,attr"`
+	} `xml:"filter>comp-filter>comp-filter"`
+}
+
+// Describe the read-only CalDAV calendar holding the todos of the feed owner
+func (s *Server) CalDAVCollection(w http.ResponseWriter, r *http.Request) {
+	username, ok := s.calendarFeedOwner(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodOptions:
+		w.Header().Set("DAV", "1, calendar-access")
+		w.Header().Set("Allow", davAllowedMethods)
+		w.WriteHeader(http.StatusOK)
+
+	case methodPropfind:
+		s.calDAVPropfind(w, r, username)
+
+	case methodReport:
+		s.calDAVReport(w, r, username)
+	}
+}
+
+// Serve a single todo of the feed owner as a calendar resource
+func (s *Server) CalDAVTodo(w http.ResponseWriter, r *http.Request) {
+	username, ok := s.calendarFeedOwner(w, r)
+	if !ok {
+		return
+	}
+
+	todoID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		util.RespondWithNotFoundError(w, "Oops!! We couldn't find the associated todo")
+		return
+	}
+
+	todo, err := s.store.GetTodoById(todoID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	if err != nil || todo.Username != username {
+		util.RespondWithNotFoundError(w, "Oops!! We couldn't find the associated todo")
+		return
+	}
+
+	data, err := todoCalendarData(todo)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", calendarContentType)
+	w.Header().Set("ETag", todoETag(todo))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write([]byte(data))
+	}
+}
+
+func (s *Server) calDAVPropfind(w http.ResponseWriter, r *http.Request, username string) {
+	todos, err := s.allUserTodos(username)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	collectionHref := calDAVCollectionHref(r)
+	responses := []davResponse{calDAVCollectionResponse(collectionHref, username, todos)}
+
+	// A Depth of 0 asks about the collection alone, anything else about its members too
+	if r.Header.Get("Depth") != "0" {
+		for _, todo := range todos {
+			responses = append(responses, calDAVTodoResponse(collectionHref, todo, false))
+		}
+	}
+
+	respondWithMultistatus(w, responses)
+}
+
+func (s *Server) calDAVReport(w http.ResponseWriter, r *http.Request, username string) {
+	var report davReport
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxDAVRequestSize))
+	if err != nil || xml.Unmarshal(body, &report) != nil {
+		util.RespondWithBadRequest(w, "Invalid report request")
+		return
+	}
+
+	todos, err := s.allUserTodos(username)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	collectionHref := calDAVCollectionHref(r)
+	responses := []davResponse{}
+
+	switch report.XMLName.Local {
+	case "calendar-multiget":
+		todosByHref := map[string]db.Todo{}
+		for _, todo := range todos {
+			todosByHref[calDAVTodoHref(collectionHref, todo)] = todo
+		}
+
+		for _, href := range report.Hrefs {
+			href = strings.TrimSpace(href)
+			if todo, ok := todosByHref[href]; ok {
+				responses = append(responses, calDAVTodoResponse(collectionHref, todo, true))
+			} else {
+				responses = append(responses, davResponse{Href: href, Status: davStatusNotFound})
+			}
+		}
+
+	case "calendar-query":
+		if reportWantsTodos(report) {
+			for _, todo := range todos {
+				responses = append(responses, calDAVTodoResponse(collectionHref, todo, true))
+			}
+		}
+
+	default:
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	respondWithMultistatus(w, responses)
+}
+
+// reportWantsTodos tells whether a calendar query filters for VTODO
+// components, or doesn't filter on the component at all
+func reportWantsTodos(report davReport) bool {
+	if len(report.CompFilters) == 0 {
+		return true
+	}
+
+	for _, filter := range report.CompFilters {
+		if strings.EqualFold(filter.Name, "VTODO") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allUserTodos loads every todo of the user, which the collection needs to
+// compute its ctag anyway
+func (s *Server) allUserTodos(username string) ([]db.Todo, error) {
+	it, err := s.store.IterateUserTodos(username)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	todos := []db.Todo{}
+	for it.Next() {
+		todos = append(todos, it.Todo())
+	}
+
+	return todos, it.Err()
+}
+
+func calDAVCollectionHref(r *http.Request) string {
+	return fmt.Sprintf("/caldav/%s/", mux.Vars(r)["token"])
+}
+
+func calDAVTodoHref(collectionHref string, todo db.Todo) string {
+	return collectionHref + todo.ID.String() + ".ics"
+}
+
+func calDAVCollectionResponse(href string, username string, todos []db.Todo) davResponse {
+	// The ctag changes whenever any todo in the collection does
+	ctag := sha1.New()
+	for _, todo := range todos {
+		ctag.Write([]byte(todoETag(todo)))
+	}
+
+	return davResponse{
+		Href: href,
+		Propstat: &davPropstat{
+			Prop: davProp{
+				ResourceType: &davResourceType{Collection: &struct{}{}, Calendar: &struct{}{}},
+				DisplayName:  "Todos of " + username,
+				GetCTag:      hex.EncodeToString(ctag.Sum(nil)),
+				SupportedComponents: &davSupportedComponents{
+					Components: []davComponent{{Name: "VTODO"}},
+				},
+			},
+			Status: davStatusOK,
+		},
+	}
+}
+
+func calDAVTodoResponse(collectionHref string, todo db.Todo, withData bool) davResponse {
+	prop := davProp{
+		ResourceType:   &davResourceType{},
+		GetETag:        todoETag(todo),
+		GetContentType: calendarContentType,
+	}
+
+	if withData {
+		data, err := todoCalendarData(todo)
+		if err != nil {
+			logger.Error(err.Error())
+		}
+		prop.CalendarData = data
+	}
+
+	return davResponse{
+		Href:     calDAVTodoHref(collectionHref, todo),
+		Propstat: &davPropstat{Prop: prop, Status: davStatusOK},
+	}
+}
+
+func respondWithMultistatus(w http.ResponseWriter, responses []davResponse) {
+	response, err := xml.Marshal(davMultistatus{
+		DAV:       "DAV:",
+		CalDAV:    "urn:ietf:params:xml:ns:caldav",
+		CS:        "http://calendarserver.org/ns/",
+		Responses: responses,
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	w.Write([]byte(xml.Header))
+	w.Write(response)
+}
+
+// todoCalendarData renders the todo on its own as a calendar object resource
+func todoCalendarData(todo db.Todo) (string, error) {
+	var buf bytes.Buffer
+	cal := newCalendarWriter(&buf)
+
+	cal.beginCalendar("")
+	cal.todo(todo, time.Now())
+	cal.endCalendar()
+
+	return buf.String(), cal.err
+}
+
+// todoETag changes whenever a field rendered in the calendar does
+func todoETag(todo db.Todo) string {
+	hash := sha1.New()
+	fmt.Fprintf(hash, "%s|%s|%t", todo.ID, todo.Title, todo.IsCompleted)
+	if todo.CompletedAt != nil {
+		fmt.Fprintf(hash, "|%d", todo.CompletedAt.Unix())
+	}
+	if todo.Priority != nil {
+		fmt.Fprintf(hash, "|p%s", *todo.Priority)
+	}
+	if todo.DueAt != nil {
+		fmt.Fprintf(hash, "|d%d", todo.DueAt.Unix())
+	}
+	fmt.Fprintf(hash, "|%s", strings.Join(todo.Tags, ","))
+
+	return `"` + hex.EncodeToString(hash.Sum(nil)) + `"`
+}
@@ -0,0 +1,250 @@
+package api
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/sbbullet/to-do/db"
+	"github.com/sbbullet/to-do/logger"
+	"github.com/sbbullet/to-do/util"
+)
+
+const (
+	calendarProductID  = "-//sbbullet//to-do//EN"
+	calendarTimeFormat = "20060102T150405Z"
+	calendarLineLimit  = 75
+)
+
+type calendarFeedResponse struct {
+	ICSURL    string    `json:"ics_url"`
+	CalDAVURL string    `json:"caldav_url"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Create the calendar feed of the authorized user, replacing the secret URL
+// of any existing feed
+func (s *Server) CreateCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	feedToken, err := util.RandomSecret(32)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	arg := db.UpsertCalendarFeedParams{
+		Username:  r.Header.Get(authUsernameHeaderKey),
+		TokenHash: util.HashSecret(feedToken),
+	}
+
+	feed, err := s.store.UpsertCalendarFeed(arg)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	// Only the hash of the token is stored, so the URLs can't be shown again
+	baseURL := requestBaseURL(r)
+	util.RespondWithOk(w, calendarFeedResponse{
+		ICSURL:    fmt.Sprintf("%s/calendar/%s.ics", baseURL, feedToken),
+		CalDAVURL: fmt.Sprintf("%s/caldav/%s/", baseURL, feedToken),
+		CreatedAt: feed.CreatedAt,
+	})
+}
+
+// Revoke the calendar feed of the authorized user
+func (s *Server) DeleteCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	err := s.store.DeleteCalendarFeed(r.Header.Get(authUsernameHeaderKey))
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	util.RespondWithOk(w, "Successfully revoked your calendar feed")
+}
+
+// Serve the todos of the feed owner as an iCalendar file
+func (s *Server) GetCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	username, ok := s.calendarFeedOwner(w, r)
+	if !ok {
+		return
+	}
+
+	it, err := s.store.IterateUserTodos(username)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+	defer it.Close()
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="todos.ics"`)
+	w.WriteHeader(http.StatusOK)
+
+	buf := bufio.NewWriter(w)
+	cal := newCalendarWriter(buf)
+	now := time.Now()
+
+	cal.beginCalendar("Todos of " + username)
+	for it.Next() {
+		cal.todo(it.Todo(), now)
+	}
+	cal.endCalendar()
+
+	// The status has already been sent, so failures can only be logged
+	if err := it.Err(); err != nil {
+		logger.Error(err.Error())
+		return
+	}
+
+	if cal.err != nil {
+		logger.Error(cal.err.Error())
+		return
+	}
+
+	if err := buf.Flush(); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+// calendarFeedOwner resolves the secret token in the route to the user owning
+// the feed, responding with an error if there is no such feed
+func (s *Server) calendarFeedOwner(w http.ResponseWriter, r *http.Request) (string, bool) {
+	feedToken := mux.Vars(r)["token"]
+
+	feed, err := s.store.GetCalendarFeedByTokenHash(util.HashSecret(feedToken))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			util.RespondWithNotFoundError(w, "Oops!! We couldn't find the calendar")
+			return "", false
+		}
+
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return "", false
+	}
+
+	return feed.Username, true
+}
+
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+
+	return fmt.Sprintf("%s://%s", scheme, r.Host)
+}
+
+// calendarWriter writes iCalendar (RFC 5545) content lines, keeping the first
+// error so callers only need to check once at the end
+type calendarWriter struct {
+	w   io.Writer
+	err error
+}
+
+func newCalendarWriter(w io.Writer) *calendarWriter {
+	return &calendarWriter{w: w}
+}
+
+// line writes a content line, folding it into lines of at most 75 octets
+// without splitting multi-byte characters
+func (c *calendarWriter) line(name string, value string) {
+	if c.err != nil {
+		return
+	}
+
+	content := name + ":" + value
+
+	var sb strings.Builder
+	lineLength := 0
+	for _, r := range content {
+		size := utf8.RuneLen(r)
+		if lineLength+size > calendarLineLimit {
+			sb.WriteString("\r\n ")
+			lineLength = 1
+		}
+		sb.WriteRune(r)
+		lineLength += size
+	}
+	sb.WriteString("\r\n")
+
+	_, c.err = io.WriteString(c.w, sb.String())
+}
+
+func (c *calendarWriter) beginCalendar(name string) {
+	c.line("BEGIN", "VCALENDAR")
+	c.line("VERSION", "2.0")
+	c.line("PRODID", calendarProductID)
+	c.line("CALSCALE", "GREGORIAN")
+	if len(name) > 0 {
+		c.line("X-WR-CALNAME", escapeCalendarText(name))
+	}
+}
+
+func (c *calendarWriter) endCalendar() {
+	c.line("END", "VCALENDAR")
+}
+
+// todo writes the todo as a VTODO component
+func (c *calendarWriter) todo(todo db.Todo, now time.Time) {
+	c.line("BEGIN", "VTODO")
+	c.line("UID", todo.ID.String())
+	c.line("DTSTAMP", formatCalendarTime(now))
+	c.line("CREATED", formatCalendarTime(todo.CreatedAt))
+	c.line("SUMMARY", escapeCalendarText(todo.Title))
+
+	if todo.Priority != nil {
+		c.line("PRIORITY", calendarPriorities[*todo.Priority])
+	}
+	if todo.DueAt != nil {
+		c.line("DUE", formatCalendarTime(*todo.DueAt))
+	}
+	if len(todo.Tags) > 0 {
+		categories := make([]string, len(todo.Tags))
+		for i, tag := range todo.Tags {
+			categories[i] = escapeCalendarText(tag)
+		}
+		c.line("CATEGORIES", strings.Join(categories, ","))
+	}
+
+	if todo.IsCompleted {
+		c.line("STATUS", "COMPLETED")
+		c.line("PERCENT-COMPLETE", "100")
+		if todo.CompletedAt != nil {
+			c.line("COMPLETED", formatCalendarTime(*todo.CompletedAt))
+		}
+	} else {
+		c.line("STATUS", "NEEDS-ACTION")
+	}
+
+	c.line("END", "VTODO")
+}
+
+// calendarPriorities maps the todo priorities to the high, medium and low
+// values of RFC 5545
+var calendarPriorities = map[string]string{
+	db.TodoPriorityHigh:   "1",
+	db.TodoPriorityMedium: "5",
+	db.TodoPriorityLow:    "9",
+}
+
+var calendarTextEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+
+func escapeCalendarText(text string) string {
+	return calendarTextEscaper.Replace(text)
+}
+
+func formatCalendarTime(t time.Time) string {
+	return t.UTC().Format(calendarTimeFormat)
+}
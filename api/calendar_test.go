@@ -0,0 +1,88 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalendarWriterLine(t *testing.T) {
+	testCases := []struct {
+		name     string
+		value    string
+		expected string
+	}{
+		{
+			name:     "Short",
+			value:    "Buy some milk",
+			expected: "SUMMARY:Buy some milk\r\n",
+		},
+		{
+			name:     "Limit",
+			value:    strings.Repeat("a", 67),
+			expected: "SUMMARY:" + strings.Repeat("a", 67) + "\r\n",
+		},
+		{
+			name:     "Folded",
+			value:    strings.Repeat("a", 72),
+			expected: "SUMMARY:" + strings.Repeat("a", 67) + "\r\n aaaaa\r\n",
+		},
+		{
+			name:     "FoldedTwice",
+			value:    strings.Repeat("a", 67+74+3),
+			expected: "SUMMARY:" + strings.Repeat("a", 67) + "\r\n " + strings.Repeat("a", 74) + "\r\n aaa\r\n",
+		},
+		{
+			// é takes 2 octets, which would make the first line 76 octets long
+			name:     "MultiByte",
+			value:    strings.Repeat("a", 66) + "été",
+			expected: "SUMMARY:" + strings.Repeat("a", 66) + "\r\n été\r\n",
+		},
+		{
+			// ✓ takes 3 octets, of which only 2 fit on the first line
+			name:     "MultiByteAtLimit",
+			value:    strings.Repeat("a", 65) + "✓✓",
+			expected: "SUMMARY:" + strings.Repeat("a", 65) + "\r\n ✓✓\r\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			var sb strings.Builder
+			c := newCalendarWriter(&sb)
+			c.line("SUMMARY", tc.value)
+			require.NoError(t, c.err)
+			require.Equal(t, tc.expected, sb.String())
+
+			for _, line := range strings.Split(strings.TrimSuffix(sb.String(), "\r\n"), "\r\n") {
+				require.LessOrEqual(t, len(line), calendarLineLimit)
+			}
+		})
+	}
+}
+
+func TestEscapeCalendarText(t *testing.T) {
+	testCases := []struct {
+		name     string
+		text     string
+		expected string
+	}{
+		{name: "Plain", text: "Buy some milk", expected: "Buy some milk"},
+		{name: "Semicolon", text: "milk; eggs", expected: `milk\; eggs`},
+		{name: "Comma", text: "milk, eggs", expected: `milk\, eggs`},
+		{name: "Backslash", text: `C:\todos`, expected: `C:\\todos`},
+		{name: "Newline", text: "milk\neggs", expected: `milk\neggs`},
+		{name: "CRLF", text: "milk\r\neggs", expected: `milk\neggs`},
+		{name: "CR", text: "milk\reggs", expected: `milk\neggs`},
+		{name: "EscapedComma", text: `\,`, expected: `\\\,`},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, escapeCalendarText(tc.text))
+		})
+	}
+}
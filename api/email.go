@@ -0,0 +1,166 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sbbullet/to-do/db"
+	"github.com/sbbullet/to-do/logger"
+	"github.com/sbbullet/to-do/mail"
+	"github.com/sbbullet/to-do/util"
+	"go.uber.org/zap"
+)
+
+// mailTimeout is how long sending an email may take
+const mailTimeout = 30 * time.Second
+
+type verifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type resendEmailVerificationRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// Handler to verify the email of a user with the token of a verification link
+func (s *Server) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req verifyEmailRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		util.RespondWithBadRequest(w, "Invalid request payload")
+		return
+	}
+
+	validationErrors := validateRequest(req)
+	if validationErrors != nil {
+		util.RespondWithValidationErrors(w, validationErrors)
+		return
+	}
+
+	user, err := s.store.VerifyEmail(util.HashSecret(req.Token))
+	if err != nil {
+		if errors.Is(err, db.ErrEmailVerificationTokenInvalid) {
+			util.RespondWithBadRequest(w, "This verification link is invalid or has expired")
+			return
+		}
+		if errors.Is(err, db.ErrEmailTaken) {
+			util.RespondWithBadRequest(w, "This email has been taken by another account since")
+			return
+		}
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	util.RespondWithOk(w, createUserResponse(user))
+}
+
+// Handler to send a new verification link to a user who hasn't verified their
+// email yet. Like ForgotPassword, it answers the same for every email.
+func (s *Server) ResendEmailVerification(w http.ResponseWriter, r *http.Request) {
+	var req resendEmailVerificationRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		util.RespondWithBadRequest(w, "Invalid request payload")
+		return
+	}
+
+	validationErrors := validateRequest(req)
+	if validationErrors != nil {
+		util.RespondWithValidationErrors(w, validationErrors)
+		return
+	}
+
+	go s.resendEmailVerificationLink(req.Email)
+
+	util.RespondWithOk(w, "If an account with this email still needs verifying, a new link is on its way")
+}
+
+// resendEmailVerificationLink sends a new verification link to the user with
+// the email, unless they have nothing to verify or were sent one too recently
+func (s *Server) resendEmailVerificationLink(email string) {
+	user, err := s.store.GetUserByEmail(email)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logger.Error(err.Error())
+		}
+		return
+	}
+
+	if user.EmailVerifiedAt != nil && user.PendingEmail == nil {
+		return
+	}
+
+	latest, err := s.store.GetLatestEmailVerificationToken(user.Username)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		logger.Error(err.Error())
+		return
+	}
+
+	if err == nil && time.Since(latest.CreatedAt) < s.config.EmailVerificationResendInterval {
+		logger.Info("Throttled email verification resend", zap.String("username", user.Username))
+		return
+	}
+
+	s.sendEmailVerificationLink(user)
+}
+
+// sendEmailVerificationLink emails the user a link verifying their email, or
+// the one they are changing to
+func (s *Server) sendEmailVerificationLink(user db.User) {
+	email := user.Email
+	if user.PendingEmail != nil {
+		email = *user.PendingEmail
+	}
+
+	verificationToken, err := util.RandomSecret(32)
+	if err != nil {
+		logger.Error(err.Error())
+		return
+	}
+
+	_, err = s.store.CreateEmailVerificationToken(db.CreateEmailVerificationTokenParams{
+		TokenHash: util.HashSecret(verificationToken),
+		Username:  user.Username,
+		Email:     email,
+		ExpiresAt: time.Now().Add(s.config.EmailVerificationTokenDuration),
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		return
+	}
+
+	s.sendMail(user, mail.Message{
+		To:      email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nPlease confirm this is your email address by opening this link:\n\n%s\n\nThe link works within %d hours. If you didn't sign up, you can ignore this email.\n",
+			user.FullName, s.appLink("/verify-email", verificationToken), int(s.config.EmailVerificationTokenDuration.Hours()),
+		),
+	})
+}
+
+// sendMail sends the email to the user, only logging failures as nobody is
+// waiting on them
+func (s *Server) sendMail(user db.User, message mail.Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+	defer cancel()
+
+	if err := s.mailer.Send(ctx, message); err != nil {
+		logger.Warn("Failed to send email", zap.String("username", user.Username), zap.String("subject", message.Subject), zap.Error(err))
+	}
+}
+
+// appLink links to the page of the app handling the token
+func (s *Server) appLink(path string, token string) string {
+	return strings.TrimSuffix(s.config.AppURL, "/") + path + "?token=" + url.QueryEscape(token)
+}
@@ -0,0 +1,243 @@
+package api
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sbbullet/to-do/db"
+	"github.com/sbbullet/to-do/logger"
+	"github.com/sbbullet/to-do/util"
+)
+
+// Formats the todos can be exported in
+const (
+	exportFormatJSON     = "json"
+	exportFormatCSV      = "csv"
+	exportFormatMarkdown = "md"
+)
+
+// todoExportColumns is the header row of the CSV export
+var todoExportColumns = []string{"id", "username", "title", "is_completed", "created_at", "completed_at", "priority", "due_at", "tags"}
+
+// todoExporter writes the todos one at a time in a specific format
+type todoExporter interface {
+	begin() error
+	write(todo db.Todo) error
+	end() error
+}
+
+// Export all the todos of the authorized user
+func (s *Server) ExportTodos(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if len(format) == 0 {
+		format = exportFormatJSON
+	}
+
+	username := r.Header.Get(authUsernameHeaderKey)
+	buf := bufio.NewWriter(w)
+
+	var exporter todoExporter
+	var contentType string
+
+	switch format {
+	case exportFormatJSON:
+		exporter = &jsonTodoExporter{w: buf}
+		contentType = "application/json"
+
+	case exportFormatCSV:
+		exporter = &csvTodoExporter{w: csv.NewWriter(buf)}
+		contentType = "text/csv; charset=utf-8"
+
+	case exportFormatMarkdown:
+		exporter = &markdownTodoExporter{w: buf, username: username}
+		contentType = "text/markdown; charset=utf-8"
+
+	default:
+		util.RespondWithBadRequest(w, "Format must be one of json, csv or md")
+		return
+	}
+
+	it, err := s.store.IterateUserTodos(username)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+	defer it.Close()
+
+	fileName := fmt.Sprintf("todos-%s-%s.%s", username, time.Now().Format("20060102"), format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+	w.WriteHeader(http.StatusOK)
+
+	// The status has already been sent, so failures past this point can only be logged
+	if err := exportTodos(exporter, it); err != nil {
+		logger.Error(err.Error())
+		return
+	}
+
+	if err := buf.Flush(); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+func exportTodos(exporter todoExporter, it *db.TodoIterator) error {
+	if err := exporter.begin(); err != nil {
+		return err
+	}
+
+	for it.Next() {
+		if err := exporter.write(it.Todo()); err != nil {
+			return err
+		}
+	}
+
+	if err := it.Err(); err != nil {
+		return err
+	}
+
+	return exporter.end()
+}
+
+// jsonTodoExporter writes the todos as a JSON array
+type jsonTodoExporter struct {
+	w     *bufio.Writer
+	count int
+}
+
+func (e *jsonTodoExporter) begin() error {
+	_, err := e.w.WriteString("[")
+	return err
+}
+
+func (e *jsonTodoExporter) write(todo db.Todo) error {
+	if e.count > 0 {
+		if _, err := e.w.WriteString(","); err != nil {
+			return err
+		}
+	}
+	e.count++
+
+	data, err := json.Marshal(todo)
+	if err != nil {
+		return err
+	}
+
+	_, err = e.w.Write(data)
+	return err
+}
+
+func (e *jsonTodoExporter) end() error {
+	_, err := e.w.WriteString("]\n")
+	return err
+}
+
+// csvTodoExporter writes the todos as CSV rows preceded by a header row
+type csvTodoExporter struct {
+	w *csv.Writer
+}
+
+func (e *csvTodoExporter) begin() error {
+	return e.w.Write(todoExportColumns)
+}
+
+func (e *csvTodoExporter) write(todo db.Todo) error {
+	return e.w.Write([]string{
+		todo.ID.String(),
+		todo.Username,
+		escapeCSVFormula(todo.Title),
+		strconv.FormatBool(todo.IsCompleted),
+		todo.CreatedAt.UTC().Format(time.RFC3339),
+		formatExportTime(todo.CompletedAt),
+		formatExportPriority(todo.Priority),
+		formatExportTime(todo.DueAt),
+		escapeCSVFormula(strings.Join(todo.Tags, ",")),
+	})
+}
+
+// csvFormulaPrefixes are the first characters that make spreadsheet apps
+// evaluate a cell as a formula
+const csvFormulaPrefixes = "=+-@"
+
+// escapeCSVFormula prefixes cells that would be evaluated as formulas with a
+// quote, so that opening the export in a spreadsheet can't run them. The
+// import removes the quote again.
+func escapeCSVFormula(value string) string {
+	if len(value) > 0 && strings.ContainsRune(csvFormulaPrefixes, rune(value[0])) {
+		return "'" + value
+	}
+
+	return value
+}
+
+// unescapeCSVFormula undoes escapeCSVFormula
+func unescapeCSVFormula(value string) string {
+	if len(value) > 1 && value[0] == '\'' && strings.ContainsRune(csvFormulaPrefixes, rune(value[1])) {
+		return value[1:]
+	}
+
+	return value
+}
+
+func (e *csvTodoExporter) end() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// markdownTodoExporter writes the todos as a Markdown table
+type markdownTodoExporter struct {
+	w        *bufio.Writer
+	username string
+}
+
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+
+func (e *markdownTodoExporter) begin() error {
+	_, err := fmt.Fprintf(e.w, "# Todos of %s\n\n| Done | Title | Priority | Due | Tags | Created at | Completed at | ID |\n| --- | --- | --- | --- | --- | --- | --- | --- |\n", e.username)
+	return err
+}
+
+func (e *markdownTodoExporter) write(todo db.Todo) error {
+	done := "[ ]"
+	if todo.IsCompleted {
+		done = "[x]"
+	}
+
+	_, err := fmt.Fprintf(e.w, "| %s | %s | %s | %s | %s | %s | %s | %s |\n",
+		done,
+		markdownEscaper.Replace(todo.Title),
+		formatExportPriority(todo.Priority),
+		formatExportTime(todo.DueAt),
+		markdownEscaper.Replace(strings.Join(todo.Tags, ", ")),
+		todo.CreatedAt.UTC().Format(time.RFC3339),
+		formatExportTime(todo.CompletedAt),
+		todo.ID,
+	)
+	return err
+}
+
+func (e *markdownTodoExporter) end() error {
+	return nil
+}
+
+func formatExportPriority(priority *string) string {
+	if priority == nil {
+		return ""
+	}
+
+	return *priority
+}
+
+func formatExportTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
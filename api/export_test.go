@@ -0,0 +1,100 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sbbullet/to-do/db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSVTodoExporterEscapesFormulas(t *testing.T) {
+	testCases := []struct {
+		name     string
+		title    string
+		tags     []string
+		cells    []string
+		expected importRow
+	}{
+		{
+			name:     "Plain",
+			title:    "Buy some milk",
+			tags:     []string{"home", "shop"},
+			cells:    []string{"Buy some milk", "home,shop"},
+			expected: importRow{Title: "Buy some milk", Tags: []string{"home", "shop"}},
+		},
+		{
+			name:     "Equals",
+			title:    `=HYPERLINK("http://example.com","Click")`,
+			tags:     []string{"=1+1"},
+			cells:    []string{`'=HYPERLINK("http://example.com","Click")`, "'=1+1"},
+			expected: importRow{Title: `=HYPERLINK("http://example.com","Click")`, Tags: []string{"=1+1"}},
+		},
+		{
+			name:     "Plus",
+			title:    "+1 for the milk",
+			tags:     []string{"+home"},
+			cells:    []string{"'+1 for the milk", "'+home"},
+			expected: importRow{Title: "+1 for the milk", Tags: []string{"+home"}},
+		},
+		{
+			name:     "Minus",
+			title:    "-2+3 eggs left",
+			tags:     []string{"home", "-shop"},
+			cells:    []string{"'-2+3 eggs left", "home,-shop"},
+			expected: importRow{Title: "-2+3 eggs left", Tags: []string{"home", "-shop"}},
+		},
+		{
+			name:     "At",
+			title:    "@SUM(1+1) is two",
+			tags:     []string{},
+			cells:    []string{"'@SUM(1+1) is two", ""},
+			expected: importRow{Title: "@SUM(1+1) is two", Tags: []string{}},
+		},
+		{
+			name:     "Quote",
+			title:    "'quoted' milk",
+			tags:     []string{},
+			cells:    []string{"'quoted' milk", ""},
+			expected: importRow{Title: "'quoted' milk", Tags: []string{}},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			todo := db.Todo{
+				ID:        uuid.New(),
+				Username:  "someone",
+				Title:     tc.title,
+				CreatedAt: time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC),
+				Tags:      tc.tags,
+			}
+
+			var buf bytes.Buffer
+			w := bufio.NewWriter(&buf)
+			exporter := &csvTodoExporter{w: csv.NewWriter(w)}
+			require.NoError(t, exporter.begin())
+			require.NoError(t, exporter.write(todo))
+			require.NoError(t, exporter.end())
+			require.NoError(t, w.Flush())
+
+			records, err := csv.NewReader(bytes.NewReader(buf.Bytes())).ReadAll()
+			require.NoError(t, err)
+			require.Len(t, records, 2)
+			require.Equal(t, tc.cells, []string{records[1][2], records[1][8]})
+
+			rows, err := parseCSVImport(bytes.NewReader(buf.Bytes()))
+			require.NoError(t, err)
+			require.Len(t, rows, 1)
+
+			tc.expected.Row = 2
+			tc.expected.CreatedAt = &todo.CreatedAt
+			require.Equal(t, tc.expected, rows[0])
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/sbbullet/to-do/db"
+	"github.com/sbbullet/to-do/logger"
+)
+
+// Event types broadcast to the subscribers of a todo or a todo list
+const (
+	eventTodoCreated = "todo_created"
+	eventTodoUpdated = "todo_updated"
+	eventTodoDeleted = "todo_deleted"
+	eventPresence    = "presence"
+)
+
+// hub keeps track of the live connections subscribed to each topic. A topic is
+// either a single todo or the whole todo list of a user.
+type hub struct {
+	mu            sync.RWMutex
+	subscriptions map[string]map[*wsClient]struct{}
+}
+
+func newHub() *hub {
+	return &hub{
+		subscriptions: map[string]map[*wsClient]struct{}{},
+	}
+}
+
+func todoTopic(id uuid.UUID) string {
+	return "todo:" + id.String()
+}
+
+func todoListTopic(username string) string {
+	return "todos:" + username
+}
+
+func (h *hub) subscribe(topic string, client *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	clients, ok := h.subscriptions[topic]
+	if !ok {
+		clients = map[*wsClient]struct{}{}
+		h.subscriptions[topic] = clients
+	}
+	clients[client] = struct{}{}
+}
+
+func (h *hub) unsubscribe(topic string, client *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.removeLocked(topic, client)
+}
+
+// unsubscribeAll removes the client from every topic it is subscribed to
+func (h *hub) unsubscribeAll(client *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for topic := range h.subscriptions {
+		h.removeLocked(topic, client)
+	}
+}
+
+func (h *hub) removeLocked(topic string, client *wsClient) {
+	clients, ok := h.subscriptions[topic]
+	if !ok {
+		return
+	}
+
+	delete(clients, client)
+	if len(clients) == 0 {
+		delete(h.subscriptions, topic)
+	}
+}
+
+// publish sends the message once to every client subscribed to any of the
+// given topics, skipping the excluded client if one is given
+func (h *hub) publish(msg wsOutgoingMessage, exclude *wsClient, topics ...string) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		logger.Error(err.Error())
+		return
+	}
+
+	h.mu.RLock()
+	recipients := map[*wsClient]struct{}{}
+	for _, topic := range topics {
+		for client := range h.subscriptions[topic] {
+			if client != exclude {
+				recipients[client] = struct{}{}
+			}
+		}
+	}
+	h.mu.RUnlock()
+
+	for client := range recipients {
+		client.enqueue(data)
+	}
+}
+
+// publishTodoEvent notifies the subscribers of the todo and of its owner's list
+func (h *hub) publishTodoEvent(event string, todo db.Todo) {
+	msg := wsOutgoingMessage{
+		Type: event,
+		Data: createTodoResponse(todo),
+	}
+
+	h.publish(msg, nil, todoTopic(todo.ID), todoListTopic(todo.Username))
+}
+
+// publishTodoDeleted notifies the subscribers that the todo no longer exists
+func (h *hub) publishTodoDeleted(id uuid.UUID, username string) {
+	msg := wsOutgoingMessage{
+		Type: eventTodoDeleted,
+		Data: map[string]uuid.UUID{"id": id},
+	}
+
+	h.publish(msg, nil, todoTopic(id), todoListTopic(username))
+}
@@ -0,0 +1,538 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sbbullet/to-do/db"
+	"github.com/sbbullet/to-do/logger"
+	"github.com/sbbullet/to-do/util"
+)
+
+// Formats the todos can be imported from
+const (
+	importFormatCSV         = "csv"
+	importFormatJSON        = "json"
+	importFormatTodoTxt     = "todotxt"
+	importFormatTaskwarrior = "taskwarrior"
+)
+
+const (
+	maxImportUploadSize = 10 << 20
+	importFileFormKey   = "file"
+)
+
+var errUnsupportedImportFormat = errors.New("format must be one of csv, json, todotxt or taskwarrior")
+
+// importRow is a todo parsed from an uploaded file along with the problems
+// found with it
+type importRow struct {
+	Row         int                 `json:"row"`
+	Title       string              `json:"title"`
+	IsCompleted bool                `json:"is_completed"`
+	CreatedAt   *time.Time          `json:"created_at,omitempty"`
+	CompletedAt *time.Time          `json:"completed_at,omitempty"`
+	Priority    string              `json:"priority,omitempty"`
+	DueAt       *time.Time          `json:"due_at,omitempty"`
+	Tags        []string            `json:"tags,omitempty"`
+	Errors      map[string][]string `json:"errors,omitempty"`
+}
+
+type importTodosResponse struct {
+	DryRun   bool           `json:"dry_run"`
+	Total    int            `json:"total"`
+	Valid    int            `json:"valid"`
+	Invalid  int            `json:"invalid"`
+	Rows     []importRow    `json:"rows,omitempty"`
+	Imported []todoResponse `json:"imported,omitempty"`
+}
+
+// Import todos for the authorized user from an uploaded file
+func (s *Server) ImportTodos(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportUploadSize)
+	if err := r.ParseMultipartForm(maxImportUploadSize); err != nil {
+		util.RespondWithBadRequest(w, "Invalid multipart upload")
+		return
+	}
+
+	file, header, err := r.FormFile(importFileFormKey)
+	if err != nil {
+		util.RespondWithBadRequest(w, "The file to import is required")
+		return
+	}
+	defer file.Close()
+
+	format := r.FormValue("format")
+	if len(format) == 0 {
+		format = importFormatFromFileName(header.Filename)
+	}
+
+	dryRun, err := strconv.ParseBool(r.FormValue("dry_run"))
+	if err != nil {
+		dryRun = false
+	}
+
+	rows, err := parseImport(format, file)
+	if err != nil {
+		if errors.Is(err, errUnsupportedImportFormat) {
+			util.RespondWithBadRequest(w, "Format must be one of csv, json, todotxt or taskwarrior")
+			return
+		}
+		util.RespondWithBadRequest(w, fmt.Sprintf("Couldn't read the %s file: %s", format, err.Error()))
+		return
+	}
+
+	response := importTodosResponse{DryRun: dryRun, Total: len(rows)}
+	invalidRows := []importRow{}
+	for i := range rows {
+		req := createTodoRequest{Title: rows[i].Title, Priority: rows[i].Priority, Tags: rows[i].Tags}
+		for field, messages := range validateRequest(req) {
+			addImportError(&rows[i], field, messages...)
+		}
+
+		if rows[i].Errors != nil {
+			invalidRows = append(invalidRows, rows[i])
+		}
+	}
+	response.Invalid = len(invalidRows)
+	response.Valid = response.Total - response.Invalid
+
+	if dryRun {
+		response.Rows = rows
+		util.RespondWithOk(w, response)
+		return
+	}
+
+	if len(invalidRows) > 0 {
+		util.RespondWithValidationErrors(w, invalidRows)
+		return
+	}
+
+	username := r.Header.Get(authUsernameHeaderKey)
+	args := make([]db.ImportTodoParams, 0, len(rows))
+	for _, row := range rows {
+		todoID, err := uuid.NewRandom()
+		if err != nil {
+			logger.Error(err.Error())
+			util.RespondWithInternalServerError(w)
+			return
+		}
+
+		arg := db.ImportTodoParams{
+			ID:          todoID,
+			Username:    username,
+			Title:       row.Title,
+			IsCompleted: row.IsCompleted,
+			Priority:    row.Priority,
+			Tags:        row.Tags,
+		}
+		if row.CreatedAt != nil {
+			arg.CreatedAt = *row.CreatedAt
+		}
+		if row.CompletedAt != nil {
+			arg.CompletedAt = *row.CompletedAt
+		}
+		if row.DueAt != nil {
+			arg.DueAt = *row.DueAt
+		}
+		args = append(args, arg)
+	}
+
+	todos, err := s.store.ImportTodos(args)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	for _, todo := range todos {
+		s.hub.publishTodoEvent(eventTodoCreated, todo)
+	}
+
+	response.Imported = createTodosResponse(todos)
+	util.RespondWithOk(w, response)
+}
+
+func addImportError(row *importRow, field string, messages ...string) {
+	if row.Errors == nil {
+		row.Errors = map[string][]string{}
+	}
+	row.Errors[field] = append(row.Errors[field], messages...)
+}
+
+func importFormatFromFileName(fileName string) string {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".csv":
+		return importFormatCSV
+	case ".json":
+		return importFormatJSON
+	case ".txt":
+		return importFormatTodoTxt
+	default:
+		return ""
+	}
+}
+
+func parseImport(format string, r io.Reader) ([]importRow, error) {
+	switch format {
+	case importFormatCSV:
+		return parseCSVImport(r)
+	case importFormatJSON:
+		return parseJSONImport(r)
+	case importFormatTodoTxt:
+		return parseTodoTxtImport(r)
+	case importFormatTaskwarrior:
+		return parseTaskwarriorImport(r)
+	default:
+		return nil, errUnsupportedImportFormat
+	}
+}
+
+// parseCSVImport reads a CSV file with a header row. Only the title column is
+// required, so both our own exports and hand written files can be imported.
+// The quotes the export puts before cells that look like formulas are removed.
+func parseCSVImport(r io.Reader) ([]importRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return []importRow{}, nil
+		}
+		return nil, err
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	titleColumn, ok := columns["title"]
+	if !ok {
+		return nil, errors.New("missing title column")
+	}
+
+	rows := []importRow{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		row := importRow{Row: line}
+		value := func(column string) string {
+			i, ok := columns[column]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return unescapeCSVFormula(strings.TrimSpace(record[i]))
+		}
+
+		if titleColumn < len(record) {
+			row.Title = unescapeCSVFormula(strings.TrimSpace(record[titleColumn]))
+		}
+
+		for _, column := range []string{"is_completed", "completed", "done", "status"} {
+			if v := value(column); len(v) > 0 {
+				row.IsCompleted = isCompletedValue(v)
+				break
+			}
+		}
+
+		row.CreatedAt = parseImportTimeColumn(&row, value, "created_at", "created")
+		if row.IsCompleted {
+			row.CompletedAt = parseImportTimeColumn(&row, value, "completed_at", "completed_on")
+		}
+
+		if v := value("priority"); len(v) > 0 {
+			row.Priority = parseImportPriority(&row, "priority", v)
+		}
+		row.DueAt = parseImportTimeColumn(&row, value, "due_at", "due", "due_date")
+		row.Tags = splitImportTags(value("tags"))
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// parseImportTimeColumn parses the first of the columns present in the row,
+// recording an error on the row if it isn't a valid date
+func parseImportTimeColumn(row *importRow, value func(string) string, columns ...string) *time.Time {
+	for _, column := range columns {
+		v := value(column)
+		if len(v) == 0 {
+			continue
+		}
+
+		t, ok := parseImportTime(v)
+		if !ok {
+			addImportError(row, column, "The date is invalid")
+			return nil
+		}
+
+		return &t
+	}
+
+	return nil
+}
+
+// parseImportPriority maps the priority names, letters and numbers used by
+// other todo apps to ours, recording an error on the row for unknown ones
+func parseImportPriority(row *importRow, field string, value string) string {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "high", "h", "a", "1":
+		return db.TodoPriorityHigh
+	case "medium", "m", "b", "2":
+		return db.TodoPriorityMedium
+	case "low", "l", "c", "3":
+		return db.TodoPriorityLow
+	default:
+		addImportError(row, field, "The priority is invalid")
+		return ""
+	}
+}
+
+// splitImportTags splits comma separated tags, dropping empty ones
+func splitImportTags(value string) []string {
+	tags := []string{}
+	for _, tag := range strings.Split(value, ",") {
+		if tag = strings.TrimSpace(tag); len(tag) > 0 {
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags
+}
+
+func isCompletedValue(value string) bool {
+	switch strings.ToLower(value) {
+	case "true", "1", "yes", "y", "x", "done", "completed":
+		return true
+	default:
+		return false
+	}
+}
+
+func parseImportTime(value string) (time.Time, bool) {
+	layouts := []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02", "20060102T150405Z"}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// parseJSONImport reads the shape written by the JSON export
+func parseJSONImport(r io.Reader) ([]importRow, error) {
+	var todos []struct {
+		Title       string     `json:"title"`
+		IsCompleted bool       `json:"is_completed"`
+		CreatedAt   time.Time  `json:"created_at"`
+		CompletedAt *time.Time `json:"completed_at"`
+		Priority    *string    `json:"priority"`
+		DueAt       *time.Time `json:"due_at"`
+		Tags        []string   `json:"tags"`
+	}
+
+	if err := json.NewDecoder(r).Decode(&todos); err != nil {
+		return nil, err
+	}
+
+	rows := []importRow{}
+	for i, todo := range todos {
+		row := importRow{
+			Row:         i + 1,
+			Title:       todo.Title,
+			IsCompleted: todo.IsCompleted,
+			CompletedAt: todo.CompletedAt,
+			DueAt:       todo.DueAt,
+			Tags:        todo.Tags,
+		}
+		if !todo.CreatedAt.IsZero() {
+			createdAt := todo.CreatedAt
+			row.CreatedAt = &createdAt
+		}
+		if todo.Priority != nil {
+			row.Priority = parseImportPriority(&row, "priority", *todo.Priority)
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+var (
+	todoTxtPriority = regexp.MustCompile(`^\([A-Z]\) `)
+	todoTxtDate     = regexp.MustCompile(`^\d{4}-\d{2}-\d{2} `)
+	todoTxtDue      = regexp.MustCompile(`(^|\s)due:(\S+)`)
+	todoTxtTag      = regexp.MustCompile(`(^|\s)[+@](\S+)`)
+)
+
+// parseTodoTxtImport reads the todo.txt format, one task per line:
+// [x [completion date]] [(priority)] [creation date] description
+// Priority A is high, B medium and the rest low, while the +project and
+// @context words of the description become tags.
+func parseTodoTxtImport(r io.Reader) ([]importRow, error) {
+	scanner := bufio.NewScanner(r)
+
+	rows := []importRow{}
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 {
+			continue
+		}
+
+		row := importRow{Row: line}
+
+		if strings.HasPrefix(text, "x ") {
+			row.IsCompleted = true
+			text = strings.TrimPrefix(text, "x ")
+
+			// A completed task may have its completion date before the creation date
+			if todoTxtDate.MatchString(text) && todoTxtDate.MatchString(text[11:]) {
+				if completedAt, ok := parseImportTime(text[:10]); ok {
+					row.CompletedAt = &completedAt
+				}
+				text = text[11:]
+			}
+		}
+
+		if todoTxtPriority.MatchString(text) {
+			switch text[1] {
+			case 'A':
+				row.Priority = db.TodoPriorityHigh
+			case 'B':
+				row.Priority = db.TodoPriorityMedium
+			default:
+				row.Priority = db.TodoPriorityLow
+			}
+			text = text[4:]
+		}
+
+		if todoTxtDate.MatchString(text) {
+			if createdAt, ok := parseImportTime(text[:10]); ok {
+				row.CreatedAt = &createdAt
+			}
+			text = text[11:]
+		}
+
+		if match := todoTxtDue.FindStringSubmatch(text); match != nil {
+			if dueAt, ok := parseImportTime(match[2]); ok {
+				row.DueAt = &dueAt
+			} else {
+				addImportError(&row, "due", "The date is invalid")
+			}
+			text = todoTxtDue.ReplaceAllString(text, "")
+		}
+
+		for _, match := range todoTxtTag.FindAllStringSubmatch(text, -1) {
+			row.Tags = append(row.Tags, match[2])
+		}
+		text = todoTxtTag.ReplaceAllString(text, "")
+
+		row.Title = strings.TrimSpace(text)
+		rows = append(rows, row)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+type taskwarriorTask struct {
+	Description string   `json:"description"`
+	Status      string   `json:"status"`
+	Entry       string   `json:"entry"`
+	End         string   `json:"end"`
+	Due         string   `json:"due"`
+	Priority    string   `json:"priority"`
+	Tags        []string `json:"tags"`
+}
+
+// parseTaskwarriorImport reads the output of `task export`, which is either a
+// JSON array or, in older versions, one JSON object per line. Deleted tasks
+// are skipped.
+func parseTaskwarriorImport(r io.Reader) ([]importRow, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var tasks []taskwarriorTask
+	if err := json.Unmarshal(data, &tasks); err != nil {
+		tasks = nil
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		for {
+			var task taskwarriorTask
+			if err := decoder.Decode(&task); errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			tasks = append(tasks, task)
+		}
+	}
+
+	rows := []importRow{}
+	for i, task := range tasks {
+		if task.Status == "deleted" {
+			continue
+		}
+
+		row := importRow{
+			Row:         i + 1,
+			Title:       strings.TrimSpace(task.Description),
+			IsCompleted: task.Status == "completed",
+		}
+
+		if len(task.Entry) > 0 {
+			if createdAt, ok := parseImportTime(task.Entry); ok {
+				row.CreatedAt = &createdAt
+			}
+		}
+
+		if row.IsCompleted && len(task.End) > 0 {
+			if completedAt, ok := parseImportTime(task.End); ok {
+				row.CompletedAt = &completedAt
+			}
+		}
+
+		if len(task.Priority) > 0 {
+			row.Priority = parseImportPriority(&row, "priority", task.Priority)
+		}
+		if len(task.Due) > 0 {
+			if dueAt, ok := parseImportTime(task.Due); ok {
+				row.DueAt = &dueAt
+			} else {
+				addImportError(&row, "due", "The date is invalid")
+			}
+		}
+		row.Tags = task.Tags
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
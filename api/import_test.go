@@ -0,0 +1,260 @@
+package api
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sbbullet/to-do/db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTodoTxtImport(t *testing.T) {
+	testCases := []struct {
+		name string
+		line string
+		row  importRow
+	}{
+		{
+			name: "Title",
+			line: "Buy some milk",
+			row:  importRow{Row: 1, Title: "Buy some milk"},
+		},
+		{
+			name: "CreationDate",
+			line: "2021-01-02 Buy some milk",
+			row:  importRow{Row: 1, Title: "Buy some milk", CreatedAt: importTime(2021, 1, 2)},
+		},
+		{
+			name: "Completed",
+			line: "x Buy some milk",
+			row:  importRow{Row: 1, Title: "Buy some milk", IsCompleted: true},
+		},
+		{
+			name: "CompletionAndCreationDates",
+			line: "x 2021-01-05 2021-01-02 Buy some milk",
+			row: importRow{
+				Row:         1,
+				Title:       "Buy some milk",
+				IsCompleted: true,
+				CreatedAt:   importTime(2021, 1, 2),
+				CompletedAt: importTime(2021, 1, 5),
+			},
+		},
+		{
+			name: "PriorityA",
+			line: "(A) 2021-01-02 Buy some milk",
+			row:  importRow{Row: 1, Title: "Buy some milk", CreatedAt: importTime(2021, 1, 2), Priority: db.TodoPriorityHigh},
+		},
+		{
+			name: "PriorityB",
+			line: "(B) Buy some milk",
+			row:  importRow{Row: 1, Title: "Buy some milk", Priority: db.TodoPriorityMedium},
+		},
+		{
+			name: "PriorityD",
+			line: "(D) Buy some milk",
+			row:  importRow{Row: 1, Title: "Buy some milk", Priority: db.TodoPriorityLow},
+		},
+		{
+			name: "Due",
+			line: "Pay the rent due:2021-02-01",
+			row:  importRow{Row: 1, Title: "Pay the rent", DueAt: importTime(2021, 2, 1)},
+		},
+		{
+			name: "InvalidDue",
+			line: "Pay the rent due:soon",
+			row:  importRow{Row: 1, Title: "Pay the rent", Errors: map[string][]string{"due": {"The date is invalid"}}},
+		},
+		{
+			name: "Tags",
+			line: "Call +family mom @phone",
+			row:  importRow{Row: 1, Title: "Call mom", Tags: []string{"family", "phone"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			rows, err := parseTodoTxtImport(strings.NewReader(tc.line + "\n"))
+			require.NoError(t, err)
+			require.Equal(t, []importRow{tc.row}, rows)
+		})
+	}
+}
+
+func TestParseTodoTxtImportSkipsBlankLines(t *testing.T) {
+	rows, err := parseTodoTxtImport(strings.NewReader("Buy some milk\n\n  \nPay the rent\n"))
+	require.NoError(t, err)
+	require.Equal(t, []importRow{
+		{Row: 1, Title: "Buy some milk"},
+		{Row: 4, Title: "Pay the rent"},
+	}, rows)
+}
+
+func TestParseTaskwarriorImport(t *testing.T) {
+	tasks := []string{
+		`{"description":"Buy some milk","status":"pending","entry":"20210102T030405Z","due":"20210201T000000Z","priority":"H","tags":["home","shop"]}`,
+		`{"description":"Pay the rent","status":"completed","entry":"20210102T030405Z","end":"20210105T000000Z","priority":"L"}`,
+		`{"description":"Forget this","status":"deleted"}`,
+		`{"description":"Water the plants","status":"pending","priority":"X"}`,
+	}
+
+	expected := []importRow{
+		{
+			Row:       1,
+			Title:     "Buy some milk",
+			CreatedAt: importDateTime(2021, 1, 2, 3, 4, 5),
+			DueAt:     importTime(2021, 2, 1),
+			Priority:  db.TodoPriorityHigh,
+			Tags:      []string{"home", "shop"},
+		},
+		{
+			Row:         2,
+			Title:       "Pay the rent",
+			IsCompleted: true,
+			CreatedAt:   importDateTime(2021, 1, 2, 3, 4, 5),
+			CompletedAt: importTime(2021, 1, 5),
+			Priority:    db.TodoPriorityLow,
+		},
+		{
+			Row:    4,
+			Title:  "Water the plants",
+			Errors: map[string][]string{"priority": {"The priority is invalid"}},
+		},
+	}
+
+	testCases := []struct {
+		name string
+		data string
+	}{
+		{name: "Array", data: "[" + strings.Join(tasks, ",\n") + "]"},
+		{name: "OnePerLine", data: strings.Join(tasks, "\n") + "\n"},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			rows, err := parseTaskwarriorImport(strings.NewReader(tc.data))
+			require.NoError(t, err)
+			require.Equal(t, expected, rows)
+		})
+	}
+}
+
+func TestParseCSVImport(t *testing.T) {
+	testCases := []struct {
+		name string
+		data string
+		row  importRow
+	}{
+		{
+			name: "Export",
+			data: "id,username,title,is_completed,created_at,completed_at,priority,due_at,tags\n" +
+				"3f1b,someone,Buy some milk,true,2021-01-02T03:04:05Z,2021-01-05T00:00:00Z,medium,2021-02-01T00:00:00Z,\"home,shop\"\n",
+			row: importRow{
+				Row:         2,
+				Title:       "Buy some milk",
+				IsCompleted: true,
+				CreatedAt:   importDateTime(2021, 1, 2, 3, 4, 5),
+				CompletedAt: importTime(2021, 1, 5),
+				Priority:    db.TodoPriorityMedium,
+				DueAt:       importTime(2021, 2, 1),
+				Tags:        []string{"home", "shop"},
+			},
+		},
+		{
+			name: "Aliases",
+			data: "Title,Done,Created,Completed_On,Due\nBuy some milk,yes,2021-01-02,2021-01-05,2021-02-01\n",
+			row: importRow{
+				Row:         2,
+				Title:       "Buy some milk",
+				IsCompleted: true,
+				CreatedAt:   importTime(2021, 1, 2),
+				CompletedAt: importTime(2021, 1, 5),
+				DueAt:       importTime(2021, 2, 1),
+				Tags:        []string{},
+			},
+		},
+		{
+			name: "CompletedAlias",
+			data: "title,completed,due_date\nBuy some milk,x,2021-02-01 10:00:00\n",
+			row: importRow{
+				Row:         2,
+				Title:       "Buy some milk",
+				IsCompleted: true,
+				DueAt:       importDateTime(2021, 2, 1, 10, 0, 0),
+				Tags:        []string{},
+			},
+		},
+		{
+			name: "StatusAlias",
+			data: "status,title,priority\ncompleted,Buy some milk,A\n",
+			row:  importRow{Row: 2, Title: "Buy some milk", IsCompleted: true, Priority: db.TodoPriorityHigh, Tags: []string{}},
+		},
+		{
+			name: "CompletionDateOfPendingTodo",
+			data: "title,completed_at\nBuy some milk,2021-01-05\n",
+			row:  importRow{Row: 2, Title: "Buy some milk", Tags: []string{}},
+		},
+		{
+			name: "Invalid",
+			data: "title,created_at,priority\nBuy some milk,yesterday,urgent\n",
+			row: importRow{
+				Row:   2,
+				Title: "Buy some milk",
+				Tags:  []string{},
+				Errors: map[string][]string{
+					"created_at": {"The date is invalid"},
+					"priority":   {"The priority is invalid"},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			rows, err := parseCSVImport(strings.NewReader(tc.data))
+			require.NoError(t, err)
+			require.Equal(t, []importRow{tc.row}, rows)
+		})
+	}
+}
+
+func TestParseCSVImportWithoutTitle(t *testing.T) {
+	_, err := parseCSVImport(strings.NewReader("name,done\nBuy some milk,true\n"))
+	require.Error(t, err)
+}
+
+func TestParseJSONImport(t *testing.T) {
+	data := `[
+		{"title":"Buy some milk","is_completed":true,"created_at":"2021-01-02T03:04:05Z","completed_at":"2021-01-05T00:00:00Z","priority":"low","due_at":"2021-02-01T00:00:00Z","tags":["home"]},
+		{"title":"Pay the rent","is_completed":false,"created_at":"0001-01-01T00:00:00Z","completed_at":null,"priority":null,"due_at":null,"tags":[]}
+	]`
+
+	rows, err := parseJSONImport(strings.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, []importRow{
+		{
+			Row:         1,
+			Title:       "Buy some milk",
+			IsCompleted: true,
+			CreatedAt:   importDateTime(2021, 1, 2, 3, 4, 5),
+			CompletedAt: importTime(2021, 1, 5),
+			Priority:    db.TodoPriorityLow,
+			DueAt:       importTime(2021, 2, 1),
+			Tags:        []string{"home"},
+		},
+		{Row: 2, Title: "Pay the rent", Tags: []string{}},
+	}, rows)
+}
+
+func importTime(year int, month time.Month, day int) *time.Time {
+	return importDateTime(year, month, day, 0, 0, 0)
+}
+
+func importDateTime(year int, month time.Month, day int, hour int, min int, sec int) *time.Time {
+	t := time.Date(year, month, day, hour, min, sec, 0, time.UTC)
+	return &t
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sbbullet/to-do/db"
+	"github.com/sbbullet/to-do/util"
+)
+
+// checkLoginAllowed responds with an error telling when to try again if too
+// many logins have failed lately, either for the username or from the client
+func (s *Server) checkLoginAllowed(w http.ResponseWriter, r *http.Request, username string) (bool, error) {
+	retryAfter, err := s.loginRetryAfter(db.LoginFailureKindUsername, username, s.config.LoginFreeAttempts, s.config.LoginLockoutAttempts)
+	if err != nil {
+		return false, err
+	}
+
+	ipRetryAfter, err := s.loginRetryAfter(db.LoginFailureKindIP, s.clientIP(r), s.config.LoginIPFreeAttempts, s.config.LoginIPLockoutAttempts)
+	if err != nil {
+		return false, err
+	}
+
+	if ipRetryAfter > retryAfter {
+		retryAfter = ipRetryAfter
+	}
+
+	if retryAfter <= 0 {
+		return true, nil
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	util.RespondWithTooManyRequestsError(w, "Too many failed login attempts. Please, try again later")
+
+	return false, nil
+}
+
+// loginRetryAfter gives how long is left to wait before logging in again is allowed
+func (s *Server) loginRetryAfter(kind string, identifier string, freeAttempts int, lockoutAttempts int) (time.Duration, error) {
+	loginFailure, err := s.store.GetLoginFailure(db.GetLoginFailureParams{Kind: kind, Identifier: identifier})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	if time.Since(loginFailure.LastFailedAt) >= s.config.LoginFailureWindow {
+		return 0, nil
+	}
+
+	wait := loginBackoff(loginFailure.Failures, freeAttempts, lockoutAttempts, s.config.LoginBackoffBase, s.config.LoginLockoutDuration)
+
+	return time.Until(loginFailure.LastFailedAt.Add(wait)), nil
+}
+
+// recordLoginFailure counts a failed login for the username and the client
+func (s *Server) recordLoginFailure(r *http.Request, username string) error {
+	now := time.Now()
+	forgetBefore := now.Add(-s.config.LoginFailureWindow)
+
+	_, err := s.store.RecordLoginFailure(db.RecordLoginFailureParams{
+		Kind:         db.LoginFailureKindUsername,
+		Identifier:   username,
+		FailedAt:     now,
+		ForgetBefore: forgetBefore,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.store.RecordLoginFailure(db.RecordLoginFailureParams{
+		Kind:         db.LoginFailureKindIP,
+		Identifier:   s.clientIP(r),
+		FailedAt:     now,
+		ForgetBefore: forgetBefore,
+	})
+
+	return err
+}
+
+// loginBackoff gives how long to wait after the last of so many failed logins.
+// The first ones are free, then the wait doubles with each failure until the
+// lockout, which is also as long as the wait ever gets.
+func loginBackoff(failures int, freeAttempts int, lockoutAttempts int, base time.Duration, lockout time.Duration) time.Duration {
+	if failures >= lockoutAttempts {
+		return lockout
+	}
+
+	if failures <= freeAttempts {
+		return 0
+	}
+
+	// Shifting by too much would overflow, and waits that long are capped anyway
+	shift := failures - freeAttempts - 1
+	if shift >= 32 {
+		return lockout
+	}
+
+	wait := base << shift
+	if wait > lockout || wait <= 0 {
+		return lockout
+	}
+
+	return wait
+}
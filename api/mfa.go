@@ -0,0 +1,432 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/sbbullet/to-do/db"
+	"github.com/sbbullet/to-do/logger"
+	"github.com/sbbullet/to-do/util"
+)
+
+// recoveryCodeCount is how many recovery codes users get at a time
+const recoveryCodeCount = 10
+
+type totpStatusResponse struct {
+	Enabled           bool       `json:"enabled"`
+	EnabledAt         *time.Time `json:"enabled_at"`
+	RecoveryCodesLeft int        `json:"recovery_codes_left"`
+}
+
+type enrollTOTPResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+	QRCode string `json:"qr_code"`
+}
+
+type confirmTOTPRequest struct {
+	Code string `json:"code" validate:"required,numeric,len=6"`
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type mfaRequiredResponse struct {
+	MFARequired       bool      `json:"mfa_required"`
+	MFAToken          string    `json:"mfa_token"`
+	MFATokenExpiresAt time.Time `json:"mfa_token_expires_at"`
+}
+
+// verifyMFARequest finishes logging in with either a code of the authenticator
+// app or one of the recovery codes
+type verifyMFARequest struct {
+	MFAToken     string `json:"mfa_token" validate:"required"`
+	Code         string `json:"code" validate:"required_without=RecoveryCode"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// Handler to tell whether the authorized user has turned on TOTP
+func (s *Server) GetTOTPStatus(w http.ResponseWriter, r *http.Request) {
+	username := r.Header.Get(authUsernameHeaderKey)
+
+	userTOTP, err := s.store.GetUserTOTP(username)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	recoveryCodesLeft, err := s.store.CountUnusedRecoveryCodes(username)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	util.RespondWithOk(w, totpStatusResponse{
+		Enabled:           userTOTP.ConfirmedAt != nil,
+		EnabledAt:         userTOTP.ConfirmedAt,
+		RecoveryCodesLeft: recoveryCodesLeft,
+	})
+}
+
+// Handler to start turning on TOTP for the authorized user, giving the secret
+// to add to their authenticator app. It's only turned on once they confirm a code.
+func (s *Server) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	user, err := s.store.GetUser(r.Header.Get(authUsernameHeaderKey))
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	key, err := util.GenerateTOTPKey(s.config.TOTPIssuer, user.Email)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	_, err = s.store.CreateUserTOTP(db.CreateUserTOTPParams{
+		Username: user.Username,
+		Secret:   key.Secret,
+	})
+	if err != nil {
+		if errors.Is(err, db.ErrTOTPAlreadyConfirmed) {
+			util.RespondWithBadRequest(w, "Two-factor authentication is already turned on")
+			return
+		}
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	util.RespondWithOk(w, enrollTOTPResponse{
+		Secret: key.Secret,
+		URI:    key.URI,
+		QRCode: key.QRCode,
+	})
+}
+
+// Handler to turn on TOTP for the authorized user with the first code of their
+// authenticator app, giving their recovery codes. They are only shown this once.
+func (s *Server) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	var req confirmTOTPRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		util.RespondWithBadRequest(w, "Invalid request payload")
+		return
+	}
+
+	validationErrors := validateRequest(req)
+	if validationErrors != nil {
+		util.RespondWithValidationErrors(w, validationErrors)
+		return
+	}
+
+	username := r.Header.Get(authUsernameHeaderKey)
+
+	userTOTP, err := s.store.GetUserTOTP(username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			util.RespondWithBadRequest(w, "Please, start setting up two-factor authentication first")
+			return
+		}
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	if userTOTP.ConfirmedAt != nil {
+		util.RespondWithBadRequest(w, "Two-factor authentication is already turned on")
+		return
+	}
+
+	step, ok := util.ValidateTOTP(userTOTP.Secret, req.Code, time.Now())
+	if !ok {
+		util.RespondWithValidationErrors(w, map[string][]string{
+			"code": {"This code is invalid"},
+		})
+		return
+	}
+
+	recoveryCodes, recoveryCodeHashes, err := generateRecoveryCodes()
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	_, err = s.store.ConfirmUserTOTP(db.ConfirmUserTOTPParams{
+		Username:           username,
+		Step:               step,
+		RecoveryCodeHashes: recoveryCodeHashes,
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			util.RespondWithBadRequest(w, "Two-factor authentication is already turned on")
+			return
+		}
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	util.RespondWithOk(w, recoveryCodesResponse{RecoveryCodes: recoveryCodes})
+}
+
+// Handler to turn off TOTP for the authorized user, once they have confirmed
+// it with their password
+func (s *Server) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	var req passwordConfirmationRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		util.RespondWithBadRequest(w, "Invalid request payload")
+		return
+	}
+
+	validationErrors := validateRequest(req)
+	if validationErrors != nil {
+		util.RespondWithValidationErrors(w, validationErrors)
+		return
+	}
+
+	user, ok := s.confirmPassword(w, r, req.Password)
+	if !ok {
+		return
+	}
+
+	if err := s.store.DeleteUserTOTP(user.Username); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			util.RespondWithBadRequest(w, "Two-factor authentication is not turned on")
+			return
+		}
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	util.RespondWithOk(w, "Two-factor authentication has been turned off")
+}
+
+// Handler to replace the recovery codes of the authorized user with new ones,
+// once they have confirmed it with their password
+func (s *Server) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	var req passwordConfirmationRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		util.RespondWithBadRequest(w, "Invalid request payload")
+		return
+	}
+
+	validationErrors := validateRequest(req)
+	if validationErrors != nil {
+		util.RespondWithValidationErrors(w, validationErrors)
+		return
+	}
+
+	user, ok := s.confirmPassword(w, r, req.Password)
+	if !ok {
+		return
+	}
+
+	userTOTP, err := s.store.GetUserTOTP(user.Username)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	if userTOTP.ConfirmedAt == nil {
+		util.RespondWithBadRequest(w, "Two-factor authentication is not turned on")
+		return
+	}
+
+	recoveryCodes, recoveryCodeHashes, err := generateRecoveryCodes()
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	err = s.store.ReplaceRecoveryCodes(db.ReplaceRecoveryCodesParams{
+		Username:   user.Username,
+		CodeHashes: recoveryCodeHashes,
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	util.RespondWithOk(w, recoveryCodesResponse{RecoveryCodes: recoveryCodes})
+}
+
+// startMFAChallenge responds with the token the user, who has only logged in
+// with their first factor so far, has to send back along with a code
+func (s *Server) startMFAChallenge(w http.ResponseWriter, user db.User, scopes []string) {
+	mfaToken, err := util.RandomSecret(32)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	challenge, err := s.store.CreateMFAChallenge(db.CreateMFAChallengeParams{
+		TokenHash: util.HashSecret(mfaToken),
+		Username:  user.Username,
+		Scopes:    scopes,
+		ExpiresAt: time.Now().Add(s.config.MFATokenDuration),
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	util.RespondWithOk(w, mfaRequiredResponse{
+		MFARequired:       true,
+		MFAToken:          mfaToken,
+		MFATokenExpiresAt: challenge.ExpiresAt,
+	})
+}
+
+// Handler to finish logging in a user who has TOTP turned on, exchanging the
+// token they got from LoginUser and a code for a session. Wrong codes count
+// as failed logins, so guessing them is throttled like guessing passwords.
+func (s *Server) VerifyMFALogin(w http.ResponseWriter, r *http.Request) {
+	var req verifyMFARequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		util.RespondWithBadRequest(w, "Invalid request payload")
+		return
+	}
+
+	validationErrors := validateRequest(req)
+	if validationErrors != nil {
+		util.RespondWithValidationErrors(w, validationErrors)
+		return
+	}
+
+	tokenHash := util.HashSecret(req.MFAToken)
+
+	challenge, err := s.store.GetMFAChallenge(tokenHash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			util.RespondWithUauthorizedError(w, "This login has expired. Please, log in again")
+			return
+		}
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	allowed, err := s.checkLoginAllowed(w, r, challenge.Username)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	if !allowed {
+		return
+	}
+
+	ok, err := s.verifySecondFactor(challenge.Username, req)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	if !ok {
+		err := s.store.FailMFAChallenge(db.FailMFAChallengeParams{TokenHash: tokenHash, MaxFailures: s.config.MFAMaxFailures})
+		if err != nil {
+			logger.Error(err.Error())
+		}
+		if err := s.recordLoginFailure(r, challenge.Username); err != nil {
+			logger.Error(err.Error())
+		}
+		util.RespondWithUauthorizedError(w, "This code is invalid")
+		return
+	}
+
+	// The challenge is only met once, even if the code is sent twice at the same time
+	challenge, err = s.store.ConsumeMFAChallenge(tokenHash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			util.RespondWithUauthorizedError(w, "This login has expired. Please, log in again")
+			return
+		}
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	user, err := s.store.GetUser(challenge.Username)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	s.completeLogin(w, r, user, challenge.Scopes)
+}
+
+// verifySecondFactor checks the code or recovery code of the request, using it up
+func (s *Server) verifySecondFactor(username string, req verifyMFARequest) (bool, error) {
+	if len(req.Code) == 0 {
+		err := s.store.UseRecoveryCode(db.UseRecoveryCodeParams{
+			Username: username,
+			CodeHash: util.HashRecoveryCode(req.RecoveryCode),
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	userTOTP, err := s.store.GetUserTOTP(username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	step, ok := util.ValidateTOTP(userTOTP.Secret, req.Code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	// Codes can't be used twice, so one seen over someone's shoulder is no use
+	err = s.store.UseTOTPStep(db.UseTOTPStepParams{Username: username, Step: step})
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// generateRecoveryCodes generates a set of recovery codes along with their hashes
+func generateRecoveryCodes() ([]string, []string, error) {
+	recoveryCodes, err := util.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	recoveryCodeHashes := make([]string, len(recoveryCodes))
+	for i, recoveryCode := range recoveryCodes {
+		recoveryCodeHashes[i] = util.HashRecoveryCode(recoveryCode)
+	}
+
+	return recoveryCodes, recoveryCodeHashes, nil
+}
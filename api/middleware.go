@@ -1,13 +1,18 @@
 package api
 
 import (
+	"bufio"
 	"context"
 	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"runtime/debug"
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
 	"github.com/sbbullet/to-do/logger"
 	"github.com/sbbullet/to-do/token"
 	"github.com/sbbullet/to-do/util"
@@ -49,6 +54,18 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.wroteHeader = true
 }
 
+// Hijack lets websocket handlers take over the connection through the wrapper
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+
+	rw.status = http.StatusSwitchingProtocols
+	rw.wroteHeader = true
+	return hijacker.Hijack()
+}
+
 // LoggingMiddleware logs the incoming HTTP request & its duration.
 func LoggingMiddleware() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -70,7 +87,7 @@ func LoggingMiddleware() func(http.Handler) http.Handler {
 			logger.Info(
 				"Request",
 				zap.String("Method", r.Method),
-				zap.String("Endpoint", r.RequestURI),
+				zap.String("Endpoint", loggedEndpoint(r)),
 				zap.Int("Status", wrapped.status),
 				zap.Duration("Duration", time.Since(start)),
 			)
@@ -80,8 +97,20 @@ func LoggingMiddleware() func(http.Handler) http.Handler {
 	}
 }
 
-// AuthMiddleware checks for authorization header and extracts payload if authorized
-func AuthMiddleware(tokenMaker token.Maker) func(http.Handler) http.Handler {
+// loggedEndpoint is the endpoint of the request as it's safe to log, leaving
+// out the query, which can carry an access token, and the secret token of the
+// calendar feed URLs
+func loggedEndpoint(r *http.Request) string {
+	if secret, ok := mux.Vars(r)["token"]; ok && len(secret) > 0 {
+		return strings.Replace(r.URL.Path, "/"+secret, "/REDACTED", 1)
+	}
+
+	return r.URL.Path
+}
+
+// AuthMiddleware checks for authorization header and extracts payload if
+// authorized, accepting both access tokens and API keys
+func (server *Server) AuthMiddleware() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			authorizationHeader := r.Header.Get(authorizationHeaderKey)
@@ -102,20 +131,130 @@ func AuthMiddleware(tokenMaker token.Maker) func(http.Handler) http.Handler {
 			}
 
 			authToken := fields[1]
-			payload, err := tokenMaker.VerifyToken(authToken)
-			if err != nil {
-				if errors.Is(err, token.ErrTokenExpired) {
-					util.RespondWithUauthorizedError(w, "Your session has expired. Please, log in again to new session")
-					return
-				}
-				util.RespondWithUauthorizedError(w, "You are not authorized to perform the action")
+			var payload *token.Payload
+			var ok bool
+			if strings.HasPrefix(authToken, apiKeyPrefix) {
+				payload, ok = server.authorizeAPIKey(w, authToken)
+			} else {
+				payload, ok = server.authorizeAccessToken(w, authToken)
+			}
+			if !ok {
 				return
 			}
 
+			// Impersonators act as the user they impersonate
 			r.Header.Set(authUsernameHeaderKey, payload.Username)
 
 			ctx := context.WithValue(r.Context(), authorizationPayloadKey, payload)
-			next.ServeHTTP(w, r.WithContext(ctx))
+			if len(payload.Impersonator) == 0 {
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
+			wrapped := wrapResponseWriter(w)
+			next.ServeHTTP(wrapped, r.WithContext(ctx))
+			logger.Info(
+				"Impersonated request",
+				zap.String("Impersonator", payload.Impersonator),
+				zap.String("Username", payload.Username),
+				zap.String("Method", r.Method),
+				zap.String("Endpoint", loggedEndpoint(r)),
+				zap.Int("Status", wrapped.status),
+			)
+		})
+	}
+}
+
+// authorizeAccessToken verifies the access token, responding with an error
+// if it's invalid, expired or revoked
+func (server *Server) authorizeAccessToken(w http.ResponseWriter, accessToken string) (*token.Payload, bool) {
+	payload, err := server.tokenMaker.VerifyToken(accessToken)
+	if err != nil {
+		if errors.Is(err, token.ErrTokenExpired) {
+			util.RespondWithUauthorizedError(w, "Your session has expired. Please, log in again to new session")
+			return nil, false
+		}
+		util.RespondWithUauthorizedError(w, "You are not authorized to perform the action")
+		return nil, false
+	}
+
+	if server.revocations.isRevoked(payload) {
+		util.RespondWithUauthorizedError(w, "Your session has ended. Please, log in again to new session")
+		return nil, false
+	}
+
+	if payload.SessionID != uuid.Nil {
+		if err := server.sessions.touch(payload.SessionID); err != nil {
+			logger.Error(err.Error())
+		}
+	}
+
+	return payload, true
+}
+
+// RequireScope only lets requests through if the token they were authorized
+// with has been granted the scope. It must run after AuthMiddleware.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			payload := authPayload(r)
+			if payload == nil {
+				util.RespondWithUauthorizedError(w, "You are not authorized to perform the action")
+				return
+			}
+
+			if !payload.HasScope(scope) {
+				util.RespondWithForbiddenError(w, fmt.Sprintf("This action requires the %s scope", scope))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireRole only lets requests through if the token they were authorized
+// with was issued to a user with the role. It must run after AuthMiddleware.
+func RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			payload := authPayload(r)
+			if payload == nil {
+				util.RespondWithUauthorizedError(w, "You are not authorized to perform the action")
+				return
+			}
+
+			if payload.Role != role {
+				util.RespondWithForbiddenError(w, "You are not allowed to perform the action")
+				return
+			}
+
+			next.ServeHTTP(w, r)
 		})
 	}
 }
+
+// ForbidImpersonation keeps impersonators out of sensitive operations. It
+// must run after AuthMiddleware.
+func ForbidImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := authPayload(r)
+		if payload == nil {
+			util.RespondWithUauthorizedError(w, "You are not authorized to perform the action")
+			return
+		}
+
+		if len(payload.Impersonator) > 0 {
+			util.RespondWithForbiddenError(w, "This action isn't allowed while impersonating a user")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authPayload gets the payload of the token AuthMiddleware authorized the request with
+func authPayload(r *http.Request) *token.Payload {
+	payload, _ := r.Context().Value(authorizationPayloadKey).(*token.Payload)
+	return payload
+}
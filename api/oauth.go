@@ -0,0 +1,867 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/sbbullet/to-do/db"
+	"github.com/sbbullet/to-do/logger"
+	"github.com/sbbullet/to-do/token"
+	"github.com/sbbullet/to-do/util"
+)
+
+const (
+	// oauthCodeDuration is how long clients have to exchange an authorization code
+	oauthCodeDuration = 10 * time.Minute
+	// pkceMethodS256 is the only PKCE method accepted, as plain doesn't protect
+	// codes intercepted along with the authorization request
+	pkceMethodS256 = "S256"
+)
+
+// Error codes of RFC 6749
+const (
+	oauthErrorInvalidRequest          = "invalid_request"
+	oauthErrorInvalidClient           = "invalid_client"
+	oauthErrorInvalidGrant            = "invalid_grant"
+	oauthErrorInvalidScope            = "invalid_scope"
+	oauthErrorAccessDenied            = "access_denied"
+	oauthErrorUnsupportedGrantType    = "unsupported_grant_type"
+	oauthErrorUnsupportedResponseType = "unsupported_response_type"
+)
+
+type createOAuthClientRequest struct {
+	Name         string   `json:"name" validate:"required,max=64"`
+	RedirectURIs []string `json:"redirect_uris" validate:"required,min=1,max=10,dive,url,excludes=0x2C"`
+	Scopes       []string `json:"scopes" validate:"required,min=1,dive,oneof=todos:read todos:write"`
+	Confidential bool     `json:"confidential"`
+}
+
+type oauthClientResponse struct {
+	ID           uuid.UUID `json:"client_id"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	Confidential bool      `json:"confidential"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type createdOAuthClientResponse struct {
+	oauthClientResponse
+	Secret string `json:"client_secret,omitempty"`
+}
+
+func createOAuthClientResponse(client db.OAuthClient) oauthClientResponse {
+	return oauthClientResponse{
+		ID:           client.ID,
+		Name:         client.Name,
+		RedirectURIs: client.RedirectURIs,
+		Scopes:       client.Scopes,
+		Confidential: len(client.SecretHash) > 0,
+		CreatedAt:    client.CreatedAt,
+	}
+}
+
+// Register OAuth client owned by the authorized user
+func (s *Server) CreateOAuthClient(w http.ResponseWriter, r *http.Request) {
+	var req createOAuthClientRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		util.RespondWithBadRequest(w, "Invalid request payload")
+		return
+	}
+
+	validationErrors := validateRequest(req)
+	if validationErrors != nil {
+		util.RespondWithValidationErrors(w, validationErrors)
+		return
+	}
+
+	clientID, err := uuid.NewRandom()
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	// Public clients, like mobile and single-page apps, can't keep a secret
+	// and rely on PKCE alone
+	var secret, secretHash string
+	if req.Confidential {
+		secret, err = util.RandomSecret(32)
+		if err != nil {
+			logger.Error(err.Error())
+			util.RespondWithInternalServerError(w)
+			return
+		}
+		secretHash = util.HashSecret(secret)
+	}
+
+	arg := db.CreateOAuthClientParams{
+		ID:           clientID,
+		Username:     r.Header.Get(authUsernameHeaderKey),
+		Name:         req.Name,
+		RedirectURIs: req.RedirectURIs,
+		Scopes:       req.Scopes,
+		SecretHash:   secretHash,
+	}
+
+	client, err := s.store.CreateOAuthClient(arg)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	// Only the hash is stored, so the secret is only ever shown on registration
+	util.RespondWithOk(w, createdOAuthClientResponse{
+		oauthClientResponse: createOAuthClientResponse(client),
+		Secret:              secret,
+	})
+}
+
+// Get OAuth clients owned by the authorized user
+func (s *Server) GetUserOAuthClients(w http.ResponseWriter, r *http.Request) {
+	clients, err := s.store.GetUserOAuthClients(r.Header.Get(authUsernameHeaderKey))
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	clientsToSend := []oauthClientResponse{}
+	for _, client := range clients {
+		clientsToSend = append(clientsToSend, createOAuthClientResponse(client))
+	}
+
+	util.RespondWithOk(w, clientsToSend)
+}
+
+// Delete specified OAuth client of the authorized user, revoking every grant
+// users made to it
+func (s *Server) DeleteOAuthClient(w http.ResponseWriter, r *http.Request) {
+	clientID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		util.RespondWithBadRequest(w, "Invalid OAuth client identifier")
+		return
+	}
+
+	revokedSessions, err := s.store.DeleteOAuthClientOfAUser(db.DeleteOAuthClientOfAUserParams{
+		ID:       clientID,
+		Username: r.Header.Get(authUsernameHeaderKey),
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			util.RespondWithNotFoundError(w, "Oops!! We couldn't find the associated OAuth client")
+			return
+		}
+
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	for _, session := range revokedSessions {
+		s.revocations.sessionRevoked(session.ID)
+	}
+
+	util.RespondWithOk(w, "Successfully deleted specified OAuth client")
+}
+
+type authorizationRequest struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+}
+
+type consentRequest struct {
+	authorizationRequest
+	Approved bool `json:"approved"`
+}
+
+type authorizationResponse struct {
+	Client          oauthClientResponse `json:"client"`
+	Scopes          []string            `json:"scopes"`
+	ConsentRequired bool                `json:"consent_required"`
+	RedirectTo      string              `json:"redirect_to,omitempty"`
+}
+
+type consentResponse struct {
+	RedirectTo string `json:"redirect_to"`
+}
+
+// Start authorizing an OAuth client on behalf of the authorized user. Unless
+// the user already allowed the client everything it asks for, the app has to
+// ask for their consent, otherwise it can send them straight back to the client.
+func (s *Server) AuthorizeOAuthClient(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	req := authorizationRequest{
+		ResponseType:        query.Get("response_type"),
+		ClientID:            query.Get("client_id"),
+		RedirectURI:         query.Get("redirect_uri"),
+		Scope:               query.Get("scope"),
+		State:               query.Get("state"),
+		CodeChallenge:       query.Get("code_challenge"),
+		CodeChallengeMethod: query.Get("code_challenge_method"),
+	}
+
+	client, redirectURI, scopes, ok := s.checkAuthorizationRequest(w, req)
+	if !ok {
+		return
+	}
+
+	response := authorizationResponse{
+		Client:          createOAuthClientResponse(client),
+		Scopes:          scopes,
+		ConsentRequired: true,
+	}
+
+	username := r.Header.Get(authUsernameHeaderKey)
+	consent, err := s.store.GetOAuthConsent(db.GetOAuthConsentParams{Username: username, ClientID: client.ID})
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	if err == nil && containsAll(consent.Scopes, scopes) {
+		redirectTo, err := s.issueAuthorizationCode(client, username, redirectURI, scopes, req)
+		if err != nil {
+			logger.Error(err.Error())
+			util.RespondWithInternalServerError(w)
+			return
+		}
+
+		response.ConsentRequired = false
+		response.RedirectTo = redirectTo
+	}
+
+	util.RespondWithOk(w, response)
+}
+
+// Record whether the authorized user allows the OAuth client what it asked
+// for, telling where to send them back to the client
+func (s *Server) ConsentToOAuthClient(w http.ResponseWriter, r *http.Request) {
+	var req consentRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		util.RespondWithBadRequest(w, "Invalid request payload")
+		return
+	}
+
+	client, redirectURI, scopes, ok := s.checkAuthorizationRequest(w, req.authorizationRequest)
+	if !ok {
+		return
+	}
+
+	if !req.Approved {
+		util.RespondWithOk(w, consentResponse{
+			RedirectTo: authorizationErrorRedirect(redirectURI, req.State, oauthErrorAccessDenied, "The user denied the request"),
+		})
+		return
+	}
+
+	username := r.Header.Get(authUsernameHeaderKey)
+	consent, err := s.store.GetOAuthConsent(db.GetOAuthConsentParams{Username: username, ClientID: client.ID})
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	// Scopes allowed earlier stay allowed
+	_, err = s.store.GrantOAuthConsent(db.GrantOAuthConsentParams{
+		Username: username,
+		ClientID: client.ID,
+		Scopes:   mergeScopes(consent.Scopes, scopes),
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	redirectTo, err := s.issueAuthorizationCode(client, username, redirectURI, scopes, req.authorizationRequest)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	util.RespondWithOk(w, consentResponse{RedirectTo: redirectTo})
+}
+
+// checkAuthorizationRequest validates the authorization request, responding
+// with an error if it's invalid. Until the client and its redirect URI are
+// known to be good errors are shown to the user, after that they are sent back
+// to the client through the redirect URI as RFC 6749 asks.
+func (s *Server) checkAuthorizationRequest(w http.ResponseWriter, req authorizationRequest) (db.OAuthClient, string, []string, bool) {
+	clientID, err := uuid.Parse(req.ClientID)
+	if err != nil {
+		util.RespondWithBadRequest(w, "Unknown OAuth client")
+		return db.OAuthClient{}, "", nil, false
+	}
+
+	client, err := s.store.GetOAuthClientById(clientID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			util.RespondWithBadRequest(w, "Unknown OAuth client")
+			return db.OAuthClient{}, "", nil, false
+		}
+
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return db.OAuthClient{}, "", nil, false
+	}
+
+	redirectURI := req.RedirectURI
+	if len(redirectURI) == 0 && len(client.RedirectURIs) == 1 {
+		redirectURI = client.RedirectURIs[0]
+	}
+
+	if !containsAll(client.RedirectURIs, []string{redirectURI}) {
+		util.RespondWithBadRequest(w, "The redirect URI isn't registered for the OAuth client")
+		return db.OAuthClient{}, "", nil, false
+	}
+
+	respondWithRedirectError := func(code string, description string) {
+		util.RespondWithOk(w, consentResponse{
+			RedirectTo: authorizationErrorRedirect(redirectURI, req.State, code, description),
+		})
+	}
+
+	if req.ResponseType != "code" {
+		respondWithRedirectError(oauthErrorUnsupportedResponseType, "Only the code response type is supported")
+		return db.OAuthClient{}, "", nil, false
+	}
+
+	if len(req.CodeChallenge) == 0 || req.CodeChallengeMethod != pkceMethodS256 {
+		respondWithRedirectError(oauthErrorInvalidRequest, "PKCE with the S256 method is required")
+		return db.OAuthClient{}, "", nil, false
+	}
+
+	// Clients get every scope they were registered with unless they ask for less
+	scopes := strings.Fields(req.Scope)
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+
+	if !containsAll(client.Scopes, scopes) {
+		respondWithRedirectError(oauthErrorInvalidScope, "The OAuth client can't ask for these scopes")
+		return db.OAuthClient{}, "", nil, false
+	}
+
+	return client, redirectURI, scopes, true
+}
+
+// issueAuthorizationCode creates an authorization code for the client, giving
+// the redirect URI that hands it over
+func (s *Server) issueAuthorizationCode(client db.OAuthClient, username string, redirectURI string, scopes []string, req authorizationRequest) (string, error) {
+	code, err := util.RandomSecret(32)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = s.store.CreateOAuthAuthorizationCode(db.CreateOAuthAuthorizationCodeParams{
+		CodeHash:      util.HashSecret(code),
+		ClientID:      client.ID,
+		Username:      username,
+		RedirectURI:   redirectURI,
+		Scopes:        scopes,
+		CodeChallenge: req.CodeChallenge,
+		ExpiresAt:     time.Now().Add(oauthCodeDuration),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{"code": {code}}
+	if len(req.State) > 0 {
+		params.Set("state", req.State)
+	}
+
+	return withQuery(redirectURI, params), nil
+}
+
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+type oauthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// Issue tokens to an OAuth client for an authorization code or a refresh token
+func (s *Server) IssueOAuthToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, oauthErrorInvalidRequest, "Invalid request payload")
+		return
+	}
+
+	client, ok := s.authenticateOAuthClient(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		s.exchangeAuthorizationCode(w, r, client)
+	case "refresh_token":
+		s.exchangeOAuthRefreshToken(w, r, client)
+	default:
+		respondWithOAuthError(w, http.StatusBadRequest, oauthErrorUnsupportedGrantType, "Only the authorization_code and refresh_token grants are supported")
+	}
+}
+
+func (s *Server) exchangeAuthorizationCode(w http.ResponseWriter, r *http.Request, client db.OAuthClient) {
+	codeHash := util.HashSecret(r.PostForm.Get("code"))
+
+	code, err := s.store.GetOAuthAuthorizationCode(codeHash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithOAuthError(w, http.StatusBadRequest, oauthErrorInvalidGrant, "The authorization code is invalid")
+			return
+		}
+
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	if code.ClientID != client.ID || code.RedirectURI != r.PostForm.Get("redirect_uri") {
+		respondWithOAuthError(w, http.StatusBadRequest, oauthErrorInvalidGrant, "The authorization code was issued to another client or redirect URI")
+		return
+	}
+
+	if time.Now().After(code.ExpiresAt) {
+		respondWithOAuthError(w, http.StatusBadRequest, oauthErrorInvalidGrant, "The authorization code has expired")
+		return
+	}
+
+	if !verifyCodeChallenge(code.CodeChallenge, r.PostForm.Get("code_verifier")) {
+		respondWithOAuthError(w, http.StatusBadRequest, oauthErrorInvalidGrant, "The code verifier doesn't match the code challenge")
+		return
+	}
+
+	// The user may have been disabled since authorizing the client
+	user, err := s.store.GetUser(code.Username)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	if user.DisabledAt != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, oauthErrorInvalidGrant, "The authorization code is invalid")
+		return
+	}
+
+	refreshToken, err := util.RandomSecret(32)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	sessionID, err := uuid.NewRandom()
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	refreshTokenID, err := uuid.NewRandom()
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	session, err := s.store.ExchangeOAuthAuthorizationCode(db.ExchangeOAuthAuthorizationCodeParams{
+		CodeHash: codeHash,
+		Session: db.CreateSessionParams{
+			ID:               sessionID,
+			Username:         code.Username,
+			UserAgent:        client.Name,
+			ClientIP:         s.clientIP(r),
+			ClientID:         client.ID.String(),
+			Scopes:           code.Scopes,
+			RefreshTokenID:   refreshTokenID,
+			RefreshTokenHash: util.HashSecret(refreshToken),
+			ExpiresAt:        time.Now().Add(s.config.RefreshTokenDuration),
+		},
+	})
+	if err != nil {
+		if errors.Is(err, db.ErrAuthorizationCodeReused) {
+			// The code leaked, so whatever it was exchanged for can't be trusted
+			if code.SessionID != nil {
+				logger.Warn("Authorization code reused, revoked session " + code.SessionID.String())
+				s.revocations.sessionRevoked(*code.SessionID)
+			}
+			respondWithOAuthError(w, http.StatusBadRequest, oauthErrorInvalidGrant, "The authorization code has already been used")
+			return
+		}
+
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	s.respondWithOAuthTokens(w, session, session.Scopes, refreshToken)
+}
+
+func (s *Server) exchangeOAuthRefreshToken(w http.ResponseWriter, r *http.Request, client db.OAuthClient) {
+	refreshToken, err := util.RandomSecret(32)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	refreshTokenID, err := uuid.NewRandom()
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	session, err := s.store.RotateRefreshToken(db.RotateRefreshTokenParams{
+		ClientID:     client.ID.String(),
+		TokenHash:    util.HashSecret(r.PostForm.Get("refresh_token")),
+		NewTokenID:   refreshTokenID,
+		NewTokenHash: util.HashSecret(refreshToken),
+		NewExpiresAt: time.Now().Add(s.config.RefreshTokenDuration),
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows), errors.Is(err, db.ErrSessionInactive):
+			respondWithOAuthError(w, http.StatusBadRequest, oauthErrorInvalidGrant, "The refresh token is invalid or expired")
+		case errors.Is(err, db.ErrRefreshTokenReused):
+			logger.Warn("Refresh token reused, revoked session " + session.ID.String())
+			s.revocations.sessionRevoked(session.ID)
+			respondWithOAuthError(w, http.StatusBadRequest, oauthErrorInvalidGrant, "The refresh token has already been used")
+		default:
+			logger.Error(err.Error())
+			util.RespondWithInternalServerError(w)
+		}
+		return
+	}
+
+	// The access token can be narrowed down to some of the granted scopes
+	scopes := session.Scopes
+	if requested := strings.Fields(r.PostForm.Get("scope")); len(requested) > 0 {
+		if !containsAll(session.Scopes, requested) {
+			respondWithOAuthError(w, http.StatusBadRequest, oauthErrorInvalidScope, "The scopes weren't granted to the client")
+			return
+		}
+		scopes = requested
+	}
+
+	s.respondWithOAuthTokens(w, session, scopes, refreshToken)
+}
+
+func (s *Server) respondWithOAuthTokens(w http.ResponseWriter, session db.Session, scopes []string, refreshToken string) {
+	// The tokens of clients carry no role, so that no client can act as an admin
+	accessToken, accessTokenPayload, err := s.tokenMaker.CreateToken(token.PayloadParams{
+		Username:  session.Username,
+		Duration:  s.config.AccessTokenDuration,
+		SessionID: session.ID,
+		Scopes:    scopes,
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	util.RespondWithJSON(w, http.StatusOK, oauthTokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(time.Until(accessTokenPayload.ExpiresAt).Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(scopes, " "),
+	})
+}
+
+// Revoke a token issued to the OAuth client as described by RFC 7009.
+// Revoking a refresh token ends the whole grant, revoking an access token only
+// the token itself.
+func (s *Server) RevokeOAuthToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, oauthErrorInvalidRequest, "Invalid request payload")
+		return
+	}
+
+	client, ok := s.authenticateOAuthClient(w, r)
+	if !ok {
+		return
+	}
+
+	tokenToRevoke := r.PostForm.Get("token")
+	if len(tokenToRevoke) == 0 {
+		respondWithOAuthError(w, http.StatusBadRequest, oauthErrorInvalidRequest, "The token to revoke is missing")
+		return
+	}
+
+	if session, _, ok := s.oauthRefreshTokenSession(client, tokenToRevoke); ok {
+		_, err := s.revocations.revokeSession(db.RevokeSessionOfAUserParams{ID: session.ID, Username: session.Username})
+		if err != nil {
+			logger.Error(err.Error())
+			util.RespondWithInternalServerError(w)
+			return
+		}
+	} else if payload, ok := s.oauthAccessTokenPayload(client, tokenToRevoke); ok {
+		if err := s.revocations.revoke(payload); err != nil {
+			logger.Error(err.Error())
+			util.RespondWithInternalServerError(w)
+			return
+		}
+	}
+
+	// Unknown tokens are answered the same way, they are as good as revoked
+	w.WriteHeader(http.StatusOK)
+}
+
+type introspectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+}
+
+// Tell the OAuth client whether a token issued to it is active as described by
+// RFC 7662. Tokens of other clients are reported as inactive.
+func (s *Server) IntrospectOAuthToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, oauthErrorInvalidRequest, "Invalid request payload")
+		return
+	}
+
+	client, ok := s.authenticateOAuthClient(w, r)
+	if !ok {
+		return
+	}
+
+	tokenToIntrospect := r.PostForm.Get("token")
+	response := introspectionResponse{}
+
+	if session, refreshToken, ok := s.oauthRefreshTokenSession(client, tokenToIntrospect); ok {
+		now := time.Now()
+		if refreshToken.UsedAt == nil && session.RevokedAt == nil && now.Before(session.ExpiresAt) && now.Before(refreshToken.ExpiresAt) {
+			response = introspectionResponse{
+				Active:    true,
+				Scope:     strings.Join(session.Scopes, " "),
+				ClientID:  session.ClientID,
+				Username:  session.Username,
+				TokenType: "refresh_token",
+				ExpiresAt: refreshToken.ExpiresAt.Unix(),
+				IssuedAt:  refreshToken.CreatedAt.Unix(),
+				Subject:   session.Username,
+			}
+		}
+	} else if payload, ok := s.oauthAccessTokenPayload(client, tokenToIntrospect); ok {
+		response = introspectionResponse{
+			Active:    true,
+			Scope:     strings.Join(payload.Scopes, " "),
+			ClientID:  client.ID.String(),
+			Username:  payload.Username,
+			TokenType: "Bearer",
+			ExpiresAt: payload.ExpiresAt.Unix(),
+			IssuedAt:  payload.IssuedAt.Unix(),
+			Subject:   payload.Username,
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	util.RespondWithJSON(w, http.StatusOK, response)
+}
+
+// oauthRefreshTokenSession finds the session of a refresh token issued to the client
+func (s *Server) oauthRefreshTokenSession(client db.OAuthClient, refreshToken string) (db.Session, db.RefreshToken, bool) {
+	storedToken, err := s.store.GetRefreshTokenByHash(util.HashSecret(refreshToken))
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logger.Error(err.Error())
+		}
+		return db.Session{}, db.RefreshToken{}, false
+	}
+
+	session, err := s.store.GetSessionById(storedToken.SessionID)
+	if err != nil {
+		logger.Error(err.Error())
+		return db.Session{}, db.RefreshToken{}, false
+	}
+
+	if session.ClientID != client.ID.String() {
+		return db.Session{}, db.RefreshToken{}, false
+	}
+
+	return session, storedToken, true
+}
+
+// oauthAccessTokenPayload verifies an access token issued to the client,
+// failing if it's invalid, expired or revoked
+func (s *Server) oauthAccessTokenPayload(client db.OAuthClient, accessToken string) (*token.Payload, bool) {
+	payload, err := s.tokenMaker.VerifyToken(accessToken)
+	if err != nil || payload.SessionID == uuid.Nil || s.revocations.isRevoked(payload) {
+		return nil, false
+	}
+
+	session, err := s.store.GetSessionById(payload.SessionID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logger.Error(err.Error())
+		}
+		return nil, false
+	}
+
+	if session.ClientID != client.ID.String() || session.RevokedAt != nil {
+		return nil, false
+	}
+
+	return payload, true
+}
+
+// authenticateOAuthClient identifies the client calling the token endpoints,
+// responding with an error if it can't. Confidential clients authenticate with
+// their secret through HTTP basic authentication or the form, public clients
+// only tell their identifier.
+func (s *Server) authenticateOAuthClient(w http.ResponseWriter, r *http.Request) (db.OAuthClient, bool) {
+	clientID, secret, usedBasicAuth := r.BasicAuth()
+	if usedBasicAuth {
+		// RFC 6749 has both form-urlencoded before being put in the header
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	respondWithInvalidClient := func() {
+		if usedBasicAuth {
+			w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+		respondWithOAuthError(w, http.StatusUnauthorized, oauthErrorInvalidClient, "Client authentication failed")
+	}
+
+	id, err := uuid.Parse(clientID)
+	if err != nil {
+		respondWithInvalidClient()
+		return db.OAuthClient{}, false
+	}
+
+	client, err := s.store.GetOAuthClientById(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithInvalidClient()
+			return db.OAuthClient{}, false
+		}
+
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return db.OAuthClient{}, false
+	}
+
+	if len(client.SecretHash) > 0 && subtle.ConstantTimeCompare([]byte(client.SecretHash), []byte(util.HashSecret(secret))) != 1 {
+		respondWithInvalidClient()
+		return db.OAuthClient{}, false
+	}
+
+	return client, true
+}
+
+func respondWithOAuthError(w http.ResponseWriter, statusCode int, code string, description string) {
+	w.Header().Set("Cache-Control", "no-store")
+	util.RespondWithJSON(w, statusCode, oauthErrorResponse{Error: code, ErrorDescription: description})
+}
+
+// verifyCodeChallenge checks the code verifier against the S256 code challenge of RFC 7636
+func verifyCodeChallenge(codeChallenge string, codeVerifier string) bool {
+	if len(codeVerifier) < 43 || len(codeVerifier) > 128 {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(codeVerifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(codeChallenge)) == 1
+}
+
+func authorizationErrorRedirect(redirectURI string, state string, code string, description string) string {
+	params := url.Values{"error": {code}, "error_description": {description}}
+	if len(state) > 0 {
+		params.Set("state", state)
+	}
+
+	return withQuery(redirectURI, params)
+}
+
+// withQuery adds the parameters to the query the URI may already have
+func withQuery(uri string, params url.Values) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+
+	query := u.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// containsAll tells whether every one of the wanted values is among the given ones
+func containsAll(granted []string, wanted []string) bool {
+	for _, w := range wanted {
+		found := false
+		for _, g := range granted {
+			if g == w {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func mergeScopes(scopes []string, more []string) []string {
+	merged := append([]string{}, scopes...)
+	for _, scope := range more {
+		if !containsAll(merged, []string{scope}) {
+			merged = append(merged, scope)
+		}
+	}
+
+	return merged
+}
@@ -0,0 +1,414 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sbbullet/to-do/db"
+	"github.com/sbbullet/to-do/logger"
+	"github.com/sbbullet/to-do/oidc"
+	"github.com/sbbullet/to-do/util"
+	"go.uber.org/zap"
+)
+
+const (
+	// oidcLoginDuration is how long users have to log in with the provider
+	oidcLoginDuration = 10 * time.Minute
+	// maxUsernameAttempts is how many usernames are tried for a user signing
+	// up through a provider before giving up
+	maxUsernameAttempts = 5
+)
+
+var notAlphanumeric = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+
+// newOIDCProviders sets up the providers of OIDC_PROVIDERS_FILE, if any
+func newOIDCProviders(config *util.Config) (map[string]*oidc.Provider, error) {
+	providers := map[string]*oidc.Provider{}
+	if len(config.OIDCProvidersFile) == 0 {
+		return providers, nil
+	}
+
+	providerConfigs, err := oidc.LoadProvidersFile(config.OIDCProvidersFile)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, providerConfig := range providerConfigs {
+		providers[providerConfig.Name] = oidc.NewProvider(providerConfig, nil)
+	}
+
+	return providers, nil
+}
+
+type oidcAuthorizationResponse struct {
+	AuthorizationURL string `json:"authorization_url"`
+}
+
+type identityResponse struct {
+	Provider  string    `json:"provider"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func createIdentityResponse(identity db.UserIdentity) identityResponse {
+	return identityResponse{
+		Provider:  identity.Provider,
+		Email:     identity.Email,
+		CreatedAt: identity.CreatedAt,
+	}
+}
+
+// Start logging in with an external provider, giving the URL of the provider
+// to send the user to. It sends them back with the code and state to log in with.
+func (s *Server) StartOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	provider, ok := s.oidcProviders[mux.Vars(r)["provider"]]
+	if !ok {
+		util.RespondWithNotFoundError(w, "Oops!! We couldn't find the associated login provider")
+		return
+	}
+
+	s.startOIDCAuthorization(w, r, provider, "")
+}
+
+// Start linking an identity of an external provider to the authorized user
+func (s *Server) StartOIDCLink(w http.ResponseWriter, r *http.Request) {
+	provider, ok := s.oidcProviders[mux.Vars(r)["provider"]]
+	if !ok {
+		util.RespondWithNotFoundError(w, "Oops!! We couldn't find the associated login provider")
+		return
+	}
+
+	s.startOIDCAuthorization(w, r, provider, r.Header.Get(authUsernameHeaderKey))
+}
+
+// startOIDCAuthorization remembers the state, nonce and PKCE code verifier of
+// the authorization until the user comes back from the provider. Logins have
+// no username, links have the one of the user linking an identity.
+func (s *Server) startOIDCAuthorization(w http.ResponseWriter, r *http.Request, provider *oidc.Provider, username string) {
+	var secrets [3]string
+	for i := range secrets {
+		secret, err := util.RandomSecret(32)
+		if err != nil {
+			logger.Error(err.Error())
+			util.RespondWithInternalServerError(w)
+			return
+		}
+		secrets[i] = secret
+	}
+	state, nonce, codeVerifier := secrets[0], secrets[1], secrets[2]
+
+	_, err := s.store.CreateOIDCLoginState(db.CreateOIDCLoginStateParams{
+		StateHash:    util.HashSecret(state),
+		Provider:     provider.Config().Name,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		Username:     username,
+		ExpiresAt:    time.Now().Add(oidcLoginDuration),
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	authorizationURL, err := provider.AuthCodeURL(r.Context(), state, nonce, codeVerifier)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	util.RespondWithOk(w, oidcAuthorizationResponse{AuthorizationURL: authorizationURL})
+}
+
+// authenticateWithOIDC finishes logging in with an external provider,
+// responding with an error if it fails. Users logging in for the first time
+// get linked to the account with the same verified email or signed up, if
+// the provider allows it.
+func (s *Server) authenticateWithOIDC(w http.ResponseWriter, r *http.Request, req loginUserRequest) (db.User, bool) {
+	provider, claims, ok := s.finishOIDCAuthorization(w, r, req.Provider, req.Code, req.State, "")
+	if !ok {
+		return db.User{}, false
+	}
+
+	config := provider.Config()
+
+	identity, err := s.store.GetUserIdentity(db.GetUserIdentityParams{Provider: config.Name, Subject: claims.Subject})
+	if err == nil {
+		user, err := s.store.GetUser(identity.Username)
+		if err != nil {
+			logger.Error(err.Error())
+			util.RespondWithInternalServerError(w)
+			return db.User{}, false
+		}
+
+		return user, true
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return db.User{}, false
+	}
+
+	// Both sides must have verified the email, or whoever claimed it first
+	// would get the other's account
+	if config.LinkByEmail && claims.EmailVerified && len(claims.Email) > 0 {
+		user, err := s.store.GetUserByVerifiedEmail(claims.Email)
+		if err == nil {
+			_, err = s.store.CreateUserIdentity(db.CreateUserIdentityParams{
+				Provider: config.Name,
+				Subject:  claims.Subject,
+				Username: user.Username,
+				Email:    claims.Email,
+			})
+			if err != nil {
+				logger.Error(err.Error())
+				util.RespondWithInternalServerError(w)
+				return db.User{}, false
+			}
+
+			return user, true
+		}
+
+		if !errors.Is(err, sql.ErrNoRows) {
+			logger.Error(err.Error())
+			util.RespondWithInternalServerError(w)
+			return db.User{}, false
+		}
+	}
+
+	if !config.AllowSignup {
+		util.RespondWithForbiddenError(w, fmt.Sprintf("No account is linked with this %s identity", config.Name))
+		return db.User{}, false
+	}
+
+	return s.signUpWithOIDC(w, config, claims)
+}
+
+// signUpWithOIDC creates the account of a user logging in with a provider
+// for the first time. They have no password, so they can only log in with it.
+func (s *Server) signUpWithOIDC(w http.ResponseWriter, config oidc.ProviderConfig, claims *oidc.Claims) (db.User, bool) {
+	if len(claims.Email) == 0 {
+		util.RespondWithForbiddenError(w, fmt.Sprintf("Your %s account must share its email address to sign up", config.Name))
+		return db.User{}, false
+	}
+
+	_, err := s.store.GetUserByEmail(claims.Email)
+	if err == nil {
+		util.RespondWithForbiddenError(w, fmt.Sprintf("An account with this email address already exists. Log in to link it with %s", config.Name))
+		return db.User{}, false
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return db.User{}, false
+	}
+
+	username := usernameFromClaims(claims)
+	fullName := claims.Name
+	if len(fullName) == 0 {
+		fullName = username
+	}
+
+	// There's no need to verify an email the provider already did
+	var emailVerifiedAt *time.Time
+	if claims.EmailVerified {
+		now := time.Now()
+		emailVerifiedAt = &now
+	}
+
+	for attempt := 1; ; attempt++ {
+		user, _, err := s.store.CreateUserWithIdentity(db.CreateUserWithIdentityParams{
+			User: db.CreateUserParams{
+				Username:        username,
+				Email:           claims.Email,
+				FullName:        fullName,
+				EmailVerifiedAt: emailVerifiedAt,
+			},
+			Provider: config.Name,
+			Subject:  claims.Subject,
+		})
+		if err == nil {
+			return user, true
+		}
+
+		// Somebody else has the username, so try it with a number
+		if strings.Contains(err.Error(), "UNIQUE constraint failed: users.username") && attempt < maxUsernameAttempts {
+			username = fmt.Sprintf("%.8s%d", usernameFromClaims(claims), util.RandomInt(1000, 9999))
+			continue
+		}
+
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return db.User{}, false
+	}
+}
+
+// usernameFromClaims picks a username following the rules of registration
+// from the preferred username or email of the user
+func usernameFromClaims(claims *oidc.Claims) string {
+	username := claims.PreferredUsername
+	if len(username) == 0 {
+		username = strings.SplitN(claims.Email, "@", 2)[0]
+	}
+
+	username = notAlphanumeric.ReplaceAllString(username, "")
+	if len(username) > 12 {
+		username = username[:12]
+	}
+
+	if len(username) < 2 {
+		username = "user"
+	}
+
+	return username
+}
+
+// finishOIDCAuthorization exchanges the code the provider redirected back
+// with, responding with an error if the authorization can't be finished
+func (s *Server) finishOIDCAuthorization(w http.ResponseWriter, r *http.Request, providerName string, code string, state string, username string) (*oidc.Provider, *oidc.Claims, bool) {
+	provider, ok := s.oidcProviders[providerName]
+	if !ok {
+		util.RespondWithNotFoundError(w, "Oops!! We couldn't find the associated login provider")
+		return nil, nil, false
+	}
+
+	loginState, err := s.store.ConsumeOIDCLoginState(util.HashSecret(state))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return nil, nil, false
+	}
+
+	if err != nil || loginState.Provider != providerName || loginState.Username != username || time.Now().After(loginState.ExpiresAt) {
+		util.RespondWithUauthorizedError(w, "Your login has expired. Please, try again")
+		return nil, nil, false
+	}
+
+	claims, err := provider.Exchange(r.Context(), code, loginState.CodeVerifier, loginState.Nonce)
+	if err != nil {
+		logger.Warn("OIDC login failed", zap.String("provider", providerName), zap.Error(err))
+		util.RespondWithUauthorizedError(w, fmt.Sprintf("Oops!! We couldn't log you in with %s", providerName))
+		return nil, nil, false
+	}
+
+	return provider, claims, true
+}
+
+type linkIdentityRequest struct {
+	Provider string `json:"provider" validate:"required"`
+	Code     string `json:"code" validate:"required"`
+	State    string `json:"state" validate:"required"`
+}
+
+// Link the identity the external provider redirected back with to the authorized user
+func (s *Server) LinkIdentity(w http.ResponseWriter, r *http.Request) {
+	var req linkIdentityRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		util.RespondWithBadRequest(w, "Invalid request payload")
+		return
+	}
+
+	validationErrors := validateRequest(req)
+	if validationErrors != nil {
+		util.RespondWithValidationErrors(w, validationErrors)
+		return
+	}
+
+	username := r.Header.Get(authUsernameHeaderKey)
+	_, claims, ok := s.finishOIDCAuthorization(w, r, req.Provider, req.Code, req.State, username)
+	if !ok {
+		return
+	}
+
+	identity, err := s.store.CreateUserIdentity(db.CreateUserIdentityParams{
+		Provider: req.Provider,
+		Subject:  claims.Subject,
+		Username: username,
+		Email:    claims.Email,
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed: user_identities.provider, user_identities.subject") {
+			util.RespondWithForbiddenError(w, "This identity is already linked with another account")
+			return
+		}
+
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			util.RespondWithForbiddenError(w, fmt.Sprintf("Your account is already linked with a %s identity", req.Provider))
+			return
+		}
+
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	util.RespondWithOk(w, createIdentityResponse(identity))
+}
+
+// Get the identities of external providers linked to the authorized user
+func (s *Server) GetUserIdentities(w http.ResponseWriter, r *http.Request) {
+	identities, err := s.store.GetUserIdentities(r.Header.Get(authUsernameHeaderKey))
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	identitiesToSend := []identityResponse{}
+	for _, identity := range identities {
+		identitiesToSend = append(identitiesToSend, createIdentityResponse(identity))
+	}
+
+	util.RespondWithOk(w, identitiesToSend)
+}
+
+// Unlink the identity of the provider from the authorized user, unless it's
+// the only way they can log in
+func (s *Server) UnlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	username := r.Header.Get(authUsernameHeaderKey)
+	provider := mux.Vars(r)["provider"]
+
+	user, err := s.store.GetUser(username)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	identities, err := s.store.GetUserIdentities(username)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	if len(user.HashedPassword) == 0 && len(identities) == 1 && identities[0].Provider == provider {
+		util.RespondWithForbiddenError(w, "This is the only way you can log in, so it can't be unlinked")
+		return
+	}
+
+	err = s.store.DeleteUserIdentityOfAUser(db.DeleteUserIdentityOfAUserParams{Provider: provider, Username: username})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			util.RespondWithNotFoundError(w, "Oops!! We couldn't find the associated identity")
+			return
+		}
+
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	util.RespondWithOk(w, "Successfully unlinked specified identity")
+}
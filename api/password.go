@@ -0,0 +1,173 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sbbullet/to-do/db"
+	"github.com/sbbullet/to-do/logger"
+	"github.com/sbbullet/to-do/mail"
+	"github.com/sbbullet/to-do/util"
+)
+
+type forgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+// Handler to send a password reset link to the email of a user. It answers the
+// same whether the email is registered or not, and before anything is sent,
+// so that neither the response nor its timing tells who has an account.
+func (s *Server) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req forgotPasswordRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		util.RespondWithBadRequest(w, "Invalid request payload")
+		return
+	}
+
+	validationErrors := validateRequest(req)
+	if validationErrors != nil {
+		util.RespondWithValidationErrors(w, validationErrors)
+		return
+	}
+
+	go s.sendPasswordResetLink(req.Email)
+
+	util.RespondWithOk(w, "If an account uses this email, a link to reset its password is on its way")
+}
+
+// sendPasswordResetLink emails a new reset link to the user with the email, if any
+func (s *Server) sendPasswordResetLink(email string) {
+	user, err := s.store.GetUserByEmail(email)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logger.Error(err.Error())
+		}
+		return
+	}
+
+	resetToken, err := util.RandomSecret(32)
+	if err != nil {
+		logger.Error(err.Error())
+		return
+	}
+
+	_, err = s.store.CreatePasswordResetToken(db.CreatePasswordResetTokenParams{
+		TokenHash: util.HashSecret(resetToken),
+		Username:  user.Username,
+		ExpiresAt: time.Now().Add(s.config.PasswordResetTokenDuration),
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		return
+	}
+
+	s.sendMail(user, mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nSomeone asked to reset the password of your account. If it was you, set a new password here:\n\n%s\n\nThe link works once, within %d minutes. If it wasn't you, you can ignore this email.\n",
+			user.FullName, s.appLink("/reset-password", resetToken), int(s.config.PasswordResetTokenDuration.Minutes()),
+		),
+	})
+}
+
+// Handler to set a new password with the token of a reset link. It logs the
+// user out everywhere, as whoever knew the old password shouldn't stay in.
+func (s *Server) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req resetPasswordRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		util.RespondWithBadRequest(w, "Invalid request payload")
+		return
+	}
+
+	validationErrors := validateRequest(req)
+	if validationErrors != nil {
+		util.RespondWithValidationErrors(w, validationErrors)
+		return
+	}
+
+	// The password is checked against the user the link was sent to
+	resetToken, err := s.store.GetPasswordResetToken(util.HashSecret(req.Token))
+	if err != nil {
+		if errors.Is(err, db.ErrPasswordResetTokenInvalid) {
+			util.RespondWithBadRequest(w, "This password reset link is invalid or has expired")
+			return
+		}
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	user, err := s.store.GetUser(resetToken.Username)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	if !s.checkPasswordPolicy(w, "password", req.Password, user.Username, user.Email) {
+		return
+	}
+
+	hashedPassword, err := s.passwords.Hash(req.Password)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	username, err := s.store.ResetPassword(db.ResetPasswordParams{
+		TokenHash:      util.HashSecret(req.Token),
+		HashedPassword: hashedPassword,
+	})
+	if err != nil {
+		if errors.Is(err, db.ErrPasswordResetTokenInvalid) {
+			util.RespondWithBadRequest(w, "This password reset link is invalid or has expired")
+			return
+		}
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	if err := s.revocations.revokeAll(username); err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	util.RespondWithOk(w, "Your password has been reset, you can now log in with it")
+}
+
+// checkPasswordPolicy checks a new password of the user against the password
+// policy, responding with what's wrong with it under the field if anything
+func (s *Server) checkPasswordPolicy(w http.ResponseWriter, field string, password string, username string, email string) bool {
+	problems, err := s.passwordPolicy.Check(password, username, email)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return false
+	}
+
+	if len(problems) > 0 {
+		util.RespondWithValidationErrors(w, map[string][]string{
+			field: problems,
+		})
+		return false
+	}
+
+	return true
+}
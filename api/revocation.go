@@ -0,0 +1,216 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sbbullet/to-do/db"
+	"github.com/sbbullet/to-do/logger"
+	"github.com/sbbullet/to-do/token"
+	"github.com/sbbullet/to-do/util"
+)
+
+const revocationPruneInterval = time.Minute
+
+// revocationStore keeps the revoked access tokens in the database and caches
+// them in memory, so that checking a token on every request doesn't need a query
+type revocationStore struct {
+	store *db.Store
+	// maxTokenDuration is how long tokens live at most, after which their
+	// revocations can be forgotten
+	maxTokenDuration time.Duration
+
+	mu            sync.RWMutex
+	revokedTokens map[uuid.UUID]time.Time
+	// issuedBefore holds, per user, the time before which all their tokens are revoked
+	issuedBefore map[string]time.Time
+	// revokedSessions holds the sessions revoked recently enough for some of
+	// their tokens to still be valid
+	revokedSessions map[uuid.UUID]bool
+}
+
+func newRevocationStore(store *db.Store, maxTokenDuration time.Duration) (*revocationStore, error) {
+	revocations := &revocationStore{
+		store:            store,
+		maxTokenDuration: maxTokenDuration,
+	}
+
+	if err := revocations.load(); err != nil {
+		return nil, err
+	}
+
+	return revocations, nil
+}
+
+// load replaces the cache with the revocations in the database
+func (rs *revocationStore) load() error {
+	revokedTokens, userRevocations, err := rs.store.GetTokenRevocations()
+	if err != nil {
+		return err
+	}
+
+	tokens := map[uuid.UUID]time.Time{}
+	for _, revokedToken := range revokedTokens {
+		tokens[revokedToken.TokenID] = revokedToken.ExpiresAt
+	}
+
+	issuedBefore := map[string]time.Time{}
+	for _, revocation := range userRevocations {
+		if revocation.IssuedBefore.After(issuedBefore[revocation.Username]) {
+			issuedBefore[revocation.Username] = revocation.IssuedBefore
+		}
+	}
+
+	sessions, err := rs.store.GetSessionsRevokedSince(time.Now().Add(-rs.maxTokenDuration))
+	if err != nil {
+		return err
+	}
+
+	revokedSessions := map[uuid.UUID]bool{}
+	for _, session := range sessions {
+		revokedSessions[session.ID] = true
+	}
+
+	rs.mu.Lock()
+	rs.revokedTokens = tokens
+	rs.issuedBefore = issuedBefore
+	rs.revokedSessions = revokedSessions
+	rs.mu.Unlock()
+
+	return nil
+}
+
+// isRevoked tells whether the token has been revoked on its own, along with
+// its session or along with every other token of its user. Impersonation
+// tokens are also revoked along with the tokens of their impersonator.
+func (rs *revocationStore) isRevoked(payload *token.Payload) bool {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	if _, ok := rs.revokedTokens[payload.ID]; ok {
+		return true
+	}
+
+	if payload.SessionID != uuid.Nil && rs.revokedSessions[payload.SessionID] {
+		return true
+	}
+
+	if len(payload.Impersonator) > 0 {
+		issuedBefore, ok := rs.issuedBefore[payload.Impersonator]
+		if ok && payload.IssuedAt.Before(issuedBefore) {
+			return true
+		}
+	}
+
+	// Tokens issued before the username was normalized are revoked under the
+	// normalized one
+	for _, username := range []string{payload.Username, util.NormalizeUsername(payload.Username)} {
+		issuedBefore, ok := rs.issuedBefore[username]
+		if ok && payload.IssuedAt.Before(issuedBefore) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// revoke revokes a single token
+func (rs *revocationStore) revoke(payload *token.Payload) error {
+	_, err := rs.store.RevokeToken(db.RevokeTokenParams{
+		TokenID:   payload.ID,
+		Username:  payload.Username,
+		ExpiresAt: payload.ExpiresAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	rs.mu.Lock()
+	rs.revokedTokens[payload.ID] = payload.ExpiresAt
+	rs.mu.Unlock()
+
+	return nil
+}
+
+// revokeSession revokes the session of the user along with every token issued for it
+func (rs *revocationStore) revokeSession(arg db.RevokeSessionOfAUserParams) (db.Session, error) {
+	session, err := rs.store.RevokeSessionOfAUser(arg)
+	if err != nil {
+		return db.Session{}, err
+	}
+
+	rs.sessionRevoked(session.ID)
+
+	return session, nil
+}
+
+// sessionRevoked records a session that has been revoked in the database
+func (rs *revocationStore) sessionRevoked(sessionID uuid.UUID) {
+	rs.mu.Lock()
+	rs.revokedSessions[sessionID] = true
+	rs.mu.Unlock()
+}
+
+// revokeAll revokes every token issued to the user so far
+func (rs *revocationStore) revokeAll(username string) error {
+	now := time.Now()
+
+	revocation, err := rs.store.RevokeUserTokens(db.RevokeUserTokensParams{
+		Username:     username,
+		IssuedBefore: now,
+		ExpiresAt:    now.Add(rs.maxTokenDuration),
+	})
+	if err != nil {
+		return err
+	}
+
+	rs.mu.Lock()
+	rs.issuedBefore[username] = revocation.IssuedBefore
+	rs.mu.Unlock()
+
+	return nil
+}
+
+// deleteUser deletes the user along with every token issued to them
+func (rs *revocationStore) deleteUser(username string) error {
+	now := time.Now()
+
+	err := rs.store.DeleteUser(db.DeleteUserParams{
+		Username:       username,
+		TokensExpireAt: now.Add(rs.maxTokenDuration),
+	})
+	if err != nil {
+		return err
+	}
+
+	rs.mu.Lock()
+	rs.issuedBefore[username] = now
+	rs.mu.Unlock()
+
+	return nil
+}
+
+// Run prunes the revocations of expired tokens until the context is cancelled,
+// reloading the cache to pick up changes made by other processes
+func (rs *revocationStore) Run(ctx context.Context) {
+	ticker := time.NewTicker(revocationPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := rs.store.PruneTokenRevocations(); err != nil {
+				logger.Error(err.Error())
+				continue
+			}
+
+			if err := rs.load(); err != nil {
+				logger.Error(err.Error())
+			}
+		}
+	}
+}
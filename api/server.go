@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
@@ -9,15 +10,27 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/sbbullet/to-do/db"
 	"github.com/sbbullet/to-do/logger"
+	"github.com/sbbullet/to-do/mail"
+	"github.com/sbbullet/to-do/oidc"
 	"github.com/sbbullet/to-do/token"
 	"github.com/sbbullet/to-do/util"
+	"github.com/sbbullet/to-do/webhook"
 )
 
 type Server struct {
-	config     *util.Config
-	store      *db.Store
-	router     *mux.Router
-	tokenMaker token.Maker
+	config         *util.Config
+	store          *db.Store
+	router         *mux.Router
+	tokenMaker     token.Maker
+	hub            *hub
+	dispatcher     *webhook.Dispatcher
+	revocations    *revocationStore
+	sessions       *sessionTracker
+	oidcProviders  map[string]*oidc.Provider
+	mailer         mail.Mailer
+	passwords      *util.PasswordHasher
+	passwordPolicy *util.PasswordPolicy
+	trustedProxies *util.TrustedProxies
 }
 
 func NewServer() *Server {
@@ -30,15 +43,60 @@ func NewServer() *Server {
 	dbInstance := db.NewDB(config)
 	store := db.NewStore(dbInstance)
 
-	pasetoMaker, err := token.NewPasetoMaker(config.SymmetricKey)
+	tokenMaker, err := newTokenMaker(config)
+	if err != nil {
+		panic(err)
+	}
+
+	revocations, err := newRevocationStore(store, config.AccessTokenDuration)
+	if err != nil {
+		panic(err)
+	}
+
+	oidcProviders, err := newOIDCProviders(config)
+	if err != nil {
+		panic(err)
+	}
+
+	mailer, err := mail.NewMailer(config)
+	if err != nil {
+		panic(err)
+	}
+
+	passwords, err := util.NewPasswordHasher(util.Argon2idParams{
+		Memory:      config.PasswordArgon2Memory,
+		Iterations:  config.PasswordArgon2Iterations,
+		Parallelism: config.PasswordArgon2Parallelism,
+		SaltLength:  util.DefaultArgon2idParams.SaltLength,
+		KeyLength:   util.DefaultArgon2idParams.KeyLength,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	passwordPolicy, err := util.NewPasswordPolicy(config)
+	if err != nil {
+		panic(err)
+	}
+
+	trustedProxies, err := util.NewTrustedProxies(config.TrustedProxies)
 	if err != nil {
 		panic(err)
 	}
 
 	server := &Server{
-		config:     config,
-		store:      store,
-		tokenMaker: pasetoMaker,
+		config:         config,
+		store:          store,
+		tokenMaker:     tokenMaker,
+		hub:            newHub(),
+		dispatcher:     webhook.NewDispatcher(store, config),
+		revocations:    revocations,
+		sessions:       newSessionTracker(store),
+		oidcProviders:  oidcProviders,
+		mailer:         mailer,
+		passwords:      passwords,
+		passwordPolicy: passwordPolicy,
+		trustedProxies: trustedProxies,
 	}
 
 	// Setup server router
@@ -47,6 +105,56 @@ func NewServer() *Server {
 	return server
 }
 
+// newTokenMaker creates the token maker selected by the TOKEN_TYPE setting.
+// PASETO tokens are signed with the keys of TOKEN_KEYRING_FILE when it's set,
+// which allows rotating them.
+func newTokenMaker(config *util.Config) (token.Maker, error) {
+	switch config.TokenType {
+	case "jwt":
+		if config.JWTAlgorithm == token.JWTAlgorithmHS256 {
+			return token.NewJWTMaker(config.SymmetricKey)
+		}
+
+		privateKeyPEM, err := os.ReadFile(config.JWTPrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		return token.NewAsymmetricJWTMaker(config.JWTAlgorithm, privateKeyPEM)
+
+	case "paseto-public":
+		if len(config.TokenKeyringFile) > 0 {
+			keyring, err := token.LoadKeyringFile(config.TokenKeyringFile)
+			if err != nil {
+				return nil, err
+			}
+
+			return token.NewPasetoPublicKeyringMaker(keyring)
+		}
+
+		privateKeyPEM, err := os.ReadFile(config.PasetoPrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		return token.NewPasetoPublicMaker(privateKeyPEM)
+
+	case "paseto":
+		if len(config.TokenKeyringFile) > 0 {
+			keyring, err := token.LoadKeyringFile(config.TokenKeyringFile)
+			if err != nil {
+				return nil, err
+			}
+
+			return token.NewPasetoKeyringMaker(keyring)
+		}
+
+		return token.NewPasetoMaker(config.SymmetricKey)
+	}
+
+	return nil, fmt.Errorf("unsupported token type %q", config.TokenType)
+}
+
 func (server *Server) setupRouter() {
 	r := mux.NewRouter()
 	r.Use(LoggingMiddleware())
@@ -55,20 +163,108 @@ func (server *Server) setupRouter() {
 		util.RespondWithOk(w, "Yup, it's working. Explore the API documentation")
 	})
 
+	r.HandleFunc("/.well-known/paseto-keys", server.GetPasetoKeys).Methods(http.MethodGet)
+
+	// OAuth clients authenticate themselves at the token endpoints, while the
+	// user authorizing them does so with their own access token
+	r.HandleFunc("/oauth/token", server.IssueOAuthToken).Methods(http.MethodPost)
+	r.HandleFunc("/oauth/revoke", server.RevokeOAuthToken).Methods(http.MethodPost)
+	r.HandleFunc("/oauth/introspect", server.IntrospectOAuthToken).Methods(http.MethodPost)
+
+	// Calendar clients authenticate with the secret token in the feed URL
+	r.HandleFunc("/calendar/{token}.ics", server.GetCalendarFeed).Methods(http.MethodGet)
+	calDAVRoutes := r.PathPrefix("/caldav/{token}").Subrouter()
+	calDAVRoutes.HandleFunc("/", server.CalDAVCollection).Methods(http.MethodOptions, methodPropfind, methodReport)
+	calDAVRoutes.HandleFunc("/{id}.ics", server.CalDAVTodo).Methods(http.MethodGet, http.MethodHead)
+
 	apiRoutes := r.PathPrefix("/api/v1").Subrouter()
 	apiRoutes.HandleFunc("/users", server.RegisterUser).Methods(http.MethodPost)
 	apiRoutes.HandleFunc("/users/login", server.LoginUser).Methods(http.MethodPost)
+	apiRoutes.HandleFunc("/users/login/mfa", server.VerifyMFALogin).Methods(http.MethodPost)
+	apiRoutes.HandleFunc("/users/login/{provider}", server.StartOIDCLogin).Methods(http.MethodGet)
+	apiRoutes.HandleFunc("/users/password/forgot", server.ForgotPassword).Methods(http.MethodPost)
+	apiRoutes.HandleFunc("/users/password/reset", server.ResetPassword).Methods(http.MethodPost)
+	apiRoutes.HandleFunc("/users/email/verify", server.VerifyEmail).Methods(http.MethodPost)
+	apiRoutes.HandleFunc("/users/email/resend", server.ResendEmailVerification).Methods(http.MethodPost)
+	apiRoutes.HandleFunc("/tokens/refresh", server.RefreshAccessToken).Methods(http.MethodPost)
+
+	// Routes only need the scopes of what they give access to
+	readTodos := RequireScope(token.ScopeTodosRead)
+	writeTodos := RequireScope(token.ScopeTodosWrite)
+	requireAccountAdmin := RequireScope(token.ScopeAccountAdmin)
+	// Managing the account is too sensitive for impersonators
+	adminAccount := func(next http.Handler) http.Handler {
+		return requireAccountAdmin(ForbidImpersonation(next))
+	}
+
+	oauthRoutes := r.PathPrefix("/oauth").Subrouter()
+	oauthRoutes.Use(server.AuthMiddleware(), adminAccount)
+	oauthRoutes.HandleFunc("/authorize", server.AuthorizeOAuthClient).Methods(http.MethodGet)
+	oauthRoutes.HandleFunc("/consent", server.ConsentToOAuthClient).Methods(http.MethodPost)
 
 	userRoutes := apiRoutes.PathPrefix("/users").Subrouter()
-	userRoutes.Use(AuthMiddleware(server.tokenMaker))
+	userRoutes.Use(server.AuthMiddleware())
+	userRoutes.HandleFunc("/logout", server.LogoutUser).Methods(http.MethodPost)
 	userRoutes.HandleFunc("/me", server.GetCurrentUser).Methods(http.MethodGet)
+	userRoutes.Handle("/me", adminAccount(http.HandlerFunc(server.UpdateCurrentUser))).Methods(http.MethodPatch)
+	userRoutes.Handle("/me", adminAccount(http.HandlerFunc(server.DeleteCurrentUser))).Methods(http.MethodDelete)
+	userRoutes.Handle("/me/password", adminAccount(http.HandlerFunc(server.ChangePassword))).Methods(http.MethodPut)
+	userRoutes.Handle("/me/email", adminAccount(http.HandlerFunc(server.ChangeEmail))).Methods(http.MethodPut)
+	userRoutes.Handle("/logout-all", adminAccount(http.HandlerFunc(server.LogoutUserEverywhere))).Methods(http.MethodPost)
+	userRoutes.Handle("/me/totp", adminAccount(http.HandlerFunc(server.GetTOTPStatus))).Methods(http.MethodGet)
+	userRoutes.Handle("/me/totp", adminAccount(http.HandlerFunc(server.EnrollTOTP))).Methods(http.MethodPost)
+	userRoutes.Handle("/me/totp", adminAccount(http.HandlerFunc(server.DisableTOTP))).Methods(http.MethodDelete)
+	userRoutes.Handle("/me/totp/confirm", adminAccount(http.HandlerFunc(server.ConfirmTOTP))).Methods(http.MethodPost)
+	userRoutes.Handle("/me/totp/recovery-codes", adminAccount(http.HandlerFunc(server.RegenerateRecoveryCodes))).Methods(http.MethodPost)
+	userRoutes.Handle("/me/sessions", adminAccount(http.HandlerFunc(server.GetUserSessions))).Methods(http.MethodGet)
+	userRoutes.Handle("/me/sessions/{id}", adminAccount(http.HandlerFunc(server.RevokeUserSession))).Methods(http.MethodDelete)
+	userRoutes.Handle("/me/api-keys", adminAccount(http.HandlerFunc(server.CreateAPIKey))).Methods(http.MethodPost)
+	userRoutes.Handle("/me/api-keys", adminAccount(http.HandlerFunc(server.GetUserAPIKeys))).Methods(http.MethodGet)
+	userRoutes.Handle("/me/api-keys/{id}", adminAccount(http.HandlerFunc(server.DeleteAPIKey))).Methods(http.MethodDelete)
+	userRoutes.Handle("/me/identities", adminAccount(http.HandlerFunc(server.GetUserIdentities))).Methods(http.MethodGet)
+	userRoutes.Handle("/me/identities", adminAccount(http.HandlerFunc(server.LinkIdentity))).Methods(http.MethodPost)
+	userRoutes.Handle("/me/identities/{provider}/authorize", adminAccount(http.HandlerFunc(server.StartOIDCLink))).Methods(http.MethodPost)
+	userRoutes.Handle("/me/identities/{provider}", adminAccount(http.HandlerFunc(server.UnlinkIdentity))).Methods(http.MethodDelete)
+	userRoutes.Handle("/me/oauth-clients", adminAccount(http.HandlerFunc(server.CreateOAuthClient))).Methods(http.MethodPost)
+	userRoutes.Handle("/me/oauth-clients", adminAccount(http.HandlerFunc(server.GetUserOAuthClients))).Methods(http.MethodGet)
+	userRoutes.Handle("/me/oauth-clients/{id}", adminAccount(http.HandlerFunc(server.DeleteOAuthClient))).Methods(http.MethodDelete)
+	userRoutes.Handle("/me/calendar-feed", adminAccount(http.HandlerFunc(server.CreateCalendarFeed))).Methods(http.MethodPost)
+	userRoutes.Handle("/me/calendar-feed", adminAccount(http.HandlerFunc(server.DeleteCalendarFeed))).Methods(http.MethodDelete)
 
 	todoRoutes := apiRoutes.PathPrefix("/todos").Subrouter()
-	todoRoutes.Use(AuthMiddleware(server.tokenMaker))
-	todoRoutes.HandleFunc("", server.CreateTodo).Methods(http.MethodPost)
-	todoRoutes.HandleFunc("", server.GetUserTodos).Methods(http.MethodGet)
-	todoRoutes.HandleFunc("/{id}", server.UpdateTodo).Methods(http.MethodPatch)
-	todoRoutes.HandleFunc("/{id}", server.DeleteTodo).Methods(http.MethodDelete)
+	todoRoutes.Use(server.AuthMiddleware())
+	todoRoutes.Handle("", writeTodos(http.HandlerFunc(server.CreateTodo))).Methods(http.MethodPost)
+	todoRoutes.Handle("", readTodos(http.HandlerFunc(server.GetUserTodos))).Methods(http.MethodGet)
+	todoRoutes.Handle("/export", readTodos(http.HandlerFunc(server.ExportTodos))).Methods(http.MethodGet)
+	todoRoutes.Handle("/import", writeTodos(http.HandlerFunc(server.ImportTodos))).Methods(http.MethodPost)
+	todoRoutes.Handle("/{id}", writeTodos(http.HandlerFunc(server.UpdateTodo))).Methods(http.MethodPatch)
+	todoRoutes.Handle("/{id}", writeTodos(http.HandlerFunc(server.DeleteTodo))).Methods(http.MethodDelete)
+
+	// Webhooks send the todos to third parties, so managing them is an account matter
+	webhookRoutes := apiRoutes.PathPrefix("/webhooks").Subrouter()
+	webhookRoutes.Use(server.AuthMiddleware(), adminAccount)
+	webhookRoutes.HandleFunc("", server.CreateWebhook).Methods(http.MethodPost)
+	webhookRoutes.HandleFunc("", server.GetUserWebhooks).Methods(http.MethodGet)
+	webhookRoutes.HandleFunc("/{id}", server.DeleteWebhook).Methods(http.MethodDelete)
+	webhookRoutes.HandleFunc("/{id}/deliveries", server.GetWebhookDeliveries).Methods(http.MethodGet)
+	webhookRoutes.HandleFunc("/{id}/deliveries/{delivery_id}", server.GetWebhookDelivery).Methods(http.MethodGet)
+	webhookRoutes.HandleFunc("/{id}/deliveries/{delivery_id}/redeliver", server.RedeliverWebhookDelivery).Methods(http.MethodPost)
+
+	// Only admins can manage the accounts of other users
+	adminRoutes := apiRoutes.PathPrefix("/admin").Subrouter()
+	adminRoutes.Use(server.AuthMiddleware(), adminAccount, RequireRole(db.UserRoleAdmin))
+	adminRoutes.HandleFunc("/users", server.AdminListUsers).Methods(http.MethodGet)
+	adminRoutes.HandleFunc("/users/{username}", server.AdminGetUser).Methods(http.MethodGet)
+	adminRoutes.HandleFunc("/users/{username}/disable", server.AdminDisableUser).Methods(http.MethodPost)
+	adminRoutes.HandleFunc("/users/{username}/enable", server.AdminEnableUser).Methods(http.MethodPost)
+	adminRoutes.HandleFunc("/users/{username}/password-reset", server.AdminForcePasswordReset).Methods(http.MethodPost)
+	adminRoutes.HandleFunc("/users/{username}/sessions", server.AdminRevokeUserSessions).Methods(http.MethodDelete)
+	adminRoutes.HandleFunc("/users/{username}/unlock", server.AdminUnlockUser).Methods(http.MethodPost)
+	adminRoutes.HandleFunc("/users/{username}/impersonate", server.AdminImpersonateUser).Methods(http.MethodPost)
+
+	wsRoutes := apiRoutes.PathPrefix("/ws").Subrouter()
+	wsRoutes.Use(wsTokenMiddleware, server.AuthMiddleware(), readTodos)
+	wsRoutes.HandleFunc("", server.ServeWebSocket).Methods(http.MethodGet)
 
 	server.router = r
 }
@@ -77,5 +273,11 @@ func (server *Server) Run() {
 	serverAddress := fmt.Sprintf("%s:%s", server.config.ServerHost, server.config.ServerPort)
 	logger.Info(fmt.Sprintf("Server starting at http://%s", serverAddress))
 
+	// Deliver queued webhook events in the background
+	go server.dispatcher.Run(context.Background())
+
+	// Forget revocations of tokens that have expired anyway
+	go server.revocations.Run(context.Background())
+
 	log.Fatal(http.ListenAndServe(serverAddress, server.router))
 }
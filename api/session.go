@@ -0,0 +1,131 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/sbbullet/to-do/db"
+	"github.com/sbbullet/to-do/logger"
+	"github.com/sbbullet/to-do/util"
+)
+
+// sessionTouchInterval is how often the last-seen time of a session in use gets written
+const sessionTouchInterval = time.Minute
+
+type sessionResponse struct {
+	ID         uuid.UUID  `json:"id"`
+	UserAgent  string     `json:"user_agent"`
+	ClientIP   string     `json:"client_ip"`
+	ClientID   string     `json:"client_id,omitempty"`
+	Current    bool       `json:"current"`
+	LastSeenAt *time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func createSessionResponse(session db.Session, currentSessionID uuid.UUID) sessionResponse {
+	return sessionResponse{
+		ID:         session.ID,
+		UserAgent:  session.UserAgent,
+		ClientIP:   session.ClientIP,
+		ClientID:   session.ClientID,
+		Current:    session.ID == currentSessionID,
+		LastSeenAt: session.LastSeenAt,
+		ExpiresAt:  session.ExpiresAt,
+		CreatedAt:  session.CreatedAt,
+	}
+}
+
+// Get the active sessions of the authorized user
+func (s *Server) GetUserSessions(w http.ResponseWriter, r *http.Request) {
+	payload := authPayload(r)
+
+	sessions, err := s.store.GetActiveUserSessions(payload.Username)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	sessionsToSend := []sessionResponse{}
+	for _, session := range sessions {
+		sessionsToSend = append(sessionsToSend, createSessionResponse(session, payload.SessionID))
+	}
+
+	util.RespondWithOk(w, sessionsToSend)
+}
+
+// Revoke specified session of the authorized user, logging out its device
+func (s *Server) RevokeUserSession(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		util.RespondWithBadRequest(w, "Invalid session identifier")
+		return
+	}
+
+	_, err = s.revocations.revokeSession(db.RevokeSessionOfAUserParams{
+		ID:       sessionID,
+		Username: r.Header.Get(authUsernameHeaderKey),
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			util.RespondWithNotFoundError(w, "Oops!! We couldn't find the associated session")
+			return
+		}
+
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	util.RespondWithOk(w, "Successfully revoked specified session")
+}
+
+// sessionTracker records when sessions were last seen, writing to the
+// database at most once per sessionTouchInterval for each session
+type sessionTracker struct {
+	store *db.Store
+
+	mu        sync.Mutex
+	touchedAt map[uuid.UUID]time.Time
+}
+
+func newSessionTracker(store *db.Store) *sessionTracker {
+	return &sessionTracker{
+		store:     store,
+		touchedAt: map[uuid.UUID]time.Time{},
+	}
+}
+
+func (st *sessionTracker) touch(sessionID uuid.UUID) error {
+	now := time.Now()
+
+	st.mu.Lock()
+	if now.Sub(st.touchedAt[sessionID]) < sessionTouchInterval {
+		st.mu.Unlock()
+		return nil
+	}
+
+	// Forget sessions that haven't been seen for a while, they get written
+	// again as soon as they are
+	for id, touchedAt := range st.touchedAt {
+		if now.Sub(touchedAt) >= sessionTouchInterval {
+			delete(st.touchedAt, id)
+		}
+	}
+	st.touchedAt[sessionID] = now
+	st.mu.Unlock()
+
+	return st.store.TouchSession(db.TouchSessionParams{ID: sessionID, LastSeenAt: now})
+}
+
+// clientIP gets the address of the client, preferring the one reported by a
+// trusted proxy
+func (s *Server) clientIP(r *http.Request) string {
+	return s.trustedProxies.ClientIP(r)
+}
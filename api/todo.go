@@ -17,7 +17,10 @@ import (
 )
 
 type createTodoRequest struct {
-	Title string `json:"title" validate:"required,min=6,max=255"`
+	Title    string   `json:"title" validate:"required,min=6,max=255"`
+	Priority string   `json:"priority" validate:"omitempty,oneof=high medium low"`
+	DueAt    string   `json:"due_at" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+	Tags     []string `json:"tags" validate:"max=20,dive,required,max=50,tag"`
 }
 
 // Create todo for the authorized user
@@ -48,6 +51,9 @@ func (s *Server) CreateTodo(w http.ResponseWriter, r *http.Request) {
 		ID:       todoID,
 		Username: username,
 		Title:    req.Title,
+		Priority: req.Priority,
+		DueAt:    parseDueAt(req.DueAt),
+		Tags:     req.Tags,
 	}
 
 	todo, err := s.store.CreateTodo(arg)
@@ -57,6 +63,8 @@ func (s *Server) CreateTodo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.hub.publishTodoEvent(eventTodoCreated, todo)
+
 	util.RespondWithOk(w, todo)
 }
 
@@ -99,9 +107,14 @@ func (s *Server) GetUserTodos(w http.ResponseWriter, r *http.Request) {
 	util.RespondWithOk(w, createTodosResponse(todos))
 }
 
+// updateTodoRequest leaves out the fields that aren't sent. An empty priority
+// or due date, like empty tags, removes them from the todo.
 type updateTodoRequest struct {
-	Title       string `json:"title" validation:"min=6,max=255"`
-	IsCompleted *bool  `json:"is_completed" validation:"boolean"`
+	Title       string   `json:"title" validation:"min=6,max=255"`
+	IsCompleted *bool    `json:"is_completed" validation:"boolean"`
+	Priority    *string  `json:"priority" validate:"omitempty,oneof=high medium low"`
+	DueAt       *string  `json:"due_at" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+	Tags        []string `json:"tags" validate:"max=20,dive,required,max=50,tag"`
 }
 
 // Update specified todo of the authorized user
@@ -152,6 +165,9 @@ func (s *Server) UpdateTodo(w http.ResponseWriter, r *http.Request) {
 		ID:          todo.ID,
 		Title:       sql.NullString{String: req.Title, Valid: len(req.Title) > 0},
 		IsCompleted: isCompleted,
+		Priority:    updatedPriority(req.Priority),
+		DueAt:       updatedDueAt(req.DueAt),
+		Tags:        req.Tags,
 	}
 	updatedTodo, err := s.store.UpdateTodo(updateTodoArgs)
 	if err != nil {
@@ -160,6 +176,8 @@ func (s *Server) UpdateTodo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.hub.publishTodoEvent(eventTodoUpdated, updatedTodo)
+
 	util.RespondWithOk(w, createTodoResponse(updatedTodo))
 }
 
@@ -189,14 +207,56 @@ func (s *Server) DeleteTodo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.hub.publishTodoDeleted(todoId, username)
+
 	util.RespondWithOk(w, "Successfully deleted specified todo from your todo list")
 }
 
+// parseDueAt parses a due date that passed validation, giving nil when empty
+func parseDueAt(dueAt string) *time.Time {
+	if len(dueAt) == 0 {
+		return nil
+	}
+
+	t, err := time.Parse(time.RFC3339, dueAt)
+	if err != nil {
+		return nil
+	}
+
+	return &t
+}
+
+func updatedPriority(priority *string) sql.NullString {
+	if priority == nil {
+		return sql.NullString{Valid: false}
+	}
+
+	return sql.NullString{String: *priority, Valid: true}
+}
+
+func updatedDueAt(dueAt *string) sql.NullTime {
+	if dueAt == nil {
+		return sql.NullTime{Valid: false}
+	}
+
+	// An empty due date is valid but zero, which removes it
+	t := parseDueAt(*dueAt)
+	if t == nil {
+		return sql.NullTime{Valid: true}
+	}
+
+	return sql.NullTime{Time: *t, Valid: true}
+}
+
 type todoResponse struct {
-	ID          uuid.UUID `json:"id"`
-	Title       string    `json:"title"`
-	CreatedAt   time.Time `json:"created_at"`
-	IsCompleted bool      `json:"is_completed"`
+	ID          uuid.UUID  `json:"id"`
+	Title       string     `json:"title"`
+	CreatedAt   time.Time  `json:"created_at"`
+	IsCompleted bool       `json:"is_completed"`
+	CompletedAt *time.Time `json:"completed_at"`
+	Priority    *string    `json:"priority"`
+	DueAt       *time.Time `json:"due_at"`
+	Tags        []string   `json:"tags"`
 }
 
 func createTodoResponse(todo db.Todo) todoResponse {
@@ -205,6 +265,10 @@ func createTodoResponse(todo db.Todo) todoResponse {
 		Title:       todo.Title,
 		IsCompleted: todo.IsCompleted,
 		CreatedAt:   todo.CreatedAt,
+		CompletedAt: todo.CompletedAt,
+		Priority:    todo.Priority,
+		DueAt:       todo.DueAt,
+		Tags:        todo.Tags,
 	}
 }
 
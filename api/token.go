@@ -1 +1,159 @@
 package api
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sbbullet/to-do/db"
+	"github.com/sbbullet/to-do/logger"
+	"github.com/sbbullet/to-do/token"
+	"github.com/sbbullet/to-do/util"
+)
+
+type pasetoKeyResponse struct {
+	ID      string `json:"kid"`
+	Version string `json:"version"`
+	Purpose string `json:"purpose"`
+	Key     string `json:"key"`
+	PASERK  string `json:"paserk"`
+}
+
+type pasetoKeySetResponse struct {
+	Keys []pasetoKeyResponse `json:"keys"`
+}
+
+// Publish the public keys access tokens can be verified with, which is none
+// unless tokens are signed with public-key PASETO
+func (s *Server) GetPasetoKeys(w http.ResponseWriter, r *http.Request) {
+	keySet := pasetoKeySetResponse{Keys: []pasetoKeyResponse{}}
+
+	if provider, ok := s.tokenMaker.(token.PublicKeyProvider); ok {
+		for _, publicKey := range provider.PublicKeys() {
+			key := base64.RawURLEncoding.EncodeToString(publicKey.Key)
+			keySet.Keys = append(keySet.Keys, pasetoKeyResponse{
+				ID:      publicKey.ID,
+				Version: publicKey.Version,
+				Purpose: publicKey.Purpose,
+				Key:     key,
+				PASERK:  fmt.Sprintf("k%s.%s.%s", strings.TrimPrefix(publicKey.Version, "v"), publicKey.Purpose, key),
+			})
+		}
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	util.RespondWithJSON(w, http.StatusOK, keySet)
+}
+
+type refreshAccessTokenRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+type refreshAccessTokenResponse struct {
+	AccessToken           string    `json:"access_token"`
+	AccessTokenExpiresAt  time.Time `json:"access_token_expires_at"`
+	RefreshToken          string    `json:"refresh_token"`
+	RefreshTokenExpiresAt time.Time `json:"refresh_token_expires_at"`
+}
+
+// Exchange a refresh token for a new access token and a new refresh token
+func (s *Server) RefreshAccessToken(w http.ResponseWriter, r *http.Request) {
+	var req refreshAccessTokenRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		util.RespondWithBadRequest(w, "Invalid request payload")
+		return
+	}
+
+	validationErrors := validateRequest(req)
+	if validationErrors != nil {
+		util.RespondWithValidationErrors(w, validationErrors)
+		return
+	}
+
+	refreshToken, err := util.RandomSecret(32)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	refreshTokenID, err := uuid.NewRandom()
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	session, err := s.store.RotateRefreshToken(db.RotateRefreshTokenParams{
+		TokenHash:    util.HashSecret(req.RefreshToken),
+		NewTokenID:   refreshTokenID,
+		NewTokenHash: util.HashSecret(refreshToken),
+		NewExpiresAt: time.Now().Add(s.config.RefreshTokenDuration),
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows), errors.Is(err, db.ErrSessionInactive):
+			util.RespondWithUauthorizedError(w, "Refresh token is invalid or expired")
+		case errors.Is(err, db.ErrRefreshTokenReused):
+			logger.Warn("Refresh token reused, revoked session " + session.ID.String())
+			s.revocations.sessionRevoked(session.ID)
+			util.RespondWithUauthorizedError(w, "Refresh token has already been used, please log in again")
+		default:
+			logger.Error(err.Error())
+			util.RespondWithInternalServerError(w)
+		}
+		return
+	}
+
+	// Sessions started before scopes existed could do everything
+	scopes := session.Scopes
+	if len(scopes) == 0 {
+		scopes = token.AllScopes
+	}
+
+	// The role is read again, as it may have changed since the login
+	user, err := s.store.GetUser(session.Username)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	if user.DisabledAt != nil {
+		util.RespondWithUauthorizedError(w, "Refresh token is invalid or expired")
+		return
+	}
+
+	role := user.Role
+	if len(session.ClientID) > 0 {
+		role = ""
+	}
+
+	accessToken, accessTokenPayload, err := s.tokenMaker.CreateToken(token.PayloadParams{
+		Username:  session.Username,
+		Duration:  s.config.AccessTokenDuration,
+		SessionID: session.ID,
+		Scopes:    scopes,
+		Role:      role,
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	util.RespondWithOk(w, refreshAccessTokenResponse{
+		AccessToken:           accessToken,
+		AccessTokenExpiresAt:  accessTokenPayload.ExpiresAt,
+		RefreshToken:          refreshToken,
+		RefreshTokenExpiresAt: session.ExpiresAt,
+	})
+}
@@ -8,31 +8,39 @@ import (
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/sbbullet/to-do/db"
 	"github.com/sbbullet/to-do/logger"
+	"github.com/sbbullet/to-do/token"
 	"github.com/sbbullet/to-do/util"
 )
 
 type userResponse struct {
-	Username  string    `json:"username"`
-	FullName  string    `json:"full_name"`
-	Email     string    `json:"email"`
-	CreatedAt time.Time `json:"created_at"`
+	Username        string     `json:"username"`
+	FullName        string     `json:"full_name"`
+	Email           string     `json:"email"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	PendingEmail    *string    `json:"pending_email"`
+	Role            string     `json:"role"`
+	CreatedAt       time.Time  `json:"created_at"`
 }
 
 type registerUserRequest struct {
 	Username string `json:"username" validate:"required,alphanum,min=2,max=12"`
 	FullName string `json:"full_name" validate:"required,full_name"`
 	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required,min=6,max=16"`
+	Password string `json:"password" validate:"required"`
 }
 
 func createUserResponse(user db.User) userResponse {
 	return userResponse{
-		Username:  user.Username,
-		FullName:  user.FullName,
-		Email:     user.Email,
-		CreatedAt: user.CreatedAt,
+		Username:        user.Username,
+		FullName:        user.FullName,
+		Email:           user.Email,
+		EmailVerifiedAt: user.EmailVerifiedAt,
+		PendingEmail:    user.PendingEmail,
+		Role:            user.Role,
+		CreatedAt:       user.CreatedAt,
 	}
 }
 
@@ -52,7 +60,11 @@ func (s *Server) RegisterUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hashedPassword, err := util.HashPassword(req.Password)
+	if !s.checkPasswordPolicy(w, "password", req.Password, req.Username, req.Email) {
+		return
+	}
+
+	hashedPassword, err := s.passwords.Hash(req.Password)
 	if err != nil {
 		logger.Error(err.Error())
 		util.RespondWithInternalServerError(w)
@@ -81,18 +93,30 @@ func (s *Server) RegisterUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	go s.sendEmailVerificationLink(user)
+
 	util.RespondWithOk(w, createUserResponse(user))
 }
 
+// loginUserRequest logs in with either the username or email and password,
+// or the code and state an external provider redirected back with
 type loginUserRequest struct {
-	Username string `json:"username" validate:"required"`
-	Password string `json:"password" validate:"required"`
+	Username string   `json:"username" validate:"required_without=Provider"`
+	Password string   `json:"password" validate:"required_without=Provider"`
+	Provider string   `json:"provider"`
+	Code     string   `json:"code" validate:"required_with=Provider"`
+	State    string   `json:"state" validate:"required_with=Provider"`
+	Scopes   []string `json:"scopes" validate:"omitempty,dive,oneof=todos:read todos:write account:admin"`
 }
 
 type loginUserResponse struct {
-	AccessToken          string       `json:"access_token"`
-	AccessTokenExpiresAt time.Time    `json:"access_token_expires_at"`
-	User                 userResponse `json:"user"`
+	SessionID             uuid.UUID    `json:"session_id"`
+	Scopes                []string     `json:"scopes"`
+	AccessToken           string       `json:"access_token"`
+	AccessTokenExpiresAt  time.Time    `json:"access_token_expires_at"`
+	RefreshToken          string       `json:"refresh_token"`
+	RefreshTokenExpiresAt time.Time    `json:"refresh_token_expires_at"`
+	User                  userResponse `json:"user"`
 }
 
 // Handler to login user
@@ -111,23 +135,106 @@ func (s *Server) LoginUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := s.store.GetUser(req.Username)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			util.RespondWithUauthorizedError(w, "Oops!! These credentials do not match our records")
+	var user db.User
+	var ok bool
+	if len(req.Provider) > 0 {
+		user, ok = s.authenticateWithOIDC(w, r, req)
+	} else {
+		user, ok = s.authenticateWithPassword(w, r, req)
+	}
+	if !ok {
+		return
+	}
+
+	// Tokens can do everything unless the client asks for less
+	scopes := req.Scopes
+	if len(scopes) == 0 {
+		scopes = token.AllScopes
+	}
+
+	if user.EmailVerifiedAt == nil {
+		switch s.config.UnverifiedLogin {
+		case "block":
+			util.RespondWithForbiddenError(w, "Please verify your email before logging in")
 			return
+		case "read-only":
+			scopes = []string{token.ScopeTodosRead}
 		}
+	}
+
+	// Users who turned on TOTP have to enter a code before getting a session
+	userTOTP, err := s.store.GetUserTOTP(user.Username)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		logger.Error(err.Error())
 		util.RespondWithInternalServerError(w)
 		return
 	}
 
-	if err := util.CheckHashPassword(user.HashedPassword, req.Password); err != nil {
-		util.RespondWithUauthorizedError(w, "Oops!! These credentials do not match our records")
+	if err == nil && userTOTP.ConfirmedAt != nil {
+		s.startMFAChallenge(w, user, scopes)
+		return
+	}
+
+	s.completeLogin(w, r, user, scopes)
+}
+
+// completeLogin starts a session for the user who has just logged in,
+// responding with its tokens
+func (s *Server) completeLogin(w http.ResponseWriter, r *http.Request, user db.User, scopes []string) {
+	if user.DisabledAt != nil {
+		util.RespondWithForbiddenError(w, "Your account has been disabled")
+		return
+	}
+
+	err := s.store.ClearLoginFailures(db.ClearLoginFailuresParams{Kind: db.LoginFailureKindUsername, Identifier: user.Username})
+	if err != nil {
+		logger.Error(err.Error())
+	}
+
+	refreshToken, err := util.RandomSecret(32)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
 		return
 	}
 
-	accessToken, accessTokenPayload, err := s.tokenMaker.CreateToken(user.Username, s.config.AccessTokenDuration)
+	sessionID, err := uuid.NewRandom()
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	refreshTokenID, err := uuid.NewRandom()
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	session, err := s.store.CreateSession(db.CreateSessionParams{
+		ID:               sessionID,
+		Username:         user.Username,
+		UserAgent:        r.UserAgent(),
+		ClientIP:         s.clientIP(r),
+		Scopes:           scopes,
+		RefreshTokenID:   refreshTokenID,
+		RefreshTokenHash: util.HashSecret(refreshToken),
+		ExpiresAt:        time.Now().Add(s.config.RefreshTokenDuration),
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	accessToken, accessTokenPayload, err := s.tokenMaker.CreateToken(token.PayloadParams{
+		Username:  user.Username,
+		Duration:  s.config.AccessTokenDuration,
+		SessionID: session.ID,
+		Scopes:    session.Scopes,
+		Role:      user.Role,
+	})
 	if err != nil {
 		logger.Error(err.Error())
 		util.RespondWithInternalServerError(w)
@@ -135,14 +242,95 @@ func (s *Server) LoginUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	response := loginUserResponse{
-		AccessToken:          accessToken,
-		AccessTokenExpiresAt: accessTokenPayload.ExpiresAt,
-		User:                 createUserResponse(user),
+		SessionID:             session.ID,
+		Scopes:                session.Scopes,
+		AccessToken:           accessToken,
+		AccessTokenExpiresAt:  accessTokenPayload.ExpiresAt,
+		RefreshToken:          refreshToken,
+		RefreshTokenExpiresAt: session.ExpiresAt,
+		User:                  createUserResponse(user),
 	}
 
 	util.RespondWithOk(w, response)
 }
 
+// authenticateWithPassword checks the credentials, logging in with either the
+// username or the email, responding with an error if they don't match or too
+// many logins have failed lately. Passwords hashed with an outdated algorithm
+// or costs are hashed again.
+func (s *Server) authenticateWithPassword(w http.ResponseWriter, r *http.Request, req loginUserRequest) (db.User, bool) {
+	user, err := s.store.GetUserByLogin(req.Username)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return db.User{}, false
+	}
+
+	// Failed logins count against the user however they're written
+	throttleKey := user.Username
+	if len(throttleKey) == 0 {
+		throttleKey = util.NormalizeUsername(req.Username)
+	}
+
+	allowed, err := s.checkLoginAllowed(w, r, throttleKey)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return db.User{}, false
+	}
+
+	if !allowed {
+		return db.User{}, false
+	}
+
+	// Unknown users, and users who signed up through a provider, have no
+	// password, which takes as long to find out as a wrong one
+	var needsRehash bool
+	if len(user.HashedPassword) > 0 {
+		needsRehash, err = s.passwords.Verify(user.HashedPassword, req.Password)
+	} else {
+		s.passwords.VerifyNothing(req.Password)
+		err = util.ErrPasswordMismatch
+	}
+
+	if err != nil {
+		if !errors.Is(err, util.ErrPasswordMismatch) {
+			logger.Error(err.Error())
+		}
+		if err := s.recordLoginFailure(r, throttleKey); err != nil {
+			logger.Error(err.Error())
+		}
+		util.RespondWithUauthorizedError(w, "Oops!! These credentials do not match our records")
+		return db.User{}, false
+	}
+
+	if needsRehash {
+		s.rehashPassword(user, req.Password)
+	}
+
+	return user, true
+}
+
+// rehashPassword replaces the hash of the password of the user with one made
+// by the current hasher. Failing to do so only gets logged, since the user
+// can still log in with the old one.
+func (s *Server) rehashPassword(user db.User, password string) {
+	hashedPassword, err := s.passwords.Hash(password)
+	if err != nil {
+		logger.Error(err.Error())
+		return
+	}
+
+	err = s.store.UpdatePasswordHash(db.UpdatePasswordHashParams{
+		Username:          user.Username,
+		OldHashedPassword: user.HashedPassword,
+		HashedPassword:    hashedPassword,
+	})
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		logger.Error(err.Error())
+	}
+}
+
 // Handler to get current user details
 func (s *Server) GetCurrentUser(w http.ResponseWriter, r *http.Request) {
 	username := r.Header.Get(authUsernameHeaderKey)
@@ -160,3 +348,84 @@ func (s *Server) GetCurrentUser(w http.ResponseWriter, r *http.Request) {
 
 	util.RespondWithOk(w, createUserResponse(user))
 }
+
+type logoutUserRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Handler to log out the current session, along with the session of the
+// refresh token if one is given
+func (s *Server) LogoutUser(w http.ResponseWriter, r *http.Request) {
+	// API keys have no session to end, and revoking them is done by deleting them
+	if _, ok := requestAPIKey(r); ok {
+		util.RespondWithForbiddenError(w, "API keys can't log out. Delete the API key to revoke it instead")
+		return
+	}
+
+	var req logoutUserRequest
+
+	// The body is optional
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.RespondWithBadRequest(w, "Invalid request payload")
+			return
+		}
+	}
+
+	payload := authPayload(r)
+
+	if len(req.RefreshToken) > 0 {
+		session, err := s.store.RevokeSessionByRefreshToken(db.RevokeSessionByRefreshTokenParams{
+			Username:  payload.Username,
+			TokenHash: util.HashSecret(req.RefreshToken),
+		})
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			logger.Error(err.Error())
+			util.RespondWithInternalServerError(w)
+			return
+		}
+
+		if err == nil {
+			s.revocations.sessionRevoked(session.ID)
+		}
+	}
+
+	if payload.SessionID != uuid.Nil {
+		_, err := s.revocations.revokeSession(db.RevokeSessionOfAUserParams{
+			ID:       payload.SessionID,
+			Username: payload.Username,
+		})
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			logger.Error(err.Error())
+			util.RespondWithInternalServerError(w)
+			return
+		}
+	}
+
+	if err := s.revocations.revoke(payload); err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	util.RespondWithOk(w, "Successfully logged out")
+}
+
+// Handler to log out every access token and session of the current user
+func (s *Server) LogoutUserEverywhere(w http.ResponseWriter, r *http.Request) {
+	username := r.Header.Get(authUsernameHeaderKey)
+
+	if err := s.store.RevokeUserSessions(username); err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	if err := s.revocations.revokeAll(username); err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	util.RespondWithOk(w, "Successfully logged out everywhere")
+}
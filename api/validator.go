@@ -15,6 +15,15 @@ var (
 	isFullName = regexp.MustCompile(`^[a-zA-Z]{2,50}(?: [a-zA-Z.'-]{2,50})+$`).MatchString
 )
 
+// tagValidator refuses commas, as tags are stored comma separated
+var tagValidator validator.Func = func(fl validator.FieldLevel) bool {
+	if field, ok := fl.Field().Interface().(string); ok {
+		return !strings.Contains(field, ",")
+	} else {
+		return false
+	}
+}
+
 var fullNameValidator validator.Func = func(fl validator.FieldLevel) bool {
 	if field, ok := fl.Field().Interface().(string); ok {
 		return isFullName(field)
@@ -36,6 +45,7 @@ func init() {
 	})
 
 	validate.RegisterValidation("full_name", fullNameValidator)
+	validate.RegisterValidation("tag", tagValidator)
 
 	// This is also other way to get the json tag from field
 	// validationErrors := err.(validator.ValidationErrors)
@@ -47,7 +57,7 @@ func init() {
 
 func msgForTag(fe validator.FieldError) string {
 	switch fe.Tag() {
-	case "required":
+	case "required", "required_with", "required_without":
 		return "This field is required"
 
 	case "alphanum":
@@ -57,11 +67,29 @@ func msgForTag(fe validator.FieldError) string {
 		return fmt.Sprintf("This field must have at least %v characters", fe.Param())
 
 	case "max":
+		if fe.Kind() == reflect.Slice {
+			return fmt.Sprintf("This field can have at most %v items", fe.Param())
+		}
 		return fmt.Sprintf("This field can have at most %v characters", fe.Param())
 
 	case "email":
 		return "The email address is invalid"
 
+	case "url":
+		return "The URL is invalid"
+
+	case "excludes":
+		return fmt.Sprintf("This field can't contain %q", fe.Param())
+
+	case "oneof":
+		return fmt.Sprintf("This field must be one of: %v", fe.Param())
+
+	case "datetime":
+		return "This field must be a date and time like 2006-01-02T15:04:05Z"
+
+	case "tag":
+		return "Tags can't contain commas"
+
 	case "full_name":
 		return "Full name must have at least first name and last name each with at least 2 & at max 50 characters & seperated by space"
 
@@ -0,0 +1,336 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/sbbullet/to-do/db"
+	"github.com/sbbullet/to-do/logger"
+	"github.com/sbbullet/to-do/util"
+	"github.com/sbbullet/to-do/webhook"
+)
+
+type createWebhookRequest struct {
+	URL    string   `json:"url" validate:"required,url,max=2048"`
+	Secret string   `json:"secret" validate:"omitempty,min=16,max=128"`
+	Events []string `json:"events" validate:"dive,oneof=* todo.created todo.updated todo.deleted"`
+}
+
+type webhookResponse struct {
+	ID        uuid.UUID `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type createdWebhookResponse struct {
+	webhookResponse
+	Secret string `json:"secret"`
+}
+
+func createWebhookResponse(webhook db.Webhook) webhookResponse {
+	return webhookResponse{
+		ID:        webhook.ID,
+		URL:       webhook.URL,
+		Events:    webhook.Events,
+		CreatedAt: webhook.CreatedAt,
+	}
+}
+
+// Create webhook subscription for the authorized user
+func (s *Server) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req createWebhookRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		util.RespondWithBadRequest(w, "Invalid request payload")
+		return
+	}
+
+	validationErrors := validateRequest(req)
+	if validationErrors != nil {
+		util.RespondWithValidationErrors(w, validationErrors)
+		return
+	}
+
+	if err := webhook.CheckReceiverURL(req.URL); err != nil {
+		message := "The URL must be an http or https URL"
+		if errors.Is(err, webhook.ErrForbiddenAddress) {
+			message = "The URL can't point to a loopback, private, link-local or unspecified address"
+		}
+		util.RespondWithValidationErrors(w, map[string][]string{
+			"url": {message},
+		})
+		return
+	}
+
+	if len(req.Events) == 0 {
+		req.Events = []string{db.WebhookEventAll}
+	}
+
+	if len(req.Secret) == 0 {
+		secret, err := util.RandomSecret(32)
+		if err != nil {
+			logger.Error(err.Error())
+			util.RespondWithInternalServerError(w)
+			return
+		}
+		req.Secret = secret
+	}
+
+	webhookID, err := uuid.NewRandom()
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	arg := db.CreateWebhookParams{
+		ID:       webhookID,
+		Username: r.Header.Get(authUsernameHeaderKey),
+		URL:      req.URL,
+		Secret:   req.Secret,
+		Events:   req.Events,
+	}
+
+	webhook, err := s.store.CreateWebhook(arg)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	// The secret is only ever shown on creation
+	util.RespondWithOk(w, createdWebhookResponse{
+		webhookResponse: createWebhookResponse(webhook),
+		Secret:          webhook.Secret,
+	})
+}
+
+// Get webhook subscriptions of the authorized user
+func (s *Server) GetUserWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := s.store.GetUserWebhooks(r.Header.Get(authUsernameHeaderKey))
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	webhooksToSend := []webhookResponse{}
+	for _, webhook := range webhooks {
+		webhooksToSend = append(webhooksToSend, createWebhookResponse(webhook))
+	}
+
+	util.RespondWithOk(w, webhooksToSend)
+}
+
+// Delete specified webhook subscription of the authorized user
+func (s *Server) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	webhookID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		util.RespondWithBadRequest(w, "Invalid webhook identifier")
+		return
+	}
+
+	arg := db.DeleteWebhookOfAUserParams{
+		ID:       webhookID,
+		Username: r.Header.Get(authUsernameHeaderKey),
+	}
+
+	err = s.store.DeleteWebhookOfAUser(arg)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			util.RespondWithNotFoundError(w, "Oops!! We couldn't find the associated webhook")
+			return
+		}
+
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	util.RespondWithOk(w, "Successfully deleted specified webhook")
+}
+
+type webhookDeliveryResponse struct {
+	ID            uuid.UUID       `json:"id"`
+	Event         string          `json:"event"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt time.Time       `json:"next_attempt_at"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+type webhookDeliveryLogResponse struct {
+	StatusCode  int       `json:"status_code"`
+	Error       string    `json:"error"`
+	DurationMs  int64     `json:"duration_ms"`
+	AttemptedAt time.Time `json:"attempted_at"`
+}
+
+type webhookDeliveryDetailResponse struct {
+	webhookDeliveryResponse
+	Logs []webhookDeliveryLogResponse `json:"logs"`
+}
+
+func createWebhookDeliveryResponse(delivery db.WebhookDelivery) webhookDeliveryResponse {
+	return webhookDeliveryResponse{
+		ID:            delivery.ID,
+		Event:         delivery.Event,
+		Payload:       json.RawMessage(delivery.Payload),
+		Status:        delivery.Status,
+		Attempts:      delivery.Attempts,
+		NextAttemptAt: delivery.NextAttemptAt,
+		CreatedAt:     delivery.CreatedAt,
+	}
+}
+
+// Get recent deliveries of specified webhook of the authorized user
+func (s *Server) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := s.ownedWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	pageNum, err := strconv.Atoi(r.URL.Query().Get("page_num"))
+	if err != nil {
+		pageNum = 1
+	}
+
+	pageSize, err := strconv.Atoi(r.URL.Query().Get("page_size"))
+	if err != nil {
+		pageSize = 20
+	}
+
+	if pageNum <= 0 || pageSize <= 0 {
+		util.RespondWithBadRequest(w, "Page number and page size must be greater than zero")
+		return
+	}
+
+	deliveries, err := s.store.GetWebhookDeliveries(db.GetWebhookDeliveriesParams{
+		WebhookID: webhook.ID,
+		Limit:     pageSize,
+		Offset:    (pageNum - 1) * pageSize,
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	deliveriesToSend := []webhookDeliveryResponse{}
+	for _, delivery := range deliveries {
+		deliveriesToSend = append(deliveriesToSend, createWebhookDeliveryResponse(delivery))
+	}
+
+	util.RespondWithOk(w, deliveriesToSend)
+}
+
+// Get specified delivery of a webhook along with the log of its attempts
+func (s *Server) GetWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	delivery, ok := s.ownedWebhookDelivery(w, r)
+	if !ok {
+		return
+	}
+
+	logs, err := s.store.GetWebhookDeliveryLogs(delivery.ID)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	response := webhookDeliveryDetailResponse{
+		webhookDeliveryResponse: createWebhookDeliveryResponse(delivery),
+		Logs:                    []webhookDeliveryLogResponse{},
+	}
+	for _, log := range logs {
+		response.Logs = append(response.Logs, webhookDeliveryLogResponse{
+			StatusCode:  log.StatusCode,
+			Error:       log.Error,
+			DurationMs:  log.DurationMs,
+			AttemptedAt: log.AttemptedAt,
+		})
+	}
+
+	util.RespondWithOk(w, response)
+}
+
+// Queue specified delivery of a webhook to be sent again
+func (s *Server) RedeliverWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	delivery, ok := s.ownedWebhookDelivery(w, r)
+	if !ok {
+		return
+	}
+
+	delivery, err := s.store.RedeliverWebhookDelivery(delivery.ID)
+	if err != nil {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return
+	}
+
+	util.RespondWithOk(w, createWebhookDeliveryResponse(delivery))
+}
+
+// ownedWebhook looks up the webhook in the route and makes sure it belongs to
+// the authorized user, responding with an error otherwise
+func (s *Server) ownedWebhook(w http.ResponseWriter, r *http.Request) (db.Webhook, bool) {
+	webhookID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		util.RespondWithBadRequest(w, "Invalid webhook identifier")
+		return db.Webhook{}, false
+	}
+
+	webhook, err := s.store.GetWebhookById(webhookID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			util.RespondWithNotFoundError(w, "Oops!! We couldn't find the associated webhook")
+			return db.Webhook{}, false
+		}
+
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return db.Webhook{}, false
+	}
+
+	if webhook.Username != r.Header.Get(authUsernameHeaderKey) {
+		util.RespondWithForbiddenError(w, "You are forbidden to perform the action on this resource")
+		return db.Webhook{}, false
+	}
+
+	return webhook, true
+}
+
+func (s *Server) ownedWebhookDelivery(w http.ResponseWriter, r *http.Request) (db.WebhookDelivery, bool) {
+	webhook, ok := s.ownedWebhook(w, r)
+	if !ok {
+		return db.WebhookDelivery{}, false
+	}
+
+	deliveryID, err := uuid.Parse(mux.Vars(r)["delivery_id"])
+	if err != nil {
+		util.RespondWithBadRequest(w, "Invalid delivery identifier")
+		return db.WebhookDelivery{}, false
+	}
+
+	delivery, err := s.store.GetWebhookDeliveryById(deliveryID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		logger.Error(err.Error())
+		util.RespondWithInternalServerError(w)
+		return db.WebhookDelivery{}, false
+	}
+
+	if err != nil || delivery.WebhookID != webhook.ID {
+		util.RespondWithNotFoundError(w, "Oops!! We couldn't find the associated delivery")
+		return db.WebhookDelivery{}, false
+	}
+
+	return delivery, true
+}
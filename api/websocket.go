@@ -0,0 +1,579 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/sbbullet/to-do/db"
+	"github.com/sbbullet/to-do/logger"
+	"github.com/sbbullet/to-do/token"
+	"github.com/sbbullet/to-do/util"
+	"go.uber.org/zap"
+)
+
+const (
+	wsWriteWait      = 10 * time.Second
+	wsPongWait       = 60 * time.Second
+	wsPingPeriod     = (wsPongWait * 9) / 10
+	wsMaxMessageSize = 4096
+	wsSendBufferSize = 64
+
+	wsAccessTokenQueryKey = "access_token"
+)
+
+// Topics a client can subscribe to
+const (
+	wsTopicTodo     = "todo"
+	wsTopicTodoList = "todos"
+)
+
+// Presence states a client can announce for a todo
+const (
+	presenceViewing = "viewing"
+	presenceEditing = "editing"
+	presenceIdle    = "idle"
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+type wsIncomingMessage struct {
+	Type        string   `json:"type"`
+	RequestID   string   `json:"request_id"`
+	Topic       string   `json:"topic"`
+	TodoID      string   `json:"todo_id"`
+	Title       string   `json:"title"`
+	IsCompleted *bool    `json:"is_completed"`
+	Priority    *string  `json:"priority"`
+	DueAt       *string  `json:"due_at"`
+	Tags        []string `json:"tags"`
+	Status      string   `json:"status"`
+}
+
+type wsOutgoingMessage struct {
+	Type      string              `json:"type"`
+	RequestID string              `json:"request_id,omitempty"`
+	Data      interface{}         `json:"data,omitempty"`
+	Error     string              `json:"error,omitempty"`
+	Errors    map[string][]string `json:"errors,omitempty"`
+}
+
+type presenceResponse struct {
+	Username string    `json:"username"`
+	TodoID   uuid.UUID `json:"todo_id"`
+	Status   string    `json:"status"`
+}
+
+// wsClient is a single authenticated websocket connection
+type wsClient struct {
+	server   *Server
+	conn     *websocket.Conn
+	username string
+	payload  *token.Payload
+	send     chan []byte
+	// apiKeyHash is the hash of the API key the connection was opened with,
+	// if it was, to find out when the key is deleted
+	apiKeyHash string
+
+	mu       sync.Mutex
+	closed   bool
+	presence map[uuid.UUID]string
+}
+
+// wsTokenMiddleware lets browser clients, which cannot set headers on a
+// websocket handshake, pass the access token as a query parameter
+func wsTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accessToken := r.URL.Query().Get(wsAccessTokenQueryKey)
+		if len(r.Header.Get(authorizationHeaderKey)) == 0 && len(accessToken) > 0 {
+			r.Header.Set(authorizationHeaderKey, authorizationType+" "+accessToken)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Upgrade the connection of the authorized user to a websocket
+func (s *Server) ServeWebSocket(w http.ResponseWriter, r *http.Request) {
+	if !websocket.IsWebSocketUpgrade(r) {
+		util.RespondWithBadRequest(w, "This endpoint only accepts websocket connections")
+		return
+	}
+
+	username := r.Header.Get(authUsernameHeaderKey)
+
+	var apiKeyHash string
+	if apiKey, ok := requestAPIKey(r); ok {
+		apiKeyHash = util.HashSecret(apiKey)
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already replied with an HTTP error
+		logger.Error(err.Error())
+		return
+	}
+
+	client := &wsClient{
+		server:   s,
+		conn:     conn,
+		username: username,
+		payload:  authPayload(r),
+		send:     make(chan []byte, wsSendBufferSize),
+		presence: map[uuid.UUID]string{},
+
+		apiKeyHash: apiKeyHash,
+	}
+
+	// The token can expire long before the connection is closed
+	if !client.payload.ExpiresAt.IsZero() {
+		expiry := time.AfterFunc(time.Until(client.payload.ExpiresAt), func() {
+			client.close(websocket.ClosePolicyViolation, "Your session has expired. Please, log in again to new session")
+		})
+		defer expiry.Stop()
+	}
+
+	go client.writePump()
+	client.readPump()
+}
+
+// authorized tells whether the token the connection was opened with is still
+// good, as it can expire or be revoked, and its user be disabled or deleted,
+// after the upgrade
+func (c *wsClient) authorized() (bool, error) {
+	if !c.payload.ExpiresAt.IsZero() && time.Now().After(c.payload.ExpiresAt) {
+		return false, nil
+	}
+
+	// API keys are deleted rather than revoked, as authorizeAPIKey has it
+	if len(c.apiKeyHash) > 0 {
+		_, err := c.server.store.GetAPIKeyByHash(c.apiKeyHash)
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}
+
+	return !c.server.revocations.isRevoked(c.payload), nil
+}
+
+// stillAuthorized closes the connection unless its token is still good
+func (c *wsClient) stillAuthorized() bool {
+	ok, err := c.authorized()
+	if err != nil {
+		logger.Error(err.Error())
+		c.close(websocket.CloseInternalServerErr, "Something went wrong on the server. Please, try after a while")
+		return false
+	}
+
+	if !ok {
+		c.close(websocket.ClosePolicyViolation, "Your session has ended. Please, log in again to new session")
+	}
+
+	return ok
+}
+
+// close tells the client why the connection is closed and closes it, which
+// stops both pumps
+func (c *wsClient) close(code int, reason string) {
+	message := websocket.FormatCloseMessage(code, reason)
+	if err := c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(wsWriteWait)); err != nil && !errors.Is(err, websocket.ErrCloseSent) {
+		logger.Error(err.Error())
+	}
+
+	c.conn.Close()
+}
+
+// enqueue queues the message for delivery, dropping the connection if the
+// client is too slow to keep up
+func (c *wsClient) enqueue(data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+
+	select {
+	case c.send <- data:
+	default:
+		c.closed = true
+		close(c.send)
+	}
+}
+
+func (c *wsClient) reply(msg wsOutgoingMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		logger.Error(err.Error())
+		return
+	}
+
+	c.enqueue(data)
+}
+
+func (c *wsClient) replyError(requestID string, errorMsg string) {
+	c.reply(wsOutgoingMessage{Type: "error", RequestID: requestID, Error: errorMsg})
+}
+
+func (c *wsClient) readPump() {
+	defer func() {
+		c.server.hub.unsubscribeAll(c)
+		c.clearPresence()
+
+		c.mu.Lock()
+		if !c.closed {
+			c.closed = true
+			close(c.send)
+		}
+		c.mu.Unlock()
+
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		var msg wsIncomingMessage
+		if err := c.conn.ReadJSON(&msg); err != nil {
+			var syntaxErr *json.SyntaxError
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+				c.replyError("", "Invalid message payload")
+				continue
+			}
+
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				logger.Error(err.Error())
+			}
+			return
+		}
+
+		c.handleMessage(msg)
+	}
+}
+
+func (c *wsClient) writePump() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case data, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			// Also stops broadcasts to connections whose token was revoked
+			// while they were idle
+			if !c.stillAuthorized() {
+				return
+			}
+
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *wsClient) handleMessage(msg wsIncomingMessage) {
+	if len(c.payload.Impersonator) > 0 {
+		logger.Info(
+			"Impersonated websocket message",
+			zap.String("Impersonator", c.payload.Impersonator),
+			zap.String("Username", c.username),
+			zap.String("Type", msg.Type),
+			zap.String("RequestID", msg.RequestID),
+		)
+	}
+
+	if !c.stillAuthorized() {
+		return
+	}
+
+	switch msg.Type {
+	case "subscribe":
+		c.handleSubscribe(msg, true)
+
+	case "unsubscribe":
+		c.handleSubscribe(msg, false)
+
+	case "create_todo":
+		if c.canWrite(msg) {
+			c.handleCreateTodo(msg)
+		}
+
+	case "update_todo":
+		if c.canWrite(msg) {
+			c.handleUpdateTodo(msg)
+		}
+
+	case "delete_todo":
+		if c.canWrite(msg) {
+			c.handleDeleteTodo(msg)
+		}
+
+	case "presence":
+		c.handlePresence(msg)
+
+	default:
+		c.replyError(msg.RequestID, "Unknown message type")
+	}
+}
+
+// canWrite tells whether the connection was authorized to change todos,
+// replying with an error otherwise
+func (c *wsClient) canWrite(msg wsIncomingMessage) bool {
+	if !c.payload.HasScope(token.ScopeTodosWrite) {
+		c.replyError(msg.RequestID, fmt.Sprintf("This action requires the %s scope", token.ScopeTodosWrite))
+		return false
+	}
+
+	return true
+}
+
+// ownedTodo looks up the todo referenced by the message and makes sure it
+// belongs to the connected user, replying with an error otherwise
+func (c *wsClient) ownedTodo(msg wsIncomingMessage) (db.Todo, bool) {
+	todoID, err := uuid.Parse(msg.TodoID)
+	if err != nil {
+		c.replyError(msg.RequestID, "Invalid todo identifier")
+		return db.Todo{}, false
+	}
+
+	todo, err := c.server.store.GetTodoById(todoID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.replyError(msg.RequestID, "Oops!! We couldn't find the associated todo")
+			return db.Todo{}, false
+		}
+
+		logger.Error(err.Error())
+		c.replyError(msg.RequestID, "Something went wrong on the server. Please, try after a while")
+		return db.Todo{}, false
+	}
+
+	if todo.Username != c.username {
+		c.replyError(msg.RequestID, "You are forbidden to perform the action on this resource")
+		return db.Todo{}, false
+	}
+
+	return todo, true
+}
+
+func (c *wsClient) handleSubscribe(msg wsIncomingMessage, subscribe bool) {
+	var topic string
+
+	switch msg.Topic {
+	case wsTopicTodoList:
+		topic = todoListTopic(c.username)
+
+	case wsTopicTodo:
+		todo, ok := c.ownedTodo(msg)
+		if !ok {
+			return
+		}
+		topic = todoTopic(todo.ID)
+
+	default:
+		c.replyError(msg.RequestID, "Topic must be either todo or todos")
+		return
+	}
+
+	if subscribe {
+		c.server.hub.subscribe(topic, c)
+	} else {
+		c.server.hub.unsubscribe(topic, c)
+	}
+
+	c.reply(wsOutgoingMessage{Type: "ack", RequestID: msg.RequestID})
+}
+
+func (c *wsClient) handleCreateTodo(msg wsIncomingMessage) {
+	req := createTodoRequest{Title: msg.Title, Tags: msg.Tags}
+	if msg.Priority != nil {
+		req.Priority = *msg.Priority
+	}
+	if msg.DueAt != nil {
+		req.DueAt = *msg.DueAt
+	}
+
+	validationErrors := validateRequest(req)
+	if validationErrors != nil {
+		c.reply(wsOutgoingMessage{Type: "error", RequestID: msg.RequestID, Errors: validationErrors})
+		return
+	}
+
+	todoID, err := uuid.NewRandom()
+	if err != nil {
+		logger.Error(err.Error())
+		c.replyError(msg.RequestID, "Something went wrong on the server. Please, try after a while")
+		return
+	}
+
+	todo, err := c.server.store.CreateTodo(db.CreateTodoParams{
+		ID:       todoID,
+		Username: c.username,
+		Title:    req.Title,
+		Priority: req.Priority,
+		DueAt:    parseDueAt(req.DueAt),
+		Tags:     req.Tags,
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		c.replyError(msg.RequestID, "Something went wrong on the server. Please, try after a while")
+		return
+	}
+
+	c.reply(wsOutgoingMessage{Type: "ack", RequestID: msg.RequestID, Data: createTodoResponse(todo)})
+	c.server.hub.publishTodoEvent(eventTodoCreated, todo)
+}
+
+func (c *wsClient) handleUpdateTodo(msg wsIncomingMessage) {
+	req := updateTodoRequest{
+		Title:       msg.Title,
+		IsCompleted: msg.IsCompleted,
+		Priority:    msg.Priority,
+		DueAt:       msg.DueAt,
+		Tags:        msg.Tags,
+	}
+
+	validationErrors := validateRequest(req)
+	if validationErrors != nil {
+		c.reply(wsOutgoingMessage{Type: "error", RequestID: msg.RequestID, Errors: validationErrors})
+		return
+	}
+
+	todo, ok := c.ownedTodo(msg)
+	if !ok {
+		return
+	}
+
+	var isCompleted sql.NullBool
+	if req.IsCompleted != nil {
+		isCompleted = sql.NullBool{Bool: *req.IsCompleted, Valid: true}
+	}
+
+	updatedTodo, err := c.server.store.UpdateTodo(db.UpdateTodoParams{
+		ID:          todo.ID,
+		Title:       sql.NullString{String: req.Title, Valid: len(req.Title) > 0},
+		IsCompleted: isCompleted,
+		Priority:    updatedPriority(req.Priority),
+		DueAt:       updatedDueAt(req.DueAt),
+		Tags:        req.Tags,
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		c.replyError(msg.RequestID, "Something went wrong on the server. Please, try after a while")
+		return
+	}
+
+	c.reply(wsOutgoingMessage{Type: "ack", RequestID: msg.RequestID, Data: createTodoResponse(updatedTodo)})
+	c.server.hub.publishTodoEvent(eventTodoUpdated, updatedTodo)
+}
+
+func (c *wsClient) handleDeleteTodo(msg wsIncomingMessage) {
+	todo, ok := c.ownedTodo(msg)
+	if !ok {
+		return
+	}
+
+	err := c.server.store.DeleteTodoOfAUser(db.DeleteTodoOfAUserParams{
+		ID:       todo.ID,
+		Username: c.username,
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.replyError(msg.RequestID, "Oops! We couldn't any of your todos with given identifier")
+			return
+		}
+
+		logger.Error(err.Error())
+		c.replyError(msg.RequestID, "Something went wrong on the server. Please, try after a while")
+		return
+	}
+
+	c.reply(wsOutgoingMessage{Type: "ack", RequestID: msg.RequestID})
+	c.server.hub.publishTodoDeleted(todo.ID, todo.Username)
+}
+
+func (c *wsClient) handlePresence(msg wsIncomingMessage) {
+	if msg.Status != presenceViewing && msg.Status != presenceEditing && msg.Status != presenceIdle {
+		c.replyError(msg.RequestID, "Status must be one of viewing, editing or idle")
+		return
+	}
+
+	todo, ok := c.ownedTodo(msg)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	if msg.Status == presenceIdle {
+		delete(c.presence, todo.ID)
+	} else {
+		c.presence[todo.ID] = msg.Status
+	}
+	c.mu.Unlock()
+
+	c.reply(wsOutgoingMessage{Type: "ack", RequestID: msg.RequestID})
+	c.broadcastPresence(todo.ID, msg.Status)
+}
+
+// clearPresence tells the other subscribers the client is gone from every
+// todo it announced presence on
+func (c *wsClient) clearPresence() {
+	c.mu.Lock()
+	todoIDs := make([]uuid.UUID, 0, len(c.presence))
+	for todoID := range c.presence {
+		todoIDs = append(todoIDs, todoID)
+	}
+	c.presence = map[uuid.UUID]string{}
+	c.mu.Unlock()
+
+	for _, todoID := range todoIDs {
+		c.broadcastPresence(todoID, presenceIdle)
+	}
+}
+
+func (c *wsClient) broadcastPresence(todoID uuid.UUID, status string) {
+	msg := wsOutgoingMessage{
+		Type: eventPresence,
+		Data: presenceResponse{
+			Username: c.username,
+			TodoID:   todoID,
+			Status:   status,
+		},
+	}
+
+	c.server.hub.publish(msg, c, todoTopic(todoID), todoListTopic(c.username))
+}
@@ -0,0 +1,179 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Roles users can have. Admins can manage the accounts of everyone else.
+const (
+	UserRoleUser  = "user"
+	UserRoleAdmin = "admin"
+)
+
+type ListUsersParams struct {
+	// Query, when set, only keeps the users whose username, email or full
+	// name contains it, ignoring case
+	Query  string `json:"query"`
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset"`
+}
+
+// ListUsers lists the users, oldest first
+func (store *Store) ListUsers(arg ListUsersParams) ([]User, error) {
+	const listUsersQuery = `
+		SELECT username, email, full_name, hashed_password, created_at, email_verified_at, role, disabled_at, pending_email
+		FROM users
+		WHERE ? = ''
+			OR instr(lower(username), lower(?)) > 0
+			OR instr(lower(email), lower(?)) > 0
+			OR instr(lower(full_name), lower(?)) > 0
+		ORDER BY created_at, username
+		LIMIT ?
+		OFFSET ?;
+	`
+
+	rows, err := store.DB.Query(listUsersQuery, arg.Query, arg.Query, arg.Query, arg.Query, arg.Limit, arg.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+type TodoCounts struct {
+	Total     int64 `json:"total"`
+	Completed int64 `json:"completed"`
+}
+
+// GetUserTodoCounts counts the todos of the user
+func (store *Store) GetUserTodoCounts(username string) (counts TodoCounts, err error) {
+	const getUserTodoCountsQuery = `
+		SELECT COUNT(*), COALESCE(SUM(is_completed), 0)
+		FROM todos
+		WHERE username = ?;
+	`
+
+	err = store.DB.QueryRow(getUserTodoCountsQuery, username).Scan(&counts.Total, &counts.Completed)
+
+	return
+}
+
+type SetUserRoleParams struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+// SetUserRole changes the role of the user
+func (store *Store) SetUserRole(arg SetUserRoleParams) (User, error) {
+	const setUserRoleQuery = `
+		UPDATE users
+		SET role = ?
+		WHERE username = ?
+		RETURNING username, email, full_name, hashed_password, created_at, email_verified_at, role, disabled_at, pending_email;
+	`
+
+	row := store.DB.QueryRow(setUserRoleQuery, arg.Role, arg.Username)
+
+	return scanUser(row)
+}
+
+// DisableUser stops the user from logging in and revokes every session they
+// have, along with the authorization codes they have yet to be exchanged for
+// one. Their API keys and calendar feed stop working until they are enabled again.
+func (store *Store) DisableUser(username string) (user User, err error) {
+	const disableUserQuery = `
+		UPDATE users
+		SET disabled_at = COALESCE(disabled_at, ?)
+		WHERE username = ?
+		RETURNING username, email, full_name, hashed_password, created_at, email_verified_at, role, disabled_at, pending_email;
+	`
+	const revokeUserSessionsQuery = `
+		UPDATE sessions
+		SET revoked_at = ?
+		WHERE username = ? AND revoked_at IS NULL;
+	`
+	const deleteUnusedAuthorizationCodesQuery = `
+		DELETE FROM oauth_authorization_codes
+		WHERE username = ? AND used_at IS NULL;
+	`
+
+	err = store.execTx(func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+
+		user, err = scanUser(tx.QueryRow(disableUserQuery, now, username))
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(revokeUserSessionsQuery, now, username); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(deleteUnusedAuthorizationCodesQuery, username)
+		return err
+	})
+
+	return
+}
+
+// EnableUser lets a disabled user log in again
+func (store *Store) EnableUser(username string) (User, error) {
+	const enableUserQuery = `
+		UPDATE users
+		SET disabled_at = NULL
+		WHERE username = ?
+		RETURNING username, email, full_name, hashed_password, created_at, email_verified_at, role, disabled_at, pending_email;
+	`
+
+	row := store.DB.QueryRow(enableUserQuery, username)
+
+	return scanUser(row)
+}
+
+// ForcePasswordReset removes the password of the user and revokes every
+// session they have, so that they can't log in with a password again until
+// they reset it
+func (store *Store) ForcePasswordReset(username string) (user User, err error) {
+	const removePasswordQuery = `
+		UPDATE users
+		SET hashed_password = ''
+		WHERE username = ?
+		RETURNING username, email, full_name, hashed_password, created_at, email_verified_at, role, disabled_at, pending_email;
+	`
+	const revokeUserSessionsQuery = `
+		UPDATE sessions
+		SET revoked_at = ?
+		WHERE username = ? AND revoked_at IS NULL;
+	`
+
+	err = store.execTx(func(tx *sql.Tx) error {
+		user, err = scanUser(tx.QueryRow(removePasswordQuery, username))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(revokeUserSessionsQuery, time.Now().UTC(), username)
+		return err
+	})
+
+	return
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListUsers(t *testing.T) {
+	user := createRandomUser(t)
+	createRandomUser(t)
+
+	users, err := testStore.ListUsers(ListUsersParams{Query: strings.ToUpper(user.Username), Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	require.Equal(t, user.Username, users[0].Username)
+
+	users, err = testStore.ListUsers(ListUsersParams{Query: user.Email, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+
+	users, err = testStore.ListUsers(ListUsersParams{Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, users, 2)
+}
+
+func TestGetUserTodoCounts(t *testing.T) {
+	user := createRandomUser(t)
+
+	counts, err := testStore.GetUserTodoCounts(user.Username)
+	require.NoError(t, err)
+	require.Equal(t, TodoCounts{}, counts)
+
+	createRandomTodo(t, user.Username)
+	todo := createRandomTodo(t, user.Username)
+	_, err = testStore.UpdateTodo(UpdateTodoParams{ID: todo.ID, IsCompleted: sql.NullBool{Bool: true, Valid: true}})
+	require.NoError(t, err)
+
+	counts, err = testStore.GetUserTodoCounts(user.Username)
+	require.NoError(t, err)
+	require.Equal(t, TodoCounts{Total: 2, Completed: 1}, counts)
+}
+
+func TestSetUserRole(t *testing.T) {
+	user := createRandomUser(t)
+
+	updated, err := testStore.SetUserRole(SetUserRoleParams{Username: user.Username, Role: UserRoleAdmin})
+	require.NoError(t, err)
+	require.Equal(t, UserRoleAdmin, updated.Role)
+
+	_, err = testStore.SetUserRole(SetUserRoleParams{Username: "missing", Role: UserRoleAdmin})
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestDisableUser(t *testing.T) {
+	user := createRandomUser(t)
+	session, _ := createRandomSession(t, user.Username)
+	apiKey := createRandomAPIKey(t, user.Username)
+	code := createRandomOAuthAuthorizationCode(t, createRandomOAuthClient(t, user.Username), user.Username)
+
+	disabled, err := testStore.DisableUser(user.Username)
+	require.NoError(t, err)
+	require.NotNil(t, disabled.DisabledAt)
+	require.WithinDuration(t, time.Now(), *disabled.DisabledAt, time.Second)
+
+	revoked, err := testStore.GetSessionById(session.ID)
+	require.NoError(t, err)
+	require.NotNil(t, revoked.RevokedAt)
+
+	_, err = testStore.GetAPIKeyByHash(apiKey.KeyHash)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	_, err = testStore.GetOAuthAuthorizationCode(code.CodeHash)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	enabled, err := testStore.EnableUser(user.Username)
+	require.NoError(t, err)
+	require.Nil(t, enabled.DisabledAt)
+
+	_, err = testStore.GetAPIKeyByHash(apiKey.KeyHash)
+	require.NoError(t, err)
+}
+
+func TestForcePasswordReset(t *testing.T) {
+	user := createRandomUser(t)
+	session, _ := createRandomSession(t, user.Username)
+
+	updated, err := testStore.ForcePasswordReset(user.Username)
+	require.NoError(t, err)
+	require.Empty(t, updated.HashedPassword)
+
+	revoked, err := testStore.GetSessionById(session.ID)
+	require.NoError(t, err)
+	require.NotNil(t, revoked.RevokedAt)
+}
@@ -0,0 +1,156 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type CreateAPIKeyParams struct {
+	ID        uuid.UUID  `json:"id"`
+	Username  string     `json:"username"`
+	Name      string     `json:"name"`
+	KeyPrefix string     `json:"key_prefix"`
+	KeyHash   string     `json:"key_hash"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func (store *Store) CreateAPIKey(arg CreateAPIKeyParams) (APIKey, error) {
+	const createAPIKeyQuery = `
+		INSERT INTO api_keys(id, username, name, key_prefix, key_hash, scopes, expires_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?)
+		RETURNING id, username, name, key_prefix, key_hash, scopes, expires_at, last_used_at, created_at;
+	`
+
+	var expiresAt *time.Time
+	if arg.ExpiresAt != nil {
+		utc := arg.ExpiresAt.UTC()
+		expiresAt = &utc
+	}
+
+	row := store.DB.QueryRow(
+		createAPIKeyQuery,
+		arg.ID,
+		arg.Username,
+		arg.Name,
+		arg.KeyPrefix,
+		arg.KeyHash,
+		strings.Join(arg.Scopes, ","),
+		expiresAt,
+	)
+
+	return scanAPIKey(row)
+}
+
+// GetAPIKeyByHash gets the API key with the hash, unless its user has been disabled
+func (store *Store) GetAPIKeyByHash(keyHash string) (APIKey, error) {
+	const getAPIKeyByHashQuery = `
+		SELECT id, username, name, key_prefix, key_hash, scopes, expires_at, last_used_at, created_at
+		FROM api_keys
+		WHERE key_hash = ? AND username NOT IN (SELECT username FROM users WHERE disabled_at IS NOT NULL);
+	`
+
+	row := store.DB.QueryRow(getAPIKeyByHashQuery, keyHash)
+
+	return scanAPIKey(row)
+}
+
+func (store *Store) GetUserAPIKeys(username string) ([]APIKey, error) {
+	const getUserAPIKeysQuery = `
+		SELECT id, username, name, key_prefix, key_hash, scopes, expires_at, last_used_at, created_at
+		FROM api_keys
+		WHERE username = ?
+		ORDER BY created_at DESC;
+	`
+
+	rows, err := store.DB.Query(getUserAPIKeysQuery, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	apiKeys := []APIKey{}
+	for rows.Next() {
+		apiKey, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		apiKeys = append(apiKeys, apiKey)
+	}
+
+	return apiKeys, rows.Err()
+}
+
+type DeleteAPIKeyOfAUserParams struct {
+	ID       uuid.UUID `json:"id"`
+	Username string    `json:"username"`
+}
+
+func (store *Store) DeleteAPIKeyOfAUser(arg DeleteAPIKeyOfAUserParams) error {
+	const deleteAPIKeyOfAUserQuery = `
+		DELETE FROM api_keys
+		WHERE id = ? AND username = ?;
+	`
+
+	result, err := store.DB.Exec(deleteAPIKeyOfAUserQuery, arg.ID, arg.Username)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected < 1 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+type TouchAPIKeyParams struct {
+	ID         uuid.UUID `json:"id"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+// TouchAPIKey records that the API key has been used, writing at most once a
+// minute for keys used by busy scripts
+func (store *Store) TouchAPIKey(arg TouchAPIKeyParams) error {
+	const touchAPIKeyQuery = `
+		UPDATE api_keys
+		SET last_used_at = ?
+		WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?);
+	`
+
+	lastUsedAt := arg.LastUsedAt.UTC()
+	_, err := store.DB.Exec(touchAPIKeyQuery, lastUsedAt, arg.ID, lastUsedAt.Add(-time.Minute))
+
+	return err
+}
+
+func scanAPIKey(row rowScanner) (apiKey APIKey, err error) {
+	var scopes string
+
+	err = row.Scan(
+		&apiKey.ID,
+		&apiKey.Username,
+		&apiKey.Name,
+		&apiKey.KeyPrefix,
+		&apiKey.KeyHash,
+		&scopes,
+		&apiKey.ExpiresAt,
+		&apiKey.LastUsedAt,
+		&apiKey.CreatedAt,
+	)
+
+	apiKey.Scopes = []string{}
+	if len(scopes) > 0 {
+		apiKey.Scopes = strings.Split(scopes, ",")
+	}
+
+	return
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sbbullet/to-do/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateAPIKey(t *testing.T) {
+	user := createRandomUser(t)
+	apiKey := createRandomAPIKey(t, user.Username)
+
+	found, err := testStore.GetAPIKeyByHash(apiKey.KeyHash)
+	require.NoError(t, err)
+	require.Equal(t, apiKey.ID, found.ID)
+	require.Equal(t, []string{"todos:read", "todos:write"}, found.Scopes)
+	require.NotNil(t, found.ExpiresAt)
+	require.Nil(t, found.LastUsedAt)
+}
+
+func TestGetUserAPIKeys(t *testing.T) {
+	user := createRandomUser(t)
+	for i := 0; i < 3; i++ {
+		createRandomAPIKey(t, user.Username)
+	}
+
+	apiKeys, err := testStore.GetUserAPIKeys(user.Username)
+	require.NoError(t, err)
+	require.Len(t, apiKeys, 3)
+}
+
+func TestDeleteAPIKeyOfAUser(t *testing.T) {
+	user := createRandomUser(t)
+	apiKey := createRandomAPIKey(t, user.Username)
+
+	err := testStore.DeleteAPIKeyOfAUser(DeleteAPIKeyOfAUserParams{ID: apiKey.ID, Username: createRandomUser(t).Username})
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	err = testStore.DeleteAPIKeyOfAUser(DeleteAPIKeyOfAUserParams{ID: apiKey.ID, Username: user.Username})
+	require.NoError(t, err)
+
+	_, err = testStore.GetAPIKeyByHash(apiKey.KeyHash)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestTouchAPIKey(t *testing.T) {
+	user := createRandomUser(t)
+	apiKey := createRandomAPIKey(t, user.Username)
+
+	usedAt := time.Now()
+	err := testStore.TouchAPIKey(TouchAPIKeyParams{ID: apiKey.ID, LastUsedAt: usedAt})
+	require.NoError(t, err)
+
+	// Uses within a minute of the last recorded one are not written
+	err = testStore.TouchAPIKey(TouchAPIKeyParams{ID: apiKey.ID, LastUsedAt: usedAt.Add(30 * time.Second)})
+	require.NoError(t, err)
+
+	touched, err := testStore.GetAPIKeyByHash(apiKey.KeyHash)
+	require.NoError(t, err)
+	require.WithinDuration(t, usedAt, *touched.LastUsedAt, time.Second)
+}
+
+func createRandomAPIKey(t *testing.T, username string) APIKey {
+	expiresAt := time.Now().Add(time.Hour)
+	arg := CreateAPIKeyParams{
+		ID:        uuid.New(),
+		Username:  username,
+		Name:      util.RandomString(8),
+		KeyPrefix: util.RandomString(12),
+		KeyHash:   util.HashSecret(util.RandomString(32)),
+		Scopes:    []string{"todos:read", "todos:write"},
+		ExpiresAt: &expiresAt,
+	}
+
+	apiKey, err := testStore.CreateAPIKey(arg)
+	require.NoError(t, err)
+	require.Equal(t, arg.ID, apiKey.ID)
+	require.Equal(t, arg.Name, apiKey.Name)
+
+	return apiKey
+}
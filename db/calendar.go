@@ -0,0 +1,51 @@
+package db
+
+type UpsertCalendarFeedParams struct {
+	Username  string `json:"username"`
+	TokenHash string `json:"token_hash"`
+}
+
+// UpsertCalendarFeed creates the calendar feed of the user or replaces its
+// token, which revokes the previous feed URL
+func (store *Store) UpsertCalendarFeed(arg UpsertCalendarFeedParams) (feed CalendarFeed, err error) {
+	const upsertCalendarFeedQuery = `
+		INSERT INTO calendar_feeds(username, token_hash)
+		VALUES(?, ?)
+		ON CONFLICT(username) DO UPDATE SET
+			token_hash = excluded.token_hash,
+			created_at = datetime('now')
+		RETURNING username, token_hash, created_at;
+	`
+
+	row := store.DB.QueryRow(upsertCalendarFeedQuery, arg.Username, arg.TokenHash)
+
+	err = row.Scan(&feed.Username, &feed.TokenHash, &feed.CreatedAt)
+
+	return
+}
+
+// GetCalendarFeedByTokenHash gets the feed with the token hash, unless its user has been disabled
+func (store *Store) GetCalendarFeedByTokenHash(tokenHash string) (feed CalendarFeed, err error) {
+	const getCalendarFeedByTokenHashQuery = `
+		SELECT username, token_hash, created_at
+		FROM calendar_feeds
+		WHERE token_hash = ? AND username NOT IN (SELECT username FROM users WHERE disabled_at IS NOT NULL);
+	`
+
+	row := store.DB.QueryRow(getCalendarFeedByTokenHashQuery, tokenHash)
+
+	err = row.Scan(&feed.Username, &feed.TokenHash, &feed.CreatedAt)
+
+	return
+}
+
+func (store *Store) DeleteCalendarFeed(username string) error {
+	const deleteCalendarFeedQuery = `
+		DELETE FROM calendar_feeds
+		WHERE username = ?;
+	`
+
+	_, err := store.DB.Exec(deleteCalendarFeedQuery, username)
+
+	return err
+}
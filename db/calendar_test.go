@@ -0,0 +1,50 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sbbullet/to-do/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpsertCalendarFeed(t *testing.T) {
+	user := createRandomUser(t)
+
+	first := upsertRandomCalendarFeed(t, user.Username)
+	second := upsertRandomCalendarFeed(t, user.Username)
+
+	// Replacing the token revokes the previous one
+	_, err := testStore.GetCalendarFeedByTokenHash(first.TokenHash)
+	require.Error(t, err)
+
+	feed, err := testStore.GetCalendarFeedByTokenHash(second.TokenHash)
+	require.NoError(t, err)
+	require.Equal(t, user.Username, feed.Username)
+}
+
+func TestDeleteCalendarFeed(t *testing.T) {
+	user := createRandomUser(t)
+	feed := upsertRandomCalendarFeed(t, user.Username)
+
+	err := testStore.DeleteCalendarFeed(user.Username)
+	require.NoError(t, err)
+
+	_, err = testStore.GetCalendarFeedByTokenHash(feed.TokenHash)
+	require.Error(t, err)
+}
+
+func upsertRandomCalendarFeed(t *testing.T, username string) CalendarFeed {
+	arg := UpsertCalendarFeedParams{
+		Username:  username,
+		TokenHash: util.HashSecret(util.RandomString(32)),
+	}
+
+	feed, err := testStore.UpsertCalendarFeed(arg)
+	require.NoError(t, err)
+	require.Equal(t, arg.Username, feed.Username)
+	require.Equal(t, arg.TokenHash, feed.TokenHash)
+	require.WithinDuration(t, time.Now(), feed.CreatedAt, 2*time.Second)
+
+	return feed
+}
@@ -2,12 +2,19 @@ package db
 
 import (
 	"database/sql"
+	"fmt"
+	"strings"
 
 	"github.com/sbbullet/to-do/util"
 )
 
 func NewDB(config *util.Config) *sql.DB {
-	db, err := sql.Open(config.DBDriver, config.DBSource)
+	source := config.DBSource
+	if config.DBDriver == "sqlite3" {
+		source = withForeignKeys(source)
+	}
+
+	db, err := sql.Open(config.DBDriver, source)
 	if err != nil {
 		panic(err)
 	}
@@ -22,7 +29,11 @@ func NewDB(config *util.Config) *sql.DB {
 		email TEXT UNIQUE NOT NULL,
 		full_name TEXT NOT NULL,
 		hashed_password TEXT NOT NULL,
-		created_at DATETIME NOT NULL DEFAULT (datetime('now'))
+		created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+		email_verified_at DATETIME,
+		role TEXT NOT NULL DEFAULT 'user',
+		disabled_at DATETIME,
+		pending_email TEXT
 	);
 	CREATE TABLE IF NOT EXISTS todos(
 		id TEXT PRIMARY KEY,
@@ -30,13 +41,293 @@ func NewDB(config *util.Config) *sql.DB {
 		title TEXT NOT NULL,
 		is_completed INTEGER DEFAULT 0 CHECK(is_completed IN(0,1)),
 		created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+		completed_at DATETIME,
+		priority TEXT,
+		due_at DATETIME,
+		tags TEXT NOT NULL DEFAULT '',
     FOREIGN KEY (username) REFERENCES users (username) ON DELETE CASCADE
 	);
+	CREATE TABLE IF NOT EXISTS user_totps(
+		username TEXT PRIMARY KEY,
+		secret TEXT NOT NULL,
+		last_used_step INTEGER NOT NULL DEFAULT 0,
+		confirmed_at DATETIME,
+		created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+		FOREIGN KEY (username) REFERENCES users (username) ON DELETE CASCADE
+	);
+	CREATE TABLE IF NOT EXISTS recovery_codes(
+		code_hash TEXT PRIMARY KEY,
+		username TEXT NOT NULL,
+		used_at DATETIME,
+		created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+		FOREIGN KEY (username) REFERENCES users (username) ON DELETE CASCADE
+	);
+	CREATE TABLE IF NOT EXISTS mfa_challenges(
+		token_hash TEXT PRIMARY KEY,
+		username TEXT NOT NULL,
+		scopes TEXT NOT NULL,
+		failures INTEGER NOT NULL DEFAULT 0,
+		expires_at DATETIME NOT NULL,
+		created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+		FOREIGN KEY (username) REFERENCES users (username) ON DELETE CASCADE
+	);
+	CREATE TABLE IF NOT EXISTS login_failures(
+		kind TEXT NOT NULL,
+		identifier TEXT NOT NULL,
+		failures INTEGER NOT NULL,
+		last_failed_at DATETIME NOT NULL,
+		PRIMARY KEY (kind, identifier)
+	);
+	CREATE TABLE IF NOT EXISTS email_verification_tokens(
+		token_hash TEXT PRIMARY KEY,
+		username TEXT NOT NULL,
+		email TEXT NOT NULL,
+		expires_at DATETIME NOT NULL,
+		created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+		FOREIGN KEY (username) REFERENCES users (username) ON DELETE CASCADE
+	);
+	CREATE TABLE IF NOT EXISTS password_reset_tokens(
+		token_hash TEXT PRIMARY KEY,
+		username TEXT NOT NULL,
+		expires_at DATETIME NOT NULL,
+		used_at DATETIME,
+		created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+		FOREIGN KEY (username) REFERENCES users (username) ON DELETE CASCADE
+	);
+	CREATE TABLE IF NOT EXISTS user_identities(
+		provider TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		username TEXT NOT NULL,
+		email TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+		PRIMARY KEY (provider, subject),
+		UNIQUE (username, provider),
+		FOREIGN KEY (username) REFERENCES users (username) ON DELETE CASCADE
+	);
+	CREATE TABLE IF NOT EXISTS oidc_login_states(
+		state_hash TEXT PRIMARY KEY,
+		provider TEXT NOT NULL,
+		nonce TEXT NOT NULL,
+		code_verifier TEXT NOT NULL,
+		username TEXT NOT NULL DEFAULT '',
+		expires_at DATETIME NOT NULL,
+		created_at DATETIME NOT NULL DEFAULT (datetime('now'))
+	);
+	CREATE TABLE IF NOT EXISTS sessions(
+		id TEXT PRIMARY KEY,
+		username TEXT NOT NULL,
+		user_agent TEXT NOT NULL DEFAULT '',
+		client_ip TEXT NOT NULL DEFAULT '',
+		client_id TEXT NOT NULL DEFAULT '',
+		scopes TEXT NOT NULL DEFAULT '',
+		last_seen_at DATETIME,
+		expires_at DATETIME NOT NULL,
+		revoked_at DATETIME,
+		created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+		FOREIGN KEY (username) REFERENCES users (username) ON DELETE CASCADE
+	);
+	CREATE TABLE IF NOT EXISTS refresh_tokens(
+		id TEXT PRIMARY KEY,
+		session_id TEXT NOT NULL,
+		token_hash TEXT UNIQUE NOT NULL,
+		expires_at DATETIME NOT NULL,
+		used_at DATETIME,
+		created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+		FOREIGN KEY (session_id) REFERENCES sessions (id) ON DELETE CASCADE
+	);
+	CREATE TABLE IF NOT EXISTS revoked_tokens(
+		token_id TEXT PRIMARY KEY,
+		username TEXT NOT NULL,
+		expires_at DATETIME NOT NULL,
+		created_at DATETIME NOT NULL DEFAULT (datetime('now'))
+	);
+	CREATE TABLE IF NOT EXISTS user_token_revocations(
+		username TEXT PRIMARY KEY,
+		issued_before DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL,
+		created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+		FOREIGN KEY (username) REFERENCES users (username) ON DELETE CASCADE
+	);
+	CREATE TABLE IF NOT EXISTS deleted_user_revocations(
+		username TEXT PRIMARY KEY,
+		issued_before DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL,
+		created_at DATETIME NOT NULL DEFAULT (datetime('now'))
+	);
+	CREATE TABLE IF NOT EXISTS api_keys(
+		id TEXT PRIMARY KEY,
+		username TEXT NOT NULL,
+		name TEXT NOT NULL,
+		key_prefix TEXT NOT NULL,
+		key_hash TEXT UNIQUE NOT NULL,
+		scopes TEXT NOT NULL,
+		expires_at DATETIME,
+		last_used_at DATETIME,
+		created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+		FOREIGN KEY (username) REFERENCES users (username) ON DELETE CASCADE
+	);
+	CREATE TABLE IF NOT EXISTS oauth_clients(
+		id TEXT PRIMARY KEY,
+		username TEXT NOT NULL,
+		name TEXT NOT NULL,
+		redirect_uris TEXT NOT NULL,
+		scopes TEXT NOT NULL,
+		secret_hash TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+		FOREIGN KEY (username) REFERENCES users (username) ON DELETE CASCADE
+	);
+	CREATE TABLE IF NOT EXISTS oauth_consents(
+		username TEXT NOT NULL,
+		client_id TEXT NOT NULL,
+		scopes TEXT NOT NULL,
+		created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+		PRIMARY KEY (username, client_id),
+		FOREIGN KEY (username) REFERENCES users (username) ON DELETE CASCADE,
+		FOREIGN KEY (client_id) REFERENCES oauth_clients (id) ON DELETE CASCADE
+	);
+	CREATE TABLE IF NOT EXISTS oauth_authorization_codes(
+		code_hash TEXT PRIMARY KEY,
+		client_id TEXT NOT NULL,
+		username TEXT NOT NULL,
+		redirect_uri TEXT NOT NULL,
+		scopes TEXT NOT NULL,
+		code_challenge TEXT NOT NULL,
+		session_id TEXT,
+		expires_at DATETIME NOT NULL,
+		used_at DATETIME,
+		created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+		FOREIGN KEY (username) REFERENCES users (username) ON DELETE CASCADE,
+		FOREIGN KEY (client_id) REFERENCES oauth_clients (id) ON DELETE CASCADE
+	);
+	CREATE TABLE IF NOT EXISTS calendar_feeds(
+		username TEXT PRIMARY KEY,
+		token_hash TEXT UNIQUE NOT NULL,
+		created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+		FOREIGN KEY (username) REFERENCES users (username) ON DELETE CASCADE
+	);
+	CREATE TABLE IF NOT EXISTS webhooks(
+		id TEXT PRIMARY KEY,
+		username TEXT NOT NULL,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events TEXT NOT NULL,
+		created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+		FOREIGN KEY (username) REFERENCES users (username) ON DELETE CASCADE
+	);
+	CREATE TABLE IF NOT EXISTS webhook_outbox(
+		id TEXT PRIMARY KEY,
+		webhook_id TEXT NOT NULL,
+		event TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending' CHECK(status IN('pending','delivered','failed')),
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at DATETIME NOT NULL,
+		created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+		FOREIGN KEY (webhook_id) REFERENCES webhooks (id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS webhook_outbox_due_idx ON webhook_outbox(status, next_attempt_at);
+	CREATE TABLE IF NOT EXISTS webhook_delivery_logs(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		delivery_id TEXT NOT NULL,
+		status_code INTEGER NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		duration_ms INTEGER NOT NULL DEFAULT 0,
+		attempted_at DATETIME NOT NULL DEFAULT (datetime('now')),
+		FOREIGN KEY (delivery_id) REFERENCES webhook_outbox (id) ON DELETE CASCADE
+	);
 	`
 	_, err = db.Exec(sqlStmt)
 	if err != nil {
 		panic(err)
 	}
 
+	if err := migrate(db); err != nil {
+		panic(err)
+	}
+
+	if err := normalizeUsers(db, config); err != nil {
+		panic(err)
+	}
+
 	return db
 }
+
+// withForeignKeys has SQLite enforce the foreign keys of the schema, which it
+// only does when told to on every connection, so that deleting a user deletes
+// everything they own along with them
+func withForeignKeys(source string) string {
+	if strings.Contains(source, "?") {
+		return source + "&_foreign_keys=on"
+	}
+
+	return source + "?_foreign_keys=on"
+}
+
+// migrate brings tables created by older versions of the schema up to date
+func migrate(db *sql.DB) error {
+	columns := []struct {
+		table      string
+		column     string
+		definition string
+	}{
+		{"users", "email_verified_at", "DATETIME"},
+		{"users", "role", "TEXT NOT NULL DEFAULT 'user'"},
+		{"users", "disabled_at", "DATETIME"},
+		{"users", "pending_email", "TEXT"},
+		{"todos", "completed_at", "DATETIME"},
+		{"todos", "priority", "TEXT"},
+		{"todos", "due_at", "DATETIME"},
+		{"todos", "tags", "TEXT NOT NULL DEFAULT ''"},
+		{"sessions", "user_agent", "TEXT NOT NULL DEFAULT ''"},
+		{"sessions", "client_ip", "TEXT NOT NULL DEFAULT ''"},
+		{"sessions", "last_seen_at", "DATETIME"},
+		{"sessions", "scopes", "TEXT NOT NULL DEFAULT ''"},
+		{"sessions", "client_id", "TEXT NOT NULL DEFAULT ''"},
+	}
+
+	for _, c := range columns {
+		if err := addColumnIfNotExists(db, c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func addColumnIfNotExists(db *sql.DB, table string, column string, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s);", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	exists := false
+	for rows.Next() {
+		var cid, notNull, primaryKey int
+		var name, columnType string
+		var defaultValue sql.NullString
+
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &primaryKey); err != nil {
+			return err
+		}
+
+		if name == column {
+			exists = true
+		}
+	}
+
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if exists {
+		return nil
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", table, column, definition))
+	return err
+}
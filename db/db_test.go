@@ -0,0 +1,25 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddColumnIfNotExists(t *testing.T) {
+	conn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "old.db"))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Exec(`CREATE TABLE todos(id TEXT PRIMARY KEY, title TEXT NOT NULL);`)
+	require.NoError(t, err)
+
+	// Running it twice must not try to add the column again
+	require.NoError(t, addColumnIfNotExists(conn, "todos", "completed_at", "DATETIME"))
+	require.NoError(t, addColumnIfNotExists(conn, "todos", "completed_at", "DATETIME"))
+
+	_, err = conn.Exec(`INSERT INTO todos(id, title, completed_at) VALUES('1', 'title', datetime('now'));`)
+	require.NoError(t, err)
+}
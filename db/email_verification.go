@@ -0,0 +1,129 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrEmailVerificationTokenInvalid = errors.New("email verification token is invalid or expired")
+var ErrEmailTaken = errors.New("email is already taken")
+
+type CreateEmailVerificationTokenParams struct {
+	TokenHash string    `json:"token_hash"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CreateEmailVerificationToken creates a token verifying the email of the
+// user, replacing the ones they were sent before
+func (store *Store) CreateEmailVerificationToken(arg CreateEmailVerificationTokenParams) (verificationToken EmailVerificationToken, err error) {
+	const deleteUserEmailVerificationTokensQuery = `
+		DELETE FROM email_verification_tokens
+		WHERE username = ?;
+	`
+	const createEmailVerificationTokenQuery = `
+		INSERT INTO email_verification_tokens(token_hash, username, email, expires_at)
+		VALUES(?, ?, ?, ?)
+		RETURNING token_hash, username, email, expires_at, created_at;
+	`
+
+	err = store.execTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(deleteUserEmailVerificationTokensQuery, arg.Username); err != nil {
+			return err
+		}
+
+		row := tx.QueryRow(createEmailVerificationTokenQuery, arg.TokenHash, arg.Username, arg.Email, arg.ExpiresAt.UTC())
+		verificationToken, err = scanEmailVerificationToken(row)
+		return err
+	})
+
+	return
+}
+
+// GetLatestEmailVerificationToken gets the token the user was sent last
+func (store *Store) GetLatestEmailVerificationToken(username string) (EmailVerificationToken, error) {
+	const getLatestEmailVerificationTokenQuery = `
+		SELECT token_hash, username, email, expires_at, created_at
+		FROM email_verification_tokens
+		WHERE username = ?
+		ORDER BY created_at DESC
+		LIMIT 1;
+	`
+
+	row := store.DB.QueryRow(getLatestEmailVerificationTokenQuery, username)
+
+	return scanEmailVerificationToken(row)
+}
+
+// VerifyEmail uses up the verification token to mark the email it was sent to
+// as verified, making it the email of the user if it was pending. The token no
+// longer works once the user has changed their email.
+func (store *Store) VerifyEmail(tokenHash string) (user User, err error) {
+	const useEmailVerificationTokenQuery = `
+		DELETE FROM email_verification_tokens
+		WHERE token_hash = ? AND expires_at > ?
+		RETURNING username, email;
+	`
+	const verifyPendingEmailQuery = `
+		UPDATE users
+		SET email = pending_email, pending_email = NULL, email_verified_at = ?
+		WHERE username = ? AND pending_email = ?
+		RETURNING username, email, full_name, hashed_password, created_at, email_verified_at, role, disabled_at, pending_email;
+	`
+	const verifyUserEmailQuery = `
+		UPDATE users
+		SET email_verified_at = COALESCE(email_verified_at, ?)
+		WHERE username = ? AND email = ?
+		RETURNING username, email, full_name, hashed_password, created_at, email_verified_at, role, disabled_at, pending_email;
+	`
+
+	err = store.execTx(func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+
+		var username, email string
+		err := tx.QueryRow(useEmailVerificationTokenQuery, tokenHash, now).Scan(&username, &email)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrEmailVerificationTokenInvalid
+			}
+			return err
+		}
+
+		user, err = scanUser(tx.QueryRow(verifyPendingEmailQuery, now, username, email))
+		if err == nil {
+			return nil
+		}
+
+		// Somebody else may have taken the email in the meantime
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return ErrEmailTaken
+		}
+
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		user, err = scanUser(tx.QueryRow(verifyUserEmailQuery, now, username, email))
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrEmailVerificationTokenInvalid
+		}
+		return err
+	})
+
+	return
+}
+
+func scanEmailVerificationToken(row rowScanner) (verificationToken EmailVerificationToken, err error) {
+	err = row.Scan(
+		&verificationToken.TokenHash,
+		&verificationToken.Username,
+		&verificationToken.Email,
+		&verificationToken.ExpiresAt,
+		&verificationToken.CreatedAt,
+	)
+
+	return
+}
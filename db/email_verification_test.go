@@ -0,0 +1,90 @@
+package db
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/sbbullet/to-do/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyEmail(t *testing.T) {
+	user := createRandomUser(t)
+	verificationToken := createRandomEmailVerificationToken(t, user, time.Now().Add(time.Hour))
+
+	verified, err := testStore.VerifyEmail(verificationToken.TokenHash)
+	require.NoError(t, err)
+	require.Equal(t, user.Username, verified.Username)
+	require.NotNil(t, verified.EmailVerifiedAt)
+	require.WithinDuration(t, time.Now(), *verified.EmailVerifiedAt, time.Second)
+
+	// Verification tokens can only be used once
+	_, err = testStore.VerifyEmail(verificationToken.TokenHash)
+	require.ErrorIs(t, err, ErrEmailVerificationTokenInvalid)
+}
+
+func TestVerifyEmailExpired(t *testing.T) {
+	user := createRandomUser(t)
+	verificationToken := createRandomEmailVerificationToken(t, user, time.Now().Add(-time.Minute))
+
+	_, err := testStore.VerifyEmail(verificationToken.TokenHash)
+	require.ErrorIs(t, err, ErrEmailVerificationTokenInvalid)
+}
+
+func TestVerifyEmailAfterEmailChanged(t *testing.T) {
+	user := createRandomUser(t)
+	verificationToken := createRandomEmailVerificationToken(t, user, time.Now().Add(time.Hour))
+
+	_, err := testStore.DB.Exec("UPDATE users SET email = ? WHERE username = ?;", util.RandomEmail(), user.Username)
+	require.NoError(t, err)
+
+	_, err = testStore.VerifyEmail(verificationToken.TokenHash)
+	require.ErrorIs(t, err, ErrEmailVerificationTokenInvalid)
+
+	unverified, err := testStore.GetUser(user.Username)
+	require.NoError(t, err)
+	require.Nil(t, unverified.EmailVerifiedAt)
+}
+
+func TestGetUserByVerifiedEmail(t *testing.T) {
+	user := createRandomUser(t)
+
+	// Unverified emails can belong to somebody else
+	_, err := testStore.GetUserByVerifiedEmail(user.Email)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	verificationToken := createRandomEmailVerificationToken(t, user, time.Now().Add(time.Hour))
+	_, err = testStore.VerifyEmail(verificationToken.TokenHash)
+	require.NoError(t, err)
+
+	verified, err := testStore.GetUserByVerifiedEmail(user.Email)
+	require.NoError(t, err)
+	require.Equal(t, user.Username, verified.Username)
+}
+
+func TestGetLatestEmailVerificationToken(t *testing.T) {
+	user := createRandomUser(t)
+	createRandomEmailVerificationToken(t, user, time.Now().Add(time.Hour))
+	newer := createRandomEmailVerificationToken(t, user, time.Now().Add(time.Hour))
+
+	latest, err := testStore.GetLatestEmailVerificationToken(user.Username)
+	require.NoError(t, err)
+	require.Equal(t, newer.TokenHash, latest.TokenHash)
+}
+
+func createRandomEmailVerificationToken(t *testing.T, user User, expiresAt time.Time) EmailVerificationToken {
+	arg := CreateEmailVerificationTokenParams{
+		TokenHash: util.HashSecret(util.RandomString(32)),
+		Username:  user.Username,
+		Email:     user.Email,
+		ExpiresAt: expiresAt,
+	}
+
+	verificationToken, err := testStore.CreateEmailVerificationToken(arg)
+	require.NoError(t, err)
+	require.Equal(t, arg.TokenHash, verificationToken.TokenHash)
+	require.Equal(t, user.Email, verificationToken.Email)
+
+	return verificationToken
+}
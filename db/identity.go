@@ -0,0 +1,230 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/sbbullet/to-do/util"
+)
+
+type CreateUserIdentityParams struct {
+	Provider string `json:"provider"`
+	Subject  string `json:"subject"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+// CreateUserIdentity links the identity of an external provider to the user
+func (store *Store) CreateUserIdentity(arg CreateUserIdentityParams) (UserIdentity, error) {
+	const createUserIdentityQuery = `
+		INSERT INTO user_identities(provider, subject, username, email)
+		VALUES(?, ?, ?, ?)
+		RETURNING provider, subject, username, email, created_at;
+	`
+
+	row := store.DB.QueryRow(createUserIdentityQuery, arg.Provider, arg.Subject, arg.Username, arg.Email)
+
+	return scanUserIdentity(row)
+}
+
+type CreateUserWithIdentityParams struct {
+	User     CreateUserParams `json:"user"`
+	Provider string           `json:"provider"`
+	Subject  string           `json:"subject"`
+}
+
+// CreateUserWithIdentity creates a user who signed up through an external
+// provider along with the identity they signed up with
+func (store *Store) CreateUserWithIdentity(arg CreateUserWithIdentityParams) (user User, identity UserIdentity, err error) {
+	const createUserQuery = `
+		INSERT INTO users(username, email, full_name, hashed_password, email_verified_at)
+		VALUES(?, ?, ?, ?, ?)
+		RETURNING username, email, full_name, hashed_password, created_at, email_verified_at, role, disabled_at, pending_email;
+	`
+	const createUserIdentityQuery = `
+		INSERT INTO user_identities(provider, subject, username, email)
+		VALUES(?, ?, ?, ?)
+		RETURNING provider, subject, username, email, created_at;
+	`
+
+	var emailVerifiedAt *time.Time
+	if arg.User.EmailVerifiedAt != nil {
+		utc := arg.User.EmailVerifiedAt.UTC()
+		emailVerifiedAt = &utc
+	}
+
+	err = store.execTx(func(tx *sql.Tx) error {
+		row := tx.QueryRow(createUserQuery,
+			util.NormalizeUsername(arg.User.Username),
+			util.NormalizeEmail(arg.User.Email),
+			arg.User.FullName,
+			arg.User.HashedPassword,
+			emailVerifiedAt,
+		)
+		user, err = scanUser(row)
+		if err != nil {
+			return err
+		}
+
+		row = tx.QueryRow(createUserIdentityQuery, arg.Provider, arg.Subject, user.Username, user.Email)
+		identity, err = scanUserIdentity(row)
+		return err
+	})
+
+	return
+}
+
+type GetUserIdentityParams struct {
+	Provider string `json:"provider"`
+	Subject  string `json:"subject"`
+}
+
+func (store *Store) GetUserIdentity(arg GetUserIdentityParams) (UserIdentity, error) {
+	const getUserIdentityQuery = `
+		SELECT provider, subject, username, email, created_at
+		FROM user_identities
+		WHERE provider = ? AND subject = ?;
+	`
+
+	row := store.DB.QueryRow(getUserIdentityQuery, arg.Provider, arg.Subject)
+
+	return scanUserIdentity(row)
+}
+
+func (store *Store) GetUserIdentities(username string) ([]UserIdentity, error) {
+	const getUserIdentitiesQuery = `
+		SELECT provider, subject, username, email, created_at
+		FROM user_identities
+		WHERE username = ?
+		ORDER BY created_at;
+	`
+
+	rows, err := store.DB.Query(getUserIdentitiesQuery, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []UserIdentity{}
+	for rows.Next() {
+		identity, err := scanUserIdentity(rows)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+
+	return identities, rows.Err()
+}
+
+type DeleteUserIdentityOfAUserParams struct {
+	Provider string `json:"provider"`
+	Username string `json:"username"`
+}
+
+func (store *Store) DeleteUserIdentityOfAUser(arg DeleteUserIdentityOfAUserParams) error {
+	const deleteUserIdentityOfAUserQuery = `
+		DELETE FROM user_identities
+		WHERE provider = ? AND username = ?;
+	`
+
+	result, err := store.DB.Exec(deleteUserIdentityOfAUserQuery, arg.Provider, arg.Username)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected < 1 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+type CreateOIDCLoginStateParams struct {
+	StateHash    string    `json:"state_hash"`
+	Provider     string    `json:"provider"`
+	Nonce        string    `json:"nonce"`
+	CodeVerifier string    `json:"code_verifier"`
+	Username     string    `json:"username"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// CreateOIDCLoginState remembers a login started with an external provider
+// until the user comes back from it, forgetting the ones never finished
+func (store *Store) CreateOIDCLoginState(arg CreateOIDCLoginStateParams) (loginState OIDCLoginState, err error) {
+	const deleteExpiredOIDCLoginStatesQuery = `
+		DELETE FROM oidc_login_states
+		WHERE expires_at <= ?;
+	`
+	const createOIDCLoginStateQuery = `
+		INSERT INTO oidc_login_states(state_hash, provider, nonce, code_verifier, username, expires_at)
+		VALUES(?, ?, ?, ?, ?, ?)
+		RETURNING state_hash, provider, nonce, code_verifier, username, expires_at, created_at;
+	`
+
+	err = store.execTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(deleteExpiredOIDCLoginStatesQuery, time.Now().UTC()); err != nil {
+			return err
+		}
+
+		row := tx.QueryRow(
+			createOIDCLoginStateQuery,
+			arg.StateHash,
+			arg.Provider,
+			arg.Nonce,
+			arg.CodeVerifier,
+			arg.Username,
+			arg.ExpiresAt.UTC(),
+		)
+
+		loginState, err = scanOIDCLoginState(row)
+		return err
+	})
+
+	return
+}
+
+// ConsumeOIDCLoginState gets the login state and deletes it, so that every
+// login can only be finished once
+func (store *Store) ConsumeOIDCLoginState(stateHash string) (OIDCLoginState, error) {
+	const consumeOIDCLoginStateQuery = `
+		DELETE FROM oidc_login_states
+		WHERE state_hash = ?
+		RETURNING state_hash, provider, nonce, code_verifier, username, expires_at, created_at;
+	`
+
+	row := store.DB.QueryRow(consumeOIDCLoginStateQuery, stateHash)
+
+	return scanOIDCLoginState(row)
+}
+
+func scanUserIdentity(row rowScanner) (identity UserIdentity, err error) {
+	err = row.Scan(
+		&identity.Provider,
+		&identity.Subject,
+		&identity.Username,
+		&identity.Email,
+		&identity.CreatedAt,
+	)
+
+	return
+}
+
+func scanOIDCLoginState(row rowScanner) (loginState OIDCLoginState, err error) {
+	err = row.Scan(
+		&loginState.StateHash,
+		&loginState.Provider,
+		&loginState.Nonce,
+		&loginState.CodeVerifier,
+		&loginState.Username,
+		&loginState.ExpiresAt,
+		&loginState.CreatedAt,
+	)
+
+	return
+}
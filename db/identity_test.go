@@ -0,0 +1,120 @@
+package db
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/sbbullet/to-do/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateUserIdentity(t *testing.T) {
+	user := createRandomUser(t)
+	identity := createRandomUserIdentity(t, user)
+
+	found, err := testStore.GetUserIdentity(GetUserIdentityParams{Provider: identity.Provider, Subject: identity.Subject})
+	require.NoError(t, err)
+	require.Equal(t, user.Username, found.Username)
+	require.Equal(t, user.Email, found.Email)
+
+	// A user can only be linked to one identity of each provider
+	_, err = testStore.CreateUserIdentity(CreateUserIdentityParams{
+		Provider: identity.Provider,
+		Subject:  util.RandomString(12),
+		Username: user.Username,
+	})
+	require.Error(t, err)
+}
+
+func TestCreateUserWithIdentity(t *testing.T) {
+	arg := CreateUserWithIdentityParams{
+		User: CreateUserParams{
+			Username: util.RandomUsername(),
+			Email:    util.RandomEmail(),
+			FullName: util.RandomString(4) + " " + util.RandomString(4),
+		},
+		Provider: "company",
+		Subject:  util.RandomString(12),
+	}
+
+	user, identity, err := testStore.CreateUserWithIdentity(arg)
+	require.NoError(t, err)
+	require.Equal(t, arg.User.Username, user.Username)
+	require.Empty(t, user.HashedPassword)
+	require.Equal(t, user.Username, identity.Username)
+	require.Equal(t, arg.Subject, identity.Subject)
+
+	// The user isn't created when the identity is already linked
+	arg.User.Username = util.RandomUsername()
+	arg.User.Email = util.RandomEmail()
+	_, _, err = testStore.CreateUserWithIdentity(arg)
+	require.Error(t, err)
+
+	_, err = testStore.GetUser(arg.User.Username)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestGetUserIdentities(t *testing.T) {
+	user := createRandomUser(t)
+	for i := 0; i < 2; i++ {
+		createRandomUserIdentity(t, user)
+	}
+
+	identities, err := testStore.GetUserIdentities(user.Username)
+	require.NoError(t, err)
+	require.Len(t, identities, 2)
+}
+
+func TestDeleteUserIdentityOfAUser(t *testing.T) {
+	user := createRandomUser(t)
+	identity := createRandomUserIdentity(t, user)
+
+	err := testStore.DeleteUserIdentityOfAUser(DeleteUserIdentityOfAUserParams{Provider: identity.Provider, Username: createRandomUser(t).Username})
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	err = testStore.DeleteUserIdentityOfAUser(DeleteUserIdentityOfAUserParams{Provider: identity.Provider, Username: user.Username})
+	require.NoError(t, err)
+
+	_, err = testStore.GetUserIdentity(GetUserIdentityParams{Provider: identity.Provider, Subject: identity.Subject})
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestConsumeOIDCLoginState(t *testing.T) {
+	arg := CreateOIDCLoginStateParams{
+		StateHash:    util.HashSecret(util.RandomString(32)),
+		Provider:     "company",
+		Nonce:        util.RandomString(32),
+		CodeVerifier: util.RandomString(43),
+		ExpiresAt:    time.Now().Add(10 * time.Minute),
+	}
+
+	created, err := testStore.CreateOIDCLoginState(arg)
+	require.NoError(t, err)
+	require.Equal(t, arg.StateHash, created.StateHash)
+	require.Empty(t, created.Username)
+
+	loginState, err := testStore.ConsumeOIDCLoginState(arg.StateHash)
+	require.NoError(t, err)
+	require.Equal(t, arg.Nonce, loginState.Nonce)
+	require.Equal(t, arg.CodeVerifier, loginState.CodeVerifier)
+
+	_, err = testStore.ConsumeOIDCLoginState(arg.StateHash)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func createRandomUserIdentity(t *testing.T, user User) UserIdentity {
+	arg := CreateUserIdentityParams{
+		Provider: util.RandomString(8),
+		Subject:  util.RandomString(12),
+		Username: user.Username,
+		Email:    user.Email,
+	}
+
+	identity, err := testStore.CreateUserIdentity(arg)
+	require.NoError(t, err)
+	require.Equal(t, arg.Provider, identity.Provider)
+	require.Equal(t, arg.Subject, identity.Subject)
+
+	return identity
+}
@@ -0,0 +1,95 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Failed logins are counted both for the username tried and the client trying it
+const (
+	LoginFailureKindUsername = "username"
+	LoginFailureKindIP       = "ip"
+)
+
+type GetLoginFailureParams struct {
+	Kind       string `json:"kind"`
+	Identifier string `json:"identifier"`
+}
+
+func (store *Store) GetLoginFailure(arg GetLoginFailureParams) (LoginFailure, error) {
+	const getLoginFailureQuery = `
+		SELECT kind, identifier, failures, last_failed_at
+		FROM login_failures
+		WHERE kind = ? AND identifier = ?;
+	`
+
+	row := store.DB.QueryRow(getLoginFailureQuery, arg.Kind, arg.Identifier)
+
+	return scanLoginFailure(row)
+}
+
+type RecordLoginFailureParams struct {
+	Kind       string    `json:"kind"`
+	Identifier string    `json:"identifier"`
+	FailedAt   time.Time `json:"failed_at"`
+	// ForgetBefore is when failures are old enough not to count anymore
+	ForgetBefore time.Time `json:"forget_before"`
+}
+
+// RecordLoginFailure counts a failed login, starting over if the last one was
+// too long ago. Failures that are too old are pruned along the way.
+func (store *Store) RecordLoginFailure(arg RecordLoginFailureParams) (loginFailure LoginFailure, err error) {
+	const recordLoginFailureQuery = `
+		INSERT INTO login_failures(kind, identifier, failures, last_failed_at)
+		VALUES(?, ?, 1, ?)
+		ON CONFLICT(kind, identifier) DO UPDATE SET
+			failures = CASE WHEN last_failed_at < ? THEN 1 ELSE failures + 1 END,
+			last_failed_at = excluded.last_failed_at
+		RETURNING kind, identifier, failures, last_failed_at;
+	`
+	const pruneLoginFailuresQuery = `
+		DELETE FROM login_failures
+		WHERE last_failed_at < ?;
+	`
+
+	err = store.execTx(func(tx *sql.Tx) error {
+		row := tx.QueryRow(recordLoginFailureQuery, arg.Kind, arg.Identifier, arg.FailedAt.UTC(), arg.ForgetBefore.UTC())
+		loginFailure, err = scanLoginFailure(row)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(pruneLoginFailuresQuery, arg.ForgetBefore.UTC())
+		return err
+	})
+
+	return
+}
+
+type ClearLoginFailuresParams struct {
+	Kind       string `json:"kind"`
+	Identifier string `json:"identifier"`
+}
+
+// ClearLoginFailures forgets the failed logins, lifting any lockout they caused
+func (store *Store) ClearLoginFailures(arg ClearLoginFailuresParams) error {
+	const clearLoginFailuresQuery = `
+		DELETE FROM login_failures
+		WHERE kind = ? AND identifier = ?;
+	`
+
+	_, err := store.DB.Exec(clearLoginFailuresQuery, arg.Kind, arg.Identifier)
+
+	return err
+}
+
+func scanLoginFailure(row rowScanner) (loginFailure LoginFailure, err error) {
+	err = row.Scan(
+		&loginFailure.Kind,
+		&loginFailure.Identifier,
+		&loginFailure.Failures,
+		&loginFailure.LastFailedAt,
+	)
+
+	return
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/sbbullet/to-do/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordLoginFailure(t *testing.T) {
+	username := util.RandomUsername()
+	now := time.Now()
+
+	for i := 1; i <= 3; i++ {
+		loginFailure, err := testStore.RecordLoginFailure(RecordLoginFailureParams{
+			Kind:         LoginFailureKindUsername,
+			Identifier:   username,
+			FailedAt:     now,
+			ForgetBefore: now.Add(-time.Hour),
+		})
+		require.NoError(t, err)
+		require.Equal(t, i, loginFailure.Failures)
+		require.WithinDuration(t, now, loginFailure.LastFailedAt, time.Second)
+	}
+
+	// The same identifier is counted apart for another kind
+	loginFailure, err := testStore.RecordLoginFailure(RecordLoginFailureParams{
+		Kind:         LoginFailureKindIP,
+		Identifier:   username,
+		FailedAt:     now,
+		ForgetBefore: now.Add(-time.Hour),
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, loginFailure.Failures)
+
+	loginFailure, err = testStore.GetLoginFailure(GetLoginFailureParams{Kind: LoginFailureKindUsername, Identifier: username})
+	require.NoError(t, err)
+	require.Equal(t, 3, loginFailure.Failures)
+}
+
+func TestRecordLoginFailureStartsOver(t *testing.T) {
+	username := util.RandomUsername()
+	longAgo := time.Now().Add(-2 * time.Hour)
+
+	_, err := testStore.RecordLoginFailure(RecordLoginFailureParams{
+		Kind:         LoginFailureKindUsername,
+		Identifier:   username,
+		FailedAt:     longAgo,
+		ForgetBefore: longAgo.Add(-time.Hour),
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+	loginFailure, err := testStore.RecordLoginFailure(RecordLoginFailureParams{
+		Kind:         LoginFailureKindUsername,
+		Identifier:   username,
+		FailedAt:     now,
+		ForgetBefore: now.Add(-time.Hour),
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, loginFailure.Failures)
+}
+
+func TestClearLoginFailures(t *testing.T) {
+	username := util.RandomUsername()
+	now := time.Now()
+
+	_, err := testStore.RecordLoginFailure(RecordLoginFailureParams{
+		Kind:         LoginFailureKindUsername,
+		Identifier:   username,
+		FailedAt:     now,
+		ForgetBefore: now.Add(-time.Hour),
+	})
+	require.NoError(t, err)
+
+	err = testStore.ClearLoginFailures(ClearLoginFailuresParams{Kind: LoginFailureKindUsername, Identifier: username})
+	require.NoError(t, err)
+
+	_, err = testStore.GetLoginFailure(GetLoginFailureParams{Kind: LoginFailureKindUsername, Identifier: username})
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
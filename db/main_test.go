@@ -6,6 +6,7 @@ import (
 	"os"
 	"testing"
 
+	"github.com/sbbullet/to-do/logger"
 	"github.com/sbbullet/to-do/util"
 )
 
@@ -13,6 +14,8 @@ var testStore *Store
 var testDB *sql.DB
 
 func TestMain(m *testing.M) {
+	logger.InitializeLogger()
+
 	config := util.LoadConfig("app", "env", "..")
 	config.DBSource = "../test_todo.db"
 
@@ -0,0 +1,115 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+type CreateMFAChallengeParams struct {
+	TokenHash string    `json:"token_hash"`
+	Username  string    `json:"username"`
+	Scopes    []string  `json:"scopes"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CreateMFAChallenge creates the challenge of a user who has logged in with
+// their first factor, pruning the challenges that have expired along the way
+func (store *Store) CreateMFAChallenge(arg CreateMFAChallengeParams) (challenge MFAChallenge, err error) {
+	const pruneMFAChallengesQuery = `
+		DELETE FROM mfa_challenges
+		WHERE expires_at <= ?;
+	`
+	const createMFAChallengeQuery = `
+		INSERT INTO mfa_challenges(token_hash, username, scopes, expires_at)
+		VALUES(?, ?, ?, ?)
+		RETURNING token_hash, username, scopes, failures, expires_at, created_at;
+	`
+
+	err = store.execTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(pruneMFAChallengesQuery, time.Now().UTC()); err != nil {
+			return err
+		}
+
+		row := tx.QueryRow(createMFAChallengeQuery, arg.TokenHash, arg.Username, strings.Join(arg.Scopes, ","), arg.ExpiresAt.UTC())
+		challenge, err = scanMFAChallenge(row)
+		return err
+	})
+
+	return
+}
+
+// GetMFAChallenge gets the challenge of the token unless it has expired
+func (store *Store) GetMFAChallenge(tokenHash string) (MFAChallenge, error) {
+	const getMFAChallengeQuery = `
+		SELECT token_hash, username, scopes, failures, expires_at, created_at
+		FROM mfa_challenges
+		WHERE token_hash = ? AND expires_at > ?;
+	`
+
+	row := store.DB.QueryRow(getMFAChallengeQuery, tokenHash, time.Now().UTC())
+
+	return scanMFAChallenge(row)
+}
+
+// ConsumeMFAChallenge deletes the challenge once it has been met, failing with
+// sql.ErrNoRows if it has been consumed already
+func (store *Store) ConsumeMFAChallenge(tokenHash string) (MFAChallenge, error) {
+	const consumeMFAChallengeQuery = `
+		DELETE FROM mfa_challenges
+		WHERE token_hash = ? AND expires_at > ?
+		RETURNING token_hash, username, scopes, failures, expires_at, created_at;
+	`
+
+	row := store.DB.QueryRow(consumeMFAChallengeQuery, tokenHash, time.Now().UTC())
+
+	return scanMFAChallenge(row)
+}
+
+type FailMFAChallengeParams struct {
+	TokenHash   string `json:"token_hash"`
+	MaxFailures int    `json:"max_failures"`
+}
+
+// FailMFAChallenge counts a wrong code entered for the challenge, deleting it
+// once it has failed too many times
+func (store *Store) FailMFAChallenge(arg FailMFAChallengeParams) error {
+	const failMFAChallengeQuery = `
+		UPDATE mfa_challenges
+		SET failures = failures + 1
+		WHERE token_hash = ?;
+	`
+	const deleteFailedMFAChallengeQuery = `
+		DELETE FROM mfa_challenges
+		WHERE token_hash = ? AND failures >= ?;
+	`
+
+	return store.execTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(failMFAChallengeQuery, arg.TokenHash); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(deleteFailedMFAChallengeQuery, arg.TokenHash, arg.MaxFailures)
+		return err
+	})
+}
+
+func scanMFAChallenge(row rowScanner) (challenge MFAChallenge, err error) {
+	var scopes string
+
+	err = row.Scan(
+		&challenge.TokenHash,
+		&challenge.Username,
+		&scopes,
+		&challenge.Failures,
+		&challenge.ExpiresAt,
+		&challenge.CreatedAt,
+	)
+
+	challenge.Scopes = []string{}
+	if len(scopes) > 0 {
+		challenge.Scopes = strings.Split(scopes, ",")
+	}
+
+	return
+}
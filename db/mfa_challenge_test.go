@@ -0,0 +1,68 @@
+package db
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/sbbullet/to-do/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsumeMFAChallenge(t *testing.T) {
+	user := createRandomUser(t)
+	challenge := createRandomMFAChallenge(t, user.Username, time.Now().Add(time.Minute))
+
+	consumed, err := testStore.ConsumeMFAChallenge(challenge.TokenHash)
+	require.NoError(t, err)
+	require.Equal(t, user.Username, consumed.Username)
+	require.Equal(t, []string{"todos:read", "todos:write"}, consumed.Scopes)
+
+	_, err = testStore.ConsumeMFAChallenge(challenge.TokenHash)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestGetMFAChallengeExpired(t *testing.T) {
+	user := createRandomUser(t)
+	challenge := createRandomMFAChallenge(t, user.Username, time.Now().Add(-time.Second))
+
+	_, err := testStore.GetMFAChallenge(challenge.TokenHash)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	_, err = testStore.ConsumeMFAChallenge(challenge.TokenHash)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestFailMFAChallenge(t *testing.T) {
+	user := createRandomUser(t)
+	challenge := createRandomMFAChallenge(t, user.Username, time.Now().Add(time.Minute))
+
+	err := testStore.FailMFAChallenge(FailMFAChallengeParams{TokenHash: challenge.TokenHash, MaxFailures: 2})
+	require.NoError(t, err)
+
+	failed, err := testStore.GetMFAChallenge(challenge.TokenHash)
+	require.NoError(t, err)
+	require.Equal(t, 1, failed.Failures)
+
+	err = testStore.FailMFAChallenge(FailMFAChallengeParams{TokenHash: challenge.TokenHash, MaxFailures: 2})
+	require.NoError(t, err)
+
+	_, err = testStore.GetMFAChallenge(challenge.TokenHash)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func createRandomMFAChallenge(t *testing.T, username string, expiresAt time.Time) MFAChallenge {
+	arg := CreateMFAChallengeParams{
+		TokenHash: util.HashSecret(util.RandomString(32)),
+		Username:  username,
+		Scopes:    []string{"todos:read", "todos:write"},
+		ExpiresAt: expiresAt,
+	}
+
+	challenge, err := testStore.CreateMFAChallenge(arg)
+	require.NoError(t, err)
+	require.Equal(t, arg.TokenHash, challenge.TokenHash)
+	require.Zero(t, challenge.Failures)
+
+	return challenge
+}
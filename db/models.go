@@ -7,17 +7,199 @@ import (
 )
 
 type User struct {
-	Username       string    `json:"username"`
-	Email          string    `json:"email"`
-	FullName       string    `json:"full_name"`
-	HashedPassword string    `json:"hashed_password"`
-	CreatedAt      time.Time `json:"created_at"`
+	Username        string     `json:"username"`
+	Email           string     `json:"email"`
+	FullName        string     `json:"full_name"`
+	HashedPassword  string     `json:"hashed_password"`
+	CreatedAt       time.Time  `json:"created_at"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	Role            string     `json:"role"`
+	DisabledAt      *time.Time `json:"disabled_at"`
+	// PendingEmail is the email the user is changing to, which only becomes
+	// their email once they verify it
+	PendingEmail *string `json:"pending_email"`
 }
 
 type Todo struct {
-	ID          uuid.UUID `json:"id"`
-	Username    string    `json:"username"`
-	Title       string    `json:"title"`
-	CreatedAt   time.Time `json:"created_at"`
-	IsCompleted bool      `json:"is_completed"`
+	ID          uuid.UUID  `json:"id"`
+	Username    string     `json:"username"`
+	Title       string     `json:"title"`
+	CreatedAt   time.Time  `json:"created_at"`
+	IsCompleted bool       `json:"is_completed"`
+	CompletedAt *time.Time `json:"completed_at"`
+	// Priority is one of the TodoPriority values, if the todo has one
+	Priority *string    `json:"priority"`
+	DueAt    *time.Time `json:"due_at"`
+	Tags     []string   `json:"tags"`
+}
+
+type Webhook struct {
+	ID        uuid.UUID `json:"id"`
+	Username  string    `json:"username"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type WebhookDelivery struct {
+	ID            uuid.UUID `json:"id"`
+	WebhookID     uuid.UUID `json:"webhook_id"`
+	Event         string    `json:"event"`
+	Payload       string    `json:"payload"`
+	Status        string    `json:"status"`
+	Attempts      int       `json:"attempts"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type WebhookDeliveryLog struct {
+	ID          int64     `json:"id"`
+	DeliveryID  uuid.UUID `json:"delivery_id"`
+	StatusCode  int       `json:"status_code"`
+	Error       string    `json:"error"`
+	DurationMs  int64     `json:"duration_ms"`
+	AttemptedAt time.Time `json:"attempted_at"`
+}
+
+type CalendarFeed struct {
+	Username  string    `json:"username"`
+	TokenHash string    `json:"token_hash"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Session struct {
+	ID         uuid.UUID  `json:"id"`
+	Username   string     `json:"username"`
+	UserAgent  string     `json:"user_agent"`
+	ClientIP   string     `json:"client_ip"`
+	ClientID   string     `json:"client_id"`
+	Scopes     []string   `json:"scopes"`
+	LastSeenAt *time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type RefreshToken struct {
+	ID        uuid.UUID  `json:"id"`
+	SessionID uuid.UUID  `json:"session_id"`
+	TokenHash string     `json:"token_hash"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type RevokedToken struct {
+	TokenID   uuid.UUID `json:"token_id"`
+	Username  string    `json:"username"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type UserTokenRevocation struct {
+	Username     string    `json:"username"`
+	IssuedBefore time.Time `json:"issued_before"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	Username   string     `json:"username"`
+	Name       string     `json:"name"`
+	KeyPrefix  string     `json:"key_prefix"`
+	KeyHash    string     `json:"key_hash"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type OAuthClient struct {
+	ID           uuid.UUID `json:"id"`
+	Username     string    `json:"username"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	SecretHash   string    `json:"secret_hash"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type OAuthConsent struct {
+	Username  string    `json:"username"`
+	ClientID  uuid.UUID `json:"client_id"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type OAuthAuthorizationCode struct {
+	CodeHash      string     `json:"code_hash"`
+	ClientID      uuid.UUID  `json:"client_id"`
+	Username      string     `json:"username"`
+	RedirectURI   string     `json:"redirect_uri"`
+	Scopes        []string   `json:"scopes"`
+	CodeChallenge string     `json:"code_challenge"`
+	SessionID     *uuid.UUID `json:"session_id"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	UsedAt        *time.Time `json:"used_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+type UserTOTP struct {
+	Username     string     `json:"username"`
+	Secret       string     `json:"secret"`
+	LastUsedStep int64      `json:"last_used_step"`
+	ConfirmedAt  *time.Time `json:"confirmed_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+type MFAChallenge struct {
+	TokenHash string    `json:"token_hash"`
+	Username  string    `json:"username"`
+	Scopes    []string  `json:"scopes"`
+	Failures  int       `json:"failures"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type LoginFailure struct {
+	Kind         string    `json:"kind"`
+	Identifier   string    `json:"identifier"`
+	Failures     int       `json:"failures"`
+	LastFailedAt time.Time `json:"last_failed_at"`
+}
+
+type EmailVerificationToken struct {
+	TokenHash string    `json:"token_hash"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type PasswordResetToken struct {
+	TokenHash string     `json:"token_hash"`
+	Username  string     `json:"username"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type UserIdentity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type OIDCLoginState struct {
+	StateHash    string    `json:"state_hash"`
+	Provider     string    `json:"provider"`
+	Nonce        string    `json:"nonce"`
+	CodeVerifier string    `json:"code_verifier"`
+	Username     string    `json:"username"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	CreatedAt    time.Time `json:"created_at"`
 }
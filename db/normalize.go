@@ -0,0 +1,226 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sbbullet/to-do/logger"
+	"github.com/sbbullet/to-do/util"
+	"go.uber.org/zap"
+)
+
+// normalizeUsers brings the usernames and emails stored before they were
+// normalized to their canonical form, then has the database keep them unique
+// regardless of case. Users whose username or email has the same canonical
+// form as another's are reported and left alone, as are the unique indexes
+// they would break, until an admin sorts them out.
+func normalizeUsers(db *sql.DB, config *util.Config) error {
+	users, err := loadUserLogins(db)
+	if err != nil {
+		return err
+	}
+
+	usernames := make(map[string][]string)
+	emails := make(map[string][]string)
+	for _, user := range users {
+		canonical := util.NormalizeUsername(user.username)
+		usernames[canonical] = append(usernames[canonical], user.username)
+
+		canonical = util.NormalizeEmail(user.email)
+		emails[canonical] = append(emails[canonical], user.email)
+	}
+
+	usernameCollisions := reportCollisions("username", usernames)
+	emailCollisions := reportCollisions("email", emails)
+
+	for canonical, group := range usernames {
+		if len(group) == 1 && group[0] != canonical {
+			if err := renameUser(db, group[0], canonical, config.AccessTokenDuration); err != nil {
+				return err
+			}
+		}
+	}
+
+	for canonical, group := range emails {
+		if len(group) == 1 && group[0] != canonical {
+			if err := changeStoredEmail(db, group[0], canonical); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := createNocaseIndex(db, "username", usernameCollisions); err != nil {
+		return err
+	}
+
+	return createNocaseIndex(db, "email", emailCollisions)
+}
+
+type userLogin struct {
+	username string
+	email    string
+}
+
+func loadUserLogins(db *sql.DB) ([]userLogin, error) {
+	rows, err := db.Query("SELECT username, email FROM users;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []userLogin{}
+	for rows.Next() {
+		var user userLogin
+		if err := rows.Scan(&user.username, &user.email); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// reportCollisions warns about the values sharing a canonical form, telling
+// how many canonical forms are shared
+func reportCollisions(column string, groups map[string][]string) int {
+	collisions := 0
+	for canonical, group := range groups {
+		if len(group) < 2 {
+			continue
+		}
+
+		sort.Strings(group)
+		logger.Warn(
+			"Users collide once normalized, leaving them as they are",
+			zap.String("Column", column),
+			zap.String("Canonical", canonical),
+			zap.Strings("Values", group),
+		)
+		collisions++
+	}
+
+	return collisions
+}
+
+// renameUser changes the username everywhere it's stored, but for the users
+// deleted before, and revokes the access tokens issued to the old one, which
+// would no longer match the user
+func renameUser(db *sql.DB, from string, to string, accessTokenDuration time.Duration) error {
+	const getTablesWithUsernameQuery = `
+		SELECT m.name
+		FROM sqlite_master m
+		JOIN pragma_table_info(m.name) p
+		WHERE m.type = 'table' AND m.name NOT IN ('users', 'deleted_user_revocations') AND p.name = 'username';
+	`
+	const revokeUserTokensQuery = `
+		INSERT INTO user_token_revocations(username, issued_before, expires_at)
+		VALUES(?, ?, ?)
+		ON CONFLICT(username) DO UPDATE SET
+			issued_before = excluded.issued_before,
+			expires_at = excluded.expires_at;
+	`
+
+	rows, err := db.Query(getTablesWithUsernameQuery)
+	if err != nil {
+		return err
+	}
+
+	tables := []string{}
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			rows.Close()
+			return err
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	// The foreign keys only hold again once every table has been updated
+	if _, err := tx.Exec("PRAGMA defer_foreign_keys = ON;"); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, table := range append(tables, "users") {
+		if _, err := tx.Exec(fmt.Sprintf("UPDATE %s SET username = ? WHERE username = ?;", table), to, from); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	now := time.Now().UTC()
+	if _, err := tx.Exec(revokeUserTokensQuery, to, now, now.Add(accessTokenDuration)); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	logger.Info("Normalized username", zap.String("From", from), zap.String("To", to))
+
+	return nil
+}
+
+// changeStoredEmail changes the email of the user along with the one they
+// were asked to verify, if it's the same
+func changeStoredEmail(db *sql.DB, from string, to string) error {
+	const changeUserEmailQuery = `
+		UPDATE users
+		SET email = ?
+		WHERE email = ?;
+	`
+	const changeEmailVerificationTokensQuery = `
+		UPDATE email_verification_tokens
+		SET email = ?
+		WHERE email = ?;
+	`
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, query := range []string{changeUserEmailQuery, changeEmailVerificationTokensQuery} {
+		if _, err := tx.Exec(query, to, from); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	logger.Info("Normalized email", zap.String("From", from), zap.String("To", to))
+
+	return nil
+}
+
+// createNocaseIndex keeps the column of the users unique regardless of case,
+// unless users already collide in it
+func createNocaseIndex(db *sql.DB, column string, collisions int) error {
+	if collisions > 0 {
+		logger.Warn(
+			"Not making the column unique regardless of case until its collisions are resolved",
+			zap.String("Column", column),
+			zap.Int("Collisions", collisions),
+		)
+		return nil
+	}
+
+	_, err := db.Exec(fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS users_%s_nocase_idx ON users(%s COLLATE NOCASE);", column, column))
+	return err
+}
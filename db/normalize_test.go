@@ -0,0 +1,62 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/sbbullet/to-do/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeUsers(t *testing.T) {
+	config := util.LoadConfig("app", "env", "..")
+	config.DBSource = filepath.Join(t.TempDir(), "legacy.db")
+
+	legacyDB := NewDB(config)
+	defer legacyDB.Close()
+
+	// Databases from before usernames and emails were normalized have neither
+	// the indexes nor canonical values
+	_, err := legacyDB.Exec(`
+		DROP INDEX users_username_nocase_idx;
+		DROP INDEX users_email_nocase_idx;
+		INSERT INTO users(username, email, full_name, hashed_password) VALUES
+			('Alice', 'Alice@Example.com', 'Alice A', ''),
+			('Carol', 'carol@example.com', 'Carol C', ''),
+			('carol', 'other@example.com', 'Carol D', '');
+	`)
+	require.NoError(t, err)
+
+	_, err = legacyDB.Exec("INSERT INTO todos(id, username, title) VALUES(?, 'Alice', 'Buy milk');", uuid.NewString())
+	require.NoError(t, err)
+
+	require.NoError(t, normalizeUsers(legacyDB, config))
+
+	store := NewStore(legacyDB)
+
+	alice, err := store.GetUser("alice")
+	require.NoError(t, err)
+	require.Equal(t, "alice@example.com", alice.Email)
+
+	counts, err := store.GetUserTodoCounts("alice")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), counts.Total)
+
+	// Tokens issued to the old username no longer match the user
+	_, revocations, err := store.GetTokenRevocations()
+	require.NoError(t, err)
+	require.Len(t, revocations, 1)
+	require.Equal(t, "alice", revocations[0].Username)
+
+	// Colliding users are left for an admin to sort out
+	for _, username := range []string{"Carol", "carol"} {
+		_, err := store.GetUser(username)
+		require.NoError(t, err)
+	}
+
+	var indexes int
+	err = legacyDB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name LIKE 'users_%_nocase_idx';").Scan(&indexes)
+	require.NoError(t, err)
+	require.Equal(t, 1, indexes)
+}
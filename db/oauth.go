@@ -0,0 +1,360 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrAuthorizationCodeReused = errors.New("authorization code has already been used")
+
+type CreateOAuthClientParams struct {
+	ID           uuid.UUID `json:"id"`
+	Username     string    `json:"username"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	SecretHash   string    `json:"secret_hash"`
+}
+
+// CreateOAuthClient registers a client, which is a public one when it has no secret
+func (store *Store) CreateOAuthClient(arg CreateOAuthClientParams) (OAuthClient, error) {
+	const createOAuthClientQuery = `
+		INSERT INTO oauth_clients(id, username, name, redirect_uris, scopes, secret_hash)
+		VALUES(?, ?, ?, ?, ?, ?)
+		RETURNING id, username, name, redirect_uris, scopes, secret_hash, created_at;
+	`
+
+	row := store.DB.QueryRow(
+		createOAuthClientQuery,
+		arg.ID,
+		arg.Username,
+		arg.Name,
+		strings.Join(arg.RedirectURIs, ","),
+		strings.Join(arg.Scopes, ","),
+		arg.SecretHash,
+	)
+
+	return scanOAuthClient(row)
+}
+
+func (store *Store) GetOAuthClientById(id uuid.UUID) (OAuthClient, error) {
+	const getOAuthClientByIdQuery = `
+		SELECT id, username, name, redirect_uris, scopes, secret_hash, created_at
+		FROM oauth_clients
+		WHERE id = ?;
+	`
+
+	row := store.DB.QueryRow(getOAuthClientByIdQuery, id)
+
+	return scanOAuthClient(row)
+}
+
+func (store *Store) GetUserOAuthClients(username string) ([]OAuthClient, error) {
+	const getUserOAuthClientsQuery = `
+		SELECT id, username, name, redirect_uris, scopes, secret_hash, created_at
+		FROM oauth_clients
+		WHERE username = ?
+		ORDER BY created_at DESC;
+	`
+
+	rows, err := store.DB.Query(getUserOAuthClientsQuery, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []OAuthClient{}
+	for rows.Next() {
+		client, err := scanOAuthClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+
+	return clients, rows.Err()
+}
+
+type DeleteOAuthClientOfAUserParams struct {
+	ID       uuid.UUID `json:"id"`
+	Username string    `json:"username"`
+}
+
+// DeleteOAuthClientOfAUser deletes the client along with its consents and
+// authorization codes, and revokes the sessions users authorized it with
+func (store *Store) DeleteOAuthClientOfAUser(arg DeleteOAuthClientOfAUserParams) (revokedSessions []Session, err error) {
+	const deleteOAuthClientOfAUserQuery = `
+		DELETE FROM oauth_clients
+		WHERE id = ? AND username = ?;
+	`
+	const deleteOAuthConsentsQuery = `
+		DELETE FROM oauth_consents
+		WHERE client_id = ?;
+	`
+	const deleteOAuthAuthorizationCodesQuery = `
+		DELETE FROM oauth_authorization_codes
+		WHERE client_id = ?;
+	`
+	const revokeClientSessionsQuery = `
+		UPDATE sessions
+		SET revoked_at = ?
+		WHERE client_id = ? AND revoked_at IS NULL
+		RETURNING id, username, user_agent, client_ip, client_id, scopes, last_seen_at, expires_at, revoked_at, created_at;
+	`
+
+	err = store.execTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec(deleteOAuthClientOfAUserQuery, arg.ID, arg.Username)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected < 1 {
+			return sql.ErrNoRows
+		}
+
+		if _, err := tx.Exec(deleteOAuthConsentsQuery, arg.ID); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(deleteOAuthAuthorizationCodesQuery, arg.ID); err != nil {
+			return err
+		}
+
+		rows, err := tx.Query(revokeClientSessionsQuery, time.Now().UTC(), arg.ID.String())
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		revokedSessions = []Session{}
+		for rows.Next() {
+			session, err := scanSession(rows)
+			if err != nil {
+				return err
+			}
+			revokedSessions = append(revokedSessions, session)
+		}
+
+		return rows.Err()
+	})
+
+	return
+}
+
+type GetOAuthConsentParams struct {
+	Username string    `json:"username"`
+	ClientID uuid.UUID `json:"client_id"`
+}
+
+func (store *Store) GetOAuthConsent(arg GetOAuthConsentParams) (OAuthConsent, error) {
+	const getOAuthConsentQuery = `
+		SELECT username, client_id, scopes, created_at
+		FROM oauth_consents
+		WHERE username = ? AND client_id = ?;
+	`
+
+	row := store.DB.QueryRow(getOAuthConsentQuery, arg.Username, arg.ClientID)
+
+	return scanOAuthConsent(row)
+}
+
+type GrantOAuthConsentParams struct {
+	Username string    `json:"username"`
+	ClientID uuid.UUID `json:"client_id"`
+	Scopes   []string  `json:"scopes"`
+}
+
+// GrantOAuthConsent records the scopes the user allowed the client, replacing
+// the ones allowed before
+func (store *Store) GrantOAuthConsent(arg GrantOAuthConsentParams) (OAuthConsent, error) {
+	const grantOAuthConsentQuery = `
+		INSERT INTO oauth_consents(username, client_id, scopes)
+		VALUES(?, ?, ?)
+		ON CONFLICT(username, client_id) DO UPDATE SET scopes = excluded.scopes
+		RETURNING username, client_id, scopes, created_at;
+	`
+
+	row := store.DB.QueryRow(grantOAuthConsentQuery, arg.Username, arg.ClientID, strings.Join(arg.Scopes, ","))
+
+	return scanOAuthConsent(row)
+}
+
+type CreateOAuthAuthorizationCodeParams struct {
+	CodeHash      string    `json:"code_hash"`
+	ClientID      uuid.UUID `json:"client_id"`
+	Username      string    `json:"username"`
+	RedirectURI   string    `json:"redirect_uri"`
+	Scopes        []string  `json:"scopes"`
+	CodeChallenge string    `json:"code_challenge"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+func (store *Store) CreateOAuthAuthorizationCode(arg CreateOAuthAuthorizationCodeParams) (OAuthAuthorizationCode, error) {
+	const createOAuthAuthorizationCodeQuery = `
+		INSERT INTO oauth_authorization_codes(code_hash, client_id, username, redirect_uri, scopes, code_challenge, expires_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?)
+		RETURNING code_hash, client_id, username, redirect_uri, scopes, code_challenge, session_id, expires_at, used_at, created_at;
+	`
+
+	row := store.DB.QueryRow(
+		createOAuthAuthorizationCodeQuery,
+		arg.CodeHash,
+		arg.ClientID,
+		arg.Username,
+		arg.RedirectURI,
+		strings.Join(arg.Scopes, ","),
+		arg.CodeChallenge,
+		arg.ExpiresAt.UTC(),
+	)
+
+	return scanOAuthAuthorizationCode(row)
+}
+
+func (store *Store) GetOAuthAuthorizationCode(codeHash string) (OAuthAuthorizationCode, error) {
+	const getOAuthAuthorizationCodeQuery = `
+		SELECT code_hash, client_id, username, redirect_uri, scopes, code_challenge, session_id, expires_at, used_at, created_at
+		FROM oauth_authorization_codes
+		WHERE code_hash = ?;
+	`
+
+	row := store.DB.QueryRow(getOAuthAuthorizationCodeQuery, codeHash)
+
+	return scanOAuthAuthorizationCode(row)
+}
+
+type ExchangeOAuthAuthorizationCodeParams struct {
+	CodeHash string              `json:"code_hash"`
+	Session  CreateSessionParams `json:"session"`
+}
+
+// ExchangeOAuthAuthorizationCode uses up the authorization code, starting the
+// session the client is granted. A code can only be exchanged once, and when
+// it's presented again the session it was exchanged for gets revoked.
+func (store *Store) ExchangeOAuthAuthorizationCode(arg ExchangeOAuthAuthorizationCodeParams) (session Session, err error) {
+	const useOAuthAuthorizationCodeQuery = `
+		UPDATE oauth_authorization_codes
+		SET used_at = ?, session_id = ?
+		WHERE code_hash = ? AND used_at IS NULL;
+	`
+	const getOAuthAuthorizationCodeSessionQuery = `
+		SELECT session_id
+		FROM oauth_authorization_codes
+		WHERE code_hash = ?;
+	`
+
+	reused := false
+	err = store.execTx(func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+
+		result, err := tx.Exec(useOAuthAuthorizationCodeQuery, now, arg.Session.ID, arg.CodeHash)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected < 1 {
+			var sessionID *uuid.UUID
+			if err := tx.QueryRow(getOAuthAuthorizationCodeSessionQuery, arg.CodeHash).Scan(&sessionID); err != nil {
+				return err
+			}
+
+			// The revocation has to be committed, so the error is only returned
+			// once the transaction is done
+			reused = true
+			if sessionID == nil {
+				return nil
+			}
+			return revokeSession(tx, *sessionID, now)
+		}
+
+		session, err = createSession(tx, arg.Session)
+		return err
+	})
+
+	if err == nil && reused {
+		err = ErrAuthorizationCodeReused
+	}
+
+	return
+}
+
+func scanOAuthClient(row rowScanner) (client OAuthClient, err error) {
+	var redirectURIs, scopes string
+
+	err = row.Scan(
+		&client.ID,
+		&client.Username,
+		&client.Name,
+		&redirectURIs,
+		&scopes,
+		&client.SecretHash,
+		&client.CreatedAt,
+	)
+
+	client.RedirectURIs = []string{}
+	if len(redirectURIs) > 0 {
+		client.RedirectURIs = strings.Split(redirectURIs, ",")
+	}
+
+	client.Scopes = []string{}
+	if len(scopes) > 0 {
+		client.Scopes = strings.Split(scopes, ",")
+	}
+
+	return
+}
+
+func scanOAuthConsent(row rowScanner) (consent OAuthConsent, err error) {
+	var scopes string
+
+	err = row.Scan(
+		&consent.Username,
+		&consent.ClientID,
+		&scopes,
+		&consent.CreatedAt,
+	)
+
+	consent.Scopes = []string{}
+	if len(scopes) > 0 {
+		consent.Scopes = strings.Split(scopes, ",")
+	}
+
+	return
+}
+
+func scanOAuthAuthorizationCode(row rowScanner) (code OAuthAuthorizationCode, err error) {
+	var scopes string
+
+	err = row.Scan(
+		&code.CodeHash,
+		&code.ClientID,
+		&code.Username,
+		&code.RedirectURI,
+		&scopes,
+		&code.CodeChallenge,
+		&code.SessionID,
+		&code.ExpiresAt,
+		&code.UsedAt,
+		&code.CreatedAt,
+	)
+
+	code.Scopes = []string{}
+	if len(scopes) > 0 {
+		code.Scopes = strings.Split(scopes, ",")
+	}
+
+	return
+}
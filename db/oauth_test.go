@@ -0,0 +1,180 @@
+package db
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sbbullet/to-do/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateOAuthClient(t *testing.T) {
+	user := createRandomUser(t)
+	client := createRandomOAuthClient(t, user.Username)
+
+	found, err := testStore.GetOAuthClientById(client.ID)
+	require.NoError(t, err)
+	require.Equal(t, client.Name, found.Name)
+	require.Equal(t, []string{"https://example.com/callback", "http://localhost:8080/callback"}, found.RedirectURIs)
+	require.Equal(t, []string{"todos:read", "todos:write"}, found.Scopes)
+	require.Equal(t, client.SecretHash, found.SecretHash)
+}
+
+func TestGetUserOAuthClients(t *testing.T) {
+	user := createRandomUser(t)
+	for i := 0; i < 2; i++ {
+		createRandomOAuthClient(t, user.Username)
+	}
+
+	clients, err := testStore.GetUserOAuthClients(user.Username)
+	require.NoError(t, err)
+	require.Len(t, clients, 2)
+}
+
+func TestDeleteOAuthClientOfAUser(t *testing.T) {
+	owner := createRandomUser(t)
+	client := createRandomOAuthClient(t, owner.Username)
+
+	user := createRandomUser(t)
+	code := createRandomOAuthAuthorizationCode(t, client, user.Username)
+	session, err := testStore.ExchangeOAuthAuthorizationCode(randomExchangeOAuthAuthorizationCodeParams(code))
+	require.NoError(t, err)
+
+	_, err = testStore.DeleteOAuthClientOfAUser(DeleteOAuthClientOfAUserParams{ID: client.ID, Username: user.Username})
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	revokedSessions, err := testStore.DeleteOAuthClientOfAUser(DeleteOAuthClientOfAUserParams{ID: client.ID, Username: owner.Username})
+	require.NoError(t, err)
+	require.Len(t, revokedSessions, 1)
+	require.Equal(t, session.ID, revokedSessions[0].ID)
+	require.NotNil(t, revokedSessions[0].RevokedAt)
+
+	_, err = testStore.GetOAuthClientById(client.ID)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	_, err = testStore.GetOAuthAuthorizationCode(code.CodeHash)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestGrantOAuthConsent(t *testing.T) {
+	owner := createRandomUser(t)
+	client := createRandomOAuthClient(t, owner.Username)
+	user := createRandomUser(t)
+
+	arg := GetOAuthConsentParams{Username: user.Username, ClientID: client.ID}
+	_, err := testStore.GetOAuthConsent(arg)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	_, err = testStore.GrantOAuthConsent(GrantOAuthConsentParams{
+		Username: user.Username,
+		ClientID: client.ID,
+		Scopes:   []string{"todos:read"},
+	})
+	require.NoError(t, err)
+
+	consent, err := testStore.GrantOAuthConsent(GrantOAuthConsentParams{
+		Username: user.Username,
+		ClientID: client.ID,
+		Scopes:   []string{"todos:read", "todos:write"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"todos:read", "todos:write"}, consent.Scopes)
+
+	found, err := testStore.GetOAuthConsent(arg)
+	require.NoError(t, err)
+	require.Equal(t, consent.Scopes, found.Scopes)
+}
+
+func TestExchangeOAuthAuthorizationCode(t *testing.T) {
+	owner := createRandomUser(t)
+	client := createRandomOAuthClient(t, owner.Username)
+	user := createRandomUser(t)
+	code := createRandomOAuthAuthorizationCode(t, client, user.Username)
+
+	arg := randomExchangeOAuthAuthorizationCodeParams(code)
+	session, err := testStore.ExchangeOAuthAuthorizationCode(arg)
+	require.NoError(t, err)
+	require.Equal(t, arg.Session.ID, session.ID)
+	require.Equal(t, client.ID.String(), session.ClientID)
+	require.Equal(t, code.Scopes, session.Scopes)
+
+	used, err := testStore.GetOAuthAuthorizationCode(code.CodeHash)
+	require.NoError(t, err)
+	require.NotNil(t, used.UsedAt)
+	require.NotNil(t, used.SessionID)
+	require.Equal(t, session.ID, *used.SessionID)
+}
+
+func TestExchangeOAuthAuthorizationCodeReused(t *testing.T) {
+	owner := createRandomUser(t)
+	client := createRandomOAuthClient(t, owner.Username)
+	user := createRandomUser(t)
+	code := createRandomOAuthAuthorizationCode(t, client, user.Username)
+
+	session, err := testStore.ExchangeOAuthAuthorizationCode(randomExchangeOAuthAuthorizationCodeParams(code))
+	require.NoError(t, err)
+
+	_, err = testStore.ExchangeOAuthAuthorizationCode(randomExchangeOAuthAuthorizationCodeParams(code))
+	require.ErrorIs(t, err, ErrAuthorizationCodeReused)
+
+	// The session the code was first exchanged for is revoked
+	revoked, err := testStore.GetSessionById(session.ID)
+	require.NoError(t, err)
+	require.NotNil(t, revoked.RevokedAt)
+}
+
+func createRandomOAuthClient(t *testing.T, username string) OAuthClient {
+	arg := CreateOAuthClientParams{
+		ID:           uuid.New(),
+		Username:     username,
+		Name:         util.RandomString(10),
+		RedirectURIs: []string{"https://example.com/callback", "http://localhost:8080/callback"},
+		Scopes:       []string{"todos:read", "todos:write"},
+		SecretHash:   util.HashSecret(util.RandomString(32)),
+	}
+
+	client, err := testStore.CreateOAuthClient(arg)
+	require.NoError(t, err)
+	require.Equal(t, arg.ID, client.ID)
+	require.Equal(t, arg.Username, client.Username)
+
+	return client
+}
+
+func createRandomOAuthAuthorizationCode(t *testing.T, client OAuthClient, username string) OAuthAuthorizationCode {
+	arg := CreateOAuthAuthorizationCodeParams{
+		CodeHash:      util.HashSecret(util.RandomString(32)),
+		ClientID:      client.ID,
+		Username:      username,
+		RedirectURI:   client.RedirectURIs[0],
+		Scopes:        []string{"todos:read"},
+		CodeChallenge: util.RandomString(43),
+		ExpiresAt:     time.Now().Add(10 * time.Minute),
+	}
+
+	code, err := testStore.CreateOAuthAuthorizationCode(arg)
+	require.NoError(t, err)
+	require.Equal(t, arg.CodeHash, code.CodeHash)
+	require.Equal(t, arg.ClientID, code.ClientID)
+	require.Nil(t, code.UsedAt)
+	require.Nil(t, code.SessionID)
+
+	return code
+}
+
+func randomExchangeOAuthAuthorizationCodeParams(code OAuthAuthorizationCode) ExchangeOAuthAuthorizationCodeParams {
+	return ExchangeOAuthAuthorizationCodeParams{
+		CodeHash: code.CodeHash,
+		Session: CreateSessionParams{
+			ID:               uuid.New(),
+			Username:         code.Username,
+			ClientID:         code.ClientID.String(),
+			Scopes:           code.Scopes,
+			RefreshTokenID:   uuid.New(),
+			RefreshTokenHash: util.HashSecret(util.RandomString(32)),
+			ExpiresAt:        time.Now().Add(time.Hour),
+		},
+	}
+}
@@ -0,0 +1,118 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrPasswordResetTokenInvalid = errors.New("password reset token is invalid, used or expired")
+
+type CreatePasswordResetTokenParams struct {
+	TokenHash string    `json:"token_hash"`
+	Username  string    `json:"username"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CreatePasswordResetToken creates a reset token for the user, replacing the
+// ones they were sent before so that only the latest link works
+func (store *Store) CreatePasswordResetToken(arg CreatePasswordResetTokenParams) (resetToken PasswordResetToken, err error) {
+	const deleteUserPasswordResetTokensQuery = `
+		DELETE FROM password_reset_tokens
+		WHERE username = ?;
+	`
+	const createPasswordResetTokenQuery = `
+		INSERT INTO password_reset_tokens(token_hash, username, expires_at)
+		VALUES(?, ?, ?)
+		RETURNING token_hash, username, expires_at, used_at, created_at;
+	`
+
+	err = store.execTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(deleteUserPasswordResetTokensQuery, arg.Username); err != nil {
+			return err
+		}
+
+		row := tx.QueryRow(createPasswordResetTokenQuery, arg.TokenHash, arg.Username, arg.ExpiresAt.UTC())
+		resetToken, err = scanPasswordResetToken(row)
+		return err
+	})
+
+	return
+}
+
+// GetPasswordResetToken gets the reset token as long as it can still be used
+func (store *Store) GetPasswordResetToken(tokenHash string) (PasswordResetToken, error) {
+	const getPasswordResetTokenQuery = `
+		SELECT token_hash, username, expires_at, used_at, created_at
+		FROM password_reset_tokens
+		WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?;
+	`
+
+	row := store.DB.QueryRow(getPasswordResetTokenQuery, tokenHash, time.Now().UTC())
+
+	resetToken, err := scanPasswordResetToken(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return resetToken, ErrPasswordResetTokenInvalid
+	}
+
+	return resetToken, err
+}
+
+type ResetPasswordParams struct {
+	TokenHash      string `json:"token_hash"`
+	HashedPassword string `json:"hashed_password"`
+}
+
+// ResetPassword uses up the reset token to change the password of its user,
+// revoking every session they have. It gives the username of the user.
+func (store *Store) ResetPassword(arg ResetPasswordParams) (username string, err error) {
+	const usePasswordResetTokenQuery = `
+		UPDATE password_reset_tokens
+		SET used_at = ?
+		WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?
+		RETURNING username;
+	`
+	const updatePasswordQuery = `
+		UPDATE users
+		SET hashed_password = ?
+		WHERE username = ?;
+	`
+	const revokeUserSessionsQuery = `
+		UPDATE sessions
+		SET revoked_at = ?
+		WHERE username = ? AND revoked_at IS NULL;
+	`
+
+	err = store.execTx(func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+
+		err := tx.QueryRow(usePasswordResetTokenQuery, now, arg.TokenHash, now).Scan(&username)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrPasswordResetTokenInvalid
+			}
+			return err
+		}
+
+		if _, err := tx.Exec(updatePasswordQuery, arg.HashedPassword, username); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(revokeUserSessionsQuery, now, username)
+		return err
+	})
+
+	return
+}
+
+func scanPasswordResetToken(row rowScanner) (resetToken PasswordResetToken, err error) {
+	err = row.Scan(
+		&resetToken.TokenHash,
+		&resetToken.Username,
+		&resetToken.ExpiresAt,
+		&resetToken.UsedAt,
+		&resetToken.CreatedAt,
+	)
+
+	return
+}
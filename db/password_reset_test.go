@@ -0,0 +1,93 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sbbullet/to-do/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResetPassword(t *testing.T) {
+	user := createRandomUser(t)
+	session, _ := createRandomSession(t, user.Username)
+	resetToken := createRandomPasswordResetToken(t, user.Username, time.Now().Add(time.Hour))
+
+	hashedPassword, err := util.HashPassword(util.RandomString(8))
+	require.NoError(t, err)
+
+	username, err := testStore.ResetPassword(ResetPasswordParams{TokenHash: resetToken.TokenHash, HashedPassword: hashedPassword})
+	require.NoError(t, err)
+	require.Equal(t, user.Username, username)
+
+	updated, err := testStore.GetUser(user.Username)
+	require.NoError(t, err)
+	require.Equal(t, hashedPassword, updated.HashedPassword)
+
+	revoked, err := testStore.GetSessionById(session.ID)
+	require.NoError(t, err)
+	require.NotNil(t, revoked.RevokedAt)
+
+	// Reset tokens can only be used once
+	_, err = testStore.ResetPassword(ResetPasswordParams{TokenHash: resetToken.TokenHash, HashedPassword: hashedPassword})
+	require.ErrorIs(t, err, ErrPasswordResetTokenInvalid)
+}
+
+func TestResetPasswordExpired(t *testing.T) {
+	user := createRandomUser(t)
+	resetToken := createRandomPasswordResetToken(t, user.Username, time.Now().Add(-time.Minute))
+
+	_, err := testStore.ResetPassword(ResetPasswordParams{TokenHash: resetToken.TokenHash, HashedPassword: "hash"})
+	require.ErrorIs(t, err, ErrPasswordResetTokenInvalid)
+
+	unchanged, err := testStore.GetUser(user.Username)
+	require.NoError(t, err)
+	require.Equal(t, user.HashedPassword, unchanged.HashedPassword)
+}
+
+func TestGetPasswordResetToken(t *testing.T) {
+	user := createRandomUser(t)
+	resetToken := createRandomPasswordResetToken(t, user.Username, time.Now().Add(time.Hour))
+
+	got, err := testStore.GetPasswordResetToken(resetToken.TokenHash)
+	require.NoError(t, err)
+	require.Equal(t, user.Username, got.Username)
+
+	_, err = testStore.ResetPassword(ResetPasswordParams{TokenHash: resetToken.TokenHash, HashedPassword: "hash"})
+	require.NoError(t, err)
+
+	_, err = testStore.GetPasswordResetToken(resetToken.TokenHash)
+	require.ErrorIs(t, err, ErrPasswordResetTokenInvalid)
+
+	expired := createRandomPasswordResetToken(t, user.Username, time.Now().Add(-time.Minute))
+	_, err = testStore.GetPasswordResetToken(expired.TokenHash)
+	require.ErrorIs(t, err, ErrPasswordResetTokenInvalid)
+}
+
+func TestCreatePasswordResetTokenReplacesOlderOnes(t *testing.T) {
+	user := createRandomUser(t)
+	older := createRandomPasswordResetToken(t, user.Username, time.Now().Add(time.Hour))
+	newer := createRandomPasswordResetToken(t, user.Username, time.Now().Add(time.Hour))
+
+	_, err := testStore.ResetPassword(ResetPasswordParams{TokenHash: older.TokenHash, HashedPassword: "hash"})
+	require.ErrorIs(t, err, ErrPasswordResetTokenInvalid)
+
+	_, err = testStore.ResetPassword(ResetPasswordParams{TokenHash: newer.TokenHash, HashedPassword: "hash"})
+	require.NoError(t, err)
+}
+
+func createRandomPasswordResetToken(t *testing.T, username string, expiresAt time.Time) PasswordResetToken {
+	arg := CreatePasswordResetTokenParams{
+		TokenHash: util.HashSecret(util.RandomString(32)),
+		Username:  username,
+		ExpiresAt: expiresAt,
+	}
+
+	resetToken, err := testStore.CreatePasswordResetToken(arg)
+	require.NoError(t, err)
+	require.Equal(t, arg.TokenHash, resetToken.TokenHash)
+	require.Equal(t, username, resetToken.Username)
+	require.Nil(t, resetToken.UsedAt)
+
+	return resetToken
+}
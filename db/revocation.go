@@ -0,0 +1,143 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type RevokeTokenParams struct {
+	TokenID   uuid.UUID `json:"token_id"`
+	Username  string    `json:"username"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// RevokeToken revokes a single access token until it expires anyway
+func (store *Store) RevokeToken(arg RevokeTokenParams) (revokedToken RevokedToken, err error) {
+	const revokeTokenQuery = `
+		INSERT INTO revoked_tokens(token_id, username, expires_at)
+		VALUES(?, ?, ?)
+		ON CONFLICT(token_id) DO UPDATE SET expires_at = excluded.expires_at
+		RETURNING token_id, username, expires_at, created_at;
+	`
+
+	row := store.DB.QueryRow(revokeTokenQuery, arg.TokenID, arg.Username, arg.ExpiresAt.UTC())
+
+	err = row.Scan(&revokedToken.TokenID, &revokedToken.Username, &revokedToken.ExpiresAt, &revokedToken.CreatedAt)
+
+	return
+}
+
+type RevokeUserTokensParams struct {
+	Username     string    `json:"username"`
+	IssuedBefore time.Time `json:"issued_before"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// RevokeUserTokens revokes every access token issued to the user before the
+// given time. The revocation is only needed until the last of those tokens expires.
+func (store *Store) RevokeUserTokens(arg RevokeUserTokensParams) (revocation UserTokenRevocation, err error) {
+	const revokeUserTokensQuery = `
+		INSERT INTO user_token_revocations(username, issued_before, expires_at)
+		VALUES(?, ?, ?)
+		ON CONFLICT(username) DO UPDATE SET
+			issued_before = excluded.issued_before,
+			expires_at = MAX(expires_at, excluded.expires_at)
+		RETURNING username, issued_before, expires_at, created_at;
+	`
+
+	row := store.DB.QueryRow(revokeUserTokensQuery, arg.Username, arg.IssuedBefore.UTC(), arg.ExpiresAt.UTC())
+
+	err = row.Scan(&revocation.Username, &revocation.IssuedBefore, &revocation.ExpiresAt, &revocation.CreatedAt)
+
+	return
+}
+
+// GetTokenRevocations gets the revocations that have not expired yet. Users
+// can have two of them, one from before they were deleted and one for the
+// user who took their username since.
+func (store *Store) GetTokenRevocations() (revokedTokens []RevokedToken, userRevocations []UserTokenRevocation, err error) {
+	const getRevokedTokensQuery = `
+		SELECT token_id, username, expires_at, created_at
+		FROM revoked_tokens
+		WHERE expires_at > ?;
+	`
+	const getUserTokenRevocationsQuery = `
+		SELECT username, issued_before, expires_at, created_at
+		FROM user_token_revocations
+		WHERE expires_at > ?
+		UNION ALL
+		SELECT username, issued_before, expires_at, created_at
+		FROM deleted_user_revocations
+		WHERE expires_at > ?;
+	`
+
+	now := time.Now().UTC()
+
+	rows, err := store.DB.Query(getRevokedTokensQuery, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var revokedToken RevokedToken
+		err = rows.Scan(&revokedToken.TokenID, &revokedToken.Username, &revokedToken.ExpiresAt, &revokedToken.CreatedAt)
+		if err != nil {
+			return nil, nil, err
+		}
+		revokedTokens = append(revokedTokens, revokedToken)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	userRows, err := store.DB.Query(getUserTokenRevocationsQuery, now, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer userRows.Close()
+
+	for userRows.Next() {
+		var revocation UserTokenRevocation
+		err = userRows.Scan(&revocation.Username, &revocation.IssuedBefore, &revocation.ExpiresAt, &revocation.CreatedAt)
+		if err != nil {
+			return nil, nil, err
+		}
+		userRevocations = append(userRevocations, revocation)
+	}
+
+	err = userRows.Err()
+
+	return
+}
+
+// PruneTokenRevocations deletes the revocations of tokens that have expired
+func (store *Store) PruneTokenRevocations() error {
+	const pruneRevokedTokensQuery = `
+		DELETE FROM revoked_tokens WHERE expires_at <= ?;
+	`
+	const pruneUserTokenRevocationsQuery = `
+		DELETE FROM user_token_revocations WHERE expires_at <= ?;
+	`
+	const pruneDeletedUserRevocationsQuery = `
+		DELETE FROM deleted_user_revocations WHERE expires_at <= ?;
+	`
+
+	now := time.Now().UTC()
+
+	return store.execTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(pruneRevokedTokensQuery, now); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(pruneUserTokenRevocationsQuery, now); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(pruneDeletedUserRevocationsQuery, now)
+		return err
+	})
+}
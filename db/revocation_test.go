@@ -0,0 +1,93 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevokeToken(t *testing.T) {
+	user := createRandomUser(t)
+
+	arg := RevokeTokenParams{
+		TokenID:   uuid.New(),
+		Username:  user.Username,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	revokedToken, err := testStore.RevokeToken(arg)
+	require.NoError(t, err)
+	require.Equal(t, arg.TokenID, revokedToken.TokenID)
+
+	// Revoking the same token again is not an error
+	_, err = testStore.RevokeToken(arg)
+	require.NoError(t, err)
+
+	revokedTokens, _, err := testStore.GetTokenRevocations()
+	require.NoError(t, err)
+	require.Contains(t, revokedTokenIDs(revokedTokens), arg.TokenID)
+}
+
+func TestRevokeUserTokens(t *testing.T) {
+	user := createRandomUser(t)
+
+	arg := RevokeUserTokensParams{
+		Username:     user.Username,
+		IssuedBefore: time.Now(),
+		ExpiresAt:    time.Now().Add(time.Hour),
+	}
+
+	revocation, err := testStore.RevokeUserTokens(arg)
+	require.NoError(t, err)
+	require.WithinDuration(t, arg.IssuedBefore, revocation.IssuedBefore, time.Second)
+
+	// A later revocation moves the cut-off forward without shortening the expiry
+	arg.IssuedBefore = arg.IssuedBefore.Add(time.Minute)
+	later, err := testStore.RevokeUserTokens(RevokeUserTokensParams{
+		Username:     user.Username,
+		IssuedBefore: arg.IssuedBefore,
+		ExpiresAt:    time.Now().Add(time.Minute),
+	})
+	require.NoError(t, err)
+	require.WithinDuration(t, arg.IssuedBefore, later.IssuedBefore, time.Second)
+	require.WithinDuration(t, arg.ExpiresAt, later.ExpiresAt, time.Second)
+}
+
+func TestPruneTokenRevocations(t *testing.T) {
+	user := createRandomUser(t)
+
+	expired, err := testStore.RevokeToken(RevokeTokenParams{
+		TokenID:   uuid.New(),
+		Username:  user.Username,
+		ExpiresAt: time.Now().Add(-time.Minute),
+	})
+	require.NoError(t, err)
+
+	active, err := testStore.RevokeToken(RevokeTokenParams{
+		TokenID:   uuid.New(),
+		Username:  user.Username,
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+
+	err = testStore.PruneTokenRevocations()
+	require.NoError(t, err)
+
+	revokedTokens, _, err := testStore.GetTokenRevocations()
+	require.NoError(t, err)
+
+	tokenIDs := revokedTokenIDs(revokedTokens)
+	require.NotContains(t, tokenIDs, expired.TokenID)
+	require.Contains(t, tokenIDs, active.TokenID)
+}
+
+func revokedTokenIDs(revokedTokens []RevokedToken) []uuid.UUID {
+	tokenIDs := []uuid.UUID{}
+	for _, revokedToken := range revokedTokens {
+		tokenIDs = append(tokenIDs, revokedToken.TokenID)
+	}
+
+	return tokenIDs
+}
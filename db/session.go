@@ -0,0 +1,373 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrRefreshTokenReused = errors.New("refresh token has already been used")
+	ErrSessionInactive    = errors.New("session is expired or revoked")
+)
+
+type CreateSessionParams struct {
+	ID               uuid.UUID `json:"id"`
+	Username         string    `json:"username"`
+	UserAgent        string    `json:"user_agent"`
+	ClientIP         string    `json:"client_ip"`
+	ClientID         string    `json:"client_id"`
+	Scopes           []string  `json:"scopes"`
+	RefreshTokenID   uuid.UUID `json:"refresh_token_id"`
+	RefreshTokenHash string    `json:"refresh_token_hash"`
+	ExpiresAt        time.Time `json:"expires_at"`
+}
+
+// CreateSession starts a session along with the first refresh token of its family
+func (store *Store) CreateSession(arg CreateSessionParams) (session Session, err error) {
+	err = store.execTx(func(tx *sql.Tx) error {
+		session, err = createSession(tx, arg)
+		return err
+	})
+
+	return
+}
+
+func (store *Store) GetSessionById(id uuid.UUID) (session Session, err error) {
+	const getSessionByIdQuery = `
+		SELECT id, username, user_agent, client_ip, client_id, scopes, last_seen_at, expires_at, revoked_at, created_at
+		FROM sessions
+		WHERE id = ?;
+	`
+
+	row := store.DB.QueryRow(getSessionByIdQuery, id)
+
+	return scanSession(row)
+}
+
+func (store *Store) GetRefreshTokenByHash(tokenHash string) (refreshToken RefreshToken, err error) {
+	const getRefreshTokenByHashQuery = `
+		SELECT id, session_id, token_hash, expires_at, used_at, created_at
+		FROM refresh_tokens
+		WHERE token_hash = ?;
+	`
+
+	row := store.DB.QueryRow(getRefreshTokenByHashQuery, tokenHash)
+	err = row.Scan(
+		&refreshToken.ID,
+		&refreshToken.SessionID,
+		&refreshToken.TokenHash,
+		&refreshToken.ExpiresAt,
+		&refreshToken.UsedAt,
+		&refreshToken.CreatedAt,
+	)
+
+	return
+}
+
+type RotateRefreshTokenParams struct {
+	ClientID     string    `json:"client_id"`
+	TokenHash    string    `json:"token_hash"`
+	NewTokenID   uuid.UUID `json:"new_token_id"`
+	NewTokenHash string    `json:"new_token_hash"`
+	NewExpiresAt time.Time `json:"new_expires_at"`
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one of the same
+// family, extending the session. Presenting a refresh token that has already
+// been exchanged means it leaked, so the whole session gets revoked. Only the
+// client the session was started by can rotate its refresh tokens.
+func (store *Store) RotateRefreshToken(arg RotateRefreshTokenParams) (session Session, err error) {
+	const getRefreshTokenQuery = `
+		SELECT id, session_id, token_hash, expires_at, used_at, created_at
+		FROM refresh_tokens
+		WHERE token_hash = ?;
+	`
+	const getSessionQuery = `
+		SELECT id, username, user_agent, client_ip, client_id, scopes, last_seen_at, expires_at, revoked_at, created_at
+		FROM sessions
+		WHERE id = ?;
+	`
+	const useRefreshTokenQuery = `
+		UPDATE refresh_tokens
+		SET used_at = ?
+		WHERE id = ? AND used_at IS NULL;
+	`
+	const createRefreshTokenQuery = `
+		INSERT INTO refresh_tokens(id, session_id, token_hash, expires_at)
+		VALUES(?, ?, ?, ?);
+	`
+	const extendSessionQuery = `
+		UPDATE sessions
+		SET expires_at = ?, last_seen_at = ?
+		WHERE id = ?
+		RETURNING id, username, user_agent, client_ip, client_id, scopes, last_seen_at, expires_at, revoked_at, created_at;
+	`
+
+	reused := false
+	err = store.execTx(func(tx *sql.Tx) error {
+		var refreshToken RefreshToken
+
+		row := tx.QueryRow(getRefreshTokenQuery, arg.TokenHash)
+		err := row.Scan(
+			&refreshToken.ID,
+			&refreshToken.SessionID,
+			&refreshToken.TokenHash,
+			&refreshToken.ExpiresAt,
+			&refreshToken.UsedAt,
+			&refreshToken.CreatedAt,
+		)
+		if err != nil {
+			return err
+		}
+
+		row = tx.QueryRow(getSessionQuery, refreshToken.SessionID)
+		session, err = scanSession(row)
+		if err != nil {
+			return err
+		}
+
+		if session.ClientID != arg.ClientID {
+			return sql.ErrNoRows
+		}
+
+		now := time.Now().UTC()
+		if refreshToken.UsedAt != nil {
+			// The revocation has to be committed, so the error is only returned
+			// once the transaction is done
+			reused = true
+			return revokeSession(tx, session.ID, now)
+		}
+
+		if session.RevokedAt != nil || now.After(session.ExpiresAt) || now.After(refreshToken.ExpiresAt) {
+			return ErrSessionInactive
+		}
+
+		result, err := tx.Exec(useRefreshTokenQuery, now, refreshToken.ID)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected < 1 {
+			reused = true
+			return revokeSession(tx, session.ID, now)
+		}
+
+		_, err = tx.Exec(createRefreshTokenQuery, arg.NewTokenID, session.ID, arg.NewTokenHash, arg.NewExpiresAt.UTC())
+		if err != nil {
+			return err
+		}
+
+		row = tx.QueryRow(extendSessionQuery, arg.NewExpiresAt.UTC(), now, session.ID)
+		session, err = scanSession(row)
+		return err
+	})
+
+	if err == nil && reused {
+		err = ErrRefreshTokenReused
+	}
+
+	return
+}
+
+type RevokeSessionOfAUserParams struct {
+	ID       uuid.UUID `json:"id"`
+	Username string    `json:"username"`
+}
+
+func (store *Store) RevokeSessionOfAUser(arg RevokeSessionOfAUserParams) (session Session, err error) {
+	const revokeSessionOfAUserQuery = `
+		UPDATE sessions
+		SET revoked_at = COALESCE(revoked_at, ?)
+		WHERE id = ? AND username = ?
+		RETURNING id, username, user_agent, client_ip, client_id, scopes, last_seen_at, expires_at, revoked_at, created_at;
+	`
+
+	row := store.DB.QueryRow(revokeSessionOfAUserQuery, time.Now().UTC(), arg.ID, arg.Username)
+
+	return scanSession(row)
+}
+
+type RevokeSessionByRefreshTokenParams struct {
+	Username  string `json:"username"`
+	TokenHash string `json:"token_hash"`
+}
+
+// RevokeSessionByRefreshToken revokes the session any refresh token of its
+// family belongs to, as long as the session is the user's
+func (store *Store) RevokeSessionByRefreshToken(arg RevokeSessionByRefreshTokenParams) (session Session, err error) {
+	const revokeSessionByRefreshTokenQuery = `
+		UPDATE sessions
+		SET revoked_at = COALESCE(revoked_at, ?)
+		WHERE username = ? AND id = (
+			SELECT session_id FROM refresh_tokens WHERE token_hash = ?
+		)
+		RETURNING id, username, user_agent, client_ip, client_id, scopes, last_seen_at, expires_at, revoked_at, created_at;
+	`
+
+	row := store.DB.QueryRow(revokeSessionByRefreshTokenQuery, time.Now().UTC(), arg.Username, arg.TokenHash)
+
+	return scanSession(row)
+}
+
+// RevokeUserSessions revokes every session of the user
+func (store *Store) RevokeUserSessions(username string) error {
+	const revokeUserSessionsQuery = `
+		UPDATE sessions
+		SET revoked_at = ?
+		WHERE username = ? AND revoked_at IS NULL;
+	`
+
+	_, err := store.DB.Exec(revokeUserSessionsQuery, time.Now().UTC(), username)
+
+	return err
+}
+
+// GetActiveUserSessions gets the sessions of the user that are neither revoked
+// nor expired, most recently used first
+func (store *Store) GetActiveUserSessions(username string) ([]Session, error) {
+	const getActiveUserSessionsQuery = `
+		SELECT id, username, user_agent, client_ip, client_id, scopes, last_seen_at, expires_at, revoked_at, created_at
+		FROM sessions
+		WHERE username = ? AND revoked_at IS NULL AND expires_at > ?
+		ORDER BY COALESCE(last_seen_at, created_at) DESC;
+	`
+
+	rows, err := store.DB.Query(getActiveUserSessionsQuery, username, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// GetSessionsRevokedSince gets the sessions revoked after the given time
+func (store *Store) GetSessionsRevokedSince(since time.Time) ([]Session, error) {
+	const getSessionsRevokedSinceQuery = `
+		SELECT id, username, user_agent, client_ip, client_id, scopes, last_seen_at, expires_at, revoked_at, created_at
+		FROM sessions
+		WHERE revoked_at > ?;
+	`
+
+	rows, err := store.DB.Query(getSessionsRevokedSinceQuery, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+type TouchSessionParams struct {
+	ID         uuid.UUID `json:"id"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
+
+// TouchSession records that the session has been used
+func (store *Store) TouchSession(arg TouchSessionParams) error {
+	const touchSessionQuery = `
+		UPDATE sessions
+		SET last_seen_at = ?
+		WHERE id = ?;
+	`
+
+	_, err := store.DB.Exec(touchSessionQuery, arg.LastSeenAt.UTC(), arg.ID)
+
+	return err
+}
+
+func scanSession(row rowScanner) (session Session, err error) {
+	var scopes string
+
+	err = row.Scan(
+		&session.ID,
+		&session.Username,
+		&session.UserAgent,
+		&session.ClientIP,
+		&session.ClientID,
+		&scopes,
+		&session.LastSeenAt,
+		&session.ExpiresAt,
+		&session.RevokedAt,
+		&session.CreatedAt,
+	)
+
+	session.Scopes = []string{}
+	if len(scopes) > 0 {
+		session.Scopes = strings.Split(scopes, ",")
+	}
+
+	return
+}
+
+func createSession(tx *sql.Tx, arg CreateSessionParams) (session Session, err error) {
+	const createSessionQuery = `
+		INSERT INTO sessions(id, username, user_agent, client_ip, client_id, scopes, last_seen_at, expires_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id, username, user_agent, client_ip, client_id, scopes, last_seen_at, expires_at, revoked_at, created_at;
+	`
+	const createRefreshTokenQuery = `
+		INSERT INTO refresh_tokens(id, session_id, token_hash, expires_at)
+		VALUES(?, ?, ?, ?);
+	`
+
+	row := tx.QueryRow(
+		createSessionQuery,
+		arg.ID,
+		arg.Username,
+		arg.UserAgent,
+		arg.ClientIP,
+		arg.ClientID,
+		strings.Join(arg.Scopes, ","),
+		time.Now().UTC(),
+		arg.ExpiresAt.UTC(),
+	)
+
+	session, err = scanSession(row)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(createRefreshTokenQuery, arg.RefreshTokenID, session.ID, arg.RefreshTokenHash, arg.ExpiresAt.UTC())
+
+	return
+}
+
+func revokeSession(tx *sql.Tx, id uuid.UUID, now time.Time) error {
+	const revokeSessionQuery = `
+		UPDATE sessions
+		SET revoked_at = COALESCE(revoked_at, ?)
+		WHERE id = ?;
+	`
+
+	_, err := tx.Exec(revokeSessionQuery, now, id)
+
+	return err
+}
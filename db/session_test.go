@@ -0,0 +1,205 @@
+package db
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sbbullet/to-do/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateSession(t *testing.T) {
+	user := createRandomUser(t)
+	session, _ := createRandomSession(t, user.Username)
+
+	require.Equal(t, user.Username, session.Username)
+	require.Nil(t, session.RevokedAt)
+	require.NotZero(t, session.CreatedAt)
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	user := createRandomUser(t)
+	session, tokenHash := createRandomSession(t, user.Username)
+
+	arg := randomRotateRefreshTokenParams(tokenHash)
+	rotated, err := testStore.RotateRefreshToken(arg)
+	require.NoError(t, err)
+	require.Equal(t, session.ID, rotated.ID)
+	require.WithinDuration(t, arg.NewExpiresAt, rotated.ExpiresAt, time.Second)
+
+	// The new refresh token can be rotated in turn
+	_, err = testStore.RotateRefreshToken(randomRotateRefreshTokenParams(arg.NewTokenHash))
+	require.NoError(t, err)
+}
+
+func TestRotateRefreshTokenReused(t *testing.T) {
+	user := createRandomUser(t)
+	session, tokenHash := createRandomSession(t, user.Username)
+
+	arg := randomRotateRefreshTokenParams(tokenHash)
+	_, err := testStore.RotateRefreshToken(arg)
+	require.NoError(t, err)
+
+	_, err = testStore.RotateRefreshToken(randomRotateRefreshTokenParams(tokenHash))
+	require.ErrorIs(t, err, ErrRefreshTokenReused)
+
+	// Reuse revokes the whole family, including the newest refresh token
+	revoked, err := testStore.GetSessionById(session.ID)
+	require.NoError(t, err)
+	require.NotNil(t, revoked.RevokedAt)
+
+	_, err = testStore.RotateRefreshToken(randomRotateRefreshTokenParams(arg.NewTokenHash))
+	require.ErrorIs(t, err, ErrSessionInactive)
+}
+
+func TestRotateRefreshTokenExpired(t *testing.T) {
+	user := createRandomUser(t)
+
+	tokenHash := util.HashSecret(util.RandomString(32))
+	_, err := testStore.CreateSession(CreateSessionParams{
+		ID:               uuid.New(),
+		Username:         user.Username,
+		RefreshTokenID:   uuid.New(),
+		RefreshTokenHash: tokenHash,
+		ExpiresAt:        time.Now().Add(-time.Minute),
+	})
+	require.NoError(t, err)
+
+	_, err = testStore.RotateRefreshToken(randomRotateRefreshTokenParams(tokenHash))
+	require.ErrorIs(t, err, ErrSessionInactive)
+}
+
+func TestRotateRefreshTokenOfAnotherClient(t *testing.T) {
+	user := createRandomUser(t)
+	_, tokenHash := createRandomSession(t, user.Username)
+
+	arg := randomRotateRefreshTokenParams(tokenHash)
+	arg.ClientID = uuid.NewString()
+	_, err := testStore.RotateRefreshToken(arg)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	// The refresh token is still good for the client that owns it
+	_, err = testStore.RotateRefreshToken(randomRotateRefreshTokenParams(tokenHash))
+	require.NoError(t, err)
+}
+
+func createRandomSession(t *testing.T, username string) (Session, string) {
+	tokenHash := util.HashSecret(util.RandomString(32))
+	arg := CreateSessionParams{
+		ID:               uuid.New(),
+		Username:         username,
+		UserAgent:        util.RandomString(20),
+		ClientIP:         "127.0.0.1",
+		Scopes:           []string{"todos:read"},
+		RefreshTokenID:   uuid.New(),
+		RefreshTokenHash: tokenHash,
+		ExpiresAt:        time.Now().Add(time.Hour),
+	}
+
+	session, err := testStore.CreateSession(arg)
+	require.NoError(t, err)
+	require.Equal(t, arg.ID, session.ID)
+	require.Equal(t, arg.UserAgent, session.UserAgent)
+	require.Equal(t, arg.ClientIP, session.ClientIP)
+	require.Equal(t, arg.Scopes, session.Scopes)
+	require.NotNil(t, session.LastSeenAt)
+
+	return session, tokenHash
+}
+
+func randomRotateRefreshTokenParams(tokenHash string) RotateRefreshTokenParams {
+	return RotateRefreshTokenParams{
+		TokenHash:    tokenHash,
+		NewTokenID:   uuid.New(),
+		NewTokenHash: util.HashSecret(util.RandomString(32)),
+		NewExpiresAt: time.Now().Add(2 * time.Hour),
+	}
+}
+
+func TestRevokeSessionByRefreshToken(t *testing.T) {
+	user := createRandomUser(t)
+	session, tokenHash := createRandomSession(t, user.Username)
+
+	// Someone else's refresh token can't be used to revoke the session
+	_, err := testStore.RevokeSessionByRefreshToken(RevokeSessionByRefreshTokenParams{
+		Username:  createRandomUser(t).Username,
+		TokenHash: tokenHash,
+	})
+	require.Error(t, err)
+
+	_, err = testStore.RevokeSessionByRefreshToken(RevokeSessionByRefreshTokenParams{
+		Username:  user.Username,
+		TokenHash: tokenHash,
+	})
+	require.NoError(t, err)
+
+	revoked, err := testStore.GetSessionById(session.ID)
+	require.NoError(t, err)
+	require.NotNil(t, revoked.RevokedAt)
+}
+
+func TestRevokeUserSessions(t *testing.T) {
+	user := createRandomUser(t)
+	first, _ := createRandomSession(t, user.Username)
+	second, _ := createRandomSession(t, user.Username)
+
+	err := testStore.RevokeUserSessions(user.Username)
+	require.NoError(t, err)
+
+	for _, id := range []uuid.UUID{first.ID, second.ID} {
+		session, err := testStore.GetSessionById(id)
+		require.NoError(t, err)
+		require.NotNil(t, session.RevokedAt)
+	}
+}
+
+func TestGetActiveUserSessions(t *testing.T) {
+	user := createRandomUser(t)
+	active, _ := createRandomSession(t, user.Username)
+	revoked, _ := createRandomSession(t, user.Username)
+
+	_, err := testStore.RevokeSessionOfAUser(RevokeSessionOfAUserParams{ID: revoked.ID, Username: user.Username})
+	require.NoError(t, err)
+
+	sessions, err := testStore.GetActiveUserSessions(user.Username)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	require.Equal(t, active.ID, sessions[0].ID)
+
+	revokedSessions, err := testStore.GetSessionsRevokedSince(time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+
+	revokedIDs := []uuid.UUID{}
+	for _, session := range revokedSessions {
+		revokedIDs = append(revokedIDs, session.ID)
+	}
+	require.Contains(t, revokedIDs, revoked.ID)
+	require.NotContains(t, revokedIDs, active.ID)
+}
+
+func TestRevokeSessionOfAUser(t *testing.T) {
+	user := createRandomUser(t)
+	session, _ := createRandomSession(t, user.Username)
+
+	_, err := testStore.RevokeSessionOfAUser(RevokeSessionOfAUserParams{ID: session.ID, Username: createRandomUser(t).Username})
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	revoked, err := testStore.RevokeSessionOfAUser(RevokeSessionOfAUserParams{ID: session.ID, Username: user.Username})
+	require.NoError(t, err)
+	require.NotNil(t, revoked.RevokedAt)
+}
+
+func TestTouchSession(t *testing.T) {
+	user := createRandomUser(t)
+	session, _ := createRandomSession(t, user.Username)
+
+	lastSeenAt := time.Now().Add(time.Minute)
+	err := testStore.TouchSession(TouchSessionParams{ID: session.ID, LastSeenAt: lastSeenAt})
+	require.NoError(t, err)
+
+	touched, err := testStore.GetSessionById(session.ID)
+	require.NoError(t, err)
+	require.WithinDuration(t, lastSeenAt, *touched.LastSeenAt, time.Second)
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"database/sql"
+	"fmt"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -15,3 +16,20 @@ func NewStore(db *sql.DB) *Store {
 		DB: db,
 	}
 }
+
+// execTx executes the function within a database transaction
+func (store *Store) execTx(fn func(*sql.Tx) error) error {
+	tx, err := store.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("tx err: %v, rb err: %v", err, rbErr)
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
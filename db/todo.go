@@ -2,26 +2,62 @@ package db
 
 import (
 	"database/sql"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+// sqliteDateTimeFormat is the format SQLite's datetime('now') writes timestamps in
+const sqliteDateTimeFormat = "2006-01-02 15:04:05"
+
+// Priorities todos can have, from the most to the least urgent
+const (
+	TodoPriorityHigh   = "high"
+	TodoPriorityMedium = "medium"
+	TodoPriorityLow    = "low"
+)
+
 type CreateTodoParams struct {
 	ID       uuid.UUID `json:"id"`
 	Username string    `json:"username"`
 	Title    string    `json:"title"`
+	// Priority is empty for todos without one
+	Priority string     `json:"priority"`
+	DueAt    *time.Time `json:"due_at"`
+	Tags     []string   `json:"tags"`
 }
 
 func (store *Store) CreateTodo(arg CreateTodoParams) (todo Todo, err error) {
 	const createTodoQuery = `
-		INSERT INTO todos(id, username, title)
-		VALUES(?, ?, ?)
-		RETURNING id, username, title, is_completed, created_at;
+		INSERT INTO todos(id, username, title, priority, due_at, tags)
+		VALUES(?, ?, ?, NULLIF(?, ''), ?, ?)
+		RETURNING id, username, title, is_completed, created_at, completed_at, priority, due_at, tags;
 	`
 
-	row := store.DB.QueryRow(createTodoQuery, arg.ID, arg.Username, arg.Title)
+	var dueAt time.Time
+	if arg.DueAt != nil {
+		dueAt = *arg.DueAt
+	}
 
-	err = row.Scan(&todo.ID, &todo.Username, &todo.Title, &todo.IsCompleted, &todo.CreatedAt)
+	err = store.execTx(func(tx *sql.Tx) error {
+		row := tx.QueryRow(createTodoQuery,
+			arg.ID,
+			arg.Username,
+			arg.Title,
+			arg.Priority,
+			nullDateTime(dueAt),
+			strings.Join(arg.Tags, ","),
+		)
+
+		var err error
+		todo, err = scanTodo(row)
+		if err != nil {
+			return err
+		}
+
+		return enqueueWebhookEvent(tx, todo.Username, WebhookEventTodoCreated, todo)
+	})
 
 	return
 }
@@ -32,23 +68,21 @@ type GetUserTodosParams struct {
 	Offset   int
 }
 
-func (store *Store) GetTodoById(id uuid.UUID) (todo Todo, err error) {
+func (store *Store) GetTodoById(id uuid.UUID) (Todo, error) {
 	const getTodoByIdQuery = `
-		SELECT id, username, title, is_completed, created_at
+		SELECT id, username, title, is_completed, created_at, completed_at, priority, due_at, tags
 		FROM todos
 		WHERE id = ?;
 	`
 
 	row := store.DB.QueryRow(getTodoByIdQuery, id)
 
-	err = row.Scan(&todo.ID, &todo.Username, &todo.Title, &todo.IsCompleted, &todo.CreatedAt)
-
-	return
+	return scanTodo(row)
 }
 
 func (store *Store) GetUserTodos(arg GetUserTodosParams) ([]Todo, error) {
 	const getUserTodosQuery = `
-		SELECT id, username, title, is_completed, created_at
+		SELECT id, username, title, is_completed, created_at, completed_at, priority, due_at, tags
 		FROM todos
 		WHERE username = ?
 		ORDER BY created_at
@@ -64,9 +98,7 @@ func (store *Store) GetUserTodos(arg GetUserTodosParams) ([]Todo, error) {
 
 	todos := []Todo{}
 	for rows.Next() {
-		var todo Todo
-
-		err := rows.Scan(&todo.ID, &todo.Username, &todo.Title, &todo.IsCompleted, &todo.CreatedAt)
+		todo, err := scanTodo(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -84,10 +116,15 @@ func (store *Store) GetUserTodos(arg GetUserTodosParams) ([]Todo, error) {
 	return todos, nil
 }
 
+// UpdateTodoParams changes the fields that are valid, or not nil for the tags.
+// A valid but empty priority or due date, like empty tags, is removed.
 type UpdateTodoParams struct {
 	ID          uuid.UUID      `json:"id"`
 	Title       sql.NullString `json:"title"`
 	IsCompleted sql.NullBool   `json:"is_completed"`
+	Priority    sql.NullString `json:"priority"`
+	DueAt       sql.NullTime   `json:"due_at"`
+	Tags        []string       `json:"tags"`
 }
 
 func (store *Store) UpdateTodo(arg UpdateTodoParams) (todo Todo, err error) {
@@ -95,15 +132,44 @@ func (store *Store) UpdateTodo(arg UpdateTodoParams) (todo Todo, err error) {
 		UPDATE todos
 		SET
 			title = COALESCE(?, title),
-			is_completed = COALESCE(?, is_completed)
+			is_completed = COALESCE(?, is_completed),
+			completed_at = CASE
+				WHEN ? IS NULL THEN completed_at
+				WHEN ? THEN COALESCE(completed_at, datetime('now'))
+				ELSE NULL
+			END,
+			priority = CASE WHEN ? THEN NULLIF(?, '') ELSE priority END,
+			due_at = CASE WHEN ? THEN ? ELSE due_at END,
+			tags = COALESCE(?, tags)
 		WHERE
 			id = ?
-		RETURNING id, username, title, is_completed, created_at;
+		RETURNING id, username, title, is_completed, created_at, completed_at, priority, due_at, tags;
 	`
 
-	row := store.DB.QueryRow(updateTodoQuery, arg.Title, arg.IsCompleted, arg.ID)
+	tags := sql.NullString{String: strings.Join(arg.Tags, ","), Valid: arg.Tags != nil}
+
+	err = store.execTx(func(tx *sql.Tx) error {
+		row := tx.QueryRow(updateTodoQuery,
+			arg.Title,
+			arg.IsCompleted,
+			arg.IsCompleted,
+			arg.IsCompleted,
+			arg.Priority.Valid,
+			arg.Priority.String,
+			arg.DueAt.Valid,
+			nullDateTime(arg.DueAt.Time),
+			tags,
+			arg.ID,
+		)
+
+		var err error
+		todo, err = scanTodo(row)
+		if err != nil {
+			return err
+		}
 
-	err = row.Scan(&todo.ID, &todo.Username, &todo.Title, &todo.IsCompleted, &todo.CreatedAt)
+		return enqueueWebhookEvent(tx, todo.Username, WebhookEventTodoUpdated, todo)
+	})
 
 	return
 }
@@ -116,22 +182,174 @@ type DeleteTodoOfAUserParams struct {
 func (store *Store) DeleteTodoOfAUser(arg DeleteTodoOfAUserParams) error {
 	const deleteTodoByIdQuery = `
 		DELETE FROM todos
-		WHERE id = ? AND username = ?;
+		WHERE id = ? AND username = ?
+		RETURNING id, username, title, is_completed, created_at, completed_at, priority, due_at, tags;
 	`
 
-	result, err := store.DB.Exec(deleteTodoByIdQuery, arg.ID, arg.Username)
+	return store.execTx(func(tx *sql.Tx) error {
+		row := tx.QueryRow(deleteTodoByIdQuery, arg.ID, arg.Username)
+
+		// Scan returns sql.ErrNoRows when no todo of the user was deleted
+		todo, err := scanTodo(row)
+		if err != nil {
+			return err
+		}
+
+		return enqueueWebhookEvent(tx, todo.Username, WebhookEventTodoDeleted, todo)
+	})
+}
+
+// TodoIterator walks over todos one row at a time so callers don't need to
+// hold a whole result set in memory
+type TodoIterator struct {
+	rows *sql.Rows
+	todo Todo
+	err  error
+}
+
+// Next advances the iterator, returning false when there are no more todos or
+// an error occurred
+func (it *TodoIterator) Next() bool {
+	if it.err != nil || !it.rows.Next() {
+		return false
+	}
+
+	todo, err := scanTodo(it.rows)
 	if err != nil {
-		return err
+		it.err = err
+		return false
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	it.todo = todo
+	return true
+}
+
+// Todo returns the todo at the current position of the iterator
+func (it *TodoIterator) Todo() Todo {
+	return it.todo
+}
+
+func (it *TodoIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+
+	return it.rows.Err()
+}
+
+func (it *TodoIterator) Close() error {
+	return it.rows.Close()
+}
+
+// IterateUserTodos returns an iterator over all the todos of the user. The
+// iterator must be closed once done with.
+func (store *Store) IterateUserTodos(username string) (*TodoIterator, error) {
+	const iterateUserTodosQuery = `
+		SELECT id, username, title, is_completed, created_at, completed_at, priority, due_at, tags
+		FROM todos
+		WHERE username = ?
+		ORDER BY created_at;
+	`
+
+	rows, err := store.DB.Query(iterateUserTodosQuery, username)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if rowsAffected < 1 {
-		return sql.ErrNoRows
+	return &TodoIterator{rows: rows}, nil
+}
+
+type ImportTodoParams struct {
+	ID          uuid.UUID `json:"id"`
+	Username    string    `json:"username"`
+	Title       string    `json:"title"`
+	IsCompleted bool      `json:"is_completed"`
+	CreatedAt   time.Time `json:"created_at"`
+	CompletedAt time.Time `json:"completed_at"`
+	Priority    string    `json:"priority"`
+	DueAt       time.Time `json:"due_at"`
+	Tags        []string  `json:"tags"`
+}
+
+// ImportTodos creates all the todos in a single transaction, so either every
+// todo is imported or none is. A zero CreatedAt defaults to the current time,
+// as does a zero CompletedAt for completed todos, while an empty priority and
+// a zero DueAt leave the todo without one.
+func (store *Store) ImportTodos(args []ImportTodoParams) ([]Todo, error) {
+	const importTodoQuery = `
+		INSERT INTO todos(id, username, title, is_completed, created_at, completed_at, priority, due_at, tags)
+		VALUES(?, ?, ?, ?, COALESCE(?, datetime('now')), CASE WHEN ? THEN COALESCE(?, datetime('now')) END, NULLIF(?, ''), ?, ?)
+		RETURNING id, username, title, is_completed, created_at, completed_at, priority, due_at, tags;
+	`
+
+	todos := []Todo{}
+	err := store.execTx(func(tx *sql.Tx) error {
+		for _, arg := range args {
+			row := tx.QueryRow(importTodoQuery,
+				arg.ID,
+				arg.Username,
+				arg.Title,
+				arg.IsCompleted,
+				nullDateTime(arg.CreatedAt),
+				arg.IsCompleted,
+				nullDateTime(arg.CompletedAt),
+				arg.Priority,
+				nullDateTime(arg.DueAt),
+				strings.Join(arg.Tags, ","),
+			)
+
+			todo, err := scanTodo(row)
+			if err != nil {
+				return err
+			}
+
+			if err := enqueueWebhookEvent(tx, todo.Username, WebhookEventTodoCreated, todo); err != nil {
+				return err
+			}
+
+			todos = append(todos, todo)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return todos, nil
+}
+
+// nullDateTime stores the time in the same format as the column defaults, or
+// as NULL when it's zero
+func nullDateTime(t time.Time) sql.NullString {
+	return sql.NullString{
+		String: t.UTC().Format(sqliteDateTimeFormat),
+		Valid:  !t.IsZero(),
+	}
+}
+
+func scanTodo(row rowScanner) (todo Todo, err error) {
+	var tags string
+
+	err = row.Scan(
+		&todo.ID,
+		&todo.Username,
+		&todo.Title,
+		&todo.IsCompleted,
+		&todo.CreatedAt,
+		&todo.CompletedAt,
+		&todo.Priority,
+		&todo.DueAt,
+		&tags,
+	)
+	if err != nil {
+		return Todo{}, err
+	}
+
+	todo.Tags = []string{}
+	if len(tags) > 0 {
+		todo.Tags = strings.Split(tags, ",")
 	}
 
-	return nil
+	return
 }
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"database/sql"
 	"testing"
 	"time"
 
@@ -50,6 +51,83 @@ func TestGetTodoById(t *testing.T) {
 	require.Equal(t, todo.CreatedAt, todoFound.CreatedAt)
 }
 
+func TestUpdateTodo(t *testing.T) {
+	user := createRandomUser(t)
+	todo := createRandomTodo(t, user.Username)
+	require.Nil(t, todo.CompletedAt)
+
+	title := util.RandomString(20)
+	updatedTodo, err := testStore.UpdateTodo(UpdateTodoParams{
+		ID:          todo.ID,
+		Title:       sql.NullString{String: title, Valid: true},
+		IsCompleted: sql.NullBool{Bool: true, Valid: true},
+	})
+	require.NoError(t, err)
+	require.Equal(t, title, updatedTodo.Title)
+	require.True(t, updatedTodo.IsCompleted)
+	require.NotNil(t, updatedTodo.CompletedAt)
+	require.WithinDuration(t, time.Now(), *updatedTodo.CompletedAt, 2*time.Second)
+
+	// Leaving the completion untouched keeps the completion time
+	renamedTodo, err := testStore.UpdateTodo(UpdateTodoParams{
+		ID:    todo.ID,
+		Title: sql.NullString{String: util.RandomString(20), Valid: true},
+	})
+	require.NoError(t, err)
+	require.True(t, renamedTodo.IsCompleted)
+	require.Equal(t, updatedTodo.CompletedAt, renamedTodo.CompletedAt)
+
+	reopenedTodo, err := testStore.UpdateTodo(UpdateTodoParams{
+		ID:          todo.ID,
+		IsCompleted: sql.NullBool{Bool: false, Valid: true},
+	})
+	require.NoError(t, err)
+	require.False(t, reopenedTodo.IsCompleted)
+	require.Nil(t, reopenedTodo.CompletedAt)
+}
+
+func TestUpdateTodoDetails(t *testing.T) {
+	user := createRandomUser(t)
+	todo := createRandomTodo(t, user.Username)
+	require.Nil(t, todo.Priority)
+	require.Nil(t, todo.DueAt)
+	require.Empty(t, todo.Tags)
+
+	dueAt := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	updatedTodo, err := testStore.UpdateTodo(UpdateTodoParams{
+		ID:       todo.ID,
+		Priority: sql.NullString{String: TodoPriorityHigh, Valid: true},
+		DueAt:    sql.NullTime{Time: dueAt, Valid: true},
+		Tags:     []string{"work", "urgent"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, TodoPriorityHigh, *updatedTodo.Priority)
+	require.True(t, dueAt.Equal(*updatedTodo.DueAt))
+	require.Equal(t, []string{"work", "urgent"}, updatedTodo.Tags)
+
+	// Fields left out are kept
+	renamedTodo, err := testStore.UpdateTodo(UpdateTodoParams{
+		ID:    todo.ID,
+		Title: sql.NullString{String: util.RandomString(20), Valid: true},
+	})
+	require.NoError(t, err)
+	require.Equal(t, updatedTodo.Priority, renamedTodo.Priority)
+	require.Equal(t, updatedTodo.DueAt, renamedTodo.DueAt)
+	require.Equal(t, updatedTodo.Tags, renamedTodo.Tags)
+
+	// Empty ones are removed
+	clearedTodo, err := testStore.UpdateTodo(UpdateTodoParams{
+		ID:       todo.ID,
+		Priority: sql.NullString{Valid: true},
+		DueAt:    sql.NullTime{Valid: true},
+		Tags:     []string{},
+	})
+	require.NoError(t, err)
+	require.Nil(t, clearedTodo.Priority)
+	require.Nil(t, clearedTodo.DueAt)
+	require.Empty(t, clearedTodo.Tags)
+}
+
 func TestDeleteTodoOfAUser(t *testing.T) {
 	user := createRandomUser(t)
 	todo := createRandomTodo(t, user.Username)
@@ -67,6 +145,73 @@ func TestDeleteTodoOfAUser(t *testing.T) {
 	require.Empty(t, todoFound)
 }
 
+func TestIterateUserTodos(t *testing.T) {
+	user := createRandomUser(t)
+
+	n := 7
+	todos := []Todo{}
+	for i := 1; i <= n; i++ {
+		todos = append(todos, createRandomTodo(t, user.Username))
+	}
+
+	it, err := testStore.IterateUserTodos(user.Username)
+	require.NoError(t, err)
+	defer it.Close()
+
+	iterated := []Todo{}
+	for it.Next() {
+		iterated = append(iterated, it.Todo())
+	}
+
+	require.NoError(t, it.Err())
+	require.ElementsMatch(t, todos, iterated)
+}
+
+func TestImportTodos(t *testing.T) {
+	user := createRandomUser(t)
+	createdAt := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	dueAt := time.Date(2021, 4, 5, 0, 0, 0, 0, time.UTC)
+
+	args := []ImportTodoParams{
+		{ID: uuid.New(), Username: user.Username, Title: util.RandomString(20), IsCompleted: true, CreatedAt: createdAt},
+		{ID: uuid.New(), Username: user.Username, Title: util.RandomString(20), Priority: TodoPriorityLow, DueAt: dueAt, Tags: []string{"home"}},
+	}
+
+	todos, err := testStore.ImportTodos(args)
+	require.NoError(t, err)
+	require.Len(t, todos, 2)
+
+	require.Equal(t, args[0].ID, todos[0].ID)
+	require.True(t, todos[0].IsCompleted)
+	require.True(t, createdAt.Equal(todos[0].CreatedAt))
+	require.NotNil(t, todos[0].CompletedAt)
+
+	require.Equal(t, args[1].Title, todos[1].Title)
+	require.False(t, todos[1].IsCompleted)
+	require.Nil(t, todos[1].CompletedAt)
+	require.WithinDuration(t, time.Now(), todos[1].CreatedAt, 2*time.Second)
+	require.Equal(t, TodoPriorityLow, *todos[1].Priority)
+	require.True(t, dueAt.Equal(*todos[1].DueAt))
+	require.Equal(t, []string{"home"}, todos[1].Tags)
+
+	require.Nil(t, todos[0].Priority)
+	require.Nil(t, todos[0].DueAt)
+	require.Empty(t, todos[0].Tags)
+
+	// A failing todo rolls back the whole import
+	duplicate := []ImportTodoParams{
+		{ID: uuid.New(), Username: user.Username, Title: util.RandomString(20)},
+		{ID: args[0].ID, Username: user.Username, Title: util.RandomString(20)},
+	}
+
+	_, err = testStore.ImportTodos(duplicate)
+	require.Error(t, err)
+
+	_, err = testStore.GetTodoById(duplicate[0].ID)
+	require.Error(t, err)
+}
+
 func createRandomTodo(t *testing.T, username string) Todo {
 	todoID, err := uuid.NewRandom()
 	require.NoError(t, err)
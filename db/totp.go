@@ -0,0 +1,234 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrTOTPAlreadyConfirmed = errors.New("totp is already confirmed")
+
+type CreateUserTOTPParams struct {
+	Username string `json:"username"`
+	Secret   string `json:"secret"`
+}
+
+// CreateUserTOTP starts enrolling the user in TOTP with the secret, replacing
+// any secret they didn't confirm. Users who already have a confirmed one have
+// to remove it first.
+func (store *Store) CreateUserTOTP(arg CreateUserTOTPParams) (UserTOTP, error) {
+	const createUserTOTPQuery = `
+		INSERT INTO user_totps(username, secret, created_at)
+		VALUES(?, ?, ?)
+		ON CONFLICT(username) DO UPDATE SET
+			secret = excluded.secret,
+			last_used_step = 0,
+			created_at = excluded.created_at
+		WHERE confirmed_at IS NULL
+		RETURNING username, secret, last_used_step, confirmed_at, created_at;
+	`
+
+	row := store.DB.QueryRow(createUserTOTPQuery, arg.Username, arg.Secret, time.Now().UTC())
+
+	userTOTP, err := scanUserTOTP(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return UserTOTP{}, ErrTOTPAlreadyConfirmed
+	}
+
+	return userTOTP, err
+}
+
+func (store *Store) GetUserTOTP(username string) (UserTOTP, error) {
+	const getUserTOTPQuery = `
+		SELECT username, secret, last_used_step, confirmed_at, created_at
+		FROM user_totps
+		WHERE username = ?;
+	`
+
+	row := store.DB.QueryRow(getUserTOTPQuery, username)
+
+	return scanUserTOTP(row)
+}
+
+type ConfirmUserTOTPParams struct {
+	Username string `json:"username"`
+	// Step is the time step of the code the user confirmed with
+	Step               int64    `json:"step"`
+	RecoveryCodeHashes []string `json:"recovery_code_hashes"`
+}
+
+// ConfirmUserTOTP turns on TOTP for the user once they have entered their
+// first code, giving them a new set of recovery codes
+func (store *Store) ConfirmUserTOTP(arg ConfirmUserTOTPParams) (userTOTP UserTOTP, err error) {
+	const confirmUserTOTPQuery = `
+		UPDATE user_totps
+		SET confirmed_at = ?, last_used_step = ?
+		WHERE username = ? AND confirmed_at IS NULL
+		RETURNING username, secret, last_used_step, confirmed_at, created_at;
+	`
+
+	err = store.execTx(func(tx *sql.Tx) error {
+		row := tx.QueryRow(confirmUserTOTPQuery, time.Now().UTC(), arg.Step, arg.Username)
+		userTOTP, err = scanUserTOTP(row)
+		if err != nil {
+			return err
+		}
+
+		return replaceRecoveryCodes(tx, arg.Username, arg.RecoveryCodeHashes)
+	})
+
+	return
+}
+
+type UseTOTPStepParams struct {
+	Username string `json:"username"`
+	Step     int64  `json:"step"`
+}
+
+// UseTOTPStep records that the code of the time step has been used, failing
+// with sql.ErrNoRows if it or a later one already was
+func (store *Store) UseTOTPStep(arg UseTOTPStepParams) error {
+	const useTOTPStepQuery = `
+		UPDATE user_totps
+		SET last_used_step = ?
+		WHERE username = ? AND confirmed_at IS NOT NULL AND last_used_step < ?;
+	`
+
+	result, err := store.DB.Exec(useTOTPStepQuery, arg.Step, arg.Username, arg.Step)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// DeleteUserTOTP turns off TOTP for the user, deleting their recovery codes
+func (store *Store) DeleteUserTOTP(username string) error {
+	const deleteUserTOTPQuery = `
+		DELETE FROM user_totps
+		WHERE username = ?;
+	`
+	const deleteRecoveryCodesQuery = `
+		DELETE FROM recovery_codes
+		WHERE username = ?;
+	`
+
+	return store.execTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec(deleteUserTOTPQuery, username)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected == 0 {
+			return sql.ErrNoRows
+		}
+
+		_, err = tx.Exec(deleteRecoveryCodesQuery, username)
+		return err
+	})
+}
+
+type ReplaceRecoveryCodesParams struct {
+	Username   string   `json:"username"`
+	CodeHashes []string `json:"code_hashes"`
+}
+
+// ReplaceRecoveryCodes replaces the recovery codes of the user with new ones
+func (store *Store) ReplaceRecoveryCodes(arg ReplaceRecoveryCodesParams) error {
+	return store.execTx(func(tx *sql.Tx) error {
+		return replaceRecoveryCodes(tx, arg.Username, arg.CodeHashes)
+	})
+}
+
+type UseRecoveryCodeParams struct {
+	Username string `json:"username"`
+	CodeHash string `json:"code_hash"`
+}
+
+// UseRecoveryCode uses up a recovery code of the user, failing with
+// sql.ErrNoRows if they have no such code left
+func (store *Store) UseRecoveryCode(arg UseRecoveryCodeParams) error {
+	const useRecoveryCodeQuery = `
+		UPDATE recovery_codes
+		SET used_at = ?
+		WHERE code_hash = ? AND username = ? AND used_at IS NULL;
+	`
+
+	result, err := store.DB.Exec(useRecoveryCodeQuery, time.Now().UTC(), arg.CodeHash, arg.Username)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// CountUnusedRecoveryCodes counts the recovery codes the user has left
+func (store *Store) CountUnusedRecoveryCodes(username string) (count int, err error) {
+	const countUnusedRecoveryCodesQuery = `
+		SELECT COUNT(*)
+		FROM recovery_codes
+		WHERE username = ? AND used_at IS NULL;
+	`
+
+	err = store.DB.QueryRow(countUnusedRecoveryCodesQuery, username).Scan(&count)
+
+	return
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, username string, codeHashes []string) error {
+	const deleteRecoveryCodesQuery = `
+		DELETE FROM recovery_codes
+		WHERE username = ?;
+	`
+	const createRecoveryCodeQuery = `
+		INSERT INTO recovery_codes(code_hash, username)
+		VALUES(?, ?);
+	`
+
+	if _, err := tx.Exec(deleteRecoveryCodesQuery, username); err != nil {
+		return err
+	}
+
+	for _, codeHash := range codeHashes {
+		if _, err := tx.Exec(createRecoveryCodeQuery, codeHash, username); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func scanUserTOTP(row rowScanner) (userTOTP UserTOTP, err error) {
+	err = row.Scan(
+		&userTOTP.Username,
+		&userTOTP.Secret,
+		&userTOTP.LastUsedStep,
+		&userTOTP.ConfirmedAt,
+		&userTOTP.CreatedAt,
+	)
+
+	return
+}
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/sbbullet/to-do/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateUserTOTP(t *testing.T) {
+	user := createRandomUser(t)
+
+	first := createRandomUserTOTP(t, user.Username)
+
+	// Unconfirmed secrets get replaced
+	second := createRandomUserTOTP(t, user.Username)
+	require.NotEqual(t, first.Secret, second.Secret)
+
+	_, err := testStore.ConfirmUserTOTP(ConfirmUserTOTPParams{Username: user.Username, Step: 1})
+	require.NoError(t, err)
+
+	_, err = testStore.CreateUserTOTP(CreateUserTOTPParams{Username: user.Username, Secret: util.RandomString(32)})
+	require.ErrorIs(t, err, ErrTOTPAlreadyConfirmed)
+
+	userTOTP, err := testStore.GetUserTOTP(user.Username)
+	require.NoError(t, err)
+	require.Equal(t, second.Secret, userTOTP.Secret)
+}
+
+func TestConfirmUserTOTP(t *testing.T) {
+	user := createRandomUser(t)
+	createRandomUserTOTP(t, user.Username)
+
+	codeHashes := []string{util.HashSecret(util.RandomString(20)), util.HashSecret(util.RandomString(20))}
+	userTOTP, err := testStore.ConfirmUserTOTP(ConfirmUserTOTPParams{
+		Username:           user.Username,
+		Step:               100,
+		RecoveryCodeHashes: codeHashes,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, userTOTP.ConfirmedAt)
+	require.Equal(t, int64(100), userTOTP.LastUsedStep)
+
+	count, err := testStore.CountUnusedRecoveryCodes(user.Username)
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+
+	_, err = testStore.ConfirmUserTOTP(ConfirmUserTOTPParams{Username: user.Username, Step: 101})
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestUseTOTPStep(t *testing.T) {
+	user := createRandomUser(t)
+	createRandomUserTOTP(t, user.Username)
+
+	// Codes can't be used before TOTP is confirmed
+	err := testStore.UseTOTPStep(UseTOTPStepParams{Username: user.Username, Step: 100})
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	_, err = testStore.ConfirmUserTOTP(ConfirmUserTOTPParams{Username: user.Username, Step: 100})
+	require.NoError(t, err)
+
+	err = testStore.UseTOTPStep(UseTOTPStepParams{Username: user.Username, Step: 100})
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	err = testStore.UseTOTPStep(UseTOTPStepParams{Username: user.Username, Step: 101})
+	require.NoError(t, err)
+
+	err = testStore.UseTOTPStep(UseTOTPStepParams{Username: user.Username, Step: 101})
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestUseRecoveryCode(t *testing.T) {
+	user := createRandomUser(t)
+	other := createRandomUser(t)
+	codeHash := util.HashSecret(util.RandomString(20))
+
+	err := testStore.ReplaceRecoveryCodes(ReplaceRecoveryCodesParams{Username: user.Username, CodeHashes: []string{codeHash}})
+	require.NoError(t, err)
+
+	err = testStore.UseRecoveryCode(UseRecoveryCodeParams{Username: other.Username, CodeHash: codeHash})
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	err = testStore.UseRecoveryCode(UseRecoveryCodeParams{Username: user.Username, CodeHash: codeHash})
+	require.NoError(t, err)
+
+	err = testStore.UseRecoveryCode(UseRecoveryCodeParams{Username: user.Username, CodeHash: codeHash})
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	count, err := testStore.CountUnusedRecoveryCodes(user.Username)
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
+
+func TestDeleteUserTOTP(t *testing.T) {
+	user := createRandomUser(t)
+	createRandomUserTOTP(t, user.Username)
+
+	_, err := testStore.ConfirmUserTOTP(ConfirmUserTOTPParams{
+		Username:           user.Username,
+		RecoveryCodeHashes: []string{util.HashSecret(util.RandomString(20))},
+	})
+	require.NoError(t, err)
+
+	err = testStore.DeleteUserTOTP(user.Username)
+	require.NoError(t, err)
+
+	_, err = testStore.GetUserTOTP(user.Username)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	count, err := testStore.CountUnusedRecoveryCodes(user.Username)
+	require.NoError(t, err)
+	require.Zero(t, count)
+
+	err = testStore.DeleteUserTOTP(user.Username)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func createRandomUserTOTP(t *testing.T, username string) UserTOTP {
+	arg := CreateUserTOTPParams{
+		Username: username,
+		Secret:   util.RandomString(32),
+	}
+
+	userTOTP, err := testStore.CreateUserTOTP(arg)
+	require.NoError(t, err)
+	require.Equal(t, arg.Secret, userTOTP.Secret)
+	require.Nil(t, userTOTP.ConfirmedAt)
+
+	return userTOTP
+}
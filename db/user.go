@@ -1,46 +1,330 @@
 package db
 
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sbbullet/to-do/util"
+)
+
 type CreateUserParams struct {
 	Username       string `json:"username"`
 	Email          string `json:"email"`
 	FullName       string `json:"full_name"`
 	HashedPassword string `json:"hashed_password"`
+	// EmailVerifiedAt is set when the email is known to be theirs already,
+	// like when an external provider vouches for it
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
 }
 
-func (store *Store) CreateUser(arg CreateUserParams) (user User, err error) {
+// CreateUser creates the user with their username and email in canonical form
+func (store *Store) CreateUser(arg CreateUserParams) (User, error) {
 	const createUserQuery = `
-		INSERT INTO users(username, email, full_name, hashed_password)
-		VALUES(?, ?, ?, ?)
-		RETURNING username, email, full_name, hashed_password, created_at;
+		INSERT INTO users(username, email, full_name, hashed_password, email_verified_at)
+		VALUES(?, ?, ?, ?, ?)
+		RETURNING username, email, full_name, hashed_password, created_at, email_verified_at, role, disabled_at, pending_email;
 	`
 
+	var emailVerifiedAt *time.Time
+	if arg.EmailVerifiedAt != nil {
+		utc := arg.EmailVerifiedAt.UTC()
+		emailVerifiedAt = &utc
+	}
+
 	row := store.DB.QueryRow(createUserQuery,
-		arg.Username,
-		arg.Email,
+		util.NormalizeUsername(arg.Username),
+		util.NormalizeEmail(arg.Email),
 		arg.FullName,
 		arg.HashedPassword,
+		emailVerifiedAt,
 	)
 
+	return scanUser(row)
+}
+
+func (store *Store) GetUser(username string) (User, error) {
+	const getUserQuery = `
+		SELECT username, email, full_name, hashed_password, created_at, email_verified_at, role, disabled_at, pending_email
+		FROM users
+		WHERE username = ?;
+	`
+
+	row := store.DB.QueryRow(getUserQuery, username)
+
+	return scanUser(row)
+}
+
+// GetUserByEmail gets the user with the email, or with its canonical form if
+// none has the email as it's written. The two only differ for users who
+// registered before emails were normalized and collide with another user.
+func (store *Store) GetUserByEmail(email string) (User, error) {
+	const getUserByEmailQuery = `
+		SELECT username, email, full_name, hashed_password, created_at, email_verified_at, role, disabled_at, pending_email
+		FROM users
+		WHERE email = ? OR email = ?
+		ORDER BY email = ? DESC
+		LIMIT 1;
+	`
+
+	row := store.DB.QueryRow(getUserByEmailQuery, email, util.NormalizeEmail(email), email)
+
+	return scanUser(row)
+}
+
+// GetUserByVerifiedEmail gets the user with the email like GetUserByEmail,
+// but only if they have verified it, as anyone can claim an email they
+// don't own
+func (store *Store) GetUserByVerifiedEmail(email string) (User, error) {
+	user, err := store.GetUserByEmail(email)
+	if err != nil {
+		return User{}, err
+	}
+
+	if user.EmailVerifiedAt == nil {
+		return User{}, sql.ErrNoRows
+	}
+
+	return user, nil
+}
+
+// GetUserByLogin gets the user with the username or email a user logs in
+// with, matching them like GetUserByEmail does
+func (store *Store) GetUserByLogin(login string) (User, error) {
+	const getUserByUsernameQuery = `
+		SELECT username, email, full_name, hashed_password, created_at, email_verified_at, role, disabled_at, pending_email
+		FROM users
+		WHERE username = ? OR username = ?
+		ORDER BY username = ? DESC
+		LIMIT 1;
+	`
+
+	// Usernames can't have an @
+	if strings.Contains(login, "@") {
+		return store.GetUserByEmail(login)
+	}
+
+	row := store.DB.QueryRow(getUserByUsernameQuery, login, util.NormalizeUsername(login), login)
+
+	return scanUser(row)
+}
+
+func scanUser(row rowScanner) (user User, err error) {
 	err = row.Scan(
 		&user.Username,
 		&user.Email,
 		&user.FullName,
 		&user.HashedPassword,
 		&user.CreatedAt,
+		&user.EmailVerifiedAt,
+		&user.Role,
+		&user.DisabledAt,
+		&user.PendingEmail,
 	)
 
 	return
 }
 
-func (store *Store) GetUser(username string) (user User, err error) {
-	const getUserQuery = `
-		SELECT * FROM users
+type UpdateUserParams struct {
+	Username string         `json:"username"`
+	FullName sql.NullString `json:"full_name"`
+}
+
+// UpdateUser updates the profile of the user, leaving the fields that aren't set as they are
+func (store *Store) UpdateUser(arg UpdateUserParams) (User, error) {
+	const updateUserQuery = `
+		UPDATE users
+		SET full_name = COALESCE(?, full_name)
+		WHERE username = ?
+		RETURNING username, email, full_name, hashed_password, created_at, email_verified_at, role, disabled_at, pending_email;
+	`
+
+	row := store.DB.QueryRow(updateUserQuery, arg.FullName, arg.Username)
+
+	return scanUser(row)
+}
+
+type ChangePasswordParams struct {
+	Username       string `json:"username"`
+	HashedPassword string `json:"hashed_password"`
+	// SessionID is the session changing the password, which stays logged in
+	SessionID uuid.UUID `json:"session_id"`
+}
+
+// ChangePassword changes the password of the user, revoking every other session
+// they have along with the password reset links they were sent. It gives the
+// sessions it revoked.
+func (store *Store) ChangePassword(arg ChangePasswordParams) (sessions []Session, err error) {
+	const changePasswordQuery = `
+		UPDATE users
+		SET hashed_password = ?
+		WHERE username = ?;
+	`
+	const revokeOtherSessionsQuery = `
+		UPDATE sessions
+		SET revoked_at = ?
+		WHERE username = ? AND id != ? AND revoked_at IS NULL
+		RETURNING id, username, user_agent, client_ip, client_id, scopes, last_seen_at, expires_at, revoked_at, created_at;
+	`
+	const deletePasswordResetTokensQuery = `
+		DELETE FROM password_reset_tokens
 		WHERE username = ?;
-		`
+	`
 
-	row := store.DB.QueryRow(getUserQuery, username)
+	err = store.execTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec(changePasswordQuery, arg.HashedPassword, arg.Username)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected == 0 {
+			return sql.ErrNoRows
+		}
 
-	err = row.Scan(&user.Username, &user.Email, &user.FullName, &user.HashedPassword, &user.CreatedAt)
+		rows, err := tx.Query(revokeOtherSessionsQuery, time.Now().UTC(), arg.Username, arg.SessionID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		sessions = []Session{}
+		for rows.Next() {
+			session, err := scanSession(rows)
+			if err != nil {
+				return err
+			}
+			sessions = append(sessions, session)
+		}
+
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(deletePasswordResetTokensQuery, arg.Username)
+		return err
+	})
 
 	return
 }
+
+type UpdatePasswordHashParams struct {
+	Username          string `json:"username"`
+	OldHashedPassword string `json:"old_hashed_password"`
+	HashedPassword    string `json:"hashed_password"`
+}
+
+// UpdatePasswordHash replaces the hash of the password of the user with a new
+// hash of the same password, as long as the password hasn't been changed since
+// the old hash was read
+func (store *Store) UpdatePasswordHash(arg UpdatePasswordHashParams) error {
+	const updatePasswordHashQuery = `
+		UPDATE users
+		SET hashed_password = ?
+		WHERE username = ? AND hashed_password = ?;
+	`
+
+	result, err := store.DB.Exec(updatePasswordHashQuery, arg.HashedPassword, arg.Username, arg.OldHashedPassword)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+type ChangeEmailParams struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+// ChangeEmail starts changing the email of the user to the canonical form of
+// the new one, which stays pending until they verify it. Users keep their
+// current email, and can reset their password with it, until then.
+func (store *Store) ChangeEmail(arg ChangeEmailParams) (user User, err error) {
+	const emailTakenQuery = `
+		SELECT COUNT(*)
+		FROM users
+		WHERE email = ?;
+	`
+	const changeEmailQuery = `
+		UPDATE users
+		SET pending_email = ?
+		WHERE username = ?
+		RETURNING username, email, full_name, hashed_password, created_at, email_verified_at, role, disabled_at, pending_email;
+	`
+
+	email := util.NormalizeEmail(arg.Email)
+
+	err = store.execTx(func(tx *sql.Tx) error {
+		var taken int
+		if err := tx.QueryRow(emailTakenQuery, email).Scan(&taken); err != nil {
+			return err
+		}
+
+		if taken > 0 {
+			return ErrEmailTaken
+		}
+
+		user, err = scanUser(tx.QueryRow(changeEmailQuery, email, arg.Username))
+		return err
+	})
+
+	return
+}
+
+type DeleteUserParams struct {
+	Username string `json:"username"`
+	// TokensExpireAt is when the last of the access tokens issued to the user
+	// expires, until which they stay revoked
+	TokensExpireAt time.Time `json:"tokens_expire_at"`
+}
+
+// DeleteUser deletes the user along with everything they own. The tokens
+// issued to them stay revoked, even once somebody else takes the username,
+// as the revocation isn't deleted with the user.
+func (store *Store) DeleteUser(arg DeleteUserParams) error {
+	const deleteUserQuery = `
+		DELETE FROM users
+		WHERE username = ?;
+	`
+	const revokeDeletedUserTokensQuery = `
+		INSERT INTO deleted_user_revocations(username, issued_before, expires_at)
+		VALUES(?, ?, ?)
+		ON CONFLICT(username) DO UPDATE SET
+			issued_before = excluded.issued_before,
+			expires_at = MAX(expires_at, excluded.expires_at);
+	`
+
+	return store.execTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec(deleteUserQuery, arg.Username)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected == 0 {
+			return sql.ErrNoRows
+		}
+
+		_, err = tx.Exec(revokeDeletedUserTokensQuery, arg.Username, time.Now().UTC(), arg.TokensExpireAt.UTC())
+		return err
+	})
+}
@@ -1,6 +1,8 @@
 package db
 
 import (
+	"database/sql"
+	"strings"
 	"testing"
 	"time"
 
@@ -27,6 +29,225 @@ func TestGetUser(t *testing.T) {
 	require.WithinDuration(t, resultingUser.CreatedAt, createdUser.CreatedAt, time.Second)
 }
 
+func TestGetUserByEmail(t *testing.T) {
+	createdUser := createRandomUser(t)
+
+	resultingUser, err := testStore.GetUserByEmail(createdUser.Email)
+
+	require.NoError(t, err)
+	require.Equal(t, resultingUser.Username, createdUser.Username)
+}
+
+func TestCreateUserNormalizes(t *testing.T) {
+	hashedPassword, err := util.HashPassword(util.RandomString(8))
+	require.NoError(t, err)
+
+	username := util.RandomUsername()
+	email := util.RandomEmail()
+
+	user, err := testStore.CreateUser(CreateUserParams{
+		Username:       strings.ToUpper(username),
+		Email:          " " + strings.ToUpper(email),
+		FullName:       util.RandomString(4) + " " + util.RandomString(4),
+		HashedPassword: hashedPassword,
+	})
+	require.NoError(t, err)
+	require.Equal(t, username, user.Username)
+	require.Equal(t, email, user.Email)
+
+	// The canonical forms are taken whichever way they're written
+	_, err = testStore.CreateUser(CreateUserParams{
+		Username:       username,
+		Email:          util.RandomEmail(),
+		FullName:       util.RandomString(4) + " " + util.RandomString(4),
+		HashedPassword: hashedPassword,
+	})
+	require.ErrorContains(t, err, "UNIQUE constraint failed: users.username")
+}
+
+func TestGetUserByLogin(t *testing.T) {
+	createdUser := createRandomUser(t)
+
+	for _, login := range []string{
+		createdUser.Username,
+		strings.ToUpper(createdUser.Username),
+		createdUser.Email,
+		strings.ToUpper(createdUser.Email),
+	} {
+		resultingUser, err := testStore.GetUserByLogin(login)
+		require.NoError(t, err)
+		require.Equal(t, createdUser.Username, resultingUser.Username)
+	}
+
+	_, err := testStore.GetUserByLogin(util.RandomUsername())
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestUpdateUser(t *testing.T) {
+	user := createRandomUser(t)
+	fullName := util.RandomString(4) + " " + util.RandomString(4)
+
+	updated, err := testStore.UpdateUser(UpdateUserParams{
+		Username: user.Username,
+		FullName: sql.NullString{String: fullName, Valid: true},
+	})
+	require.NoError(t, err)
+	require.Equal(t, fullName, updated.FullName)
+	require.Equal(t, user.Email, updated.Email)
+
+	unchanged, err := testStore.UpdateUser(UpdateUserParams{Username: user.Username})
+	require.NoError(t, err)
+	require.Equal(t, fullName, unchanged.FullName)
+}
+
+func TestChangePassword(t *testing.T) {
+	user := createRandomUser(t)
+	current, _ := createRandomSession(t, user.Username)
+	other, _ := createRandomSession(t, user.Username)
+	resetToken := createRandomPasswordResetToken(t, user.Username, time.Now().Add(time.Hour))
+
+	hashedPassword, err := util.HashPassword(util.RandomString(8))
+	require.NoError(t, err)
+
+	revoked, err := testStore.ChangePassword(ChangePasswordParams{
+		Username:       user.Username,
+		HashedPassword: hashedPassword,
+		SessionID:      current.ID,
+	})
+	require.NoError(t, err)
+	require.Len(t, revoked, 1)
+	require.Equal(t, other.ID, revoked[0].ID)
+
+	session, err := testStore.GetSessionById(current.ID)
+	require.NoError(t, err)
+	require.Nil(t, session.RevokedAt)
+
+	updated, err := testStore.GetUser(user.Username)
+	require.NoError(t, err)
+	require.Equal(t, hashedPassword, updated.HashedPassword)
+
+	_, err = testStore.ResetPassword(ResetPasswordParams{TokenHash: resetToken.TokenHash, HashedPassword: "hash"})
+	require.ErrorIs(t, err, ErrPasswordResetTokenInvalid)
+}
+
+func TestUpdatePasswordHash(t *testing.T) {
+	user := createRandomUser(t)
+
+	err := testStore.UpdatePasswordHash(UpdatePasswordHashParams{
+		Username:          user.Username,
+		OldHashedPassword: user.HashedPassword,
+		HashedPassword:    "new hash",
+	})
+	require.NoError(t, err)
+
+	updated, err := testStore.GetUser(user.Username)
+	require.NoError(t, err)
+	require.Equal(t, "new hash", updated.HashedPassword)
+
+	// The password was changed since the old hash was read
+	err = testStore.UpdatePasswordHash(UpdatePasswordHashParams{
+		Username:          user.Username,
+		OldHashedPassword: user.HashedPassword,
+		HashedPassword:    "newer hash",
+	})
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestChangeEmail(t *testing.T) {
+	user := createRandomUser(t)
+	verificationToken := createRandomEmailVerificationToken(t, user, time.Now().Add(time.Hour))
+	_, err := testStore.VerifyEmail(verificationToken.TokenHash)
+	require.NoError(t, err)
+
+	// The new email is pending until it's verified
+	email := util.RandomEmail()
+	updated, err := testStore.ChangeEmail(ChangeEmailParams{Username: user.Username, Email: email})
+	require.NoError(t, err)
+	require.Equal(t, user.Email, updated.Email)
+	require.NotNil(t, updated.EmailVerifiedAt)
+	require.NotNil(t, updated.PendingEmail)
+	require.Equal(t, email, *updated.PendingEmail)
+
+	verificationToken, err = testStore.CreateEmailVerificationToken(CreateEmailVerificationTokenParams{
+		TokenHash: util.HashSecret(util.RandomString(32)),
+		Username:  user.Username,
+		Email:     email,
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+
+	verified, err := testStore.VerifyEmail(verificationToken.TokenHash)
+	require.NoError(t, err)
+	require.Equal(t, email, verified.Email)
+	require.Nil(t, verified.PendingEmail)
+	require.NotNil(t, verified.EmailVerifiedAt)
+
+	other := createRandomUser(t)
+	_, err = testStore.ChangeEmail(ChangeEmailParams{Username: other.Username, Email: email})
+	require.ErrorIs(t, err, ErrEmailTaken)
+}
+
+func TestChangeEmailTakenBeforeVerified(t *testing.T) {
+	user := createRandomUser(t)
+	email := util.RandomEmail()
+	_, err := testStore.ChangeEmail(ChangeEmailParams{Username: user.Username, Email: email})
+	require.NoError(t, err)
+
+	verificationToken, err := testStore.CreateEmailVerificationToken(CreateEmailVerificationTokenParams{
+		TokenHash: util.HashSecret(util.RandomString(32)),
+		Username:  user.Username,
+		Email:     email,
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+
+	// Pending emails don't keep others from taking them
+	_, err = testStore.DB.Exec("UPDATE users SET email = ? WHERE username = ?;", email, createRandomUser(t).Username)
+	require.NoError(t, err)
+
+	_, err = testStore.VerifyEmail(verificationToken.TokenHash)
+	require.ErrorIs(t, err, ErrEmailTaken)
+
+	unchanged, err := testStore.GetUser(user.Username)
+	require.NoError(t, err)
+	require.Equal(t, user.Email, unchanged.Email)
+}
+
+func TestDeleteUser(t *testing.T) {
+	user := createRandomUser(t)
+	todo := createRandomTodo(t, user.Username)
+	session, _ := createRandomSession(t, user.Username)
+
+	err := testStore.DeleteUser(DeleteUserParams{Username: user.Username, TokensExpireAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+
+	_, err = testStore.GetUser(user.Username)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	// The tokens of the user stay revoked after they are gone
+	_, revocations, err := testStore.GetTokenRevocations()
+	require.NoError(t, err)
+
+	var revoked bool
+	for _, revocation := range revocations {
+		if revocation.Username == user.Username {
+			revoked = true
+			require.WithinDuration(t, time.Now(), revocation.IssuedBefore, time.Second)
+		}
+	}
+	require.True(t, revoked)
+
+	// Foreign keys delete everything the user owned along with them
+	_, err = testStore.GetTodoById(todo.ID)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	_, err = testStore.GetSessionById(session.ID)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	err = testStore.DeleteUser(DeleteUserParams{Username: user.Username, TokensExpireAt: time.Now().Add(time.Hour)})
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
+
 // Create a random user in the test database
 func createRandomUser(t *testing.T) User {
 	hashedPassword, err := util.HashPassword(util.RandomString(8))
@@ -48,6 +269,9 @@ func createRandomUser(t *testing.T) User {
 	require.Equal(t, arg.FullName, user.FullName)
 	require.Equal(t, arg.HashedPassword, user.HashedPassword)
 	require.WithinDuration(t, time.Now(), user.CreatedAt, time.Second)
+	require.Nil(t, user.EmailVerifiedAt)
+	require.Equal(t, UserRoleUser, user.Role)
+	require.Nil(t, user.DisabledAt)
 
 	return user
 }
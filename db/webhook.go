@@ -0,0 +1,449 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Events a webhook can subscribe to
+const (
+	WebhookEventAll         = "*"
+	WebhookEventTodoCreated = "todo.created"
+	WebhookEventTodoUpdated = "todo.updated"
+	WebhookEventTodoDeleted = "todo.deleted"
+)
+
+// Statuses of a delivery in the webhook outbox
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// WebhookEventPayload is the body posted to the webhook receivers
+type WebhookEventPayload struct {
+	Event      string      `json:"event"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	Data       interface{} `json:"data"`
+}
+
+type CreateWebhookParams struct {
+	ID       uuid.UUID `json:"id"`
+	Username string    `json:"username"`
+	URL      string    `json:"url"`
+	Secret   string    `json:"secret"`
+	Events   []string  `json:"events"`
+}
+
+func (store *Store) CreateWebhook(arg CreateWebhookParams) (webhook Webhook, err error) {
+	const createWebhookQuery = `
+		INSERT INTO webhooks(id, username, url, secret, events)
+		VALUES(?, ?, ?, ?, ?)
+		RETURNING id, username, url, secret, events, created_at;
+	`
+
+	row := store.DB.QueryRow(createWebhookQuery,
+		arg.ID,
+		arg.Username,
+		arg.URL,
+		arg.Secret,
+		strings.Join(arg.Events, ","),
+	)
+
+	return scanWebhook(row)
+}
+
+func (store *Store) GetWebhookById(id uuid.UUID) (Webhook, error) {
+	const getWebhookByIdQuery = `
+		SELECT id, username, url, secret, events, created_at
+		FROM webhooks
+		WHERE id = ?;
+	`
+
+	row := store.DB.QueryRow(getWebhookByIdQuery, id)
+
+	return scanWebhook(row)
+}
+
+func (store *Store) GetUserWebhooks(username string) ([]Webhook, error) {
+	const getUserWebhooksQuery = `
+		SELECT id, username, url, secret, events, created_at
+		FROM webhooks
+		WHERE username = ?
+		ORDER BY created_at;
+	`
+
+	rows, err := store.DB.Query(getUserWebhooksQuery, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+type DeleteWebhookOfAUserParams struct {
+	ID       uuid.UUID `json:"id"`
+	Username string    `json:"username"`
+}
+
+// DeleteWebhookOfAUser removes the webhook along with its outbox and delivery logs
+func (store *Store) DeleteWebhookOfAUser(arg DeleteWebhookOfAUserParams) error {
+	const deleteWebhookQuery = `
+		DELETE FROM webhooks
+		WHERE id = ? AND username = ?;
+	`
+	const deleteDeliveryLogsQuery = `
+		DELETE FROM webhook_delivery_logs
+		WHERE delivery_id IN (SELECT id FROM webhook_outbox WHERE webhook_id = ?);
+	`
+	const deleteDeliveriesQuery = `
+		DELETE FROM webhook_outbox
+		WHERE webhook_id = ?;
+	`
+
+	return store.execTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec(deleteWebhookQuery, arg.ID, arg.Username)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected < 1 {
+			return sql.ErrNoRows
+		}
+
+		if _, err := tx.Exec(deleteDeliveryLogsQuery, arg.ID); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(deleteDeliveriesQuery, arg.ID)
+		return err
+	})
+}
+
+// enqueueWebhookEvent writes a delivery to the outbox for every webhook of the
+// user subscribed to the event, as part of the transaction of the mutation
+func enqueueWebhookEvent(tx *sql.Tx, username string, event string, data interface{}) error {
+	const getWebhooksQuery = `
+		SELECT id, events
+		FROM webhooks
+		WHERE username = ?;
+	`
+	const enqueueDeliveryQuery = `
+		INSERT INTO webhook_outbox(id, webhook_id, event, payload, next_attempt_at)
+		VALUES(?, ?, ?, ?, ?);
+	`
+
+	rows, err := tx.Query(getWebhooksQuery, username)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	webhookIDs := []uuid.UUID{}
+	for rows.Next() {
+		var webhookID uuid.UUID
+		var events string
+
+		if err := rows.Scan(&webhookID, &events); err != nil {
+			return err
+		}
+
+		if webhookSubscribedTo(strings.Split(events, ","), event) {
+			webhookIDs = append(webhookIDs, webhookID)
+		}
+	}
+
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(webhookIDs) == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	payload, err := json.Marshal(WebhookEventPayload{
+		Event:      event,
+		OccurredAt: now,
+		Data:       data,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, webhookID := range webhookIDs {
+		deliveryID, err := uuid.NewRandom()
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(enqueueDeliveryQuery, deliveryID, webhookID, event, string(payload), now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func webhookSubscribedTo(events []string, event string) bool {
+	for _, e := range events {
+		if e == WebhookEventAll || e == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DueWebhookDelivery is a pending delivery along with where and how to send it
+type DueWebhookDelivery struct {
+	WebhookDelivery
+	URL    string
+	Secret string
+}
+
+type GetDueWebhookDeliveriesParams struct {
+	Now   time.Time
+	Limit int
+}
+
+func (store *Store) GetDueWebhookDeliveries(arg GetDueWebhookDeliveriesParams) ([]DueWebhookDelivery, error) {
+	const getDueWebhookDeliveriesQuery = `
+		SELECT o.id, o.webhook_id, o.event, o.payload, o.status, o.attempts, o.next_attempt_at, o.created_at,
+			w.url, w.secret
+		FROM webhook_outbox o
+		INNER JOIN webhooks w ON w.id = o.webhook_id
+		WHERE o.status = ? AND o.next_attempt_at <= ?
+		ORDER BY o.next_attempt_at
+		LIMIT ?;
+	`
+
+	rows, err := store.DB.Query(getDueWebhookDeliveriesQuery, WebhookDeliveryPending, arg.Now.UTC(), arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []DueWebhookDelivery{}
+	for rows.Next() {
+		var delivery DueWebhookDelivery
+
+		err := rows.Scan(
+			&delivery.ID,
+			&delivery.WebhookID,
+			&delivery.Event,
+			&delivery.Payload,
+			&delivery.Status,
+			&delivery.Attempts,
+			&delivery.NextAttemptAt,
+			&delivery.CreatedAt,
+			&delivery.URL,
+			&delivery.Secret,
+		)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+type RecordWebhookDeliveryAttemptParams struct {
+	DeliveryID    uuid.UUID
+	StatusCode    int
+	Error         string
+	Duration      time.Duration
+	Status        string
+	NextAttemptAt time.Time
+}
+
+// RecordWebhookDeliveryAttempt logs an attempt and moves the delivery to its next state
+func (store *Store) RecordWebhookDeliveryAttempt(arg RecordWebhookDeliveryAttemptParams) error {
+	const createDeliveryLogQuery = `
+		INSERT INTO webhook_delivery_logs(delivery_id, status_code, error, duration_ms, attempted_at)
+		VALUES(?, ?, ?, ?, ?);
+	`
+	const updateDeliveryQuery = `
+		UPDATE webhook_outbox
+		SET
+			status = ?,
+			attempts = attempts + 1,
+			next_attempt_at = ?
+		WHERE id = ?;
+	`
+
+	return store.execTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(createDeliveryLogQuery,
+			arg.DeliveryID,
+			arg.StatusCode,
+			arg.Error,
+			arg.Duration.Milliseconds(),
+			time.Now().UTC(),
+		)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(updateDeliveryQuery, arg.Status, arg.NextAttemptAt.UTC(), arg.DeliveryID)
+		return err
+	})
+}
+
+type GetWebhookDeliveriesParams struct {
+	WebhookID uuid.UUID
+	Limit     int
+	Offset    int
+}
+
+func (store *Store) GetWebhookDeliveries(arg GetWebhookDeliveriesParams) ([]WebhookDelivery, error) {
+	const getWebhookDeliveriesQuery = `
+		SELECT id, webhook_id, event, payload, status, attempts, next_attempt_at, created_at
+		FROM webhook_outbox
+		WHERE webhook_id = ?
+		ORDER BY created_at DESC
+		LIMIT ?
+		OFFSET ?;
+	`
+
+	rows, err := store.DB.Query(getWebhookDeliveriesQuery, arg.WebhookID, arg.Limit, arg.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func (store *Store) GetWebhookDeliveryById(id uuid.UUID) (WebhookDelivery, error) {
+	const getWebhookDeliveryByIdQuery = `
+		SELECT id, webhook_id, event, payload, status, attempts, next_attempt_at, created_at
+		FROM webhook_outbox
+		WHERE id = ?;
+	`
+
+	row := store.DB.QueryRow(getWebhookDeliveryByIdQuery, id)
+
+	return scanWebhookDelivery(row)
+}
+
+func (store *Store) GetWebhookDeliveryLogs(deliveryID uuid.UUID) ([]WebhookDeliveryLog, error) {
+	const getWebhookDeliveryLogsQuery = `
+		SELECT id, delivery_id, status_code, error, duration_ms, attempted_at
+		FROM webhook_delivery_logs
+		WHERE delivery_id = ?
+		ORDER BY id;
+	`
+
+	rows, err := store.DB.Query(getWebhookDeliveryLogsQuery, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	logs := []WebhookDeliveryLog{}
+	for rows.Next() {
+		var log WebhookDeliveryLog
+
+		err := rows.Scan(&log.ID, &log.DeliveryID, &log.StatusCode, &log.Error, &log.DurationMs, &log.AttemptedAt)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, log)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return logs, nil
+}
+
+// RedeliverWebhookDelivery queues the delivery to be sent again right away
+func (store *Store) RedeliverWebhookDelivery(id uuid.UUID) (WebhookDelivery, error) {
+	const redeliverWebhookDeliveryQuery = `
+		UPDATE webhook_outbox
+		SET
+			status = ?,
+			attempts = 0,
+			next_attempt_at = ?
+		WHERE id = ?
+		RETURNING id, webhook_id, event, payload, status, attempts, next_attempt_at, created_at;
+	`
+
+	row := store.DB.QueryRow(redeliverWebhookDeliveryQuery, WebhookDeliveryPending, time.Now().UTC(), id)
+
+	return scanWebhookDelivery(row)
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanWebhook(row rowScanner) (webhook Webhook, err error) {
+	var events string
+
+	err = row.Scan(&webhook.ID, &webhook.Username, &webhook.URL, &webhook.Secret, &events, &webhook.CreatedAt)
+	webhook.Events = strings.Split(events, ",")
+
+	return
+}
+
+func scanWebhookDelivery(row rowScanner) (delivery WebhookDelivery, err error) {
+	err = row.Scan(
+		&delivery.ID,
+		&delivery.WebhookID,
+		&delivery.Event,
+		&delivery.Payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.NextAttemptAt,
+		&delivery.CreatedAt,
+	)
+
+	return
+}
@@ -0,0 +1,141 @@
+package db
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateWebhook(t *testing.T) {
+	user := createRandomUser(t)
+	createRandomWebhook(t, user.Username, []string{WebhookEventAll})
+}
+
+func TestGetUserWebhooks(t *testing.T) {
+	user := createRandomUser(t)
+	webhook := createRandomWebhook(t, user.Username, []string{WebhookEventAll})
+
+	webhooks, err := testStore.GetUserWebhooks(user.Username)
+	require.NoError(t, err)
+	require.Len(t, webhooks, 1)
+	require.Equal(t, webhook, webhooks[0])
+}
+
+func TestTodoMutationsEnqueueWebhookDeliveries(t *testing.T) {
+	user := createRandomUser(t)
+	allEvents := createRandomWebhook(t, user.Username, []string{WebhookEventAll})
+	createdOnly := createRandomWebhook(t, user.Username, []string{WebhookEventTodoCreated})
+
+	todo := createRandomTodo(t, user.Username)
+
+	err := testStore.DeleteTodoOfAUser(DeleteTodoOfAUserParams{ID: todo.ID, Username: user.Username})
+	require.NoError(t, err)
+
+	deliveries, err := testStore.GetWebhookDeliveries(GetWebhookDeliveriesParams{WebhookID: allEvents.ID, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+
+	events := []string{deliveries[0].Event, deliveries[1].Event}
+	require.ElementsMatch(t, []string{WebhookEventTodoCreated, WebhookEventTodoDeleted}, events)
+
+	deliveries, err = testStore.GetWebhookDeliveries(GetWebhookDeliveriesParams{WebhookID: createdOnly.ID, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+
+	delivery := deliveries[0]
+	require.Equal(t, WebhookEventTodoCreated, delivery.Event)
+	require.Equal(t, WebhookDeliveryPending, delivery.Status)
+	require.Zero(t, delivery.Attempts)
+
+	var payload struct {
+		Event string `json:"event"`
+		Data  Todo   `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(delivery.Payload), &payload))
+	require.Equal(t, WebhookEventTodoCreated, payload.Event)
+	require.Equal(t, todo.ID, payload.Data.ID)
+}
+
+func TestRecordWebhookDeliveryAttempt(t *testing.T) {
+	user := createRandomUser(t)
+	webhook := createRandomWebhook(t, user.Username, []string{WebhookEventAll})
+	createRandomTodo(t, user.Username)
+
+	deliveries, err := testStore.GetWebhookDeliveries(GetWebhookDeliveriesParams{WebhookID: webhook.ID, Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+
+	nextAttemptAt := time.Now().Add(time.Hour)
+	err = testStore.RecordWebhookDeliveryAttempt(RecordWebhookDeliveryAttemptParams{
+		DeliveryID:    deliveries[0].ID,
+		StatusCode:    500,
+		Error:         "receiver responded with status 500",
+		Duration:      20 * time.Millisecond,
+		Status:        WebhookDeliveryPending,
+		NextAttemptAt: nextAttemptAt,
+	})
+	require.NoError(t, err)
+
+	delivery, err := testStore.GetWebhookDeliveryById(deliveries[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, 1, delivery.Attempts)
+	require.WithinDuration(t, nextAttemptAt, delivery.NextAttemptAt, time.Second)
+
+	due, err := testStore.GetDueWebhookDeliveries(GetDueWebhookDeliveriesParams{Now: time.Now(), Limit: 1000})
+	require.NoError(t, err)
+	for _, d := range due {
+		require.NotEqual(t, delivery.ID, d.ID)
+	}
+
+	logs, err := testStore.GetWebhookDeliveryLogs(delivery.ID)
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	require.Equal(t, 500, logs[0].StatusCode)
+	require.Equal(t, int64(20), logs[0].DurationMs)
+
+	delivery, err = testStore.RedeliverWebhookDelivery(delivery.ID)
+	require.NoError(t, err)
+	require.Equal(t, WebhookDeliveryPending, delivery.Status)
+	require.Zero(t, delivery.Attempts)
+}
+
+func TestDeleteWebhookOfAUser(t *testing.T) {
+	user := createRandomUser(t)
+	webhook := createRandomWebhook(t, user.Username, []string{WebhookEventAll})
+
+	err := testStore.DeleteWebhookOfAUser(DeleteWebhookOfAUserParams{ID: webhook.ID, Username: "someone-else"})
+	require.Error(t, err)
+
+	err = testStore.DeleteWebhookOfAUser(DeleteWebhookOfAUserParams{ID: webhook.ID, Username: user.Username})
+	require.NoError(t, err)
+
+	_, err = testStore.GetWebhookById(webhook.ID)
+	require.Error(t, err)
+}
+
+func createRandomWebhook(t *testing.T, username string, events []string) Webhook {
+	webhookID, err := uuid.NewRandom()
+	require.NoError(t, err)
+
+	arg := CreateWebhookParams{
+		ID:       webhookID,
+		Username: username,
+		URL:      "https://example.com/hooks/todo",
+		Secret:   "a-very-secret-value",
+		Events:   events,
+	}
+
+	webhook, err := testStore.CreateWebhook(arg)
+	require.NoError(t, err)
+	require.Equal(t, arg.ID, webhook.ID)
+	require.Equal(t, arg.Username, webhook.Username)
+	require.Equal(t, arg.URL, webhook.URL)
+	require.Equal(t, arg.Secret, webhook.Secret)
+	require.Equal(t, arg.Events, webhook.Events)
+	require.WithinDuration(t, time.Now(), webhook.CreatedAt, 2*time.Second)
+
+	return webhook
+}
@@ -0,0 +1,174 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sbbullet/to-do/logger"
+	"github.com/sbbullet/to-do/util"
+	"go.uber.org/zap"
+)
+
+var ErrInvalidHeader = errors.New("mail header contains a line break")
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails to users
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// NewMailer creates the mailer selected by the MAILER setting. Unless SMTP is
+// set up, emails are only written to MAIL_DIR or the log, which is enough to
+// follow their links when developing locally.
+func NewMailer(config *util.Config) (Mailer, error) {
+	switch config.Mailer {
+	case "smtp":
+		return NewSMTPMailer(config.SMTPHost, config.SMTPPort, config.SMTPUsername, config.SMTPPassword, config.MailFrom), nil
+	case "file":
+		return NewFileMailer(config.MailDir, config.MailFrom)
+	case "log":
+		logger.Warn("Emails are written to the log, along with the password reset and email verification links in them. Only use MAILER=log for development")
+		return NewFileMailer("", config.MailFrom)
+	}
+
+	return nil, fmt.Errorf("unsupported mailer %q", config.Mailer)
+}
+
+// SMTPMailer sends emails through an SMTP server, upgrading the connection
+// with STARTTLS when the server supports it
+type SMTPMailer struct {
+	address string
+	auth    smtp.Auth
+	from    string
+}
+
+func NewSMTPMailer(host string, port string, username string, password string, from string) *SMTPMailer {
+	var auth smtp.Auth
+	if len(username) > 0 {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &SMTPMailer{
+		address: net.JoinHostPort(host, port),
+		auth:    auth,
+		from:    from,
+	}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, message Message) error {
+	data, err := formatMessage(m.from, message, time.Now())
+	if err != nil {
+		return err
+	}
+
+	// net/smtp can't be cancelled, so the context is only checked up front
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return smtp.SendMail(m.address, m.auth, addressOf(m.from), []string{message.To}, data)
+}
+
+// FileMailer writes every email to a file of its directory, or to the log
+// when it has none
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+func NewFileMailer(dir string, from string) (*FileMailer, error) {
+	if len(dir) > 0 {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+	}
+
+	return &FileMailer{dir: dir, from: from}, nil
+}
+
+func (m *FileMailer) Send(ctx context.Context, message Message) error {
+	now := time.Now()
+
+	data, err := formatMessage(m.from, message, now)
+	if err != nil {
+		return err
+	}
+
+	if len(m.dir) == 0 {
+		logger.Info("Mail", zap.String("to", message.To), zap.String("subject", message.Subject), zap.String("body", message.Body))
+		return nil
+	}
+
+	// Names sort in the order the emails were sent
+	name := fmt.Sprintf("%s-%s.eml", now.UTC().Format("20060102T150405.000000000"), randomHex(4))
+
+	return os.WriteFile(filepath.Join(m.dir, name), data, 0600)
+}
+
+// formatMessage renders the message as RFC 5322 text
+func formatMessage(from string, message Message, date time.Time) ([]byte, error) {
+	for _, header := range []string{from, message.To, message.Subject} {
+		if strings.ContainsAny(header, "\r\n") {
+			return nil, ErrInvalidHeader
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", message.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", randomHex(16), domainOf(from))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	buf.WriteString("\r\n")
+
+	// SMTP wants every line to end with CRLF
+	body := strings.ReplaceAll(message.Body, "\r\n", "\n")
+	buf.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	return buf.Bytes(), nil
+}
+
+// addressOf gets the bare address of a sender like "Todo <todo@example.com>"
+func addressOf(from string) string {
+	if start := strings.LastIndex(from, "<"); start >= 0 {
+		return strings.TrimSuffix(from[start+1:], ">")
+	}
+
+	return from
+}
+
+func domainOf(from string) string {
+	address := addressOf(from)
+	if at := strings.LastIndex(address, "@"); at >= 0 {
+		return address[at+1:]
+	}
+
+	return "localhost"
+}
+
+func randomHex(n int) string {
+	bytes := make([]byte, n)
+	rand.Read(bytes)
+
+	return hex.EncodeToString(bytes)
+}
@@ -0,0 +1,65 @@
+package mail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+
+	mailer, err := NewFileMailer(dir, "Todo <todo@example.com>")
+	require.NoError(t, err)
+
+	err = mailer.Send(context.Background(), Message{
+		To:      "jane@example.com",
+		Subject: "Reset your password",
+		Body:    "Hi Jane,\nfollow the link.",
+	})
+	require.NoError(t, err)
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	data, err := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	require.NoError(t, err)
+	require.Contains(t, string(data), "To: jane@example.com\r\n")
+	require.Contains(t, string(data), "Subject: Reset your password\r\n")
+	require.True(t, strings.HasSuffix(string(data), "\r\n\r\nHi Jane,\r\nfollow the link."))
+}
+
+func TestFormatMessageRejectsHeaderInjection(t *testing.T) {
+	_, err := formatMessage("todo@example.com", Message{
+		To:      "jane@example.com\r\nBcc: everyone@example.com",
+		Subject: "Hello",
+	}, time.Now())
+	require.ErrorIs(t, err, ErrInvalidHeader)
+
+	_, err = formatMessage("todo@example.com", Message{
+		To:      "jane@example.com",
+		Subject: "Hello\nBcc: everyone@example.com",
+	}, time.Now())
+	require.ErrorIs(t, err, ErrInvalidHeader)
+}
+
+func TestFormatMessageEncodesSubject(t *testing.T) {
+	data, err := formatMessage("Todo <todo@example.com>", Message{
+		To:      "jane@example.com",
+		Subject: "Réinitialisez votre mot de passe",
+	}, time.Now())
+	require.NoError(t, err)
+	require.Contains(t, string(data), "Subject: =?utf-8?q?")
+	require.Contains(t, string(data), "@example.com>\r\n")
+}
+
+func TestAddressOf(t *testing.T) {
+	require.Equal(t, "todo@example.com", addressOf("Todo <todo@example.com>"))
+	require.Equal(t, "todo@example.com", addressOf("todo@example.com"))
+}
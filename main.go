@@ -1,8 +1,16 @@
 package main
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
 	"github.com/sbbullet/to-do/api"
+	"github.com/sbbullet/to-do/db"
 	"github.com/sbbullet/to-do/logger"
+	"github.com/sbbullet/to-do/util"
 )
 
 func main() {
@@ -10,9 +18,92 @@ func main() {
 	// Initialize logger
 	logger.InitializeLogger()
 
+	// Lift the login lockout of a user instead of running the server
+	if len(os.Args) > 1 && os.Args[1] == "unlock" {
+		if len(os.Args) != 3 {
+			fmt.Fprintln(os.Stderr, "usage: unlock <username>")
+			os.Exit(2)
+		}
+
+		unlock(os.Args[2])
+		return
+	}
+
+	// Change the role of a user instead of running the server
+	if len(os.Args) > 1 && os.Args[1] == "set-role" {
+		if len(os.Args) != 4 || (os.Args[3] != db.UserRoleUser && os.Args[3] != db.UserRoleAdmin) {
+			fmt.Fprintln(os.Stderr, "usage: set-role <username> <user|admin>")
+			os.Exit(2)
+		}
+
+		setRole(os.Args[2], os.Args[3])
+		return
+	}
+
 	// Create a new server
 	server := api.NewServer()
 
 	// Run the server
 	server.Run()
 }
+
+// setRole changes the role of the user, revoking their tokens so that they
+// have to log in again to get it
+func setRole(username string, role string) {
+	wd, err := os.Getwd()
+	if err != nil {
+		panic(err)
+	}
+
+	config := util.LoadConfig("app", "env", wd)
+	store := db.NewStore(db.NewDB(config))
+
+	if _, err := store.SetUserRole(db.SetUserRoleParams{Username: username, Role: role}); err != nil {
+		logger.Panic(err.Error())
+	}
+
+	if err := store.RevokeUserSessions(username); err != nil {
+		logger.Panic(err.Error())
+	}
+
+	// The running server picks the revocation up when it next reloads them
+	now := time.Now()
+	_, err = store.RevokeUserTokens(db.RevokeUserTokensParams{
+		Username:     username,
+		IssuedBefore: now,
+		ExpiresAt:    now.Add(config.AccessTokenDuration),
+	})
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+
+	logger.Info(fmt.Sprintf("Changed the role of %s to %s", username, role))
+}
+
+// unlock forgets the failed logins of the username, so that it can be logged
+// in with again right away. They are counted against the user whichever way
+// the username is written, or against its canonical form if there's no user.
+func unlock(username string) {
+	wd, err := os.Getwd()
+	if err != nil {
+		panic(err)
+	}
+
+	config := util.LoadConfig("app", "env", wd)
+	store := db.NewStore(db.NewDB(config))
+
+	identifier := util.NormalizeUsername(username)
+	user, err := store.GetUserByLogin(username)
+	if err == nil {
+		identifier = user.Username
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		logger.Panic(err.Error())
+	}
+
+	err = store.ClearLoginFailures(db.ClearLoginFailuresParams{Kind: db.LoginFailureKindUsername, Identifier: identifier})
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+
+	logger.Info(fmt.Sprintf("Unlocked logging in as %s", identifier))
+}
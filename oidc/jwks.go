@@ -0,0 +1,204 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// keySetRefreshInterval limits how often the key set gets fetched again for
+// tokens signed with a key that isn't known yet
+const keySetRefreshInterval = time.Minute
+
+var ErrKeyNotFound = errors.New("no key of the provider matches the id token")
+
+// jwkSet is a JSON Web Key Set of RFC 7517
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+type jwk struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	Alg     string `json:"alg"`
+	Curve   string `json:"crv"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+type publicKey struct {
+	id  string
+	alg string
+	key interface{}
+}
+
+// keySet holds the signing keys published by a provider
+type keySet struct {
+	keys      []publicKey
+	fetchedAt time.Time
+}
+
+// newKeySet parses the keys of the set that can verify signatures, skipping
+// the ones of unsupported types
+func newKeySet(set jwkSet, fetchedAt time.Time) (*keySet, error) {
+	keys := &keySet{fetchedAt: fetchedAt}
+
+	for _, k := range set.Keys {
+		if len(k.Use) > 0 && k.Use != "sig" {
+			continue
+		}
+
+		key, err := parseJWK(k)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.KeyID, err)
+		}
+
+		if key != nil {
+			keys.keys = append(keys.keys, publicKey{id: k.KeyID, alg: k.Alg, key: key})
+		}
+	}
+
+	return keys, nil
+}
+
+// find gets the key with the ID that can verify signatures of the algorithm.
+// Tokens without a key ID can only be verified when a single key fits.
+func (ks *keySet) find(keyID string, alg string) (interface{}, error) {
+	var found []interface{}
+	for _, k := range ks.keys {
+		if len(keyID) > 0 && k.id != keyID {
+			continue
+		}
+
+		if len(k.alg) > 0 && k.alg != alg {
+			continue
+		}
+
+		if !keyFitsAlgorithm(k.key, alg) {
+			continue
+		}
+
+		found = append(found, k.key)
+	}
+
+	if len(found) != 1 {
+		return nil, ErrKeyNotFound
+	}
+
+	return found[0], nil
+}
+
+func (ks *keySet) stale(now time.Time) bool {
+	return now.Sub(ks.fetchedAt) >= keySetRefreshInterval
+}
+
+func keyFitsAlgorithm(key interface{}, alg string) bool {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return strings.HasPrefix(alg, "RS")
+	case *ecdsa.PublicKey:
+		switch alg {
+		case "ES256":
+			return k.Curve == elliptic.P256()
+		case "ES384":
+			return k.Curve == elliptic.P384()
+		case "ES512":
+			return k.Curve == elliptic.P521()
+		}
+	case ed25519.PublicKey:
+		return alg == "EdDSA"
+	}
+
+	return false
+}
+
+func parseJWK(k jwk) (interface{}, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		if !e.IsInt64() {
+			return nil, errors.New("invalid RSA exponent")
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, nil
+		}
+
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, nil
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key size")
+		}
+
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, nil
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		return nil, errors.New("missing key parameter")
+	}
+
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,389 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	ErrInvalidIDToken = errors.New("id token is invalid")
+	ErrNonceMismatch  = errors.New("id token nonce does not match")
+)
+
+// supportedSigningMethods are the algorithms ID tokens may be signed with,
+// which leaves out none and the HMAC ones keyed with the client secret
+var supportedSigningMethods = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// ProviderConfig describes an OpenID Connect provider users can log in with
+type ProviderConfig struct {
+	Name         string   `json:"name"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	RedirectURL  string   `json:"redirect_url"`
+	Scopes       []string `json:"scopes"`
+	// AllowSignup creates accounts for users logging in for the first time
+	AllowSignup bool `json:"allow_signup"`
+	// LinkByEmail links the identity to the account with the same email when
+	// both the provider and the account have verified it, which is only safe
+	// for trusted providers
+	LinkByEmail bool `json:"link_by_email"`
+}
+
+type providersFile struct {
+	Providers []ProviderConfig `json:"providers"`
+}
+
+// LoadProvidersFile loads the providers from a JSON file like
+//
+//	{"providers": [
+//	  {"name": "company", "issuer": "https://sso.example.com", "client_id": "...",
+//	   "client_secret": "...", "redirect_url": "https://todo.example.com/login/company",
+//	   "allow_signup": true}
+//	]}
+func LoadProvidersFile(path string) ([]ProviderConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file providersFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid providers file: %w", err)
+	}
+
+	names := map[string]bool{}
+	for _, config := range file.Providers {
+		if len(config.Name) == 0 || len(config.Issuer) == 0 || len(config.ClientID) == 0 || len(config.RedirectURL) == 0 {
+			return nil, fmt.Errorf("provider %q: name, issuer, client_id and redirect_url are required", config.Name)
+		}
+
+		if names[config.Name] {
+			return nil, fmt.Errorf("provider %q is configured twice", config.Name)
+		}
+		names[config.Name] = true
+	}
+
+	return file.Providers, nil
+}
+
+// Claims are the claims of a verified ID token the app cares about
+type Claims struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+}
+
+type idTokenClaims struct {
+	Email             string       `json:"email"`
+	EmailVerified     flexibleBool `json:"email_verified"`
+	Name              string       `json:"name"`
+	PreferredUsername string       `json:"preferred_username"`
+	Nonce             string       `json:"nonce"`
+	AuthorizedParty   string       `json:"azp"`
+	jwt.RegisteredClaims
+}
+
+// flexibleBool also accepts booleans sent as strings, as some providers do
+type flexibleBool bool
+
+func (b *flexibleBool) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case bool:
+		*b = flexibleBool(v)
+	case string:
+		*b = flexibleBool(v == "true")
+	default:
+		*b = false
+	}
+
+	return nil
+}
+
+// discoveryDocument is the part of the provider metadata the app uses
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider logs users in with an OpenID Connect provider through the
+// authorization code flow. The provider metadata is discovered on first use.
+type Provider struct {
+	config ProviderConfig
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *discoveryDocument
+	keys      *keySet
+}
+
+func NewProvider(config ProviderConfig, client *http.Client) *Provider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &Provider{
+		config: config,
+		client: client,
+	}
+}
+
+func (p *Provider) Config() ProviderConfig {
+	return p.config
+}
+
+// AuthCodeURL gives the URL of the provider to send the user to, asking for
+// an authorization code bound to the state, nonce and PKCE code verifier
+func (p *Provider) AuthCodeURL(ctx context.Context, state string, nonce string, codeVerifier string) (string, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	scopes := append([]string{"openid"}, p.config.Scopes...)
+	if len(p.config.Scopes) == 0 {
+		scopes = append(scopes, "email", "profile")
+	}
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {CodeChallenge(codeVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return discovery.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange redeems the authorization code at the provider, giving the claims
+// of the ID token it's exchanged for once they are verified
+func (p *Provider) Exchange(ctx context.Context, code string, codeVerifier string, nonce string) (*Claims, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var token tokenResponse
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&token); err != nil {
+		return nil, fmt.Errorf("invalid token response: %w", err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token request failed with %d: %s %s", res.StatusCode, token.Error, token.ErrorDescription)
+	}
+
+	if len(token.IDToken) == 0 {
+		return nil, fmt.Errorf("%w: token response has no id token", ErrInvalidIDToken)
+	}
+
+	return p.VerifyIDToken(ctx, token.IDToken, nonce)
+}
+
+// VerifyIDToken checks the signature of the ID token against the keys the
+// provider publishes, along with its issuer, audience, expiry and nonce
+func (p *Provider) VerifyIDToken(ctx context.Context, rawIDToken string, nonce string) (*Claims, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		keyID, _ := token.Header["kid"].(string)
+		return p.verificationKey(ctx, keyID, token.Method.Alg())
+	}
+
+	claims := &idTokenClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods(supportedSigningMethods))
+	if _, err := parser.ParseWithClaims(rawIDToken, claims, keyFunc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	if !claims.VerifyIssuer(discovery.Issuer, true) {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidIDToken, claims.Issuer)
+	}
+
+	if !claims.VerifyAudience(p.config.ClientID, true) {
+		return nil, fmt.Errorf("%w: not issued to the client", ErrInvalidIDToken)
+	}
+
+	// Tokens meant for several audiences have to be issued to the client itself
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.config.ClientID {
+		return nil, fmt.Errorf("%w: authorized party is not the client", ErrInvalidIDToken)
+	}
+
+	if claims.ExpiresAt == nil || claims.IssuedAt == nil || len(claims.Subject) == 0 {
+		return nil, fmt.Errorf("%w: exp, iat and sub are required", ErrInvalidIDToken)
+	}
+
+	if len(nonce) == 0 || claims.Nonce != nonce {
+		return nil, ErrNonceMismatch
+	}
+
+	return &Claims{
+		Subject:           claims.Subject,
+		Email:             claims.Email,
+		EmailVerified:     bool(claims.EmailVerified),
+		Name:              claims.Name,
+		PreferredUsername: claims.PreferredUsername,
+	}, nil
+}
+
+// verificationKey finds the key the ID token was signed with, fetching the
+// key set again when the provider may have rotated its keys
+func (p *Provider) verificationKey(ctx context.Context, keyID string, alg string) (interface{}, error) {
+	p.mu.Lock()
+	keys := p.keys
+	p.mu.Unlock()
+
+	if keys != nil {
+		if key, err := keys.find(keyID, alg); err == nil || !keys.stale(time.Now()) {
+			return key, err
+		}
+	}
+
+	keys, err := p.fetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return keys.find(keyID, alg)
+}
+
+func (p *Provider) fetchKeys(ctx context.Context) (*keySet, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var document jwkSet
+	if err := p.getJSON(ctx, discovery.JWKSURI, &document); err != nil {
+		return nil, fmt.Errorf("fetching keys: %w", err)
+	}
+
+	keys, err := newKeySet(document, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	return keys, nil
+}
+
+// discover fetches the provider metadata, which must be for the configured issuer
+func (p *Provider) discover(ctx context.Context) (*discoveryDocument, error) {
+	p.mu.Lock()
+	discovery := p.discovery
+	p.mu.Unlock()
+
+	if discovery != nil {
+		return discovery, nil
+	}
+
+	discoveryURL := strings.TrimSuffix(p.config.Issuer, "/") + "/.well-known/openid-configuration"
+
+	var document discoveryDocument
+	if err := p.getJSON(ctx, discoveryURL, &document); err != nil {
+		return nil, fmt.Errorf("discovering provider %q: %w", p.config.Name, err)
+	}
+
+	if document.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("provider %q: discovered issuer %q does not match", p.config.Name, document.Issuer)
+	}
+
+	if len(document.AuthorizationEndpoint) == 0 || len(document.TokenEndpoint) == 0 || len(document.JWKSURI) == 0 {
+		return nil, fmt.Errorf("provider %q: metadata is missing endpoints", p.config.Name)
+	}
+
+	p.mu.Lock()
+	p.discovery = &document
+	p.mu.Unlock()
+
+	return &document, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded with %d", url, res.StatusCode)
+	}
+
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(v)
+}
+
+// CodeChallenge derives the S256 PKCE code challenge of the code verifier
+func CodeChallenge(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,206 @@
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/sbbullet/to-do/oidc/oidctest"
+	"github.com/sbbullet/to-do/util"
+	"github.com/stretchr/testify/require"
+)
+
+const testRedirectURL = "http://localhost:8000/login/mock"
+
+func TestProviderExchange(t *testing.T) {
+	server, provider := newTestProvider(t)
+	user := oidctest.User{
+		Subject:           util.RandomString(12),
+		Email:             util.RandomEmail(),
+		EmailVerified:     true,
+		Name:              "Jane Doe",
+		PreferredUsername: "jane",
+	}
+	server.SetUser(user)
+
+	code, state := authorize(t, provider, "state", "nonce", "verifier-"+util.RandomString(43))
+	require.Equal(t, "state", state)
+
+	_, err := provider.Exchange(context.Background(), code, "wrong-verifier-"+util.RandomString(43), "nonce")
+	require.Error(t, err)
+
+	codeVerifier := "verifier-" + util.RandomString(43)
+	code, _ = authorize(t, provider, "state", "nonce", codeVerifier)
+
+	claims, err := provider.Exchange(context.Background(), code, codeVerifier, "nonce")
+	require.NoError(t, err)
+	require.Equal(t, user.Subject, claims.Subject)
+	require.Equal(t, user.Email, claims.Email)
+	require.True(t, claims.EmailVerified)
+	require.Equal(t, user.Name, claims.Name)
+	require.Equal(t, user.PreferredUsername, claims.PreferredUsername)
+}
+
+func TestProviderExchangeNonceMismatch(t *testing.T) {
+	server, provider := newTestProvider(t)
+	server.SetUser(oidctest.User{Subject: util.RandomString(12)})
+
+	codeVerifier := "verifier-" + util.RandomString(43)
+	code, _ := authorize(t, provider, "state", "nonce", codeVerifier)
+
+	_, err := provider.Exchange(context.Background(), code, codeVerifier, "another-nonce")
+	require.ErrorIs(t, err, ErrNonceMismatch)
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	server, provider := newTestProvider(t)
+	now := time.Now()
+
+	validClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":   server.URL,
+			"aud":   server.ClientID,
+			"sub":   "subject",
+			"nonce": "nonce",
+			"iat":   now.Unix(),
+			"exp":   now.Add(time.Minute).Unix(),
+		}
+	}
+
+	claims, err := provider.VerifyIDToken(context.Background(), server.SignIDToken(validClaims()), "nonce")
+	require.NoError(t, err)
+	require.Equal(t, "subject", claims.Subject)
+
+	testCases := []struct {
+		name   string
+		modify func(claims jwt.MapClaims)
+	}{
+		{"another issuer", func(claims jwt.MapClaims) { claims["iss"] = "https://evil.example.com" }},
+		{"another audience", func(claims jwt.MapClaims) { claims["aud"] = "another-client" }},
+		{"several audiences", func(claims jwt.MapClaims) { claims["aud"] = []string{server.ClientID, "another-client"} }},
+		{"expired", func(claims jwt.MapClaims) { claims["exp"] = now.Add(-time.Minute).Unix() }},
+		{"no subject", func(claims jwt.MapClaims) { delete(claims, "sub") }},
+		{"another nonce", func(claims jwt.MapClaims) { claims["nonce"] = "another-nonce" }},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			claims := validClaims()
+			tc.modify(claims)
+
+			_, err := provider.VerifyIDToken(context.Background(), server.SignIDToken(claims), "nonce")
+			require.Error(t, err)
+		})
+	}
+
+	t.Run("signed with the client secret", func(t *testing.T) {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims()).SignedString([]byte(server.ClientSecret))
+		require.NoError(t, err)
+
+		_, err = provider.VerifyIDToken(context.Background(), token, "nonce")
+		require.ErrorIs(t, err, ErrInvalidIDToken)
+	})
+
+	t.Run("unsigned", func(t *testing.T) {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+		require.NoError(t, err)
+
+		_, err = provider.VerifyIDToken(context.Background(), token, "nonce")
+		require.ErrorIs(t, err, ErrInvalidIDToken)
+	})
+}
+
+func TestVerifyIDTokenKeyRotation(t *testing.T) {
+	server, provider := newTestProvider(t)
+	claims := jwt.MapClaims{
+		"iss":   server.URL,
+		"aud":   server.ClientID,
+		"sub":   "subject",
+		"nonce": "nonce",
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	}
+
+	_, err := provider.VerifyIDToken(context.Background(), server.SignIDToken(claims), "nonce")
+	require.NoError(t, err)
+
+	server.RotateKey()
+	token := server.SignIDToken(claims)
+
+	// The keys were fetched too recently to be fetched again
+	_, err = provider.VerifyIDToken(context.Background(), token, "nonce")
+	require.ErrorIs(t, err, ErrInvalidIDToken)
+
+	provider.keys.fetchedAt = time.Now().Add(-keySetRefreshInterval)
+	_, err = provider.VerifyIDToken(context.Background(), token, "nonce")
+	require.NoError(t, err)
+}
+
+func TestLoadProvidersFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "providers.json")
+
+	err := os.WriteFile(path, []byte(`{"providers": [
+		{"name": "company", "issuer": "https://sso.example.com", "client_id": "todo", "redirect_url": "https://todo.example.com/login/company", "allow_signup": true}
+	]}`), 0600)
+	require.NoError(t, err)
+
+	configs, err := LoadProvidersFile(path)
+	require.NoError(t, err)
+	require.Len(t, configs, 1)
+	require.Equal(t, "company", configs[0].Name)
+	require.True(t, configs[0].AllowSignup)
+	require.False(t, configs[0].LinkByEmail)
+
+	err = os.WriteFile(path, []byte(`{"providers": [
+		{"name": "company", "issuer": "https://sso.example.com", "client_id": "todo", "redirect_url": "https://todo.example.com/login/company"},
+		{"name": "company", "issuer": "https://other.example.com", "client_id": "todo", "redirect_url": "https://todo.example.com/login/company"}
+	]}`), 0600)
+	require.NoError(t, err)
+
+	_, err = LoadProvidersFile(path)
+	require.Error(t, err)
+}
+
+func newTestProvider(t *testing.T) (*oidctest.Server, *Provider) {
+	server := oidctest.NewServer("todo-app", util.RandomString(32))
+	t.Cleanup(server.Close)
+
+	provider := NewProvider(ProviderConfig{
+		Name:         "mock",
+		Issuer:       server.URL,
+		ClientID:     server.ClientID,
+		ClientSecret: server.ClientSecret,
+		RedirectURL:  testRedirectURL,
+	}, nil)
+
+	return server, provider
+}
+
+// authorize goes through the authorization endpoint of the provider, giving
+// the code and state it redirects back with
+func authorize(t *testing.T, provider *Provider, state string, nonce string, codeVerifier string) (string, string) {
+	authURL, err := provider.AuthCodeURL(context.Background(), state, nonce, codeVerifier)
+	require.NoError(t, err)
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	res, err := client.Get(authURL)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusFound, res.StatusCode)
+
+	location, err := url.Parse(res.Header.Get("Location"))
+	require.NoError(t, err)
+
+	return location.Query().Get("code"), location.Query().Get("state")
+}
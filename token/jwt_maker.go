@@ -0,0 +1,145 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Algorithms a JWTMaker can sign tokens with
+const (
+	JWTAlgorithmHS256 = "HS256"
+	JWTAlgorithmEdDSA = "EdDSA"
+	JWTAlgorithmRS256 = "RS256"
+)
+
+const minSecretKeySize = 32
+
+// JWTMaker creates and verifies JSON Web Tokens. Every maker is pinned to a
+// single algorithm, so tokens claiming any other one (including "none") are
+// rejected before their signature is even looked at.
+type JWTMaker struct {
+	method       jwt.SigningMethod
+	signingKey   interface{}
+	verifyingKey interface{}
+}
+
+// jwtClaims carries the payload along with the registered claims other JWT
+// consumers understand
+type jwtClaims struct {
+	*Payload
+	jwt.RegisteredClaims
+}
+
+func (claims jwtClaims) Valid() error {
+	return claims.Payload.Valid()
+}
+
+// NewJWTMaker creates a maker signing tokens with HS256
+func NewJWTMaker(secretKey string) (Maker, error) {
+	if len(secretKey) < minSecretKeySize {
+		return nil, fmt.Errorf("invalid secret key size: must be at least %d characters long", minSecretKeySize)
+	}
+
+	maker := &JWTMaker{
+		method:       jwt.SigningMethodHS256,
+		signingKey:   []byte(secretKey),
+		verifyingKey: []byte(secretKey),
+	}
+
+	return maker, nil
+}
+
+// NewAsymmetricJWTMaker creates a maker signing tokens with EdDSA or RS256
+// using the PEM encoded private key
+func NewAsymmetricJWTMaker(algorithm string, privateKeyPEM []byte) (Maker, error) {
+	switch algorithm {
+	case JWTAlgorithmEdDSA:
+		privateKey, err := jwt.ParseEdPrivateKeyFromPEM(privateKeyPEM)
+		if err != nil {
+			return nil, err
+		}
+
+		edPrivateKey, ok := privateKey.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("invalid private key: must be an Ed25519 key")
+		}
+
+		maker := &JWTMaker{
+			method:       jwt.SigningMethodEdDSA,
+			signingKey:   edPrivateKey,
+			verifyingKey: edPrivateKey.Public(),
+		}
+
+		return maker, nil
+
+	case JWTAlgorithmRS256:
+		privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(privateKeyPEM)
+		if err != nil {
+			return nil, err
+		}
+
+		if privateKey.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("invalid private key: RSA keys must be at least 2048 bits long")
+		}
+
+		maker := &JWTMaker{
+			method:       jwt.SigningMethodRS256,
+			signingKey:   privateKey,
+			verifyingKey: privateKey.Public(),
+		}
+
+		return maker, nil
+	}
+
+	return nil, fmt.Errorf("unsupported JWT algorithm %q", algorithm)
+}
+
+func (maker *JWTMaker) CreateToken(arg PayloadParams) (string, *Payload, error) {
+	payload, err := NewPayload(arg)
+	if err != nil {
+		return "", nil, err
+	}
+
+	claims := jwtClaims{
+		Payload: payload,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        payload.ID.String(),
+			Subject:   payload.Username,
+			IssuedAt:  jwt.NewNumericDate(payload.IssuedAt),
+			ExpiresAt: jwt.NewNumericDate(payload.ExpiresAt),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(maker.method, claims).SignedString(maker.signingKey)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, payload, nil
+}
+
+func (maker *JWTMaker) VerifyToken(token string) (*Payload, error) {
+	keyFunc := func(t *jwt.Token) (interface{}, error) {
+		// Checked by the parser already, but the key must never be handed
+		// out for another algorithm
+		if t.Method.Alg() != maker.method.Alg() {
+			return nil, ErrTokenInvalid
+		}
+
+		return maker.verifyingKey, nil
+	}
+
+	claims := &jwtClaims{Payload: &Payload{}}
+	_, err := jwt.ParseWithClaims(token, claims, keyFunc, jwt.WithValidMethods([]string{maker.method.Alg()}))
+	if err != nil {
+		if errors.Is(err, ErrTokenExpired) {
+			return nil, ErrTokenExpired
+		}
+		return nil, ErrTokenInvalid
+	}
+
+	return claims.Payload, nil
+}
@@ -0,0 +1,263 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/sbbullet/to-do/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWTMaker(t *testing.T) {
+	makers := map[string]Maker{
+		JWTAlgorithmHS256: randomJWTMaker(t),
+		JWTAlgorithmEdDSA: randomAsymmetricJWTMaker(t, JWTAlgorithmEdDSA, randomEd25519PrivateKeyPEM(t)),
+		JWTAlgorithmRS256: randomAsymmetricJWTMaker(t, JWTAlgorithmRS256, randomRSAPrivateKeyPEM(t)),
+	}
+
+	for algorithm, maker := range makers {
+		maker := maker
+		t.Run(algorithm, func(t *testing.T) {
+			username := util.RandomUsername()
+			token, payload, err := maker.CreateToken(PayloadParams{Username: username, Duration: time.Minute})
+			require.NoError(t, err)
+			require.Equal(t, algorithm, jwtHeaderOf(t, token)["alg"])
+
+			verified, err := maker.VerifyToken(token)
+			require.NoError(t, err)
+			require.Equal(t, payload.ID, verified.ID)
+			require.Equal(t, username, verified.Username)
+			require.WithinDuration(t, payload.ExpiresAt, verified.ExpiresAt, time.Second)
+		})
+	}
+}
+
+func TestJWTMakerExpiredToken(t *testing.T) {
+	maker := randomJWTMaker(t)
+
+	token, _, err := maker.CreateToken(PayloadParams{Username: util.RandomUsername(), Duration: -time.Minute})
+	require.NoError(t, err)
+
+	_, err = maker.VerifyToken(token)
+	require.ErrorIs(t, err, ErrTokenExpired)
+
+	// Tokens signed with another key are invalid, however long ago they expired
+	_, err = randomJWTMaker(t).VerifyToken(token)
+	require.ErrorIs(t, err, ErrTokenInvalid)
+}
+
+func TestJWTMakerWrongKey(t *testing.T) {
+	edPrivateKeyPEM := randomEd25519PrivateKeyPEM(t)
+	rsaPrivateKeyPEM := randomRSAPrivateKeyPEM(t)
+
+	testCases := []struct {
+		name     string
+		signer   Maker
+		verifier Maker
+	}{
+		{
+			name:     "HS256",
+			signer:   randomJWTMaker(t),
+			verifier: randomJWTMaker(t),
+		},
+		{
+			name:     "EdDSA",
+			signer:   randomAsymmetricJWTMaker(t, JWTAlgorithmEdDSA, randomEd25519PrivateKeyPEM(t)),
+			verifier: randomAsymmetricJWTMaker(t, JWTAlgorithmEdDSA, edPrivateKeyPEM),
+		},
+		{
+			name:     "RS256",
+			signer:   randomAsymmetricJWTMaker(t, JWTAlgorithmRS256, randomRSAPrivateKeyPEM(t)),
+			verifier: randomAsymmetricJWTMaker(t, JWTAlgorithmRS256, rsaPrivateKeyPEM),
+		},
+		{
+			name:     "EdDSAForRS256",
+			signer:   randomAsymmetricJWTMaker(t, JWTAlgorithmEdDSA, edPrivateKeyPEM),
+			verifier: randomAsymmetricJWTMaker(t, JWTAlgorithmRS256, rsaPrivateKeyPEM),
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			token, _, err := tc.signer.CreateToken(PayloadParams{Username: util.RandomUsername(), Duration: time.Minute})
+			require.NoError(t, err)
+
+			_, err = tc.verifier.VerifyToken(token)
+			require.ErrorIs(t, err, ErrTokenInvalid)
+		})
+	}
+}
+
+func TestJWTMakerTamperedToken(t *testing.T) {
+	maker := randomJWTMaker(t)
+
+	token, _, err := maker.CreateToken(PayloadParams{Username: util.RandomUsername(), Duration: time.Minute})
+	require.NoError(t, err)
+
+	// Claiming to be another user keeps the signature of the original claims
+	parts := strings.Split(token, ".")
+	require.Len(t, parts, 3)
+
+	data, err := base64.RawURLEncoding.DecodeString(parts[1])
+	require.NoError(t, err)
+
+	claims := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(data, &claims))
+	claims["username"] = util.RandomUsername()
+	claims["sub"] = claims["username"]
+
+	data, err = json.Marshal(claims)
+	require.NoError(t, err)
+	parts[1] = base64.RawURLEncoding.EncodeToString(data)
+
+	_, err = maker.VerifyToken(strings.Join(parts, "."))
+	require.ErrorIs(t, err, ErrTokenInvalid)
+
+	// So does one with its signature cut off
+	_, err = maker.VerifyToken(strings.Join(parts[:2], ".") + ".")
+	require.ErrorIs(t, err, ErrTokenInvalid)
+}
+
+func TestJWTMakerAlgNone(t *testing.T) {
+	maker := randomJWTMaker(t)
+
+	claims := randomJWTClaims(t)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+
+	_, err = maker.VerifyToken(token)
+	require.ErrorIs(t, err, ErrTokenInvalid)
+}
+
+func TestJWTMakerAlgorithmConfusion(t *testing.T) {
+	edPrivateKey := randomEd25519PrivateKey(t)
+	edPrivateKeyDER, err := x509.MarshalPKCS8PrivateKey(edPrivateKey)
+	require.NoError(t, err)
+	edPublicKeyDER, err := x509.MarshalPKIXPublicKey(edPrivateKey.Public())
+	require.NoError(t, err)
+
+	rsaPrivateKey := randomRSAPrivateKey(t)
+	rsaPublicKeyDER, err := x509.MarshalPKIXPublicKey(rsaPrivateKey.Public())
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name      string
+		algorithm string
+		// privateKeyPEM is the key the maker signs with
+		privateKeyPEM []byte
+		// publicKeys are the forms of the public key an attacker could use as an HMAC secret
+		publicKeys [][]byte
+	}{
+		{
+			name:          "EdDSA",
+			algorithm:     JWTAlgorithmEdDSA,
+			privateKeyPEM: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: edPrivateKeyDER}),
+			publicKeys: [][]byte{
+				pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: edPublicKeyDER}),
+				edPublicKeyDER,
+				edPrivateKey[32:],
+			},
+		},
+		{
+			name:          "RS256",
+			algorithm:     JWTAlgorithmRS256,
+			privateKeyPEM: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaPrivateKey)}),
+			publicKeys: [][]byte{
+				pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rsaPublicKeyDER}),
+				rsaPublicKeyDER,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			maker := randomAsymmetricJWTMaker(t, tc.algorithm, tc.privateKeyPEM)
+
+			for _, publicKey := range tc.publicKeys {
+				token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, randomJWTClaims(t)).SignedString(publicKey)
+				require.NoError(t, err)
+
+				_, err = maker.VerifyToken(token)
+				require.ErrorIs(t, err, ErrTokenInvalid)
+			}
+		})
+	}
+}
+
+func TestJWTMakerInvalidKey(t *testing.T) {
+	_, err := NewJWTMaker(util.RandomString(16))
+	require.Error(t, err)
+
+	_, err = NewAsymmetricJWTMaker(JWTAlgorithmEdDSA, randomRSAPrivateKeyPEM(t))
+	require.Error(t, err)
+
+	_, err = NewAsymmetricJWTMaker(JWTAlgorithmRS256, randomEd25519PrivateKeyPEM(t))
+	require.Error(t, err)
+
+	_, err = NewAsymmetricJWTMaker(JWTAlgorithmHS256, randomEd25519PrivateKeyPEM(t))
+	require.Error(t, err)
+}
+
+func randomJWTMaker(t *testing.T) Maker {
+	maker, err := NewJWTMaker(util.RandomString(32))
+	require.NoError(t, err)
+
+	return maker
+}
+
+func randomAsymmetricJWTMaker(t *testing.T, algorithm string, privateKeyPEM []byte) Maker {
+	maker, err := NewAsymmetricJWTMaker(algorithm, privateKeyPEM)
+	require.NoError(t, err)
+
+	return maker
+}
+
+func randomRSAPrivateKey(t *testing.T) *rsa.PrivateKey {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	return privateKey
+}
+
+func randomRSAPrivateKeyPEM(t *testing.T) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(randomRSAPrivateKey(t))})
+}
+
+// randomJWTClaims gives valid claims for tokens the makers didn't sign themselves
+func randomJWTClaims(t *testing.T) jwtClaims {
+	payload, err := NewPayload(PayloadParams{Username: util.RandomUsername(), Duration: time.Minute})
+	require.NoError(t, err)
+
+	return jwtClaims{
+		Payload: payload,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        payload.ID.String(),
+			Subject:   payload.Username,
+			IssuedAt:  jwt.NewNumericDate(payload.IssuedAt),
+			ExpiresAt: jwt.NewNumericDate(payload.ExpiresAt),
+		},
+	}
+}
+
+func jwtHeaderOf(t *testing.T, token string) map[string]interface{} {
+	parts := strings.Split(token, ".")
+	require.Len(t, parts, 3)
+
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	require.NoError(t, err)
+
+	header := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(data, &header))
+
+	return header
+}
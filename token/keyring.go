@@ -0,0 +1,187 @@
+package token
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// DefaultKeyID identifies the key of a single-key keyring. Tokens without a
+// key ID in their footer are verified with it.
+const DefaultKeyID = "default"
+
+var (
+	ErrNoActiveKey = errors.New("no active signing key")
+	ErrKeyNotFound = errors.New("key not found or retired")
+)
+
+// Key is a signing key of a keyring. Material is the symmetric key for local
+// tokens or the Ed25519 private key for public ones.
+type Key struct {
+	ID       string
+	Material []byte
+	// ActiveFrom is when the key starts signing new tokens, the zero time
+	// meaning it always could
+	ActiveFrom time.Time
+	// RetiredAt is when tokens signed with the key stop being accepted, the
+	// zero time meaning never
+	RetiredAt time.Time
+}
+
+func (key Key) retired(now time.Time) bool {
+	return !key.RetiredAt.IsZero() && !now.Before(key.RetiredAt)
+}
+
+// Keyring holds the keys tokens are signed and verified with, so that keys
+// can be rotated without invalidating the tokens already issued.
+//
+// To rotate without downtime, add the new key with an ActiveFrom in the
+// future and roll it out everywhere. Once it activates, give the old key a
+// RetiredAt of at least the activation time plus the token duration, and
+// remove it after that.
+type Keyring struct {
+	keys []Key
+}
+
+func NewKeyring(keys []Key) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("keyring must have at least one key")
+	}
+
+	ids := map[string]bool{}
+	for _, key := range keys {
+		if len(key.ID) == 0 {
+			return nil, fmt.Errorf("every key of the keyring must have an ID")
+		}
+
+		if ids[key.ID] {
+			return nil, fmt.Errorf("duplicate key ID %q in keyring", key.ID)
+		}
+		ids[key.ID] = true
+	}
+
+	// Latest activation first, which makes the first usable key the active one
+	sorted := append([]Key{}, keys...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].ActiveFrom.After(sorted[j].ActiveFrom)
+	})
+
+	return &Keyring{keys: sorted}, nil
+}
+
+// ActiveKey gets the key new tokens should be signed with: the most recently
+// activated key that isn't retired
+func (keyring *Keyring) ActiveKey(now time.Time) (Key, error) {
+	for _, key := range keyring.keys {
+		if !now.Before(key.ActiveFrom) && !key.retired(now) {
+			return key, nil
+		}
+	}
+
+	return Key{}, ErrNoActiveKey
+}
+
+// VerificationKey gets the key with the ID unless it's retired. Keys that
+// aren't active yet are accepted, as other instances may have activated them
+// slightly earlier.
+func (keyring *Keyring) VerificationKey(id string, now time.Time) (Key, error) {
+	if len(id) == 0 {
+		id = DefaultKeyID
+	}
+
+	for _, key := range keyring.keys {
+		if key.ID == id {
+			if key.retired(now) {
+				return Key{}, ErrKeyNotFound
+			}
+			return key, nil
+		}
+	}
+
+	return Key{}, ErrKeyNotFound
+}
+
+// Keys gets every key of the keyring that isn't retired
+func (keyring *Keyring) Keys(now time.Time) []Key {
+	keys := []Key{}
+	for _, key := range keyring.keys {
+		if !key.retired(now) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// keyringFile is the format of keyring files. Each key either holds a
+// symmetric key or points to a PEM file with an Ed25519 private key.
+type keyringFile struct {
+	Keys []struct {
+		ID             string    `json:"id"`
+		Key            string    `json:"key"`
+		PrivateKeyFile string    `json:"private_key_file"`
+		ActiveFrom     time.Time `json:"active_from"`
+		RetiredAt      time.Time `json:"retired_at"`
+	} `json:"keys"`
+}
+
+// LoadKeyringFile loads a keyring from a JSON file like
+//
+//	{"keys": [
+//	  {"id": "2022-09", "key": "...", "retired_at": "2022-10-01T01:00:00Z"},
+//	  {"id": "2022-10", "key": "...", "active_from": "2022-10-01T00:00:00Z"}
+//	]}
+//
+// Paths of private key files are relative to the keyring file.
+func LoadKeyringFile(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file keyringFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid keyring file: %w", err)
+	}
+
+	keys := []Key{}
+	for _, fileKey := range file.Keys {
+		key := Key{
+			ID:         fileKey.ID,
+			Material:   []byte(fileKey.Key),
+			ActiveFrom: fileKey.ActiveFrom,
+			RetiredAt:  fileKey.RetiredAt,
+		}
+
+		if len(fileKey.PrivateKeyFile) > 0 {
+			privateKeyPath := fileKey.PrivateKeyFile
+			if !filepath.IsAbs(privateKeyPath) {
+				privateKeyPath = filepath.Join(filepath.Dir(path), privateKeyPath)
+			}
+
+			privateKeyPEM, err := os.ReadFile(privateKeyPath)
+			if err != nil {
+				return nil, err
+			}
+
+			privateKey, err := ParseEd25519PrivateKeyPEM(privateKeyPEM)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %w", fileKey.ID, err)
+			}
+			key.Material = privateKey
+		}
+
+		keys = append(keys, key)
+	}
+
+	return NewKeyring(keys)
+}
+
+// keyFooter is the footer of tokens, naming the key they were signed with
+type keyFooter struct {
+	KeyID string `json:"kid"`
+}
@@ -0,0 +1,109 @@
+package token
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sbbullet/to-do/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewKeyringInvalid(t *testing.T) {
+	_, err := NewKeyring(nil)
+	require.Error(t, err)
+
+	_, err = NewKeyring([]Key{{Material: []byte(util.RandomString(32))}})
+	require.Error(t, err)
+
+	_, err = NewKeyring([]Key{
+		{ID: "a", Material: []byte(util.RandomString(32))},
+		{ID: "a", Material: []byte(util.RandomString(32))},
+	})
+	require.Error(t, err)
+}
+
+func TestKeyringActiveKey(t *testing.T) {
+	now := time.Now()
+
+	keyring, err := NewKeyring([]Key{
+		{ID: "old", Material: []byte(util.RandomString(32)), RetiredAt: now.Add(time.Hour)},
+		{ID: "current", Material: []byte(util.RandomString(32)), ActiveFrom: now.Add(-time.Hour)},
+		{ID: "next", Material: []byte(util.RandomString(32)), ActiveFrom: now.Add(time.Hour)},
+	})
+	require.NoError(t, err)
+
+	key, err := keyring.ActiveKey(now)
+	require.NoError(t, err)
+	require.Equal(t, "current", key.ID)
+
+	// The scheduled key takes over once it activates
+	key, err = keyring.ActiveKey(now.Add(2 * time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, "next", key.ID)
+}
+
+func TestKeyringNoActiveKey(t *testing.T) {
+	now := time.Now()
+
+	keyring, err := NewKeyring([]Key{
+		{ID: "retired", Material: []byte(util.RandomString(32)), RetiredAt: now.Add(-time.Minute)},
+	})
+	require.NoError(t, err)
+
+	_, err = keyring.ActiveKey(now)
+	require.ErrorIs(t, err, ErrNoActiveKey)
+}
+
+func TestKeyringVerificationKey(t *testing.T) {
+	now := time.Now()
+
+	keyring, err := NewKeyring([]Key{
+		{ID: DefaultKeyID, Material: []byte(util.RandomString(32))},
+		{ID: "retired", Material: []byte(util.RandomString(32)), RetiredAt: now.Add(-time.Minute)},
+		{ID: "next", Material: []byte(util.RandomString(32)), ActiveFrom: now.Add(time.Hour)},
+	})
+	require.NoError(t, err)
+
+	key, err := keyring.VerificationKey("", now)
+	require.NoError(t, err)
+	require.Equal(t, DefaultKeyID, key.ID)
+
+	key, err = keyring.VerificationKey("next", now)
+	require.NoError(t, err)
+	require.Equal(t, "next", key.ID)
+
+	_, err = keyring.VerificationKey("retired", now)
+	require.ErrorIs(t, err, ErrKeyNotFound)
+
+	_, err = keyring.VerificationKey("unknown", now)
+	require.ErrorIs(t, err, ErrKeyNotFound)
+
+	require.Len(t, keyring.Keys(now), 2)
+}
+
+func TestLoadKeyringFile(t *testing.T) {
+	dir := t.TempDir()
+
+	keyringPath := filepath.Join(dir, "keyring.json")
+	err := os.WriteFile(keyringPath, []byte(`{"keys": [
+		{"id": "2022-09", "key": "`+util.RandomString(32)+`", "retired_at": "2022-10-01T01:00:00Z"},
+		{"id": "2022-10", "key": "`+util.RandomString(32)+`", "active_from": "2022-10-01T00:00:00Z"}
+	]}`), 0600)
+	require.NoError(t, err)
+
+	keyring, err := LoadKeyringFile(keyringPath)
+	require.NoError(t, err)
+
+	key, err := keyring.ActiveKey(time.Date(2022, 10, 1, 0, 30, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Equal(t, "2022-10", key.ID)
+
+	// Both keys verify tokens during the overlap
+	_, err = keyring.VerificationKey("2022-09", time.Date(2022, 10, 1, 0, 30, 0, 0, time.UTC))
+	require.NoError(t, err)
+
+	_, err = LoadKeyringFile(filepath.Join(dir, "missing.json"))
+	require.Error(t, err)
+}
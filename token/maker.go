@@ -1,8 +1,6 @@
 package token
 
-import "time"
-
 type Maker interface {
-	CreateToken(username string, duration time.Duration) (string, *Payload, error)
+	CreateToken(arg PayloadParams) (string, *Payload, error)
 	VerifyToken(token string) (*Payload, error)
 }
@@ -9,30 +9,48 @@ import (
 )
 
 type PasetoMaker struct {
-	paseto       *paseto.V2
-	symmetricKey []byte
+	paseto  *paseto.V2
+	keyring *Keyring
 }
 
 func NewPasetoMaker(symmetricKey string) (Maker, error) {
-	if len(symmetricKey) < chacha20poly1305.KeySize {
-		return nil, fmt.Errorf("invalid symmetric key size: must be exactly %d characters long", chacha20poly1305.KeySize)
+	keyring, err := NewKeyring([]Key{{ID: DefaultKeyID, Material: []byte(symmetricKey)}})
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPasetoKeyringMaker(keyring)
+}
+
+// NewPasetoKeyringMaker creates a maker encrypting tokens with the active key
+// of the keyring and decrypting them with any key that isn't retired
+func NewPasetoKeyringMaker(keyring *Keyring) (Maker, error) {
+	for _, key := range keyring.keys {
+		if len(key.Material) != chacha20poly1305.KeySize {
+			return nil, fmt.Errorf("invalid symmetric key size of key %q: must be exactly %d characters long", key.ID, chacha20poly1305.KeySize)
+		}
 	}
 
 	maker := &PasetoMaker{
-		paseto:       paseto.NewV2(),
-		symmetricKey: []byte(symmetricKey),
+		paseto:  paseto.NewV2(),
+		keyring: keyring,
 	}
 
 	return maker, nil
 }
 
-func (maker *PasetoMaker) CreateToken(username string, duration time.Duration) (string, *Payload, error) {
-	payload, err := NewPayload(username, duration)
+func (maker *PasetoMaker) CreateToken(arg PayloadParams) (string, *Payload, error) {
+	key, err := maker.keyring.ActiveKey(time.Now())
 	if err != nil {
 		return "", nil, err
 	}
 
-	token, err := maker.paseto.Encrypt(maker.symmetricKey, payload, nil)
+	payload, err := NewPayload(arg)
+	if err != nil {
+		return "", nil, err
+	}
+
+	token, err := maker.paseto.Encrypt(key.Material, payload, keyFooter{KeyID: key.ID})
 	if err != nil {
 		return "", nil, err
 	}
@@ -41,8 +59,20 @@ func (maker *PasetoMaker) CreateToken(username string, duration time.Duration) (
 }
 
 func (maker *PasetoMaker) VerifyToken(token string) (*Payload, error) {
+	// The footer is authenticated along with the payload, so a forged key ID
+	// only leads to decrypting with the wrong key
+	var footer keyFooter
+	if err := paseto.ParseFooter(token, &footer); err != nil {
+		return nil, ErrTokenInvalid
+	}
+
+	key, err := maker.keyring.VerificationKey(footer.KeyID, time.Now())
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+
 	payload := &Payload{}
-	err := maker.paseto.Decrypt(token, maker.symmetricKey, payload, nil)
+	err = maker.paseto.Decrypt(token, key.Material, payload, nil)
 	if err != nil {
 		return nil, ErrTokenInvalid
 	}
@@ -0,0 +1,105 @@
+package token
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sbbullet/to-do/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasetoMaker(t *testing.T) {
+	maker, err := NewPasetoMaker(util.RandomString(32))
+	require.NoError(t, err)
+
+	username := util.RandomUsername()
+	token, payload, err := maker.CreateToken(PayloadParams{Username: username, Duration: time.Minute})
+	require.NoError(t, err)
+	require.NotEmpty(t, token)
+
+	verified, err := maker.VerifyToken(token)
+	require.NoError(t, err)
+	require.Equal(t, payload.ID, verified.ID)
+	require.Equal(t, username, verified.Username)
+	require.WithinDuration(t, payload.ExpiresAt, verified.ExpiresAt, time.Second)
+}
+
+func TestPasetoMakerExpiredToken(t *testing.T) {
+	maker, err := NewPasetoMaker(util.RandomString(32))
+	require.NoError(t, err)
+
+	token, _, err := maker.CreateToken(PayloadParams{Username: util.RandomUsername(), Duration: -time.Minute})
+	require.NoError(t, err)
+
+	_, err = maker.VerifyToken(token)
+	require.ErrorIs(t, err, ErrTokenExpired)
+}
+
+func TestPasetoMakerInvalidKeySize(t *testing.T) {
+	_, err := NewPasetoMaker(util.RandomString(16))
+	require.Error(t, err)
+}
+
+func TestPasetoMakerKeyRotation(t *testing.T) {
+	now := time.Now()
+	oldKey := Key{ID: "old", Material: []byte(util.RandomString(32))}
+	newKey := Key{ID: "new", Material: []byte(util.RandomString(32)), ActiveFrom: now.Add(time.Hour)}
+
+	// Before the rotation only the old key signs
+	keyring, err := NewKeyring([]Key{oldKey, newKey})
+	require.NoError(t, err)
+	before, err := NewPasetoKeyringMaker(keyring)
+	require.NoError(t, err)
+
+	oldToken, _, err := before.CreateToken(PayloadParams{Username: util.RandomUsername(), Duration: time.Minute})
+	require.NoError(t, err)
+
+	// After it the new key signs while the old one still verifies
+	newKey.ActiveFrom = now.Add(-time.Minute)
+	keyring, err = NewKeyring([]Key{oldKey, newKey})
+	require.NoError(t, err)
+	after, err := NewPasetoKeyringMaker(keyring)
+	require.NoError(t, err)
+
+	newToken, _, err := after.CreateToken(PayloadParams{Username: util.RandomUsername(), Duration: time.Minute})
+	require.NoError(t, err)
+	require.NotEqual(t, keyFooterOf(t, oldToken), keyFooterOf(t, newToken))
+
+	_, err = after.VerifyToken(oldToken)
+	require.NoError(t, err)
+
+	// New tokens verify on instances that haven't activated the new key yet
+	_, err = before.VerifyToken(newToken)
+	require.NoError(t, err)
+
+	// Retiring the old key rejects the tokens it signed
+	oldKey.RetiredAt = now.Add(-time.Second)
+	keyring, err = NewKeyring([]Key{oldKey, newKey})
+	require.NoError(t, err)
+	retired, err := NewPasetoKeyringMaker(keyring)
+	require.NoError(t, err)
+
+	_, err = retired.VerifyToken(oldToken)
+	require.ErrorIs(t, err, ErrTokenInvalid)
+
+	_, err = retired.VerifyToken(newToken)
+	require.NoError(t, err)
+}
+
+func TestPasetoMakerForgedKeyID(t *testing.T) {
+	keyring, err := NewKeyring([]Key{
+		{ID: "a", Material: []byte(util.RandomString(32))},
+		{ID: "b", Material: []byte(util.RandomString(32)), ActiveFrom: time.Now().Add(time.Hour)},
+	})
+	require.NoError(t, err)
+	maker, err := NewPasetoKeyringMaker(keyring)
+	require.NoError(t, err)
+
+	token, _, err := maker.CreateToken(PayloadParams{Username: util.RandomUsername(), Duration: time.Minute})
+	require.NoError(t, err)
+
+	// Swapping the key ID in the footer breaks the authentication tag
+	forged := replaceFooter(t, token, keyFooter{KeyID: "b"})
+	_, err = maker.VerifyToken(forged)
+	require.ErrorIs(t, err, ErrTokenInvalid)
+}
@@ -0,0 +1,166 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"github.com/o1egl/paseto"
+)
+
+// PasetoPublicMaker signs v2.public tokens with an Ed25519 private key, so
+// services holding only the public key can verify tokens but not mint them
+type PasetoPublicMaker struct {
+	paseto  *paseto.V2
+	keyring *Keyring
+}
+
+// PublicKey is a key tokens can be verified with
+type PublicKey struct {
+	ID      string            `json:"kid"`
+	Version string            `json:"version"`
+	Purpose string            `json:"purpose"`
+	Key     ed25519.PublicKey `json:"-"`
+}
+
+// PublicKeyProvider is implemented by makers whose tokens can be verified
+// with keys that are safe to publish
+type PublicKeyProvider interface {
+	PublicKeys() []PublicKey
+}
+
+// NewPasetoPublicMaker creates a maker from a PEM encoded PKCS #8 Ed25519 private key
+func NewPasetoPublicMaker(privateKeyPEM []byte) (Maker, error) {
+	privateKey, err := ParseEd25519PrivateKeyPEM(privateKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	keyring, err := NewKeyring([]Key{{ID: DefaultKeyID, Material: privateKey}})
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPasetoPublicKeyringMaker(keyring)
+}
+
+// NewPasetoPublicKeyringMaker creates a maker signing tokens with the active
+// key of the keyring, whose keys must be Ed25519 private keys
+func NewPasetoPublicKeyringMaker(keyring *Keyring) (Maker, error) {
+	for _, key := range keyring.keys {
+		if len(key.Material) != ed25519.PrivateKeySize {
+			return nil, fmt.Errorf("invalid private key %q: must be an Ed25519 key", key.ID)
+		}
+	}
+
+	maker := &PasetoPublicMaker{
+		paseto:  paseto.NewV2(),
+		keyring: keyring,
+	}
+
+	return maker, nil
+}
+
+func (maker *PasetoPublicMaker) CreateToken(arg PayloadParams) (string, *Payload, error) {
+	key, err := maker.keyring.ActiveKey(time.Now())
+	if err != nil {
+		return "", nil, err
+	}
+
+	payload, err := NewPayload(arg)
+	if err != nil {
+		return "", nil, err
+	}
+
+	token, err := maker.paseto.Sign(ed25519.PrivateKey(key.Material), payload, keyFooter{KeyID: key.ID})
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, payload, nil
+}
+
+func (maker *PasetoPublicMaker) VerifyToken(token string) (*Payload, error) {
+	var footer keyFooter
+	if err := paseto.ParseFooter(token, &footer); err != nil {
+		return nil, ErrTokenInvalid
+	}
+
+	key, err := maker.keyring.VerificationKey(footer.KeyID, time.Now())
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+
+	payload := &Payload{}
+	err = maker.paseto.Verify(token, ed25519.PrivateKey(key.Material).Public(), payload, nil)
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+
+	err = payload.Valid()
+	if err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
+
+// PublicKeys gets the public keys of every key that isn't retired, including
+// the ones yet to be activated so verifiers know them in advance
+func (maker *PasetoPublicMaker) PublicKeys() []PublicKey {
+	publicKeys := []PublicKey{}
+	for _, key := range maker.keyring.Keys(time.Now()) {
+		publicKeys = append(publicKeys, PublicKey{
+			ID:      key.ID,
+			Version: "v2",
+			Purpose: "public",
+			Key:     ed25519.PrivateKey(key.Material).Public().(ed25519.PublicKey),
+		})
+	}
+
+	return publicKeys
+}
+
+// ParseEd25519PrivateKeyPEM parses a PEM encoded PKCS #8 Ed25519 private key,
+// as written by `openssl genpkey -algorithm ed25519`
+func ParseEd25519PrivateKeyPEM(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("invalid private key: no PEM block found")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("invalid private key: must be an Ed25519 key")
+	}
+
+	return privateKey, nil
+}
+
+// ParseEd25519PublicKeyPEM parses a PEM encoded PKIX Ed25519 public key, as
+// written by `openssl pkey -pubout`
+func ParseEd25519PublicKeyPEM(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("invalid public key: no PEM block found")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("invalid public key: must be an Ed25519 key")
+	}
+
+	return publicKey, nil
+}
@@ -0,0 +1,106 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/o1egl/paseto"
+	"github.com/sbbullet/to-do/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasetoPublicMaker(t *testing.T) {
+	maker, err := NewPasetoPublicMaker(randomEd25519PrivateKeyPEM(t))
+	require.NoError(t, err)
+
+	username := util.RandomUsername()
+	token, payload, err := maker.CreateToken(PayloadParams{Username: username, Duration: time.Minute})
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(token, "v2.public."))
+
+	verified, err := maker.VerifyToken(token)
+	require.NoError(t, err)
+	require.Equal(t, payload.ID, verified.ID)
+	require.Equal(t, username, verified.Username)
+
+	// Anyone with the published key can verify the token
+	publicKeys := maker.(PublicKeyProvider).PublicKeys()
+	require.Len(t, publicKeys, 1)
+	require.Equal(t, DefaultKeyID, publicKeys[0].ID)
+
+	err = paseto.NewV2().Verify(token, publicKeys[0].Key, &Payload{}, nil)
+	require.NoError(t, err)
+}
+
+func TestPasetoPublicMakerKeyRotation(t *testing.T) {
+	now := time.Now()
+	oldKey := Key{ID: "old", Material: randomEd25519PrivateKey(t), RetiredAt: now.Add(time.Hour)}
+	newKey := Key{ID: "new", Material: randomEd25519PrivateKey(t), ActiveFrom: now.Add(-time.Minute)}
+
+	keyring, err := NewKeyring([]Key{oldKey, newKey})
+	require.NoError(t, err)
+	maker, err := NewPasetoPublicKeyringMaker(keyring)
+	require.NoError(t, err)
+
+	token, _, err := maker.CreateToken(PayloadParams{Username: util.RandomUsername(), Duration: time.Minute})
+	require.NoError(t, err)
+	require.Equal(t, "new", keyFooterOf(t, token).KeyID)
+
+	// Both keys are published until the old one retires
+	ids := []string{}
+	for _, publicKey := range maker.(PublicKeyProvider).PublicKeys() {
+		ids = append(ids, publicKey.ID)
+	}
+	require.ElementsMatch(t, []string{"old", "new"}, ids)
+}
+
+func TestPasetoPublicMakerInvalidKey(t *testing.T) {
+	_, err := NewPasetoPublicMaker([]byte("not a key"))
+	require.Error(t, err)
+
+	keyring, err := NewKeyring([]Key{{ID: DefaultKeyID, Material: []byte(util.RandomString(32))}})
+	require.NoError(t, err)
+
+	_, err = NewPasetoPublicKeyringMaker(keyring)
+	require.Error(t, err)
+}
+
+func randomEd25519PrivateKey(t *testing.T) ed25519.PrivateKey {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	return privateKey
+}
+
+func randomEd25519PrivateKeyPEM(t *testing.T) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(randomEd25519PrivateKey(t))
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func keyFooterOf(t *testing.T, token string) keyFooter {
+	var footer keyFooter
+	err := paseto.ParseFooter(token, &footer)
+	require.NoError(t, err)
+
+	return footer
+}
+
+func replaceFooter(t *testing.T, token string, footer keyFooter) string {
+	data, err := json.Marshal(footer)
+	require.NoError(t, err)
+
+	parts := strings.Split(token, ".")
+	require.Len(t, parts, 4)
+	parts[3] = base64.RawURLEncoding.EncodeToString(data)
+
+	return strings.Join(parts, ".")
+}
@@ -12,24 +12,57 @@ var (
 	ErrTokenInvalid = errors.New("token is invalid")
 )
 
+// Scopes limit what a token can be used for
+const (
+	ScopeTodosRead    = "todos:read"
+	ScopeTodosWrite   = "todos:write"
+	ScopeAccountAdmin = "account:admin"
+)
+
+// AllScopes are the scopes granted when none are asked for
+var AllScopes = []string{ScopeTodosRead, ScopeTodosWrite, ScopeAccountAdmin}
+
 type Payload struct {
-	ID        uuid.UUID `json:"id"`
+	ID uuid.UUID `json:"id"`
+	// Username is the user the token acts as, who is impersonated when
+	// Impersonator is set
 	Username  string    `json:"username"`
-	IssuedAt  time.Time `json:"issued_at"`
-	ExpiresAt time.Time `json:"expires_at"`
+	SessionID uuid.UUID `json:"session_id"`
+	Scopes    []string  `json:"scopes"`
+	// Role is the role of the user when the token was issued
+	Role string `json:"role,omitempty"`
+	// Impersonator is the admin who had the token issued to act as the user
+	Impersonator string    `json:"impersonator,omitempty"`
+	IssuedAt     time.Time `json:"issued_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+type PayloadParams struct {
+	Username string        `json:"username"`
+	Duration time.Duration `json:"duration"`
+	// SessionID is the login session the token was issued for, if any
+	SessionID uuid.UUID `json:"session_id"`
+	Scopes    []string  `json:"scopes"`
+	Role      string    `json:"role"`
+	// Impersonator is the admin the token is issued to, if it's not the user
+	Impersonator string `json:"impersonator"`
 }
 
-func NewPayload(username string, duration time.Duration) (*Payload, error) {
+func NewPayload(arg PayloadParams) (*Payload, error) {
 	tokenID, err := uuid.NewRandom()
 	if err != nil {
 		return nil, err
 	}
 
 	payload := &Payload{
-		ID:        tokenID,
-		Username:  username,
-		IssuedAt:  time.Now(),
-		ExpiresAt: time.Now().Add(duration),
+		ID:           tokenID,
+		Username:     arg.Username,
+		SessionID:    arg.SessionID,
+		Scopes:       arg.Scopes,
+		Role:         arg.Role,
+		Impersonator: arg.Impersonator,
+		IssuedAt:     time.Now(),
+		ExpiresAt:    time.Now().Add(arg.Duration),
 	}
 
 	return payload, nil
@@ -42,3 +75,14 @@ func (payload *Payload) Valid() error {
 
 	return nil
 }
+
+// HasScope tells whether the token was granted the scope
+func (payload *Payload) HasScope(scope string) bool {
+	for _, s := range payload.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
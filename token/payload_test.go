@@ -0,0 +1,42 @@
+package token
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sbbullet/to-do/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPayloadHasScope(t *testing.T) {
+	payload, err := NewPayload(PayloadParams{
+		Username: util.RandomUsername(),
+		Duration: time.Minute,
+		Scopes:   []string{ScopeTodosRead},
+	})
+	require.NoError(t, err)
+
+	require.True(t, payload.HasScope(ScopeTodosRead))
+	require.False(t, payload.HasScope(ScopeTodosWrite))
+	require.False(t, payload.HasScope(ScopeAccountAdmin))
+}
+
+func TestPayloadScopesSurviveToken(t *testing.T) {
+	maker, err := NewPasetoMaker(util.RandomString(32))
+	require.NoError(t, err)
+
+	token, _, err := maker.CreateToken(PayloadParams{
+		Username:     util.RandomUsername(),
+		Duration:     time.Minute,
+		Scopes:       AllScopes,
+		Role:         "admin",
+		Impersonator: util.RandomUsername(),
+	})
+	require.NoError(t, err)
+
+	payload, err := maker.VerifyToken(token)
+	require.NoError(t, err)
+	require.Equal(t, AllScopes, payload.Scopes)
+	require.Equal(t, "admin", payload.Role)
+	require.NotEmpty(t, payload.Impersonator)
+}
@@ -0,0 +1,102 @@
+package util
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies are the reverse proxies whose X-Forwarded-For header is
+// believed. Anyone else can put whatever they like in it.
+type TrustedProxies struct {
+	networks []*net.IPNet
+}
+
+// NewTrustedProxies parses the comma separated list of IP addresses and CIDR
+// ranges of the trusted proxies
+func NewTrustedProxies(list string) (*TrustedProxies, error) {
+	proxies := &TrustedProxies{}
+
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			entry = fmt.Sprintf("%s/%d", entry, bits)
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		proxies.networks = append(proxies.networks, network)
+	}
+
+	return proxies, nil
+}
+
+// trusts tells whether the address is one of a trusted proxy
+func (p *TrustedProxies) trusts(address string) bool {
+	if p == nil {
+		return false
+	}
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range p.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ClientIP gets the address of the client of the request. Behind trusted
+// proxies, it's the rightmost address of X-Forwarded-For that isn't one of
+// them, since every proxy appends the address it got the request from.
+func (p *TrustedProxies) ClientIP(r *http.Request) string {
+	address, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		address = r.RemoteAddr
+	}
+
+	if !p.trusts(address) {
+		return address
+	}
+
+	var hops []string
+	for _, forwardedFor := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(forwardedFor, ",")...)
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if len(hop) == 0 {
+			continue
+		}
+
+		if !p.trusts(hop) {
+			return hop
+		}
+		address = hop
+	}
+
+	// Every hop is a trusted proxy, so the first one is the client
+	return address
+}
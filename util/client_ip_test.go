@@ -0,0 +1,89 @@
+package util
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := NewTrustedProxies("10.0.0.0/8, 192.168.1.1, ::1")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name          string
+		proxies       *TrustedProxies
+		remoteAddr    string
+		forwardedFors []string
+		expected      string
+	}{
+		{
+			name:       "NoProxy",
+			proxies:    proxies,
+			remoteAddr: "203.0.113.7:1234",
+			expected:   "203.0.113.7",
+		},
+		{
+			name:          "UntrustedClientForwarding",
+			proxies:       proxies,
+			remoteAddr:    "203.0.113.7:1234",
+			forwardedFors: []string{"198.51.100.1"},
+			expected:      "203.0.113.7",
+		},
+		{
+			name:          "NoTrustedProxies",
+			remoteAddr:    "10.0.0.2:1234",
+			forwardedFors: []string{"198.51.100.1"},
+			expected:      "10.0.0.2",
+		},
+		{
+			name:          "TrustedProxy",
+			proxies:       proxies,
+			remoteAddr:    "10.0.0.2:1234",
+			forwardedFors: []string{"198.51.100.1"},
+			expected:      "198.51.100.1",
+		},
+		{
+			name:          "SpoofedHop",
+			proxies:       proxies,
+			remoteAddr:    "10.0.0.2:1234",
+			forwardedFors: []string{"1.2.3.4, 198.51.100.1"},
+			expected:      "198.51.100.1",
+		},
+		{
+			name:          "ChainOfProxies",
+			proxies:       proxies,
+			remoteAddr:    "[::1]:1234",
+			forwardedFors: []string{"1.2.3.4, 198.51.100.1", "192.168.1.1, 10.1.2.3"},
+			expected:      "198.51.100.1",
+		},
+		{
+			name:          "OnlyProxies",
+			proxies:       proxies,
+			remoteAddr:    "10.0.0.2:1234",
+			forwardedFors: []string{"10.0.0.3, 10.0.0.4"},
+			expected:      "10.0.0.3",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tc.remoteAddr
+			for _, forwardedFor := range tc.forwardedFors {
+				r.Header.Add("X-Forwarded-For", forwardedFor)
+			}
+
+			require.Equal(t, tc.expected, tc.proxies.ClientIP(r))
+		})
+	}
+}
+
+func TestNewTrustedProxiesInvalid(t *testing.T) {
+	_, err := NewTrustedProxies("10.0.0.1, nope")
+	require.Error(t, err)
+
+	_, err = NewTrustedProxies("10.0.0.0/99")
+	require.Error(t, err)
+}
@@ -10,12 +10,89 @@ import (
 )
 
 type Config struct {
-	DBDriver            string        `mapstructure:"DB_DRIVER"`
-	DBSource            string        `mapstructure:"DB_SOURCE"`
-	ServerHost          string        `mapstructure:"SERVER_HOST"`
-	ServerPort          string        `mapstructure:"SERVER_PORT"`
-	SymmetricKey        string        `mapstructure:"SYMMETRIC_KEY"`
-	AccessTokenDuration time.Duration `mapstructure:"ACCESS_TOKEN_DURATION"`
+	DBDriver             string        `mapstructure:"DB_DRIVER"`
+	DBSource             string        `mapstructure:"DB_SOURCE"`
+	ServerHost           string        `mapstructure:"SERVER_HOST"`
+	ServerPort           string        `mapstructure:"SERVER_PORT"`
+	SymmetricKey         string        `mapstructure:"SYMMETRIC_KEY"`
+	TokenType            string        `mapstructure:"TOKEN_TYPE" validate:"oneof=paseto paseto-public jwt"`
+	JWTAlgorithm         string        `mapstructure:"JWT_ALGORITHM" validate:"oneof=HS256 EdDSA RS256"`
+	JWTPrivateKeyFile    string        `mapstructure:"JWT_PRIVATE_KEY_FILE"`
+	PasetoPrivateKeyFile string        `mapstructure:"PASETO_PRIVATE_KEY_FILE"`
+	TokenKeyringFile     string        `mapstructure:"TOKEN_KEYRING_FILE"`
+	AccessTokenDuration  time.Duration `mapstructure:"ACCESS_TOKEN_DURATION"`
+
+	RefreshTokenDuration time.Duration `mapstructure:"REFRESH_TOKEN_DURATION"`
+
+	OIDCProvidersFile string `mapstructure:"OIDC_PROVIDERS_FILE"`
+
+	// AppURL is the address of the app the links in emails point to. Mailer
+	// has no default, as the log one writes those links, tokens included, to
+	// the log.
+	AppURL                     string        `mapstructure:"APP_URL"`
+	Mailer                     string        `mapstructure:"MAILER" validate:"required,oneof=smtp file log"`
+	MailFrom                   string        `mapstructure:"MAIL_FROM"`
+	MailDir                    string        `mapstructure:"MAIL_DIR"`
+	SMTPHost                   string        `mapstructure:"SMTP_HOST"`
+	SMTPPort                   string        `mapstructure:"SMTP_PORT"`
+	SMTPUsername               string        `mapstructure:"SMTP_USERNAME"`
+	SMTPPassword               string        `mapstructure:"SMTP_PASSWORD"`
+	PasswordResetTokenDuration time.Duration `mapstructure:"PASSWORD_RESET_TOKEN_DURATION"`
+
+	// Passwords are hashed with argon2id at these costs, the memory being in
+	// KiB. Hashes made at other costs, or with bcrypt, are upgraded on login.
+	PasswordArgon2Memory      uint32 `mapstructure:"PASSWORD_ARGON2_MEMORY"`
+	PasswordArgon2Iterations  uint32 `mapstructure:"PASSWORD_ARGON2_ITERATIONS"`
+	PasswordArgon2Parallelism uint8  `mapstructure:"PASSWORD_ARGON2_PARALLELISM"`
+
+	// New passwords have to follow the password policy. The strength is a
+	// zxcvbn score from 0 to 4, and passwords listed in the range files of
+	// BreachedPasswordsDir are refused when it's set.
+	PasswordMinLength            int    `mapstructure:"PASSWORD_MIN_LENGTH" validate:"min=1"`
+	PasswordMaxLength            int    `mapstructure:"PASSWORD_MAX_LENGTH" validate:"gtefield=PasswordMinLength"`
+	PasswordMinCharClasses       int    `mapstructure:"PASSWORD_MIN_CHAR_CLASSES" validate:"min=0,max=4"`
+	PasswordMinStrength          int    `mapstructure:"PASSWORD_MIN_STRENGTH" validate:"min=0,max=4"`
+	PasswordDisallowPersonalInfo bool   `mapstructure:"PASSWORD_DISALLOW_PERSONAL_INFO"`
+	BreachedPasswordsDir         string `mapstructure:"BREACHED_PASSWORDS_DIR"`
+
+	EmailVerificationTokenDuration  time.Duration `mapstructure:"EMAIL_VERIFICATION_TOKEN_DURATION"`
+	EmailVerificationResendInterval time.Duration `mapstructure:"EMAIL_VERIFICATION_RESEND_INTERVAL"`
+	// UnverifiedLogin is what users who haven't verified their email get when
+	// logging in: everything, only reading their todos, or nothing at all
+	UnverifiedLogin string `mapstructure:"UNVERIFIED_LOGIN" validate:"oneof=allow read-only block"`
+
+	// Failed logins are counted per username and per client IP. Past the free
+	// attempts, each failure doubles the wait before the next try, starting
+	// from the base, until so many have failed that logging in gets locked.
+	LoginFreeAttempts      int           `mapstructure:"LOGIN_FREE_ATTEMPTS"`
+	LoginIPFreeAttempts    int           `mapstructure:"LOGIN_IP_FREE_ATTEMPTS"`
+	LoginBackoffBase       time.Duration `mapstructure:"LOGIN_BACKOFF_BASE"`
+	LoginLockoutAttempts   int           `mapstructure:"LOGIN_LOCKOUT_ATTEMPTS"`
+	LoginIPLockoutAttempts int           `mapstructure:"LOGIN_IP_LOCKOUT_ATTEMPTS"`
+	LoginLockoutDuration   time.Duration `mapstructure:"LOGIN_LOCKOUT_DURATION"`
+	// LoginFailureWindow is how long failures are remembered for
+	LoginFailureWindow time.Duration `mapstructure:"LOGIN_FAILURE_WINDOW"`
+	// TrustedProxies is the comma separated list of IP addresses and CIDR
+	// ranges of the reverse proxies whose X-Forwarded-For header is believed
+	// when telling client IPs apart
+	TrustedProxies string `mapstructure:"TRUSTED_PROXIES"`
+
+	// TOTPIssuer is the name authenticator apps list the account under
+	TOTPIssuer string `mapstructure:"TOTP_ISSUER"`
+	// MFATokenDuration is how long users have to enter their code after
+	// logging in with their password
+	MFATokenDuration time.Duration `mapstructure:"MFA_TOKEN_DURATION"`
+	MFAMaxFailures   int           `mapstructure:"MFA_MAX_FAILURES"`
+
+	// ImpersonationTokenDuration is how long admins can act as a user with
+	// the token they get for impersonating them. It can't be longer than
+	// access tokens last, which is as long as their revocations are kept.
+	ImpersonationTokenDuration time.Duration `mapstructure:"IMPERSONATION_TOKEN_DURATION" validate:"ltefield=AccessTokenDuration"`
+
+	WebhookDispatchInterval time.Duration `mapstructure:"WEBHOOK_DISPATCH_INTERVAL"`
+	WebhookTimeout          time.Duration `mapstructure:"WEBHOOK_TIMEOUT"`
+	WebhookRetryBackoff     time.Duration `mapstructure:"WEBHOOK_RETRY_BACKOFF"`
+	WebhookMaxAttempts      int           `mapstructure:"WEBHOOK_MAX_ATTEMPTS"`
 }
 
 func LoadConfig(fileName string, fileType string, path string) *Config {
@@ -34,6 +111,48 @@ func LoadConfig(fileName string, fileType string, path string) *Config {
 		DBSource:   "todo.db",
 		ServerHost: "0.0.0.0",
 		ServerPort: "5000",
+
+		TokenType:    "paseto",
+		JWTAlgorithm: "HS256",
+
+		RefreshTokenDuration: 30 * 24 * time.Hour,
+
+		AppURL:                     "http://localhost:3000",
+		MailFrom:                   "Todo <no-reply@localhost>",
+		SMTPPort:                   "587",
+		PasswordResetTokenDuration: time.Hour,
+
+		PasswordArgon2Memory:      DefaultArgon2idParams.Memory,
+		PasswordArgon2Iterations:  DefaultArgon2idParams.Iterations,
+		PasswordArgon2Parallelism: DefaultArgon2idParams.Parallelism,
+
+		PasswordMinLength:            8,
+		PasswordMaxLength:            128,
+		PasswordMinStrength:          2,
+		PasswordDisallowPersonalInfo: true,
+
+		EmailVerificationTokenDuration:  24 * time.Hour,
+		EmailVerificationResendInterval: time.Minute,
+		UnverifiedLogin:                 "allow",
+
+		LoginFreeAttempts:      3,
+		LoginIPFreeAttempts:    20,
+		LoginBackoffBase:       time.Second,
+		LoginLockoutAttempts:   10,
+		LoginIPLockoutAttempts: 100,
+		LoginLockoutDuration:   15 * time.Minute,
+		LoginFailureWindow:     time.Hour,
+
+		TOTPIssuer:       "Todo",
+		MFATokenDuration: 5 * time.Minute,
+		MFAMaxFailures:   5,
+
+		ImpersonationTokenDuration: 15 * time.Minute,
+
+		WebhookDispatchInterval: 5 * time.Second,
+		WebhookTimeout:          10 * time.Second,
+		WebhookRetryBackoff:     30 * time.Second,
+		WebhookMaxAttempts:      8,
 	}
 
 	// Unmarshal and override config
@@ -0,0 +1,26 @@
+package util
+
+import (
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// NormalizeUsername gives the canonical form of the username, so that
+// usernames differing only by case or by how their characters are written
+// are the same
+func NormalizeUsername(username string) string {
+	return foldNFKC(username)
+}
+
+// NormalizeEmail gives the canonical form of the email, like NormalizeUsername
+func NormalizeEmail(email string) string {
+	return foldNFKC(email)
+}
+
+// foldNFKC normalizes the string to NFKC and folds its case, normalizing it
+// again as folding can undo the normalization
+func foldNFKC(s string) string {
+	return norm.NFKC.String(cases.Fold().String(norm.NFKC.String(strings.TrimSpace(s))))
+}
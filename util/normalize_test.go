@@ -0,0 +1,32 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeUsername(t *testing.T) {
+	testCases := []struct {
+		username string
+		expected string
+	}{
+		{"bob", "bob"},
+		{"Bob", "bob"},
+		{" BOB ", "bob"},
+		// Fullwidth letters
+		{"Ｂｏｂ", "bob"},
+		// The ligature is compatible with its letters
+		{"ﬁona", "fiona"},
+		{"Straße", "strasse"},
+	}
+
+	for _, tc := range testCases {
+		require.Equal(t, tc.expected, NormalizeUsername(tc.username), tc.username)
+	}
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	require.Equal(t, "jane.doe@example.com", NormalizeEmail("Jane.Doe@EXAMPLE.com"))
+	require.Equal(t, NormalizeEmail("jane@example.com"), NormalizeEmail("ｊａｎｅ@example.com"))
+}
@@ -1,16 +1,185 @@
 package util
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
 
-func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrPasswordMismatch        = errors.New("password doesn't match the hash")
+	ErrUnsupportedPasswordHash = errors.New("password hash is in an unsupported format")
+)
+
+// Argon2idParams are the costs of hashing passwords with argon2id. Memory is
+// in KiB.
+type Argon2idParams struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2idParams are the minimum OWASP recommends
+var DefaultArgon2idParams = Argon2idParams{
+	Memory:      19 * 1024,
+	Iterations:  2,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// PasswordHasher hashes passwords with argon2id into PHC strings like
+// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>, which carry everything needed
+// to verify them later. Passwords hashed with bcrypt by older versions can
+// still be verified, but need to be hashed again.
+type PasswordHasher struct {
+	params Argon2idParams
+	// dummyHash is verified against when there is no hash to verify against
+	dummyHash string
+}
+
+func NewPasswordHasher(params Argon2idParams) (*PasswordHasher, error) {
+	if params.Memory == 0 || params.Iterations == 0 || params.Parallelism == 0 || params.SaltLength == 0 || params.KeyLength == 0 {
+		return nil, fmt.Errorf("invalid argon2id parameters %+v", params)
+	}
+
+	hasher := &PasswordHasher{params: params}
+
+	dummyHash, err := hasher.Hash(RandomString(16))
+	if err != nil {
+		return nil, err
+	}
+	hasher.dummyHash = dummyHash
+
+	return hasher, nil
+}
+
+var defaultPasswordHasher = func() *PasswordHasher {
+	hasher, err := NewPasswordHasher(DefaultArgon2idParams)
 	if err != nil {
+		panic(err)
+	}
+
+	return hasher
+}()
+
+// Hash hashes the password with a new random salt
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
 		return "", err
 	}
 
-	return string(bytes), nil
+	key := argon2.IDKey([]byte(password), salt, h.params.Iterations, h.params.Memory, h.params.Parallelism, h.params.KeyLength)
+
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		h.params.Memory,
+		h.params.Iterations,
+		h.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
 }
 
+// Verify checks the password against the hash, telling whether the hash
+// should be replaced by a new one because it was made with another algorithm
+// or other costs than the hasher's
+func (h *PasswordHasher) Verify(hashedPassword string, password string) (bool, error) {
+	switch {
+	case strings.HasPrefix(hashedPassword, "$argon2id$"):
+		params, salt, key, err := decodeArgon2idHash(hashedPassword)
+		if err != nil {
+			return false, err
+		}
+
+		candidate := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+		if subtle.ConstantTimeCompare(key, candidate) != 1 {
+			return false, ErrPasswordMismatch
+		}
+
+		return params != h.params, nil
+
+	case strings.HasPrefix(hashedPassword, "$2a$"), strings.HasPrefix(hashedPassword, "$2b$"), strings.HasPrefix(hashedPassword, "$2y$"):
+		err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, ErrPasswordMismatch
+		}
+		if err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}
+
+	return false, ErrUnsupportedPasswordHash
+}
+
+// VerifyNothing takes as long as verifying a password, for when there is no
+// hash to verify it against, so that timing doesn't tell the cases apart
+func (h *PasswordHasher) VerifyNothing(password string) {
+	h.Verify(h.dummyHash, password)
+}
+
+// decodeArgon2idHash parses a PHC string made by Hash
+func decodeArgon2idHash(hashedPassword string) (params Argon2idParams, salt []byte, key []byte, err error) {
+	// The string starts with a $, so the first part is empty
+	parts := strings.Split(hashedPassword, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, ErrUnsupportedPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrUnsupportedPasswordHash
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, ErrUnsupportedPasswordHash
+	}
+
+	salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrUnsupportedPasswordHash
+	}
+
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, ErrUnsupportedPasswordHash
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
+
+// HashPassword hashes the password with the default parameters
+func HashPassword(password string) (string, error) {
+	return defaultPasswordHasher.Hash(password)
+}
+
+// CheckHashPassword checks the password against a hash made by any supported algorithm
 func CheckHashPassword(hashedPassword string, password string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+	_, err := defaultPasswordHasher.Verify(hashedPassword, password)
+	return err
+}
+
+// HashSecret hashes a high entropy random secret, like a token, so that it can
+// be stored and looked up without keeping the secret itself. Passwords must
+// use HashPassword instead.
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
 }
@@ -0,0 +1,179 @@
+package util
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/nbutton23/zxcvbn-go"
+)
+
+// PasswordPolicy decides which passwords users may choose
+type PasswordPolicy struct {
+	MinLength int
+	MaxLength int
+	// MinCharClasses is how many of lowercase letters, uppercase letters,
+	// digits and symbols the password has to mix
+	MinCharClasses int
+	// MinStrength is the lowest zxcvbn score allowed, from 0 to 4
+	MinStrength int
+	// DisallowPersonalInfo forbids passwords containing the username or email
+	DisallowPersonalInfo bool
+	// Breached, when set, forbids passwords known from data breaches
+	Breached *BreachedPasswords
+}
+
+func NewPasswordPolicy(config *Config) (*PasswordPolicy, error) {
+	policy := &PasswordPolicy{
+		MinLength:            config.PasswordMinLength,
+		MaxLength:            config.PasswordMaxLength,
+		MinCharClasses:       config.PasswordMinCharClasses,
+		MinStrength:          config.PasswordMinStrength,
+		DisallowPersonalInfo: config.PasswordDisallowPersonalInfo,
+	}
+
+	if len(config.BreachedPasswordsDir) > 0 {
+		breached, err := NewBreachedPasswords(config.BreachedPasswordsDir)
+		if err != nil {
+			return nil, err
+		}
+		policy.Breached = breached
+	}
+
+	return policy, nil
+}
+
+// personalInfoMinLength is the length below which personal info is too short
+// to be told apart from any other part of a password
+const personalInfoMinLength = 3
+
+// Check tells everything wrong with the password of the user with the
+// username and email, or nothing if the policy allows it
+func (p *PasswordPolicy) Check(password string, username string, email string) ([]string, error) {
+	var problems []string
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		problems = append(problems, fmt.Sprintf("This password must have at least %d characters", p.MinLength))
+	}
+
+	if p.MaxLength > 0 && length > p.MaxLength {
+		problems = append(problems, fmt.Sprintf("This password can have at most %d characters", p.MaxLength))
+	}
+
+	if charClasses(password) < p.MinCharClasses {
+		problems = append(problems, fmt.Sprintf("This password must mix at least %d of lowercase letters, uppercase letters, digits and symbols", p.MinCharClasses))
+	}
+
+	if p.DisallowPersonalInfo {
+		localPart := strings.SplitN(email, "@", 2)[0]
+		lowered := strings.ToLower(password)
+
+		for _, info := range []string{username, localPart} {
+			if len(info) >= personalInfoMinLength && strings.Contains(lowered, strings.ToLower(info)) {
+				problems = append(problems, "This password can't contain your username or email")
+				break
+			}
+		}
+	}
+
+	// Passwords too long for zxcvbn to score quickly are long enough anyway
+	if p.MinStrength > 0 && length <= 100 {
+		if zxcvbn.PasswordStrength(password, []string{username, email}).Score < p.MinStrength {
+			problems = append(problems, "This password is too easy to guess")
+		}
+	}
+
+	if p.Breached != nil {
+		breached, err := p.Breached.Contains(password)
+		if err != nil {
+			return nil, err
+		}
+
+		if breached {
+			problems = append(problems, "This password has appeared in a data breach, please choose another one")
+		}
+	}
+
+	return problems, nil
+}
+
+// charClasses counts the kinds of characters the password has
+func charClasses(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+
+	return lower + upper + digit + symbol
+}
+
+// BreachedPasswords looks passwords up in a local copy of a breached password
+// list split into ranges by the first 5 hex characters of their SHA-1 hash,
+// like the one served by Have I Been Pwned. The directory has a file per
+// range, such as 21BD1.txt, with a SUFFIX:COUNT line per hash in it, so only
+// one small file is read per lookup.
+type BreachedPasswords struct {
+	dir string
+}
+
+const breachedPasswordsPrefixLength = 5
+
+func NewBreachedPasswords(dir string) (*BreachedPasswords, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return nil, fmt.Errorf("breached passwords %s is not a directory", dir)
+	}
+
+	return &BreachedPasswords{dir: dir}, nil
+}
+
+// Contains tells whether the password is in the list
+func (b *BreachedPasswords) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:breachedPasswordsPrefixLength], hash[breachedPasswordsPrefixLength:]
+
+	file, err := os.Open(filepath.Join(b.dir, prefix+".txt"))
+	if err != nil {
+		// No file means no breached password in the range
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		candidate := strings.SplitN(line, ":", 2)[0]
+
+		// Padding entries have a count of 0
+		if strings.EqualFold(candidate, suffix) && !strings.HasSuffix(line, ":0") {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
@@ -0,0 +1,77 @@
+package util
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := &PasswordPolicy{
+		MinLength:            8,
+		MaxLength:            64,
+		MinCharClasses:       2,
+		MinStrength:          2,
+		DisallowPersonalInfo: true,
+	}
+
+	problems, err := policy.Check("correct horse battery staple", "alice", "alice@example.com")
+	require.NoError(t, err)
+	require.Empty(t, problems)
+
+	testCases := []struct {
+		name     string
+		password string
+		problem  string
+	}{
+		{"too short", "Ab1!", "at least 8 characters"},
+		{"too long", strings.Repeat("Ab1! ", 13), "at most 64 characters"},
+		{"one char class", "zqxjvkwmtrpl", "mix at least 2"},
+		{"too easy", "password1", "too easy to guess"},
+		{"username", "xx alice-Kx9 zz", "username or email"},
+		{"email", "ALICE@9 kW!xz", "username or email"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			problems, err := policy.Check(tc.password, "alice", "alice@example.com")
+			require.NoError(t, err)
+			require.NotEmpty(t, problems)
+			require.Contains(t, strings.Join(problems, "\n"), tc.problem)
+		})
+	}
+}
+
+func TestBreachedPasswords(t *testing.T) {
+	dir := t.TempDir()
+
+	sum := sha1.Sum([]byte("breached password"))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	rangeFile := "0018A45C4D1DEF81644B54AB7F969B88D65:1\n" + hash[5:] + ":42\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, hash[:5]+".txt"), []byte(rangeFile), 0600))
+
+	breached, err := NewBreachedPasswords(dir)
+	require.NoError(t, err)
+
+	found, err := breached.Contains("breached password")
+	require.NoError(t, err)
+	require.True(t, found)
+
+	found, err = breached.Contains("another password")
+	require.NoError(t, err)
+	require.False(t, found)
+
+	policy := &PasswordPolicy{MinLength: 8, Breached: breached}
+	problems, err := policy.Check("breached password", "alice", "alice@example.com")
+	require.NoError(t, err)
+	require.Len(t, problems, 1)
+	require.Contains(t, problems[0], "data breach")
+
+	_, err = NewBreachedPasswords(filepath.Join(dir, "missing"))
+	require.Error(t, err)
+}
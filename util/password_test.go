@@ -1,6 +1,7 @@
 package util
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -19,10 +20,72 @@ func TestHashPassword(t *testing.T) {
 
 	wrongPassword := RandomString(8)
 	err = CheckHashPassword(hashedPassword1, wrongPassword)
-	require.EqualError(t, err, bcrypt.ErrMismatchedHashAndPassword.Error())
+	require.ErrorIs(t, err, ErrPasswordMismatch)
 
 	hashedPassword2, err := HashPassword(password)
 	require.NoError(t, err)
 	require.NotEmpty(t, hashedPassword2)
 	require.NotEqual(t, hashedPassword1, hashedPassword2)
 }
+
+func TestPasswordHasher(t *testing.T) {
+	params := Argon2idParams{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	hasher, err := NewPasswordHasher(params)
+	require.NoError(t, err)
+
+	password := RandomString(8)
+	hashedPassword, err := hasher.Hash(password)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(hashedPassword, "$argon2id$v=19$m=1024,t=1,p=1$"))
+
+	rehash, err := hasher.Verify(hashedPassword, password)
+	require.NoError(t, err)
+	require.False(t, rehash)
+
+	_, err = hasher.Verify(hashedPassword, RandomString(8))
+	require.ErrorIs(t, err, ErrPasswordMismatch)
+
+	// Hashes made with other costs still verify, but need to be made again
+	stronger, err := NewPasswordHasher(Argon2idParams{Memory: 2048, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+	require.NoError(t, err)
+
+	rehash, err = stronger.Verify(hashedPassword, password)
+	require.NoError(t, err)
+	require.True(t, rehash)
+}
+
+func TestPasswordHasherBcrypt(t *testing.T) {
+	hasher, err := NewPasswordHasher(DefaultArgon2idParams)
+	require.NoError(t, err)
+
+	password := RandomString(8)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	rehash, err := hasher.Verify(string(hashedPassword), password)
+	require.NoError(t, err)
+	require.True(t, rehash)
+
+	_, err = hasher.Verify(string(hashedPassword), RandomString(8))
+	require.ErrorIs(t, err, ErrPasswordMismatch)
+}
+
+func TestPasswordHasherUnsupportedHash(t *testing.T) {
+	hasher, err := NewPasswordHasher(DefaultArgon2idParams)
+	require.NoError(t, err)
+
+	for _, hashedPassword := range []string{"", "plaintext", "$argon2i$v=19$m=1024,t=1,p=1$c2FsdA$a2V5", "$argon2id$v=16$m=1024,t=1,p=1$c2FsdA$a2V5"} {
+		_, err = hasher.Verify(hashedPassword, "password")
+		require.ErrorIs(t, err, ErrUnsupportedPasswordHash)
+	}
+}
+
+func TestHashSecret(t *testing.T) {
+	secret, err := RandomSecret(32)
+	require.NoError(t, err)
+
+	hashedSecret := HashSecret(secret)
+	require.Len(t, hashedSecret, 64)
+	require.Equal(t, hashedSecret, HashSecret(secret))
+	require.NotEqual(t, hashedSecret, HashSecret(secret+"x"))
+}
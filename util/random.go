@@ -1,6 +1,8 @@
 package util
 
 import (
+	cryptorand "crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"math/rand"
 	"strings"
@@ -40,3 +42,13 @@ func RandomUsername() string {
 func RandomEmail() string {
 	return fmt.Sprintf("%s@gmail.com", RandomString(6))
 }
+
+// RandomSecret generates a cryptographically secure hex encoded secret of n bytes
+func RandomSecret(n int) (string, error) {
+	bytes := make([]byte, n)
+	if _, err := cryptorand.Read(bytes); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(bytes), nil
+}
@@ -23,3 +23,13 @@ func TestRandomString(t *testing.T) {
 	randomString := RandomString(length)
 	require.Equal(t, len(randomString), length)
 }
+
+func TestRandomSecret(t *testing.T) {
+	secret1, err := RandomSecret(16)
+	require.NoError(t, err)
+	require.Len(t, secret1, 32)
+
+	secret2, err := RandomSecret(16)
+	require.NoError(t, err)
+	require.NotEqual(t, secret1, secret2)
+}
@@ -61,3 +61,10 @@ func RespondWithForbiddenError(w http.ResponseWriter, errorMsg string) {
 		"error":   errorMsg,
 	})
 }
+
+func RespondWithTooManyRequestsError(w http.ResponseWriter, errorMsg string) {
+	RespondWithJSON(w, http.StatusTooManyRequests, map[string]interface{}{
+		"success": false,
+		"error":   errorMsg,
+	})
+}
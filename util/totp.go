@@ -0,0 +1,108 @@
+package util
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"image/png"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	// TOTPPeriod is how long each code is valid for, in seconds
+	TOTPPeriod = 30
+	// totpSkew is how many periods a code may be off by, allowing for clocks
+	// that drift and codes typed in as they change
+	totpSkew = 1
+	// totpQRCodeSize is the width and height of QR code images, in pixels
+	totpQRCodeSize = 256
+)
+
+// TOTPKey is a new TOTP secret along with the ways of adding it to an authenticator app
+type TOTPKey struct {
+	Secret string
+	URI    string
+	// QRCode is a data URI of a PNG image of the URI
+	QRCode string
+}
+
+// GenerateTOTPKey generates an RFC 6238 secret for the account
+func GenerateTOTPKey(issuer string, accountName string) (TOTPKey, error) {
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      issuer,
+		AccountName: accountName,
+		Period:      TOTPPeriod,
+	})
+	if err != nil {
+		return TOTPKey{}, err
+	}
+
+	image, err := key.Image(totpQRCodeSize, totpQRCodeSize)
+	if err != nil {
+		return TOTPKey{}, err
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image); err != nil {
+		return TOTPKey{}, err
+	}
+
+	return TOTPKey{
+		Secret: key.Secret(),
+		URI:    key.URL(),
+		QRCode: "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}, nil
+}
+
+// ValidateTOTP checks the code against the secret at the time, giving the
+// time step the code belongs to. Storing the step of the last code used lets
+// codes be rejected when they are used again.
+func ValidateTOTP(secret string, code string, t time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	step := t.Unix() / TOTPPeriod
+
+	for offset := int64(-totpSkew); offset <= totpSkew; offset++ {
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix((step+offset)*TOTPPeriod, 0), totp.ValidateOpts{
+			Period:    TOTPPeriod,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err != nil {
+			return 0, false
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step + offset, true
+		}
+	}
+
+	return 0, false
+}
+
+// GenerateRecoveryCodes generates n single use codes to log in with when the
+// authenticator app is lost, formatted to be easy to copy down
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	for i := range codes {
+		secret, err := RandomSecret(10)
+		if err != nil {
+			return nil, err
+		}
+
+		codes[i] = strings.Join([]string{secret[0:5], secret[5:10], secret[10:15], secret[15:20]}, "-")
+	}
+
+	return codes, nil
+}
+
+// HashRecoveryCode hashes the recovery code however it was typed in
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+
+	return HashSecret(code)
+}
@@ -0,0 +1,59 @@
+package util
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateTOTPKey(t *testing.T) {
+	key, err := GenerateTOTPKey("Todo", "jane@example.com")
+	require.NoError(t, err)
+	require.NotEmpty(t, key.Secret)
+	require.True(t, strings.HasPrefix(key.URI, "otpauth://totp/Todo:jane@example.com?"))
+	require.Contains(t, key.URI, "secret="+key.Secret)
+	require.True(t, strings.HasPrefix(key.QRCode, "data:image/png;base64,"))
+}
+
+func TestValidateTOTP(t *testing.T) {
+	key, err := GenerateTOTPKey("Todo", "jane@example.com")
+	require.NoError(t, err)
+
+	now := time.Now()
+	code, err := totp.GenerateCode(key.Secret, now)
+	require.NoError(t, err)
+
+	step, ok := ValidateTOTP(key.Secret, code, now)
+	require.True(t, ok)
+	require.Equal(t, now.Unix()/TOTPPeriod, step)
+
+	// Codes of the previous period still work, but not older ones
+	step, ok = ValidateTOTP(key.Secret, code, now.Add(TOTPPeriod*time.Second))
+	require.True(t, ok)
+	require.Equal(t, now.Unix()/TOTPPeriod, step)
+
+	_, ok = ValidateTOTP(key.Secret, code, now.Add(2*TOTPPeriod*time.Second))
+	require.False(t, ok)
+
+	_, ok = ValidateTOTP(key.Secret, "000000", now)
+	require.Equal(t, code == "000000", ok)
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	require.NoError(t, err)
+	require.Len(t, codes, 10)
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		require.Len(t, code, 23)
+		require.False(t, seen[code])
+		seen[code] = true
+	}
+
+	typed := strings.ToUpper(strings.ReplaceAll(codes[0], "-", " "))
+	require.Equal(t, HashRecoveryCode(codes[0]), HashRecoveryCode(typed))
+}
@@ -0,0 +1,181 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/sbbullet/to-do/db"
+	"github.com/sbbullet/to-do/logger"
+	"github.com/sbbullet/to-do/util"
+	"go.uber.org/zap"
+)
+
+const (
+	dispatchBatchSize = 50
+	maxRetryBackoff   = 6 * time.Hour
+)
+
+// errReceiverUnreachable is what the owner of a webhook is told when its
+// receiver couldn't be reached, rather than why, which would tell them about
+// the network of the server
+var errReceiverUnreachable = errors.New("couldn't connect to the receiver")
+
+// Dispatcher delivers the events queued in the webhook outbox, retrying failed
+// deliveries with exponential backoff
+type Dispatcher struct {
+	store       *db.Store
+	client      *http.Client
+	interval    time.Duration
+	maxAttempts int
+	backoff     time.Duration
+}
+
+// NewDispatcher creates a dispatcher that only delivers to receivers on public
+// addresses
+func NewDispatcher(store *db.Store, config *util.Config) *Dispatcher {
+	return newDispatcher(store, config, forbidInternalAddresses)
+}
+
+func newDispatcher(store *db.Store, config *util.Config, control func(network string, address string, c syscall.RawConn) error) *Dispatcher {
+	return &Dispatcher{
+		store:       store,
+		client:      newReceiverClient(config.WebhookTimeout, control),
+		interval:    config.WebhookDispatchInterval,
+		maxAttempts: config.WebhookMaxAttempts,
+		backoff:     config.WebhookRetryBackoff,
+	}
+}
+
+// Run polls the outbox until the context is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := d.DispatchDue(ctx); err != nil {
+				logger.Error("Webhook dispatch", zap.Error(err))
+			}
+		}
+	}
+}
+
+// DispatchDue sends every delivery whose next attempt is due
+func (d *Dispatcher) DispatchDue(ctx context.Context) error {
+	for {
+		deliveries, err := d.store.GetDueWebhookDeliveries(db.GetDueWebhookDeliveriesParams{
+			Now:   time.Now(),
+			Limit: dispatchBatchSize,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, delivery := range deliveries {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			if err := d.deliver(ctx, delivery); err != nil {
+				return err
+			}
+		}
+
+		if len(deliveries) < dispatchBatchSize {
+			return nil
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery db.DueWebhookDelivery) error {
+	start := time.Now()
+	statusCode, sendErr := d.send(ctx, delivery)
+
+	arg := db.RecordWebhookDeliveryAttemptParams{
+		DeliveryID:    delivery.ID,
+		StatusCode:    statusCode,
+		Duration:      time.Since(start),
+		Status:        db.WebhookDeliveryDelivered,
+		NextAttemptAt: delivery.NextAttemptAt,
+	}
+
+	if sendErr != nil {
+		arg.Error = sendErr.Error()
+		if statusCode == 0 {
+			arg.Error = errReceiverUnreachable.Error()
+		}
+
+		attempts := delivery.Attempts + 1
+		if attempts >= d.maxAttempts {
+			arg.Status = db.WebhookDeliveryFailed
+		} else {
+			arg.Status = db.WebhookDeliveryPending
+			arg.NextAttemptAt = time.Now().Add(retryBackoff(d.backoff, attempts))
+		}
+
+		logger.Warn(
+			"Webhook delivery failed",
+			zap.String("delivery_id", delivery.ID.String()),
+			zap.Int("attempts", attempts),
+			zap.Error(sendErr),
+		)
+	}
+
+	return d.store.RecordWebhookDeliveryAttempt(arg)
+}
+
+// send posts the payload to the receiver, treating any non 2xx response as a failure
+func (d *Dispatcher) send(ctx context.Context, delivery db.DueWebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "to-do-webhooks/1.0")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.ID.String())
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, timestamp, body))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	// Drain the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("receiver responded with status %d", res.StatusCode)
+	}
+
+	return res.StatusCode, nil
+}
+
+// retryBackoff doubles the wait after every failed attempt, up to a limit
+func retryBackoff(base time.Duration, attempts int) time.Duration {
+	backoff := base
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= maxRetryBackoff {
+			return maxRetryBackoff
+		}
+	}
+
+	return backoff
+}
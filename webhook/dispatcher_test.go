@@ -0,0 +1,170 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sbbullet/to-do/db"
+	"github.com/sbbullet/to-do/logger"
+	"github.com/sbbullet/to-do/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDispatcherRetriesAndSignsDeliveries(t *testing.T) {
+	logger.InitializeLogger()
+
+	const secret = "webhook-test-secret"
+
+	var mu sync.Mutex
+	var received []db.WebhookEventPayload
+	calls := 0
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		timestamp, err := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+		require.NoError(t, err)
+		require.True(t, VerifySignature(secret, timestamp, body, r.Header.Get(SignatureHeader)))
+		require.Equal(t, db.WebhookEventTodoCreated, r.Header.Get(EventHeader))
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		// Fail the first attempt so the delivery has to be retried
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var payload db.WebhookEventPayload
+		require.NoError(t, json.Unmarshal(body, &payload))
+		received = append(received, payload)
+	}))
+	defer receiver.Close()
+
+	user := createRandomUser(t)
+	webhook, err := testStore.CreateWebhook(db.CreateWebhookParams{
+		ID:       uuid.New(),
+		Username: user.Username,
+		URL:      receiver.URL,
+		Secret:   secret,
+		Events:   []string{db.WebhookEventTodoCreated},
+	})
+	require.NoError(t, err)
+
+	_, err = testStore.CreateTodo(db.CreateTodoParams{
+		ID:       uuid.New(),
+		Username: user.Username,
+		Title:    util.RandomString(10),
+	})
+	require.NoError(t, err)
+
+	// The receiver listens on the loopback address
+	dispatcher := newDispatcher(testStore, &util.Config{
+		WebhookTimeout:      time.Second,
+		WebhookRetryBackoff: 10 * time.Millisecond,
+		WebhookMaxAttempts:  3,
+	}, nil)
+
+	require.NoError(t, dispatcher.DispatchDue(context.Background()))
+
+	deliveries, err := testStore.GetWebhookDeliveries(db.GetWebhookDeliveriesParams{WebhookID: webhook.ID, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	require.Equal(t, db.WebhookDeliveryPending, deliveries[0].Status)
+	require.Equal(t, 1, deliveries[0].Attempts)
+
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, dispatcher.DispatchDue(context.Background()))
+
+	delivery, err := testStore.GetWebhookDeliveryById(deliveries[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, db.WebhookDeliveryDelivered, delivery.Status)
+	require.Equal(t, 2, delivery.Attempts)
+
+	logs, err := testStore.GetWebhookDeliveryLogs(delivery.ID)
+	require.NoError(t, err)
+	require.Len(t, logs, 2)
+	require.Equal(t, http.StatusServiceUnavailable, logs[0].StatusCode)
+	require.NotEmpty(t, logs[0].Error)
+	require.Equal(t, http.StatusOK, logs[1].StatusCode)
+	require.Empty(t, logs[1].Error)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, received, 1)
+	require.Equal(t, db.WebhookEventTodoCreated, received[0].Event)
+}
+
+func TestDispatcherGivesUpAfterMaxAttempts(t *testing.T) {
+	logger.InitializeLogger()
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	user := createRandomUser(t)
+	webhook, err := testStore.CreateWebhook(db.CreateWebhookParams{
+		ID:       uuid.New(),
+		Username: user.Username,
+		URL:      receiver.URL,
+		Secret:   "webhook-test-secret",
+		Events:   []string{db.WebhookEventAll},
+	})
+	require.NoError(t, err)
+
+	_, err = testStore.CreateTodo(db.CreateTodoParams{
+		ID:       uuid.New(),
+		Username: user.Username,
+		Title:    util.RandomString(10),
+	})
+	require.NoError(t, err)
+
+	// The receiver listens on the loopback address
+	dispatcher := newDispatcher(testStore, &util.Config{
+		WebhookTimeout:      time.Second,
+		WebhookRetryBackoff: time.Millisecond,
+		WebhookMaxAttempts:  2,
+	}, nil)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, dispatcher.DispatchDue(context.Background()))
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	deliveries, err := testStore.GetWebhookDeliveries(db.GetWebhookDeliveriesParams{WebhookID: webhook.ID, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	require.Equal(t, db.WebhookDeliveryFailed, deliveries[0].Status)
+	require.Equal(t, 2, deliveries[0].Attempts)
+}
+
+func TestRetryBackoff(t *testing.T) {
+	require.Equal(t, time.Second, retryBackoff(time.Second, 1))
+	require.Equal(t, 2*time.Second, retryBackoff(time.Second, 2))
+	require.Equal(t, 8*time.Second, retryBackoff(time.Second, 4))
+	require.Equal(t, maxRetryBackoff, retryBackoff(time.Hour, 10))
+}
+
+func createRandomUser(t *testing.T) db.User {
+	user, err := testStore.CreateUser(db.CreateUserParams{
+		Username:       util.RandomUsername(),
+		Email:          util.RandomEmail(),
+		FullName:       util.RandomString(4) + " " + util.RandomString(4),
+		HashedPassword: util.RandomString(20),
+	})
+	require.NoError(t, err)
+
+	return user
+}
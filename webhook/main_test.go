@@ -0,0 +1,26 @@
+package webhook
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/sbbullet/to-do/db"
+	"github.com/sbbullet/to-do/util"
+)
+
+var testStore *db.Store
+
+func TestMain(m *testing.M) {
+	config := util.LoadConfig("app", "env", "..")
+	config.DBSource = "../test_webhook.db"
+
+	if _, err := os.Stat(config.DBSource); !errors.Is(err, os.ErrNotExist) {
+		// file exists
+		os.Remove(config.DBSource)
+	}
+
+	testStore = db.NewStore(db.NewDB(config))
+
+	os.Exit(m.Run())
+}
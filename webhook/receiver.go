@@ -0,0 +1,88 @@
+package webhook
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrUnsupportedScheme = errors.New("webhook receivers must be http or https URLs")
+	ErrForbiddenAddress  = errors.New("webhook receivers can't be on a loopback, private, link-local or unspecified address")
+)
+
+// CheckReceiverURL checks that events can be delivered to the URL. Receivers
+// whose host name resolves to a forbidden address are only caught when
+// connecting to them, as the name can resolve differently by then.
+func CheckReceiverURL(rawURL string) error {
+	receiverURL, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	scheme := strings.ToLower(receiverURL.Scheme)
+	if scheme != "http" && scheme != "https" {
+		return ErrUnsupportedScheme
+	}
+
+	if ip := net.ParseIP(receiverURL.Hostname()); ip != nil && isForbiddenIP(ip) {
+		return ErrForbiddenAddress
+	}
+
+	return nil
+}
+
+// isForbiddenIP tells whether the address belongs to the server or its
+// network, which users mustn't be able to send requests to
+func isForbiddenIP(ip net.IP) bool {
+	return ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsUnspecified() ||
+		// Connecting to 0.0.0.0/8 reaches the server itself on some systems
+		(ip.To4() != nil && ip.To4()[0] == 0)
+}
+
+// forbidInternalAddresses refuses to connect to forbidden addresses. It's
+// checked on the address actually dialed, after the host name is resolved, so
+// that DNS rebinding can't get around it.
+func forbidInternalAddresses(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || isForbiddenIP(ip) {
+		return ErrForbiddenAddress
+	}
+
+	return nil
+}
+
+// newReceiverClient creates the client delivering events, which doesn't
+// follow redirects, as they could point anywhere
+func newReceiverClient(timeout time.Duration, control func(network string, address string, c syscall.RawConn) error) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: control,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,142 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sbbullet/to-do/db"
+	"github.com/sbbullet/to-do/logger"
+	"github.com/sbbullet/to-do/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckReceiverURL(t *testing.T) {
+	testCases := []struct {
+		name string
+		url  string
+		err  error
+	}{
+		{name: "HTTPS", url: "https://example.com/hooks"},
+		{name: "HTTP", url: "http://203.0.113.10:8080/hooks"},
+		{name: "FTP", url: "ftp://example.com/hooks", err: ErrUnsupportedScheme},
+		{name: "File", url: "file:///etc/passwd", err: ErrUnsupportedScheme},
+		{name: "Loopback", url: "http://127.0.0.1:4000/", err: ErrForbiddenAddress},
+		{name: "LoopbackIPv6", url: "http://[::1]/", err: ErrForbiddenAddress},
+		{name: "MappedLoopback", url: "http://[::ffff:127.0.0.1]/", err: ErrForbiddenAddress},
+		{name: "Private", url: "http://10.1.2.3/", err: ErrForbiddenAddress},
+		{name: "UniqueLocal", url: "http://[fd00::1]/", err: ErrForbiddenAddress},
+		{name: "Metadata", url: "http://169.254.169.254/latest/meta-data/", err: ErrForbiddenAddress},
+		{name: "Unspecified", url: "http://0.0.0.0:4000/", err: ErrForbiddenAddress},
+		{name: "ThisNetwork", url: "http://0.1.2.3/", err: ErrForbiddenAddress},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			err := CheckReceiverURL(tc.url)
+			if tc.err == nil {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+func TestDispatcherRefusesInternalReceivers(t *testing.T) {
+	logger.InitializeLogger()
+
+	var calls int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer receiver.Close()
+
+	// Host names are only resolved when connecting, which is where they're refused
+	delivery := createDueDelivery(t, "http://localhost:"+receiverPort(t, receiver))
+
+	dispatcher := NewDispatcher(testStore, &util.Config{
+		WebhookTimeout:      time.Second,
+		WebhookRetryBackoff: time.Minute,
+		WebhookMaxAttempts:  3,
+	})
+	require.NoError(t, dispatcher.DispatchDue(context.Background()))
+	require.Zero(t, atomic.LoadInt32(&calls))
+
+	logs, err := testStore.GetWebhookDeliveryLogs(delivery.ID)
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	require.Zero(t, logs[0].StatusCode)
+	require.Equal(t, errReceiverUnreachable.Error(), logs[0].Error)
+}
+
+func TestDispatcherDoesNotFollowRedirects(t *testing.T) {
+	logger.InitializeLogger()
+
+	var redirected int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&redirected, 1)
+	}))
+	defer target.Close()
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusTemporaryRedirect)
+	}))
+	defer receiver.Close()
+
+	delivery := createDueDelivery(t, receiver.URL)
+
+	// The receiver listens on the loopback address
+	dispatcher := newDispatcher(testStore, &util.Config{
+		WebhookTimeout:      time.Second,
+		WebhookRetryBackoff: time.Minute,
+		WebhookMaxAttempts:  3,
+	}, nil)
+	require.NoError(t, dispatcher.DispatchDue(context.Background()))
+	require.Zero(t, atomic.LoadInt32(&redirected))
+
+	logs, err := testStore.GetWebhookDeliveryLogs(delivery.ID)
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	require.Equal(t, http.StatusTemporaryRedirect, logs[0].StatusCode)
+}
+
+// createDueDelivery creates a webhook posting to the URL along with a
+// delivery due right away
+func createDueDelivery(t *testing.T, receiverURL string) db.WebhookDelivery {
+	user := createRandomUser(t)
+	webhook, err := testStore.CreateWebhook(db.CreateWebhookParams{
+		ID:       uuid.New(),
+		Username: user.Username,
+		URL:      receiverURL,
+		Secret:   "webhook-test-secret",
+		Events:   []string{db.WebhookEventAll},
+	})
+	require.NoError(t, err)
+
+	_, err = testStore.CreateTodo(db.CreateTodoParams{
+		ID:       uuid.New(),
+		Username: user.Username,
+		Title:    util.RandomString(10),
+	})
+	require.NoError(t, err)
+
+	deliveries, err := testStore.GetWebhookDeliveries(db.GetWebhookDeliveriesParams{WebhookID: webhook.ID, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+
+	return deliveries[0]
+}
+
+func receiverPort(t *testing.T, receiver *httptest.Server) string {
+	receiverURL, err := url.Parse(receiver.URL)
+	require.NoError(t, err)
+
+	return receiverURL.Port()
+}